@@ -0,0 +1,142 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrAPIKeyNotFound is returned by APIKeyStore.APIKeySecrets
+// when there is no key with the requested ID.
+var ErrAPIKeyNotFound = errgo.New("API key not found")
+
+// APIKeyStore is used by a Server to check the API keys held in fields
+// with the "apikey" attribute (see Unmarshal).
+//
+// An API key is of the form ID.SECRET, where ID identifies the key
+// and may not contain a "." character. A key is valid if SECRET
+// matches any of the secrets returned for ID by the store, so a key
+// can be rotated by adding a new secret, then removing the old one
+// once clients have switched to the new key.
+type APIKeyStore interface {
+	// APIKeySecrets returns the current secrets for the API key with
+	// the given ID. If there is no such key, it returns an error with
+	// an ErrAPIKeyNotFound cause.
+	APIKeySecrets(ctx context.Context, id string) ([]string, error)
+}
+
+// MemAPIKeyStore is an in-memory implementation of APIKeyStore that
+// maps from each key ID to its secrets.
+type MemAPIKeyStore map[string][]string
+
+// APIKeySecrets implements APIKeyStore.APIKeySecrets.
+func (s MemAPIKeyStore) APIKeySecrets(ctx context.Context, id string) ([]string, error) {
+	secrets, ok := s[id]
+	if !ok {
+		return nil, ErrAPIKeyNotFound
+	}
+	return secrets, nil
+}
+
+type apiKeyIDKey struct{}
+
+// APIKeyIDFromContext returns the ID of the API key that was used to
+// authenticate the request with the given context, or the empty string
+// if there is none.
+func APIKeyIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(apiKeyIDKey{}).(string)
+	return id
+}
+
+// wrapAPIKey returns a handler that checks the API key found as
+// specified by f before calling h.
+func (srv *Server) wrapAPIKey(f *apiKeyField, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ctx := req.Context()
+		var key string
+		switch f.source {
+		case sourcePath:
+			key = p.ByName(f.name)
+		case sourceForm:
+			key = req.FormValue(f.name)
+		case sourceFormBody:
+			key = req.PostFormValue(f.name)
+		case sourceHeader:
+			key = req.Header.Get(f.name)
+		}
+		if key == "" {
+			srv.WriteError(ctx, w, Errorf(CodeUnauthorized, "missing API key"))
+			return
+		}
+		id, err := srv.checkAPIKey(ctx, key)
+		if err != nil {
+			srv.WriteError(ctx, w, errgo.Mask(err, errgo.Any))
+			return
+		}
+		h(w, req.WithContext(context.WithValue(ctx, apiKeyIDKey{}, id)), p)
+	}
+}
+
+// checkAPIKey checks the given API key against srv.APIKeyStore
+// and returns its ID.
+func (srv *Server) checkAPIKey(ctx context.Context, key string) (string, error) {
+	i := strings.Index(key, ".")
+	if i <= 0 {
+		return "", Errorf(CodeUnauthorized, "invalid API key")
+	}
+	id, secret := key[:i], key[i+1:]
+	secrets, err := srv.APIKeyStore.APIKeySecrets(ctx, id)
+	if errgo.Cause(err) == ErrAPIKeyNotFound {
+		return "", Errorf(CodeUnauthorized, "invalid API key")
+	}
+	if err != nil {
+		return "", errgo.Notef(err, "cannot get API key")
+	}
+	ok := 0
+	for _, s := range secrets {
+		// Check all the secrets so that the time taken does not
+		// reveal which one matched.
+		ok |= subtle.ConstantTimeCompare([]byte(secret), []byte(s))
+	}
+	if ok != 1 {
+		return "", Errorf(CodeUnauthorized, "invalid API key")
+	}
+	return id, nil
+}
+
+// unmarshalAPIKeyID sets a string field to the ID of the API key
+// held in the request context.
+func unmarshalAPIKeyID(v reflect.Value, p Params, makeResult resultMaker) error {
+	if p.Context == nil {
+		return nil
+	}
+	if id := APIKeyIDFromContext(p.Context); id != "" {
+		makeResult(v).SetString(id)
+	}
+	return nil
+}
+
+// withAPIKey returns a copy of the request parameters x, which has the
+// given request type, with its API key field set to key if it is
+// empty. If there is no such field, x is returned unchanged.
+func withAPIKey(x interface{}, rt *requestType, key string) interface{} {
+	if rt.apiKey == nil {
+		return x
+	}
+	xv := reflect.ValueOf(x)
+	if xv.Elem().FieldByIndex(rt.apiKey.index).String() != "" {
+		return x
+	}
+	xv1 := reflect.New(xv.Type().Elem())
+	xv1.Elem().Set(xv.Elem())
+	xv1.Elem().FieldByIndex(rt.apiKey.index).SetString(key)
+	return xv1.Interface()
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type apiKeyReq struct {
+	httprequest.Route `httprequest:"GET /things"`
+	Key               string `httprequest:"X-API-Key,header,apikey"`
+}
+
+var apiKeyTests = []struct {
+	about       string
+	key         string
+	expectID    string
+	expectError string
+}{{
+	about:    "current secret",
+	key:      "svc.new-secret",
+	expectID: "svc",
+}, {
+	about:    "rotated secret still valid",
+	key:      "svc.old-secret",
+	expectID: "svc",
+}, {
+	about:       "missing key",
+	expectError: "missing API key",
+}, {
+	about:       "key without id",
+	key:         "new-secret",
+	expectError: "invalid API key",
+}, {
+	about:       "unknown id",
+	key:         "other.new-secret",
+	expectError: "invalid API key",
+}, {
+	about:       "wrong secret",
+	key:         "svc.new-secreT",
+	expectError: "invalid API key",
+}}
+
+func newAPIKeyServer() *httprequest.Server {
+	return &httprequest.Server{
+		APIKeyStore: httprequest.MemAPIKeyStore{
+			"svc": {"old-secret", "new-secret"},
+		},
+	}
+}
+
+func TestServerAPIKey(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newAPIKeyServer()
+	h := srv.Handle(func(p httprequest.Params, req *apiKeyReq) (string, error) {
+		c.Check(httprequest.APIKeyIDFromContext(p.Context), qt.Equals, req.Key)
+		return req.Key, nil
+	})
+	for _, test := range apiKeyTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("GET", "/things", nil)
+			if test.key != "" {
+				req.Header.Set("X-API-Key", test.key)
+			}
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			if test.expectError != "" {
+				qthttptest.AssertJSONResponse(c, rec, http.StatusUnauthorized, &httprequest.RemoteError{
+					Code:    httprequest.CodeUnauthorized,
+					Message: test.expectError,
+				})
+				return
+			}
+			qthttptest.AssertJSONResponse(c, rec, http.StatusOK, test.expectID)
+		})
+	}
+}
+
+func TestServerAPIKeyInPath(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newAPIKeyServer()
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *struct {
+			httprequest.Route `httprequest:"GET /keys/:key/things"`
+			Key               string `httprequest:"key,path,apikey"`
+		}) (string, error) {
+			return req.Key, nil
+		}),
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/keys/svc.old-secret/things", nil))
+	qthttptest.AssertJSONResponse(c, rec, http.StatusOK, "svc")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/keys/svc.bad/things", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusUnauthorized)
+}
+
+func TestClientAPIKey(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newAPIKeyServer()
+	server := newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *apiKeyReq) (string, error) {
+			return req.Key, nil
+		}),
+	})
+	defer server.Close()
+
+	client := httprequest.Client{
+		BaseURL: server.URL,
+		APIKey:  "svc.new-secret",
+	}
+	var id string
+	req := &apiKeyReq{}
+	err := client.Call(context.Background(), req, &id)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(id, qt.Equals, "svc")
+	// The caller's parameters are not changed.
+	c.Assert(req.Key, qt.Equals, "")
+
+	// A key in the parameters takes precedence.
+	err = client.Call(context.Background(), &apiKeyReq{
+		Key: "svc.wrong",
+	}, &id)
+	c.Assert(err, qt.ErrorMatches, `Get http.*: invalid API key`)
+}
+
+func TestServerAPIKeyStoreNotSet(t *testing.T) {
+	c := qt.New(t)
+
+	var srv httprequest.Server
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, _ *apiKeyReq) {})
+	}, qt.PanicMatches, `bad handler function: route requires API key but Server.APIKeyStore is nil`)
+}
+
+func TestAPIKeyBadTag(t *testing.T) {
+	c := qt.New(t)
+
+	err := httprequest.Unmarshal(httprequest.Params{}, &struct {
+		Key string `httprequest:",body,apikey"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `bad type .*: bad tag "httprequest:\\",body,apikey\\"" in field Key: can only use apikey with path, form or header fields`)
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+type baseURLVarsKey struct{}
+
+// ContextWithBaseURLVars returns a context that holds values for the
+// placeholders in a Client's BaseURL, in addition to any already held
+// in ctx. See Client.BaseURL for details.
+func ContextWithBaseURLVars(ctx context.Context, vars map[string]string) context.Context {
+	old := baseURLVarsFromContext(ctx)
+	merged := make(map[string]string, len(old)+len(vars))
+	for k, v := range old {
+		merged[k] = v
+	}
+	for k, v := range vars {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, baseURLVarsKey{}, merged)
+}
+
+func baseURLVarsFromContext(ctx context.Context) map[string]string {
+	vars, _ := ctx.Value(baseURLVarsKey{}).(map[string]string)
+	return vars
+}
+
+// WithBaseURLVar returns a CallOption that sets the value of the
+// placeholder with the given name in the Client's BaseURL, overriding
+// any value held in the context of the call.
+func WithBaseURLVar(name, value string) CallOption {
+	return func(o *callOptions) {
+		if o.baseURLVars == nil {
+			o.baseURLVars = make(map[string]string)
+		}
+		o.baseURLVars[name] = value
+	}
+}
+
+// expandBaseURL replaces the placeholders in the given base URL with
+// their values, taken from vars or, failing that, from ctx.
+func expandBaseURL(ctx context.Context, baseURL string, vars map[string]string) (string, error) {
+	if !strings.Contains(baseURL, "{") {
+		return baseURL, nil
+	}
+	ctxVars := baseURLVarsFromContext(ctx)
+	var buf strings.Builder
+	s := baseURL
+	for {
+		i := strings.Index(s, "{")
+		if i == -1 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:i])
+		s = s[i+1:]
+		j := strings.Index(s, "}")
+		if j <= 0 {
+			return "", errgo.Newf("bad placeholder in base URL %q", baseURL)
+		}
+		name := s[:j]
+		s = s[j+1:]
+		val, ok := vars[name]
+		if !ok {
+			val, ok = ctxVars[name]
+		}
+		if !ok {
+			return "", errgo.Newf("no value for {%s} in base URL %q", name, baseURL)
+		}
+		if !validBaseURLVar(val) {
+			return "", errgo.Newf("invalid value %q for {%s} in base URL %q", val, name, baseURL)
+		}
+		buf.WriteString(val)
+	}
+	return buf.String(), nil
+}
+
+// validBaseURLVar reports whether the given placeholder value is
+// acceptable. Values are restricted to characters that are valid in a
+// host name label or path segment without escaping, so that a value
+// cannot change the host or structure of the URL.
+func validBaseURLVar(val string) bool {
+	if val == "" || val == "." || val == ".." {
+		return false
+	}
+	for i := 0; i < len(val); i++ {
+		c := val[i]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
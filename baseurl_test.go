@@ -0,0 +1,74 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type baseURLReq struct {
+	httprequest.Route `httprequest:"GET /things/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func TestBaseURLTemplate(t *testing.T) {
+	c := qt.New(t)
+
+	var got string
+	client := &httprequest.Client{
+		BaseURL: "https://{region}.api.example.com/{tenant}",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.URL.String()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+	ctx := httprequest.ContextWithBaseURLVars(context.Background(), map[string]string{
+		"region": "eu-west-1",
+	})
+	ctx = httprequest.ContextWithBaseURLVars(ctx, map[string]string{
+		"tenant": "acme",
+	})
+
+	err := client.Call(ctx, &baseURLReq{ID: "1"}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "https://eu-west-1.api.example.com/acme/things/1")
+
+	// Call options override the context.
+	err = client.CallWithOptions(ctx, &baseURLReq{ID: "2"}, nil, httprequest.WithBaseURLVar("region", "us-east-1"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "https://us-east-1.api.example.com/acme/things/2")
+
+	// Relative requests made with Do use the context.
+	err = client.Get(ctx, "/status", nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "https://eu-west-1.api.example.com/acme/status")
+
+	got = ""
+	err = client.Call(context.Background(), &baseURLReq{ID: "1"}, nil)
+	c.Assert(err, qt.ErrorMatches, `no value for \{region\} in base URL "https://\{region\}.api.example.com/\{tenant\}"`)
+
+	err = client.CallWithOptions(ctx, &baseURLReq{ID: "1"}, nil, httprequest.WithBaseURLVar("region", "evil.com/x?"))
+	c.Assert(err, qt.ErrorMatches, `invalid value "evil.com/x\?" for \{region\} in base URL .*`)
+	c.Assert(got, qt.Equals, "")
+}
+
+func TestBaseURLTemplateBadPlaceholder(t *testing.T) {
+	c := qt.New(t)
+
+	client := &httprequest.Client{
+		BaseURL: "https://{region.example.com",
+	}
+	err := client.Get(context.Background(), "/x", nil)
+	c.Assert(err, qt.ErrorMatches, `bad placeholder in base URL "https://\{region.example.com"`)
+}
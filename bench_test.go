@@ -121,6 +121,82 @@ func BenchmarkUnmarshal2Fields(b *testing.B) {
 	}
 }
 
+type testParamsQueryOnly struct {
+	Id     string  `httprequest:"id,path"`
+	Limit  int     `httprequest:"limit,form"`
+	Offset uint64  `httprequest:"offset,form"`
+	Ratio  float64 `httprequest:"ratio,form"`
+	All    bool    `httprequest:"all,form"`
+	Sort   string  `httprequest:"sort,form"`
+}
+
+var queryOnlyParams = httprequest.Params{
+	Request: &http.Request{
+		Form: url.Values{
+			"limit":  {"2000"},
+			"offset": {"100"},
+			"ratio":  {"0.5"},
+			"all":    {"true"},
+			"sort":   {"name"},
+		},
+	},
+	PathVar: httprouter.Params{{
+		Key:   "id",
+		Value: "someid",
+	}},
+}
+
+func BenchmarkUnmarshalQueryOnly(b *testing.B) {
+	var arg testParamsQueryOnly
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		arg = testParamsQueryOnly{}
+		err := httprequest.Unmarshal(queryOnlyParams, &arg)
+		if err != nil {
+			b.Fatalf("unmarshal failed: %v", err)
+		}
+	}
+	b.StopTimer()
+	if arg.Limit != 2000 || !arg.All {
+		b.Fatalf("unexpected result: got %#v", arg)
+	}
+}
+
+func BenchmarkMarshalQueryOnly(b *testing.B) {
+	arg := &testParamsQueryOnly{
+		Id:     "someid",
+		Limit:  2000,
+		Offset: 100,
+		Ratio:  0.5,
+		All:    true,
+		Sort:   "name",
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := httprequest.Marshal("http://example.com/things/:id", "GET", arg)
+		if err != nil {
+			b.Fatalf("marshal failed: %v", err)
+		}
+	}
+}
+
+func TestUnmarshalQueryOnlyDoesNotAllocate(t *testing.T) {
+	var arg testParamsQueryOnly
+	// Prime the type cache.
+	if err := httprequest.Unmarshal(queryOnlyParams, &arg); err != nil {
+		t.Fatalf("unmarshal failed: %v", err)
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		arg = testParamsQueryOnly{}
+		httprequest.Unmarshal(queryOnlyParams, &arg)
+	})
+	if allocs != 0 {
+		t.Fatalf("unexpected allocations; got %v want 0", allocs)
+	}
+}
+
 func BenchmarkHandle2FieldsTrad(b *testing.B) {
 	results := []testResult{}
 	benchmarkHandle2Fields(b, testServer.HandleJSON(func(p httprequest.Params) (interface{}, error) {
@@ -454,3 +530,46 @@ func benchmarkHandleNFields(b *testing.B, n int, handle func(w http.ResponseWrit
 		handle(rec, params.Request, params.PathVar)
 	}
 }
+
+func BenchmarkWriteJSON(b *testing.B) {
+	benchmarkWriteJSON(b, httprequest.WriteJSON)
+}
+
+func BenchmarkWriteJSONBufferPool(b *testing.B) {
+	var pool httprequest.BufferPool
+	benchmarkWriteJSON(b, pool.WriteJSON)
+}
+
+func benchmarkWriteJSON(b *testing.B, writeJSON func(w http.ResponseWriter, code int, val interface{}) error) {
+	results := make([]testResult, 100)
+	for i := range results {
+		results[i] = testResult{
+			Key:   "key" + strconv.Itoa(i),
+			Count: int64(i),
+		}
+	}
+	w := discardResponseWriter{make(http.Header)}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := writeJSON(w, http.StatusOK, results); err != nil {
+			b.Fatalf("write failed: %v", err)
+		}
+	}
+}
+
+// discardResponseWriter is an http.ResponseWriter that
+// discards everything written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (w discardResponseWriter) WriteHeader(int) {}
@@ -0,0 +1,403 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// TimeFormat specifies how time.Time values are serialized in JSON
+// bodies. It applies to all time.Time values found in a body,
+// including those in nested structs, slices and maps, but not to
+// values inside types that implement json.Marshaler or
+// encoding.TextMarshaler, which are marshaled as usual.
+//
+// Note that bodies are always unmarshaled as usual by encoding/json,
+// so a Layout other than the RFC 3339 layouts should only be used when
+// the recipient is prepared to parse it.
+type TimeFormat struct {
+	// UTC specifies that times are converted to UTC
+	// before being formatted.
+	UTC bool
+
+	// Truncate, if non-zero, specifies that times are truncated
+	// to a multiple of the given duration (for example time.Second
+	// or time.Millisecond) before being formatted.
+	Truncate time.Duration
+
+	// Layout holds the layout used to format times. It may be
+	// any of the layout names accepted by the "format" tag (see
+	// Unmarshal), including "unix" and "unixmilli", which format
+	// times as JSON numbers, or a layout as accepted by
+	// time.Time.Format. If it is empty, time.RFC3339Nano is used,
+	// as by encoding/json.
+	Layout string
+}
+
+// marshal marshals v as JSON with jc (see jsonMarshal), formatting
+// any time.Time values it contains as specified by tf. If tf is nil,
+// the values are marshaled as usual.
+func (tf *TimeFormat) marshal(v interface{}, jc Codec) ([]byte, error) {
+	v, err := tf.convert(v)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return jsonMarshal(jc, v)
+}
+
+// marshalTo is like marshal except that the JSON is appended to buf.
+// When jc is nil, this avoids allocating a new slice for the data.
+func (tf *TimeFormat) marshalTo(buf *bytes.Buffer, v interface{}, jc Codec) error {
+	v, err := tf.convert(v)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if jc != nil {
+		data, err := jc.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	}
+	if err := json.NewEncoder(buf).Encode(v); err != nil {
+		return err
+	}
+	// Remove the newline added by Encode, so that the data is
+	// the same as that produced by json.Marshal.
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+// convert returns a value that encoding/json marshals in the same way
+// as v except that time.Time values are formatted as specified by tf.
+// If tf is nil, it returns v.
+func (tf *TimeFormat) convert(v interface{}) (interface{}, error) {
+	if tf == nil {
+		return v, nil
+	}
+	layout := tf.Layout
+	if l, ok := timeLayouts[strings.ToLower(layout)]; ok {
+		layout = l
+	} else if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	conv := &timeConverter{
+		tf:     tf,
+		layout: layout,
+	}
+	return conv.convert(reflect.ValueOf(v))
+}
+
+// setRequestBody marshals the body field of x, which was marshaled
+// into req with the given request type, again using tf and jc.
+func (tf *TimeFormat) setRequestBody(req *http.Request, x interface{}, rt *requestType, jc Codec) error {
+	xv := reflect.ValueOf(x).Elem()
+	for _, f := range rt.fields {
+		if f.source != sourceBody {
+			continue
+		}
+		fv := xv.FieldByIndex(f.index)
+		if f.isPointer {
+			if fv.IsNil() {
+				return nil
+			}
+			fv = fv.Elem()
+		}
+		if fv.Type() == ndjsonReaderType {
+			// The body is streamed as it is.
+			continue
+		}
+		data, err := tf.marshal(fv.Addr().Interface(), jc)
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal request body")
+		}
+		setJSONBody(req, data)
+	}
+	return nil
+}
+
+// timeConverter converts values to a form that encoding/json marshals
+// in the same way except for the time.Time values they contain.
+type timeConverter struct {
+	tf     *TimeFormat
+	layout string
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	customHeaderType  = reflect.TypeOf(CustomHeader{})
+	interfaceType     = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+func (conv *timeConverter) convert(v reflect.Value) (interface{}, error) {
+	if !v.IsValid() {
+		return nil, nil
+	}
+	t := v.Type()
+	switch {
+	case t == timeType:
+		return conv.formatTime(v.Interface().(time.Time)), nil
+	case t == customHeaderType:
+		return conv.convert(v.FieldByName("Body"))
+	case t.Implements(jsonMarshalerType) || t.Implements(textMarshalerType):
+		return v.Interface(), nil
+	case v.CanAddr() && (reflect.PtrTo(t).Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType)):
+		return v.Addr().Interface(), nil
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil, nil
+		}
+		return conv.convert(v.Elem())
+	case reflect.Struct:
+		return conv.convertStruct(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return nil, nil
+		}
+		m := reflect.MakeMapWithSize(reflect.MapOf(t.Key(), interfaceType), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ev, err := conv.convert(iter.Value())
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			m.SetMapIndex(iter.Key(), reflect.ValueOf(&ev).Elem())
+		}
+		return m.Interface(), nil
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil, nil
+		}
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Byte slices are marshaled as base64 strings.
+			return v.Interface(), nil
+		}
+		fallthrough
+	case reflect.Array:
+		elems := make([]interface{}, v.Len())
+		for i := range elems {
+			ev, err := conv.convert(v.Index(i))
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			elems[i] = ev
+		}
+		return elems, nil
+	}
+	return v.Interface(), nil
+}
+
+func (conv *timeConverter) formatTime(t time.Time) interface{} {
+	if conv.tf.UTC {
+		t = t.UTC()
+	}
+	if conv.tf.Truncate > 0 {
+		t = t.Truncate(conv.tf.Truncate)
+	}
+	s := formatTime(conv.layout, t)
+	switch conv.layout {
+	case unixLayout, unixMilliLayout:
+		return json.Number(s)
+	}
+	return s
+}
+
+func (conv *timeConverter) convertStruct(v reflect.Value) (interface{}, error) {
+	var obj jsonObject
+	for _, f := range jsonFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyJSONValue(fv) {
+			continue
+		}
+		var mv interface{}
+		if f.quoted && !(fv.Kind() == reflect.Ptr && fv.IsNil()) {
+			data, err := json.Marshal(fv.Interface())
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			data, _ = json.Marshal(string(data))
+			mv = json.RawMessage(data)
+		} else {
+			var err error
+			mv, err = conv.convert(fv)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+		obj = append(obj, jsonMember{
+			name:  f.name,
+			value: mv,
+		})
+	}
+	return obj, nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex except that
+// it returns false rather than panicking when the index
+// goes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyJSONValue reports whether v is empty as
+// defined by the encoding/json omitempty option.
+func isEmptyJSONValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonObject is a JSON object that marshals its
+// members in order.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	name  string
+	value interface{}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (obj jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range obj {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(m.name)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		value, err := json.Marshal(m.value)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// jsonField holds a struct field as marshaled by encoding/json.
+type jsonField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	quoted    bool
+}
+
+var jsonFieldsCache sync.Map // map[reflect.Type][]jsonField
+
+// jsonFields returns the fields of the given struct type
+// in the order that encoding/json marshals them.
+func jsonFields(t reflect.Type) []jsonField {
+	if fields, ok := jsonFieldsCache.Load(t); ok {
+		return fields.([]jsonField)
+	}
+	fields := appendJSONFields(nil, t, nil, map[string]int{})
+	jsonFieldsCache.Store(t, fields)
+	return fields
+}
+
+// appendJSONFields appends the fields of the struct type t, which is
+// embedded at the given index, to fields. The names map records the
+// depth of the field already added with a given name; as for
+// encoding/json, shallower fields take precedence over deeper ones.
+func appendJSONFields(fields []jsonField, t reflect.Type, index []int, names map[string]int) []jsonField {
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if n := strings.Index(tag, ","); n >= 0 {
+			name, opts = tag[:n], tag[n+1:]
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		ft := sf.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if sf.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			fields = appendJSONFields(fields, ft, fieldIndex, names)
+			continue
+		}
+		if sf.PkgPath != "" {
+			// Unexported field.
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if depth, ok := names[name]; ok {
+			if depth <= len(index) {
+				continue
+			}
+			// Remove the deeper field.
+			for j, f := range fields {
+				if f.name == name {
+					fields = append(fields[:j], fields[j+1:]...)
+					break
+				}
+			}
+		}
+		names[name] = len(index)
+		f := jsonField{
+			name:  name,
+			index: fieldIndex,
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "omitempty":
+				f.omitEmpty = true
+			case "string":
+				switch ft.Kind() {
+				case reflect.Bool, reflect.String,
+					reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+					reflect.Float32, reflect.Float64:
+					f.quoted = true
+				}
+			}
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type timeFormatEmbedded struct {
+	Created time.Time `json:"created"`
+}
+
+type timeFormatResp struct {
+	timeFormatEmbedded
+	Name     string               `json:"name"`
+	When     time.Time            `json:"when"`
+	Optional *time.Time           `json:"optional,omitempty"`
+	Times    []time.Time          `json:"times"`
+	ByName   map[string]time.Time `json:"byName"`
+	Any      interface{}          `json:"any"`
+	Ignored  time.Time            `json:"-"`
+	Raw      []byte               `json:"raw"`
+	Count    int                  `json:"count,string"`
+}
+
+var timeFormatTime = time.Date(2020, 1, 2, 3, 4, 5, 678901234, time.FixedZone("X", 3600))
+
+var timeFormatTests = []struct {
+	about      string
+	timeFormat *httprequest.TimeFormat
+	expectTime string
+}{{
+	about:      "no time format",
+	expectTime: `"2020-01-02T03:04:05.678901234+01:00"`,
+}, {
+	about:      "default layout",
+	timeFormat: &httprequest.TimeFormat{},
+	expectTime: `"2020-01-02T03:04:05.678901234+01:00"`,
+}, {
+	about: "utc truncated to milliseconds",
+	timeFormat: &httprequest.TimeFormat{
+		UTC:      true,
+		Truncate: time.Millisecond,
+	},
+	expectTime: `"2020-01-02T02:04:05.678Z"`,
+}, {
+	about: "rfc3339 truncated to seconds",
+	timeFormat: &httprequest.TimeFormat{
+		Truncate: time.Second,
+		Layout:   "RFC3339",
+	},
+	expectTime: `"2020-01-02T03:04:05+01:00"`,
+}, {
+	about: "unix",
+	timeFormat: &httprequest.TimeFormat{
+		Layout: "unix",
+	},
+	expectTime: `1577930645`,
+}, {
+	about: "unixmilli",
+	timeFormat: &httprequest.TimeFormat{
+		Layout: "unixmilli",
+	},
+	expectTime: `1577930645678`,
+}, {
+	about: "custom layout",
+	timeFormat: &httprequest.TimeFormat{
+		UTC:    true,
+		Layout: "2006-01-02 15:04",
+	},
+	expectTime: `"2020-01-02 02:04"`,
+}}
+
+func TestServerTimeFormat(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range timeFormatTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := httprequest.Server{
+				TimeFormat: test.timeFormat,
+			}
+			h := srv.Handle(func(p httprequest.Params, _ *struct {
+				httprequest.Route `httprequest:"GET /"`
+			}) (*timeFormatResp, error) {
+				return &timeFormatResp{
+					timeFormatEmbedded: timeFormatEmbedded{
+						Created: timeFormatTime,
+					},
+					Name:    "x",
+					When:    timeFormatTime,
+					Times:   []time.Time{timeFormatTime},
+					ByName:  map[string]time.Time{"a": timeFormatTime},
+					Any:     timeFormatTime,
+					Ignored: timeFormatTime,
+					Raw:     []byte("hi"),
+					Count:   3,
+				}, nil
+			})
+			rec := httptest.NewRecorder()
+			h.Handle(rec, httptest.NewRequest("GET", "/", nil), nil)
+			c.Assert(rec.Code, qt.Equals, http.StatusOK)
+			tm := test.expectTime
+			c.Assert(rec.Body.String(), qt.Equals, `{"created":`+tm+`,"name":"x","when":`+tm+`,"times":[`+tm+`],"byName":{"a":`+tm+`},"any":`+tm+`,"raw":"aGk=","count":"3"}`)
+		})
+	}
+}
+
+func TestHandleJSONTimeFormat(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		TimeFormat: &httprequest.TimeFormat{
+			Layout: "unix",
+		},
+		BufferPool: &httprequest.BufferPool{},
+	}
+	h := srv.HandleJSON(func(p httprequest.Params) (interface{}, error) {
+		return []time.Time{timeFormatTime}, nil
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `[1577930645]`)
+}
+
+func TestClientTimeFormat(t *testing.T) {
+	c := qt.New(t)
+
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := ioutil.ReadAll(req.Body)
+		body = string(data)
+	}))
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		TimeFormat: &httprequest.TimeFormat{
+			UTC:      true,
+			Truncate: time.Second,
+		},
+	}
+	type item struct {
+		Name string
+		When time.Time
+	}
+	err := client.Call(context.Background(), &struct {
+		httprequest.Route `httprequest:"POST /items"`
+		Body              item `httprequest:",body"`
+	}{
+		Body: item{
+			Name: "x",
+			When: timeFormatTime,
+		},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(body, qt.Equals, `{"Name":"x","When":"2020-01-02T02:04:05Z"}`)
+}
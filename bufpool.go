@@ -0,0 +1,132 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// defaultMaxBufferSize holds the default value of
+// BufferPool.MaxBufferSize.
+const defaultMaxBufferSize = 64 * 1024
+
+// BufferPool is a pool of buffers used to encode JSON bodies, which
+// reduces the memory allocated by a busy server or client. It is
+// enabled by setting the Server.BufferPool or Client.BufferPool
+// field, and the same pool may be used by several servers and
+// clients at once. The zero BufferPool is ready to use.
+type BufferPool struct {
+	// MaxBufferSize holds the capacity above which a buffer is not
+	// returned to the pool, so that an occasional large body does
+	// not keep a large amount of memory in use. If it is zero,
+	// 64KiB is used. If it is negative, buffers are never
+	// returned to the pool, which disables pooling.
+	MaxBufferSize int
+
+	pool sync.Pool
+}
+
+// WriteJSON is like the WriteJSON function except that the value is
+// encoded into a buffer from p.
+func (p *BufferPool) WriteJSON(w http.ResponseWriter, code int, val interface{}) error {
+	return writeJSON(w, code, val, nil, nil, p)
+}
+
+// get returns an empty buffer from the pool, or a new buffer if
+// there is none.
+func (p *BufferPool) get() *bytes.Buffer {
+	if buf, ok := p.pool.Get().(*bytes.Buffer); ok {
+		return buf
+	}
+	return new(bytes.Buffer)
+}
+
+// put returns buf to the pool unless it is too large.
+func (p *BufferPool) put(buf *bytes.Buffer) {
+	max := p.MaxBufferSize
+	if max == 0 {
+		max = defaultMaxBufferSize
+	}
+	if buf.Cap() > max {
+		return
+	}
+	buf.Reset()
+	p.pool.Put(buf)
+}
+
+// pooledBody holds a request body encoded into a buffer from a
+// BufferPool. The transport that sends a request may read its body
+// after the call has returned, and a retried or redirected request
+// reads it again through GetBody, so the buffer is returned to the
+// pool only when the call has finished with it and every reader of it
+// has been closed.
+type pooledBody struct {
+	pool *BufferPool
+
+	mu       sync.Mutex
+	buf      *bytes.Buffer
+	refs     int
+	released bool
+}
+
+// newPooledBody returns a pooledBody holding buf, with a single
+// reference held by the caller.
+func newPooledBody(pool *BufferPool, buf *bytes.Buffer) *pooledBody {
+	return &pooledBody{
+		pool: pool,
+		buf:  buf,
+		refs: 1,
+	}
+}
+
+// reader returns a new reader of the body. It is suitable for use as
+// http.Request.GetBody.
+func (b *pooledBody) reader() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.released {
+		return nil, errgo.New("request body no longer available")
+	}
+	b.refs++
+	return &pooledBodyReader{
+		Reader: bytes.NewReader(b.buf.Bytes()),
+		body:   b,
+	}, nil
+}
+
+// release drops a reference to the body, returning its buffer to the
+// pool when there are none left. It does nothing if b is nil.
+func (b *pooledBody) release() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refs--
+	if b.refs > 0 {
+		return
+	}
+	b.released = true
+	b.pool.put(b.buf)
+	b.buf = nil
+}
+
+// pooledBodyReader reads a pooledBody, releasing its reference to it
+// when closed.
+type pooledBodyReader struct {
+	*bytes.Reader
+	body *pooledBody
+	once sync.Once
+}
+
+// Close implements io.Closer.Close.
+func (r *pooledBodyReader) Close() error {
+	r.once.Do(r.body.release)
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type bufPoolReq struct {
+	httprequest.Route `httprequest:"PUT /things/:name"`
+	Name              string `httprequest:"name,path"`
+	Body              struct {
+		Value string `json:"value"`
+	} `httprequest:",body"`
+}
+
+type bufPoolResp struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func newBufPoolServer(pool *httprequest.BufferPool) *httptest.Server {
+	srv := &httprequest.Server{
+		BufferPool: pool,
+	}
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *bufPoolReq) (*bufPoolResp, error) {
+			if req.Name == "bad" {
+				return nil, httprequest.Errorf(httprequest.CodeBadRequest, "bad <name>")
+			}
+			return &bufPoolResp{
+				Name:  req.Name,
+				Value: req.Body.Value,
+			}, nil
+		}),
+	})
+}
+
+func TestServerBufferPool(t *testing.T) {
+	c := qt.New(t)
+
+	var bodies [2][]string
+	for i, pool := range []*httprequest.BufferPool{nil, {}} {
+		srv := newBufPoolServer(pool)
+		for _, name := range []string{"a", "bad", "a"} {
+			req, err := http.NewRequest("PUT", srv.URL+"/things/"+name, strings.NewReader(`{"value":"<x>"}`))
+			c.Assert(err, qt.IsNil)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.IsNil)
+			data, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			c.Assert(err, qt.IsNil)
+			c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+			bodies[i] = append(bodies[i], string(data))
+		}
+		srv.Close()
+	}
+	// The responses are the same whether or not a pool is used.
+	c.Assert(bodies[1], qt.DeepEquals, bodies[0])
+	c.Assert(bodies[1], qt.DeepEquals, []string{
+		`{"name":"a","value":"\u003cx\u003e"}`,
+		`{"Message":"bad \u003cname\u003e","Code":"bad request"}`,
+		`{"name":"a","value":"\u003cx\u003e"}`,
+	})
+}
+
+func TestBufferPoolWriteJSON(t *testing.T) {
+	c := qt.New(t)
+
+	var pool httprequest.BufferPool
+	rec := httptest.NewRecorder()
+	err := pool.WriteJSON(rec, http.StatusTeapot, httprequest.CustomHeader{
+		Body: []int{1, 2},
+		SetHeaderFunc: func(h http.Header) {
+			h.Set("X-Test", "yes")
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(rec.Code, qt.Equals, http.StatusTeapot)
+	c.Assert(rec.Header().Get("X-Test"), qt.Equals, "yes")
+	c.Assert(rec.Body.String(), qt.Equals, `[1,2]`)
+
+	err = pool.WriteJSON(httptest.NewRecorder(), http.StatusOK, func() {})
+	c.Assert(err, qt.ErrorMatches, `json: unsupported type: func\(\)`)
+}
+
+func TestBufferPoolMaxBufferSize(t *testing.T) {
+	c := qt.New(t)
+
+	pool := &httprequest.BufferPool{
+		MaxBufferSize: 10,
+	}
+	buf := httprequest.BufferPoolGet(pool)
+	buf.WriteString("more than ten bytes")
+	httprequest.BufferPoolPut(pool, buf)
+	buf1 := httprequest.BufferPoolGet(pool)
+	c.Assert(buf1, qt.Not(qt.Equals), buf)
+	c.Assert(buf1.Len(), qt.Equals, 0)
+
+	pool = &httprequest.BufferPool{
+		MaxBufferSize: -1,
+	}
+	buf = httprequest.BufferPoolGet(pool)
+	httprequest.BufferPoolPut(pool, buf)
+	c.Assert(httprequest.BufferPoolGet(pool), qt.Not(qt.Equals), buf)
+}
+
+func TestClientBufferPool(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newBufPoolServer(nil)
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:    srv.URL,
+		BufferPool: &httprequest.BufferPool{},
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &bufPoolReq{
+				Name: "n" + strconv.Itoa(i),
+			}
+			req.Body.Value = strings.Repeat("x", i*100)
+			var resp bufPoolResp
+			err := client.Call(context.Background(), req, &resp)
+			c.Check(err, qt.IsNil)
+			c.Check(resp, qt.DeepEquals, bufPoolResp{
+				Name:  req.Name,
+				Value: req.Body.Value,
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestClientBufferPoolBodyReleased(t *testing.T) {
+	c := qt.New(t)
+
+	var doneReq *http.Request
+	var sent []string
+	client := &httprequest.Client{
+		BaseURL:    "http://example.com",
+		BufferPool: &httprequest.BufferPool{},
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			// Read the body twice, as a transport might when
+			// retrying a request.
+			for i := 0; i < 2; i++ {
+				body := req.Body
+				if i > 0 {
+					var err error
+					body, err = req.GetBody()
+					c.Assert(err, qt.IsNil)
+				}
+				data, err := ioutil.ReadAll(body)
+				c.Assert(err, qt.IsNil)
+				body.Close()
+				sent = append(sent, string(data))
+			}
+			doneReq = req
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       ioutil.NopCloser(bytes.NewReader([]byte(`{"name":"x"}`))),
+				Request:    req,
+			}, nil
+		}),
+	}
+	req := &bufPoolReq{
+		Name: "x",
+	}
+	req.Body.Value = "v"
+	var resp bufPoolResp
+	err := client.Call(context.Background(), req, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(sent, qt.DeepEquals, []string{`{"value":"v"}`, `{"value":"v"}`})
+
+	// Once the call has finished with the body and the readers
+	// of it have been closed, it cannot be read again.
+	_, err = doneReq.GetBody()
+	c.Assert(err, qt.ErrorMatches, `request body no longer available`)
+}
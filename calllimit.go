@@ -0,0 +1,107 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrCallQueueTimeout is used as the cause of the error returned by a
+// Client call that could not start within CallLimiter.QueueTimeout.
+var ErrCallQueueTimeout = errgo.New("timed out waiting to make call")
+
+// CallLimiter limits the number of calls that a Client has in flight
+// at once and keeps a count of the calls in flight to each endpoint
+// (see SLOTracker for how endpoints are identified). A call is in
+// flight from when its request is sent until its response has been
+// read; when the response is returned to the caller as an
+// *http.Response, that is until its body is closed.
+//
+// A CallLimiter is enabled by setting the Client.CallLimiter field.
+// The same CallLimiter may be used by several clients, in which case
+// the limit applies to all of them together.
+type CallLimiter struct {
+	// MaxInFlight holds the maximum number of calls that may be
+	// in flight at once. Further calls wait until an earlier one
+	// has finished. If it is zero or negative, the number of
+	// calls is not limited but they are still counted.
+	MaxInFlight int
+
+	// QueueTimeout holds the maximum time that a call waits to
+	// start. A call that waits longer fails with an error with an
+	// ErrCallQueueTimeout cause. If it is zero, a call waits until
+	// its context is done.
+	QueueTimeout time.Duration
+
+	mu       sync.Mutex
+	sem      chan struct{}
+	inFlight map[string]int
+}
+
+// InFlight returns the number of calls currently in flight
+// to each endpoint. Endpoints with no calls in flight are omitted.
+func (l *CallLimiter) InFlight() map[string]int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	m := make(map[string]int, len(l.inFlight))
+	for endpoint, n := range l.inFlight {
+		m[endpoint] = n
+	}
+	return m
+}
+
+// acquire waits until a call to the given endpoint may be made and
+// returns a function that must be called when the call has finished.
+func (l *CallLimiter) acquire(ctx context.Context, endpoint string) (release func(), err error) {
+	sem := l.semaphore()
+	if sem != nil {
+		var timeout <-chan time.Time
+		if l.QueueTimeout > 0 {
+			t := time.NewTimer(l.QueueTimeout)
+			defer t.Stop()
+			timeout = t.C
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-timeout:
+			return nil, errgo.WithCausef(nil, ErrCallQueueTimeout, "timed out after %v waiting to call %s", l.QueueTimeout, endpoint)
+		case <-ctx.Done():
+			return nil, errgo.NoteMask(ctx.Err(), "cannot call "+endpoint, errgo.Any)
+		}
+	}
+	l.mu.Lock()
+	if l.inFlight == nil {
+		l.inFlight = make(map[string]int)
+	}
+	l.inFlight[endpoint]++
+	l.mu.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			if l.inFlight[endpoint]--; l.inFlight[endpoint] <= 0 {
+				delete(l.inFlight, endpoint)
+			}
+			l.mu.Unlock()
+			if sem != nil {
+				<-sem
+			}
+		})
+	}, nil
+}
+
+// semaphore returns the channel used to limit the calls in flight,
+// or nil if they are not limited.
+func (l *CallLimiter) semaphore() chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.sem == nil && l.MaxInFlight > 0 {
+		l.sem = make(chan struct{}, l.MaxInFlight)
+	}
+	return l.sem
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type limitedReq struct {
+	httprequest.Route `httprequest:"GET /limited/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func TestCallLimiter(t *testing.T) {
+	c := qt.New(t)
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	limiter := &httprequest.CallLimiter{
+		MaxInFlight:  2,
+		QueueTimeout: 50 * time.Millisecond,
+	}
+	client := &httprequest.Client{
+		BaseURL:     "http://example.com",
+		CallLimiter: limiter,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			started <- struct{}{}
+			<-unblock
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+	ctx := context.Background()
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- client.Call(ctx, &limitedReq{ID: "x"}, nil)
+		}()
+		<-started
+	}
+	c.Assert(limiter.InFlight(), qt.DeepEquals, map[string]int{
+		"GET /limited/:id": 2,
+	})
+
+	// A third call times out waiting for a slot.
+	err := client.Call(ctx, &limitedReq{ID: "y"}, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrCallQueueTimeout)
+	c.Assert(err, qt.ErrorMatches, `timed out after 50ms waiting to call GET /limited/:id`)
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		c.Assert(<-done, qt.IsNil)
+	}
+	c.Assert(limiter.InFlight(), qt.DeepEquals, map[string]int{})
+}
+
+func TestCallLimiterResponseBody(t *testing.T) {
+	c := qt.New(t)
+
+	limiter := &httprequest.CallLimiter{
+		MaxInFlight: 1,
+	}
+	client := &httprequest.Client{
+		CallLimiter: limiter,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+	ctx := context.Background()
+	var resp *http.Response
+	err := client.Get(ctx, "http://example.com/stream", &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(limiter.InFlight(), qt.DeepEquals, map[string]int{
+		"GET /stream": 1,
+	})
+
+	// The slot is still held, so another call waits until its
+	// context is done.
+	ctx1, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = client.Get(ctx1, "http://example.com/other", nil)
+	c.Assert(errgo.Cause(err), qt.Equals, context.DeadlineExceeded)
+
+	resp.Body.Close()
+	resp.Body.Close()
+	c.Assert(limiter.InFlight(), qt.DeepEquals, map[string]int{})
+	err = client.Get(ctx, "http://example.com/other", nil)
+	c.Assert(err, qt.IsNil)
+}
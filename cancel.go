@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// CanceledError is the cause of the error returned by a Client call
+// that fails because its context was canceled or reached its deadline
+// before a response was received. It distinguishes such failures
+// from errors returned by the server.
+//
+// When a call is canceled, the connection it was using is closed
+// rather than returned to the connection pool, because it may hold a
+// partially sent request.
+type CanceledError struct {
+	// Err holds the error returned when sending the request.
+	Err error
+
+	// ContextErr holds the error from the context of the call
+	// (context.Canceled or context.DeadlineExceeded).
+	ContextErr error
+
+	// BodySent holds the number of bytes of the request body
+	// that had been sent when the call was canceled.
+	BodySent int64
+
+	// BodyComplete reports whether the whole request body had
+	// been sent when the call was canceled.
+	BodyComplete bool
+}
+
+// Error implements the error interface.
+func (e *CanceledError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the context error, so that errors.Is
+// can be used to check for context.Canceled or
+// context.DeadlineExceeded.
+func (e *CanceledError) Unwrap() error {
+	return e.ContextErr
+}
+
+// sentCounter wraps a request body and counts the bytes
+// that have been read from it. It is safe to check while
+// the transport is reading it.
+type sentCounter struct {
+	io.ReadCloser
+	n   int64
+	eof int32
+}
+
+func (r *sentCounter) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	atomic.AddInt64(&r.n, int64(n))
+	if err == io.EOF {
+		atomic.StoreInt32(&r.eof, 1)
+	}
+	return n, err
+}
+
+// countBody returns a shallow copy of req with the body replaced with
+// a sentCounter, which is also returned. If req has no body, it returns
+// req and a nil sentCounter.
+func countBody(req *http.Request) (*http.Request, *sentCounter) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req, nil
+	}
+	body := &sentCounter{
+		ReadCloser: req.Body,
+	}
+	req1 := *req
+	req1.Body = body
+	return &req1, body
+}
+
+// canceledError returns the error for a call with the given request
+// that failed with err because ctx is done, and calls c.OnAbort if the
+// request body was not completely sent.
+func (c *Client) canceledError(ctx context.Context, req *http.Request, body *sentCounter, err error) *CanceledError {
+	cerr := &CanceledError{
+		Err:          urlError(err, req),
+		ContextErr:   ctx.Err(),
+		BodyComplete: true,
+	}
+	if body != nil {
+		cerr.BodySent = atomic.LoadInt64(&body.n)
+		cerr.BodyComplete = atomic.LoadInt32(&body.eof) != 0 || req.ContentLength > 0 && cerr.BodySent >= req.ContentLength
+	}
+	if !cerr.BodyComplete && c.OnAbort != nil {
+		c.OnAbort(req, cerr)
+	}
+	return cerr
+}
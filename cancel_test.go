@@ -0,0 +1,107 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestClientCanceledMidBody(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+	}))
+	defer srv.Close()
+
+	var aborted *httprequest.CanceledError
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		OnAbort: func(req *http.Request, err *httprequest.CanceledError) {
+			aborted = err
+		},
+	}
+	// The body stalls after its first five bytes. The transport
+	// closes it when the call is canceled.
+	pr, pw := io.Pipe()
+	req, err := http.NewRequest("PUT", "/upload", pr)
+	c.Assert(err, qt.Equals, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		pw.Write([]byte("hello"))
+		cancel()
+	}()
+	err = client.Do(ctx, req, nil)
+	c.Assert(err, qt.ErrorMatches, `Put "?http.*/upload"?: .*`)
+	cerr, ok := errgo.Cause(err).(*httprequest.CanceledError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("cause %#v", errgo.Cause(err)))
+	c.Assert(cerr.ContextErr, qt.Equals, context.Canceled)
+	c.Assert(cerr.BodySent, qt.Equals, int64(5))
+	c.Assert(cerr.BodyComplete, qt.Equals, false)
+	c.Assert(errors.Is(cerr, context.Canceled), qt.Equals, true)
+	c.Assert(aborted, qt.Equals, cerr)
+}
+
+func TestClientCanceledAfterBody(t *testing.T) {
+	c := qt.New(t)
+
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ioutil.ReadAll(req.Body)
+		<-unblock
+	}))
+	defer srv.Close()
+	defer close(unblock)
+
+	aborted := false
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		OnAbort: func(req *http.Request, err *httprequest.CanceledError) {
+			aborted = true
+		},
+	}
+	req, err := http.NewRequest("PUT", "/upload", strings.NewReader("hello"))
+	c.Assert(err, qt.Equals, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Do(ctx, req, nil)
+	cerr, ok := errgo.Cause(err).(*httprequest.CanceledError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("cause %#v", errgo.Cause(err)))
+	c.Assert(cerr.ContextErr, qt.Equals, context.DeadlineExceeded)
+	c.Assert(cerr.BodySent, qt.Equals, int64(5))
+	c.Assert(cerr.BodyComplete, qt.Equals, true)
+	c.Assert(aborted, qt.Equals, false)
+}
+
+func TestClientServerErrorNotCanceled(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Close the connection without responding.
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.Get(context.Background(), "/", nil)
+	c.Assert(err, qt.Not(qt.IsNil))
+	_, ok := errgo.Cause(err).(*httprequest.CanceledError)
+	c.Assert(ok, qt.Equals, false)
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Capabilities describes the operations available on a resource. It is
+// sent in response to OPTIONS requests by the handlers added by
+// Server.DeriveHandlers when Server.OptionsCapabilities is set, and
+// can be retrieved with Client.Capabilities.
+type Capabilities struct {
+	// Path holds the path pattern of the resource.
+	Path string `json:",omitempty"`
+
+	// Methods holds the capabilities of each method allowed on
+	// the resource, sorted by method.
+	Methods []MethodCapabilities
+
+	// Schema holds the URL of a schema describing the resource,
+	// if known.
+	Schema string `json:",omitempty"`
+}
+
+// MethodCapabilities describes a method allowed on a resource.
+type MethodCapabilities struct {
+	// Method holds the HTTP method.
+	Method string
+
+	// Accept holds the content types accepted in request
+	// bodies, if the method takes a body.
+	Accept []string `json:",omitempty"`
+
+	// Produces holds the content types of successful
+	// responses, if the method returns a body.
+	Produces []string `json:",omitempty"`
+
+	// Scopes holds the scopes required to call the method,
+	// if any (see Server.Handle).
+	Scopes []string `json:",omitempty"`
+}
+
+// routeState holds the capabilities of the routes of the handlers
+// created by a server, keyed by method and path pattern.
+type routeState struct {
+	mu     sync.Mutex
+	routes map[string]MethodCapabilities
+}
+
+var routeMu sync.Mutex
+
+// routeState returns the state used to record the capabilities of
+// srv's routes, creating it if necessary.
+func (srv *Server) routeState() *routeState {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	if srv.routes == nil {
+		srv.routes = &routeState{
+			routes: make(map[string]MethodCapabilities),
+		}
+	}
+	return srv.routes
+}
+
+// recordRoute records the capabilities of the route
+// handled by hf for use by DeriveHandlers.
+func (srv *Server) recordRoute(hf handlerFunc) {
+	mc := MethodCapabilities{
+		Method: hf.method,
+		Scopes: hf.scopes,
+	}
+	if hf.argType != nil {
+		if rt, err := getRequestType(reflect.PtrTo(hf.argType)); err == nil {
+			mc.Accept = acceptedContentTypes(rt)
+		}
+	}
+	if hf.resultType != nil && hf.resultType != reflect.TypeOf(CustomResponse{}) && hf.resultType != reflect.TypeOf(&CustomResponse{}) {
+		if len(srv.Codecs) == 0 {
+			mc.Produces = []string{"application/json"}
+		}
+		for _, c := range srv.Codecs {
+			mc.Produces = append(mc.Produces, c.ContentType())
+		}
+	}
+	st := srv.routeState()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.routes[hf.method+" "+hf.pathPattern] = mc
+}
+
+// acceptedContentTypes returns the content types of the request
+// bodies accepted by a route with the given request type.
+func acceptedContentTypes(rt *requestType) []string {
+	if rt.formBody {
+		return []string{"application/x-www-form-urlencoded"}
+	}
+	for _, f := range rt.fields {
+		if f.source != sourceBody {
+			continue
+		}
+		if f.tag.mergePatch {
+			return []string{mergePatchContentType}
+		}
+		return []string{"application/json"}
+	}
+	return nil
+}
+
+// capabilities returns the capabilities of the resource with the
+// given path pattern, which allows the given methods.
+func (srv *Server) capabilities(path string, methods []string) *Capabilities {
+	caps := &Capabilities{
+		Path: path,
+	}
+	st := srv.routeState()
+	st.mu.Lock()
+	for _, m := range methods {
+		mc, ok := st.routes[m+" "+path]
+		if !ok {
+			mc = MethodCapabilities{
+				Method: m,
+			}
+		}
+		caps.Methods = append(caps.Methods, mc)
+	}
+	st.mu.Unlock()
+	if srv.SchemaURL != nil {
+		caps.Schema = srv.SchemaURL(path)
+	}
+	return caps
+}
+
+// Capabilities returns the capabilities of the resource at the given
+// path, relative to c.BaseURL unless it is absolute, by making an
+// OPTIONS request. If the server does not describe its capabilities
+// in the response body, the methods are taken from the Allow header.
+func (c *Client) Capabilities(ctx context.Context, path string) (*Capabilities, error) {
+	req, err := http.NewRequest("OPTIONS", path, nil)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot make request")
+	}
+	req.Header.Set("Accept", "application/json")
+	var resp *http.Response
+	if err := c.Do(ctx, req, &resp); err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	defer resp.Body.Close()
+	var caps Capabilities
+	if !isEmptyBody(resp) {
+		if err := UnmarshalJSONResponse(resp, &caps); err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		return &caps, nil
+	}
+	for _, m := range strings.Split(resp.Header.Get("Allow"), ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			caps.Methods = append(caps.Methods, MethodCapabilities{
+				Method: m,
+			})
+		}
+	}
+	sort.Slice(caps.Methods, func(i, j int) bool {
+		return caps.Methods[i].Method < caps.Methods[j].Method
+	})
+	return &caps, nil
+}
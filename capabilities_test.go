@@ -0,0 +1,137 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type capabilitiesHandlers struct{}
+
+func (capabilitiesHandlers) List(_ *struct {
+	httprequest.Route `httprequest:"GET /things"`
+}) ([]string, error) {
+	return nil, nil
+}
+
+func (capabilitiesHandlers) Create(_ *struct {
+	httprequest.Route `httprequest:"POST /things" scope:"write"`
+	Body              struct{ Name string } `httprequest:",body"`
+}) error {
+	return nil
+}
+
+func (capabilitiesHandlers) Update(_ *struct {
+	httprequest.Route `httprequest:"PATCH /things/:id"`
+	ID                string `httprequest:"id,path"`
+	Body              struct {
+		Name        string
+		PatchFields httprequest.PatchFields
+	} `httprequest:",body,mergepatch"`
+}) error {
+	return nil
+}
+
+func (capabilitiesHandlers) Rename(_ *struct {
+	httprequest.Route `httprequest:"POST /things/:id"`
+	ID                string `httprequest:"id,path"`
+	Name              string `httprequest:"name,form,inbody"`
+}) error {
+	return nil
+}
+
+func newCapabilitiesServer(srv *httprequest.Server) *httptest.Server {
+	hs := srv.Handlers(func(p httprequest.Params) (capabilitiesHandlers, context.Context, error) {
+		return capabilitiesHandlers{}, p.Context, nil
+	})
+	return newHandlerServer(srv.DeriveHandlers(hs))
+}
+
+func TestClientCapabilities(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		OptionsCapabilities: true,
+		Scopes: func(context.Context, *http.Request) ([]string, error) {
+			return nil, nil
+		},
+		SchemaURL: func(path string) string {
+			return "https://schemas.example.com" + path
+		},
+	}
+	hsrv := newCapabilitiesServer(srv)
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	caps, err := client.Capabilities(context.Background(), "/things")
+	c.Assert(err, qt.IsNil)
+	c.Assert(caps, qt.DeepEquals, &httprequest.Capabilities{
+		Path: "/things",
+		Methods: []httprequest.MethodCapabilities{{
+			Method:   "GET",
+			Produces: []string{"application/json"},
+		}, {
+			Method: "HEAD",
+		}, {
+			Method: "OPTIONS",
+		}, {
+			Method: "POST",
+			Accept: []string{"application/json"},
+			Scopes: []string{"write"},
+		}},
+		Schema: "https://schemas.example.com/things",
+	})
+
+	caps, err = client.Capabilities(context.Background(), "/things/1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(caps.Methods, qt.DeepEquals, []httprequest.MethodCapabilities{{
+		Method: "OPTIONS",
+	}, {
+		Method: "PATCH",
+		Accept: []string{"application/merge-patch+json"},
+	}, {
+		Method: "POST",
+		Accept: []string{"application/x-www-form-urlencoded"},
+	}})
+
+	resp, err := http.DefaultClient.Do(mustNewRequest(hsrv.URL+"/things", "OPTIONS", nil))
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Link"), qt.Equals, `<https://schemas.example.com/things>; rel="describedby"`)
+}
+
+func TestClientCapabilitiesFromAllowHeader(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv := newCapabilitiesServer(&httprequest.Server{
+		Scopes: func(context.Context, *http.Request) ([]string, error) {
+			return nil, nil
+		},
+	})
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	caps, err := client.Capabilities(context.Background(), "/things")
+	c.Assert(err, qt.IsNil)
+	c.Assert(caps, qt.DeepEquals, &httprequest.Capabilities{
+		Methods: []httprequest.MethodCapabilities{
+			{Method: "GET"},
+			{Method: "HEAD"},
+			{Method: "OPTIONS"},
+			{Method: "POST"},
+		},
+	})
+}
@@ -14,7 +14,9 @@ import (
 	"net/url"
 	"reflect"
 	"strings"
+	"time"
 
+	"golang.org/x/oauth2"
 	"gopkg.in/errgo.v1"
 )
 
@@ -35,6 +37,14 @@ type DoerWithContext interface {
 type Client struct {
 	// BaseURL holds the base URL to use when making
 	// HTTP requests.
+	//
+	// It may contain placeholders of the form {name}, for example
+	// "https://{region}.api.example.com", so that one Client can
+	// be used for several tenants or regions. The values are taken
+	// from the WithBaseURLVar options of a call or from the context
+	// (see ContextWithBaseURLVars), and the call fails if any value
+	// is missing or contains characters other than ASCII letters,
+	// digits, '-', '_' and '.'.
 	BaseURL string
 
 	// Doer holds a value that will be used to actually
@@ -49,6 +59,200 @@ type Client struct {
 	// way to create an UnmarshalError function for a given type. If
 	// this is nil, DefaultErrorUnmarshaler will be used.
 	UnmarshalError func(resp *http.Response) error
+
+	// MaxErrorBodySize, if positive, holds the maximum number of
+	// bytes of the body of an error response that are read by the
+	// client, so that a very large error page cannot use large
+	// amounts of memory. UnmarshalError sees only that many bytes
+	// of the body, so an error that does not fit results in a
+	// *DecodeResponseError holding the truncated body.
+	MaxErrorBodySize int
+
+	// InternalHeaderPrefix, if non-empty, holds the prefix of the
+	// names of internal headers (see Server.InternalHeaderPrefix).
+	// Requests that set such headers are refused with an error
+	// with an ErrInternalHeader cause unless AllowInternalHeaders
+	// is set. Instead, the internal headers held in the context of
+	// a call (see ContextWithInternalHeaders) are added to the
+	// request, so that a handler propagates the internal headers of
+	// the request it is handling.
+	InternalHeaderPrefix string
+
+	// AllowInternalHeaders specifies that requests may set
+	// internal headers themselves. Headers set by the request
+	// take precedence over those held in the context.
+	AllowInternalHeaders bool
+
+	// HARRecorder, if non-nil, is used to record the calls made
+	// by the client as HAR entries.
+	HARRecorder *HARRecorder
+
+	// SSRFGuard, if non-nil, is used to check the destination
+	// of each request. If Doer is nil, the guard is also used to
+	// check the addresses that are connected to; otherwise only
+	// the URL is checked, and Doer should use a transport
+	// returned by SSRFGuard.Transport.
+	SSRFGuard *SSRFGuard
+
+	// Signer, if non-nil, is used to sign each request
+	// after it has been marshaled and before it is sent.
+	Signer Signer
+
+	// TokenSource, if non-nil, is used to obtain an OAuth2 token
+	// that is added to the Authorization header of each request.
+	// If the server responds with a 401 Unauthorized status, a new
+	// token is obtained and the request is retried once. If
+	// TokenSource implements TokenRefresher, RefreshToken is used
+	// to obtain the new token; otherwise Token is called again and
+	// the request is retried only if the token has changed.
+	TokenSource oauth2.TokenSource
+
+	// MaxResponseSize, if positive, holds the maximum size in bytes
+	// of a response body. If a response body is larger than this,
+	// the error returned has a *ResponseTooLargeError cause. This
+	// can be overridden for individual calls with
+	// WithMaxResponseSize.
+	MaxResponseSize int64
+
+	// IdempotencyKey, if non-nil, is called to generate an
+	// idempotency key for each POST or PUT request that does not
+	// already have an Idempotency-Key header. The same key is
+	// used if the request is retried by the client. See
+	// NewIdempotencyKey and IdempotencyGuard.
+	IdempotencyKey func() string
+
+	// RequireResponseBody specifies that a successful response with
+	// an empty body is an error when a response value is being
+	// unmarshaled. By default, an empty response (for example one
+	// with a 204 No Content status) is treated as success and leaves
+	// the response value unchanged.
+	RequireResponseBody bool
+
+	// CanonicalQuery specifies that the query parameters of each
+	// request are rewritten in the canonical form returned by
+	// CanonicalQuery before the request is signed and sent. This
+	// can be used when talking to services that compute signatures
+	// over the exact query string.
+	CanonicalQuery bool
+
+	// SLOTracker, if non-nil, is used to keep counts of the
+	// successful and failed calls made by the client.
+	SLOTracker *SLOTracker
+
+	// CallLimiter, if non-nil, is used to limit the number of
+	// calls in flight at once and to count them by endpoint.
+	CallLimiter *CallLimiter
+
+	// TimeFormat, if non-nil, specifies how time.Time values
+	// are serialized in the JSON request bodies sent by Call.
+	TimeFormat *TimeFormat
+
+	// JSON, if non-nil, is used in place of encoding/json to
+	// marshal the JSON request bodies sent by Call and to
+	// unmarshal JSON responses. See NewJSONCodec. Error
+	// responses are unmarshaled by UnmarshalError.
+	JSON Codec
+
+	// BufferPool, if non-nil, holds a pool of buffers used to
+	// marshal the JSON request bodies sent by Call instead of
+	// allocating a new buffer for each request. It is not used
+	// when TimeFormat or JSON is set.
+	BufferPool *BufferPool
+
+	// APIKey holds the API key used by Call to fill in any empty
+	// field with the "apikey" attribute (see Unmarshal) in the
+	// request parameters.
+	APIKey string
+
+	// OnAbort, if non-nil, is called when a call is abandoned
+	// because its context is done before the request body has been
+	// completely sent, for example to clean up a partially uploaded
+	// resource. The error describes how much of the body was sent.
+	OnAbort func(req *http.Request, err *CanceledError)
+
+	// OnDeprecation, if non-nil, is called when the response to
+	// a request has a Deprecation, Sunset or Link rel="deprecation"
+	// header, so that callers of deprecated endpoints can be
+	// warned. See Lifecycle.
+	OnDeprecation func(req *http.Request, l Lifecycle)
+
+	// ForwardRequestID specifies that the request ID held in the
+	// context of a call (see RequestIDFromContext) is sent in the
+	// X-Request-ID header of the request, so that a call made by a
+	// handler carries the ID of the request being handled.
+	ForwardRequestID bool
+
+	// ForwardTraceContext specifies that the trace context held in
+	// the context of a call (see TraceContextFromContext) is sent in
+	// the traceparent and tracestate headers of the request, with
+	// the current span as the parent, so that a call made by a
+	// handler is part of the trace of the request being handled.
+	ForwardTraceContext bool
+
+	// Priority, if non-nil, holds the priority sent in the Priority
+	// header of requests that have none (see RFC 9218). A priority
+	// specified with the priority tag of a Route field overrides
+	// it, and one held in the context of a call (see
+	// ContextWithPriority) overrides both.
+	Priority *Priority
+
+	// MediaType holds the vendor media type of the API, for
+	// example "application/vnd.myapp". If it is set, calls to
+	// routes with a version tag ask for that version in their
+	// Accept header (see VersionMediaType) unless it is set
+	// already.
+	MediaType string
+
+	// MaxReadModifyWriteAttempts holds the maximum number of
+	// times that ReadModifyWrite tries to update a resource
+	// that is being modified concurrently. If it is zero, 3 is
+	// used.
+	MaxReadModifyWriteAttempts int
+
+	// FaultInjector, if non-nil, is used to inject faults into
+	// requests made by the client, for testing how the caller
+	// copes with a misbehaving server. Calls made with Call use
+	// the faults for the method and path pattern of their
+	// Route; other requests use the faults for their method
+	// and URL path.
+	FaultInjector *FaultInjector
+
+	// Codecs holds the encodings that the client accepts for
+	// successful responses. If it is non-empty, requests that have
+	// no Accept header are sent with one listing the content types
+	// of the codecs in order of preference, and a response is
+	// decoded with the codec that matches its Content-Type. A
+	// response that matches none of them is decoded as JSON.
+	Codecs []Codec
+
+	// Now returns the current time, used to find the delay
+	// requested by a Retry-After header holding a date (see
+	// WithRetry). If it is nil, time.Now is used.
+	Now func() time.Time
+
+	// retry holds the retry policy for a call, set
+	// with WithRetry.
+	retry *RetryPolicy
+
+	// decoder holds the decoder for the response to a call,
+	// set with WithResponseDecoder.
+	decoder ResponseDecoder
+}
+
+// Signer is implemented by types that can sign HTTP requests, for
+// example by adding an HMAC of the request to its headers.
+type Signer interface {
+	// Sign signs the given request. The body argument holds the
+	// complete request body, which remains available to be sent.
+	Sign(req *http.Request, body []byte) error
+}
+
+// SignerFunc implements Signer by calling the function.
+type SignerFunc func(req *http.Request, body []byte) error
+
+// Sign implements Signer.Sign.
+func (f SignerFunc) Sign(req *http.Request, body []byte) error {
+	return f(req, body)
 }
 
 // Call invokes the endpoint implied by the given params,
@@ -61,7 +265,14 @@ type Client struct {
 // will be called to add additional headers to the HTTP request.
 //
 // If resp is nil, the response will be ignored if the
-// request was successful.
+// request was successful. If the response body is empty (for example
+// when the status is 204 No Content), resp is left unchanged unless
+// c.RequireResponseBody is set.
+//
+// If resp points to a struct, any fields in it with the "header"
+// or "cookie" attribute (see Unmarshal) are set from the headers or
+// cookies of the response, taking precedence over any values in
+// the body.
 //
 // If resp is of type **http.Response, instead of unmarshaling
 // into it, its element will be set to the returned HTTP
@@ -81,9 +292,84 @@ func (c *Client) Call(ctx context.Context, params, resp interface{}) error {
 	return c.CallURL(ctx, c.BaseURL, params, resp)
 }
 
+// CallWithOptions is like Call except that the given options are
+// applied to the call. See CallOption.
+func (c *Client) CallWithOptions(ctx context.Context, params, resp interface{}, opts ...CallOption) error {
+	return c.callURL(ctx, c.BaseURL, params, resp, opts)
+}
+
 // CallURL is like Call except that the given URL is used instead of
 // c.BaseURL.
 func (c *Client) CallURL(ctx context.Context, url string, params, resp interface{}) error {
+	return c.callURL(ctx, url, params, resp, nil)
+}
+
+// URL returns the URL that Call would use for a request with the
+// given params, without making the request, for example to generate
+// a hyperlink or a Location header. The path and form parameters are
+// marshaled as for Call; any other parameters, and c.APIKey, are not
+// included. The context and any WithBaseURLVar options are used to
+// expand the placeholders in c.BaseURL.
+func (c *Client) URL(ctx context.Context, params interface{}, opts ...CallOption) (*url.URL, error) {
+	rt, err := getRequestType(reflect.TypeOf(params))
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if rt.method == "" {
+		return nil, errgo.Newf("type %T has no httprequest.Route field", params)
+	}
+	baseURL, err := expandBaseURL(ctx, c.BaseURL, newCallOptions(opts).baseURLVars)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	reqURL, err := appendURL(baseURL, rt.path)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	req, err := Marshal(reqURL.String(), rt.method, params)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if c.CanonicalQuery && req.URL.RawQuery != "" {
+		q, err := url.ParseQuery(req.URL.RawQuery)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot parse query")
+		}
+		req.URL.RawQuery = CanonicalQuery(q)
+	}
+	return req.URL, nil
+}
+
+// Precompile prepares the marshaling plans for the given parameter
+// types so that the first Call with each of them does not pay the
+// cost of inspecting its fields by reflection. Each argument is a
+// value of a parameter type, usually a nil pointer such as
+// (*GetUserParams)(nil).
+//
+// Plans are cached for the lifetime of the program and shared with
+// Server, so Precompile is usually called once at startup. It returns
+// an error if any of the types is not suitable for use with Call.
+func (c *Client) Precompile(types ...interface{}) error {
+	for _, x := range types {
+		t := reflect.TypeOf(x)
+		if t == nil {
+			return errgo.New("nil parameter type")
+		}
+		if t.Kind() != reflect.Ptr {
+			t = reflect.PtrTo(t)
+		}
+		rt, err := getRequestType(t)
+		if err != nil {
+			return errgo.Notef(err, "bad type %s", t)
+		}
+		if rt.method == "" {
+			return errgo.Newf("type %s has no httprequest.Route field", t)
+		}
+	}
+	return nil
+}
+
+func (c *Client) callURL(ctx context.Context, url string, params, resp interface{}, opts []CallOption) error {
 	rt, err := getRequestType(reflect.TypeOf(params))
 	if err != nil {
 		return errgo.Mask(err)
@@ -91,17 +377,161 @@ func (c *Client) CallURL(ctx context.Context, url string, params, resp interface
 	if rt.method == "" {
 		return errgo.Newf("type %T has no httprequest.Route field", params)
 	}
+	o := newCallOptions(opts)
+	url, err = expandBaseURL(ctx, url, o.baseURLVars)
+	if err != nil {
+		return errgo.Mask(err)
+	}
 	reqURL, err := appendURL(url, rt.path)
 	if err != nil {
 		return errgo.Mask(err)
 	}
-	req, err := Marshal(reqURL.String(), rt.method, params)
+	if c.APIKey != "" {
+		params = withAPIKey(params, rt, c.APIKey)
+	}
+	var pool *BufferPool
+	if c.TimeFormat == nil && c.JSON == nil {
+		pool = c.BufferPool
+	}
+	req, body, err := marshalRequest(reqURL.String(), rt.method, params, pool)
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	// The buffer holding the body is returned to the pool only
+	// when the transport has also finished with it.
+	defer body.release()
+	if c.TimeFormat != nil || c.JSON != nil {
+		if err := c.TimeFormat.setRequestBody(req, params, rt, c.JSON); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if c.MediaType != "" && rt.version != "" && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", VersionMediaType(c.MediaType, rt.version))
+	}
+	if c.SLOTracker != nil || c.CallLimiter != nil {
+		ctx = contextWithEndpoint(ctx, rt.method+" "+rt.path)
+	}
+	if len(opts) == 0 {
+		return c.Do(ctx, req, resp)
+	}
+	for k, v := range o.header {
+		req.Header[k] = v
+	}
+	if o.unmarshalError != nil || o.maxResponseSize != 0 || o.retry != nil || o.decoder != nil {
+		c1 := *c
+		if o.unmarshalError != nil {
+			c1.UnmarshalError = o.unmarshalError
+		}
+		if o.maxResponseSize != 0 {
+			c1.MaxResponseSize = o.maxResponseSize
+		}
+		if o.retry != nil {
+			c1.retry = o.retry
+		}
+		if o.decoder != nil {
+			c1.decoder = o.decoder
+		}
+		c = &c1
+	}
+	if o.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+		if respPt, ok := resp.(**http.Response); ok {
+			// The caller will read the body after we return,
+			// so cancel the context only when the body is closed.
+			err := c.Do(ctx, req, respPt)
+			if err != nil || *respPt == nil {
+				cancel()
+				return err
+			}
+			(*respPt).Body = cancelOnCloseBody{(*respPt).Body, cancel}
+			return nil
+		}
+		defer cancel()
+	}
 	return c.Do(ctx, req, resp)
 }
 
+// CallOption represents an option that applies to an individual call
+// made by Client.CallWithOptions.
+type CallOption func(*callOptions)
+
+// callOptions holds the options for a call as set by
+// a set of CallOption values.
+type callOptions struct {
+	header          http.Header
+	timeout         time.Duration
+	unmarshalError  func(*http.Response) error
+	maxResponseSize int64
+	baseURLVars     map[string]string
+	retry           *RetryPolicy
+	decoder         ResponseDecoder
+}
+
+func newCallOptions(opts []CallOption) *callOptions {
+	o := &callOptions{
+		header: make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHeader returns a CallOption that sets the given header
+// on the request.
+func WithHeader(key, value string) CallOption {
+	return func(o *callOptions) {
+		o.header.Set(key, value)
+	}
+}
+
+// WithTimeout returns a CallOption that limits the time taken
+// by the call, including reading the response body, to d.
+func WithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) {
+		o.timeout = d
+	}
+}
+
+// WithIdempotencyKey returns a CallOption that sets the
+// Idempotency-Key header on the request to the given key.
+func WithIdempotencyKey(key string) CallOption {
+	return WithHeader("Idempotency-Key", key)
+}
+
+// WithUnmarshalError returns a CallOption that uses f instead of
+// Client.UnmarshalError to unmarshal an error response from the call.
+// This is useful when some endpoints return errors in a different
+// form from the others.
+func WithUnmarshalError(f func(*http.Response) error) CallOption {
+	return func(o *callOptions) {
+		o.unmarshalError = f
+	}
+}
+
+// WithMaxResponseSize returns a CallOption that limits the size of
+// the response body for the call to n bytes, overriding
+// Client.MaxResponseSize. If n is negative, the size is not limited.
+func WithMaxResponseSize(n int64) CallOption {
+	return func(o *callOptions) {
+		o.maxResponseSize = n
+	}
+}
+
+// cancelOnCloseBody wraps a response body, calling
+// cancel when it is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
 // Do sends the given request and unmarshals its JSON
 // result into resp, which should be a pointer to the response value.
 // If an error status is returned, the error will be unmarshaled
@@ -126,29 +556,244 @@ func (c *Client) CallURL(ctx context.Context, url string, params, resp interface
 // the entire response body.
 func (c *Client) Do(ctx context.Context, req *http.Request, resp interface{}) error {
 	if req.URL.Host == "" {
-		var err error
-		req.URL, err = appendURL(c.BaseURL, req.URL.String())
+		baseURL, err := expandBaseURL(ctx, c.BaseURL, nil)
 		if err != nil {
 			return errgo.Mask(err)
 		}
+		req.URL, err = appendURL(baseURL, req.URL.String())
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if c.CanonicalQuery && req.URL.RawQuery != "" {
+		q, err := url.ParseQuery(req.URL.RawQuery)
+		if err != nil {
+			return errgo.Notef(err, "cannot parse query")
+		}
+		req.URL.RawQuery = CanonicalQuery(q)
 	}
 	doer := c.Doer
+	if c.SSRFGuard != nil {
+		if err := c.SSRFGuard.CheckURL(req.URL); err != nil {
+			return errgo.Mask(err, errgo.Is(ErrDisallowedDestination))
+		}
+		if doer == nil {
+			doer = c.SSRFGuard.httpClient()
+		}
+	}
 	if doer == nil {
 		doer = http.DefaultClient
 	}
+	if c.IdempotencyKey != nil && (req.Method == "POST" || req.Method == "PUT") && req.Header.Get("Idempotency-Key") == "" {
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Header.Set("Idempotency-Key", c.IdempotencyKey())
+	}
+	if c.ForwardRequestID && req.Header.Get(requestIDHeader) == "" {
+		if id := RequestIDFromContext(ctx); id != "" {
+			if req.Header == nil {
+				req.Header = make(http.Header)
+			}
+			req.Header.Set(requestIDHeader, id)
+		}
+	}
+	if c.ForwardTraceContext {
+		setTraceContextHeaders(ctx, req)
+	}
+	if c.InternalHeaderPrefix != "" {
+		if err := c.setInternalHeaders(ctx, req); err != nil {
+			return errgo.Mask(err, errgo.Is(ErrInternalHeader))
+		}
+	}
+	c.setPriorityHeader(ctx, req)
+	if len(c.Codecs) > 0 && req.Header.Get("Accept") == "" {
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		req.Header.Set("Accept", acceptHeader(c.Codecs))
+	}
+	if c.CallLimiter != nil {
+		release, err := c.CallLimiter.acquire(ctx, requestEndpoint(ctx, req))
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		if respPt, ok := resp.(**http.Response); ok {
+			// The call remains in flight until the caller
+			// has finished with the response body.
+			old := *respPt
+			defer func() {
+				if *respPt != nil && *respPt != old {
+					(*respPt).Body = cancelOnCloseBody{(*respPt).Body, release}
+				} else {
+					release()
+				}
+			}()
+		} else {
+			defer release()
+		}
+	}
 	var httpResp *http.Response
 	var err error
-	if ctxDoer, ok := doer.(DoerWithContext); ok {
-		httpResp, err = ctxDoer.DoWithContext(ctx, req)
-	} else {
-		httpResp, err = doer.Do(req.WithContext(ctx))
+	if c.FaultInjector != nil {
+		httpResp, err = c.injectFault(ctx, req)
+	}
+	if httpResp == nil && err == nil {
+		httpResp, err = c.sendRetrying(ctx, doer, req)
+	}
+	if c.SLOTracker != nil {
+		c.SLOTracker.record(requestEndpoint(ctx, req), httpResp, err)
 	}
 	if err != nil {
-		return errgo.Mask(urlError(err, req), errgo.Any)
+		return errgo.Mask(err, errgo.Any)
+	}
+	c.notifyLifecycle(req, httpResp)
+	if c.MaxResponseSize > 0 {
+		if err := limitResponse(httpResp, c.MaxResponseSize); err != nil {
+			return errgo.Mask(urlError(err, req), errgo.Any)
+		}
 	}
 	return c.unmarshalResponse(httpResp, resp)
 }
 
+// sendAuthorized sends req using doer, authorizing it with a token
+// from c.TokenSource if that is set and retrying once with a new
+// token if the first is rejected.
+func (c *Client) sendAuthorized(ctx context.Context, doer Doer, req *http.Request) (*http.Response, error) {
+	var tok *oauth2.Token
+	if c.TokenSource != nil {
+		// Make sure that the body can be sent again
+		// if the request needs to be retried.
+		if err := setGetBody(req); err != nil {
+			return nil, errgo.Mask(err)
+		}
+		var err error
+		tok, err = c.TokenSource.Token()
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot obtain token")
+		}
+	}
+	httpResp, err := c.send(ctx, doer, req, tok)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	if tok != nil && httpResp.StatusCode == http.StatusUnauthorized {
+		newTok, err := refreshToken(c.TokenSource, tok)
+		if err != nil {
+			httpResp.Body.Close()
+			return nil, errgo.Notef(err, "cannot refresh token")
+		}
+		if newTok.AccessToken != tok.AccessToken {
+			io.Copy(ioutil.Discard, io.LimitReader(httpResp.Body, 8*1024))
+			httpResp.Body.Close()
+			req = req.Clone(ctx)
+			if req.GetBody != nil {
+				req.Body, err = req.GetBody()
+				if err != nil {
+					return nil, errgo.Mask(err)
+				}
+			}
+			return c.send(ctx, doer, req, newTok)
+		}
+	}
+	return httpResp, nil
+}
+
+// send authorizes the request with tok if it is non-nil, signs the
+// request if c.Signer is set, and sends it using doer.
+func (c *Client) send(ctx context.Context, doer Doer, req *http.Request, tok *oauth2.Token) (*http.Response, error) {
+	if tok != nil {
+		tok.SetAuthHeader(req)
+	}
+	if c.Signer != nil {
+		if err := signRequest(c.Signer, req); err != nil {
+			return nil, errgo.NoteMask(err, "cannot sign request", errgo.Any)
+		}
+	}
+	do := func(req *http.Request) (*http.Response, error) {
+		req1, body := countBody(req)
+		if body != nil && ctx.Done() != nil {
+			// The transport waits for the body to be written
+			// even after the call is canceled, so close it to
+			// unblock any pending read.
+			done := make(chan struct{})
+			defer close(done)
+			go func() {
+				select {
+				case <-ctx.Done():
+					body.Close()
+				case <-done:
+				}
+			}()
+		}
+		var resp *http.Response
+		var err error
+		if ctxDoer, ok := doer.(DoerWithContext); ok {
+			resp, err = ctxDoer.DoWithContext(ctx, req1)
+		} else {
+			resp, err = doer.Do(req1.WithContext(ctx))
+		}
+		if err != nil && ctx.Err() != nil {
+			return nil, c.canceledError(ctx, req, body, err)
+		}
+		return resp, err
+	}
+	var httpResp *http.Response
+	var err error
+	if c.HARRecorder != nil {
+		httpResp, err = c.HARRecorder.do(req, do)
+	} else {
+		httpResp, err = do(req)
+	}
+	if err != nil {
+		if _, ok := errgo.Cause(err).(*CanceledError); ok {
+			// The error is already annotated.
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		return nil, errgo.Mask(urlError(err, req), errgo.Any)
+	}
+	return httpResp, nil
+}
+
+// signRequest signs req with s, making the request body
+// available to the signer without consuming it.
+func signRequest(s Signer, req *http.Request) error {
+	if err := setGetBody(req); err != nil {
+		return errgo.Mask(err)
+	}
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		r, err := req.GetBody()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		body, err = ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return s.Sign(req, body)
+}
+
+// setGetBody makes sure that req.GetBody is set if the request has a
+// body, reading the body into memory if necessary.
+func setGetBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil
+}
+
 // Get is a convenience method that uses c.Do to issue a GET request to
 // the given URL. If the given URL does not have a host part then it will
 // be treated as relative to c.BaseURL.
@@ -168,12 +813,44 @@ func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) er
 			return nil
 		}
 		defer httpResp.Body.Close()
-		if err := UnmarshalJSONResponse(httpResp, resp); err != nil {
+		if cr, ok := resp.(*Created); ok {
+			if resp = unmarshalCreated(httpResp, cr); resp == nil {
+				return nil
+			}
+		}
+		if resp != nil && isEmptyBody(httpResp) {
+			if !c.RequireResponseBody {
+				return unmarshalResponseHeader(httpResp, resp)
+			}
+			err := newDecodeResponseError(httpResp, []byte{}, errgo.New("unexpected empty response body"))
 			return errgo.Mask(urlError(err, httpResp.Request), isDecodeResponseError)
 		}
-		return nil
+		unmarshal := func(resp *http.Response, x interface{}) error {
+			return unmarshalJSONResponse(resp, x, c.JSON)
+		}
+		if codec := responseCodec(httpResp, c.Codecs); codec != nil && codec != JSONCodec {
+			unmarshal = func(resp *http.Response, x interface{}) error {
+				return unmarshalCodecResponse(resp, x, codec)
+			}
+		}
+		if c.decoder != nil {
+			unmarshal = c.decoder.decode
+		}
+		if err := unmarshal(httpResp, resp); err != nil {
+			if err := responseTooLarge(err); err != nil {
+				return errgo.Mask(urlError(err, httpResp.Request), errgo.Any)
+			}
+			return errgo.Mask(urlError(err, httpResp.Request), isDecodeResponseError)
+		}
+		return unmarshalResponseHeader(httpResp, resp)
 	}
 	defer httpResp.Body.Close()
+	if c.MaxErrorBodySize > 0 {
+		httpResp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(httpResp.Body, int64(c.MaxErrorBodySize)), httpResp.Body}
+	}
 	errUnmarshaler := c.UnmarshalError
 	if errUnmarshaler == nil {
 		errUnmarshaler = DefaultErrorUnmarshaler
@@ -181,10 +858,44 @@ func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) er
 	err := errUnmarshaler(httpResp)
 	if err == nil {
 		err = errgo.Newf("unexpected HTTP response status: %s", httpResp.Status)
+	} else if err1 := responseTooLarge(errgo.Cause(err)); err1 != nil {
+		err = err1
 	}
 	return errgo.Mask(urlError(err, httpResp.Request), errgo.Any)
 }
 
+// unmarshalResponseHeader unmarshals the header fields of resp, if
+// any, from the headers of httpResp.
+func unmarshalResponseHeader(httpResp *http.Response, resp interface{}) error {
+	if err := unmarshalResultHeader(httpResp, resp); err != nil {
+		err := newDecodeResponseError(httpResp, []byte{}, err)
+		return errgo.Mask(urlError(err, httpResp.Request), isDecodeResponseError)
+	}
+	return nil
+}
+
+// isEmptyBody reports whether the given response has an empty body.
+// It may replace resp.Body so that data read while checking is
+// preserved.
+func isEmptyBody(resp *http.Response) bool {
+	if resp.StatusCode == http.StatusNoContent || resp.ContentLength == 0 {
+		return true
+	}
+	if resp.ContentLength > 0 {
+		return false
+	}
+	var buf [1]byte
+	n, err := io.ReadFull(resp.Body, buf[:])
+	if n == 0 && err == io.EOF {
+		return true
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf[:n]), resp.Body), resp.Body}
+	return false
+}
+
 // ErrorUnmarshaler returns a function which will unmarshal error
 // responses into new values of the same type as template. The argument
 // must be a pointer. A new instance of it is created every time the
@@ -192,6 +903,10 @@ func (c *Client) unmarshalResponse(httpResp *http.Response, resp interface{}) er
 //
 // If the error cannot by unmarshaled, the function will return an
 // *HTTPResponseError holding the response from the request.
+//
+// When template is a *RemoteError, responses in the RFC 7807 problem
+// details format (see ProblemErrorWriter) are also unmarshaled into
+// RemoteError values.
 func ErrorUnmarshaler(template error) func(*http.Response) error {
 	t := reflect.TypeOf(template)
 	if t.Kind() != reflect.Ptr {
@@ -204,6 +919,9 @@ func ErrorUnmarshaler(template error) func(*http.Response) error {
 			loc, _ := resp.Location()
 			return newDecodeResponseError(resp, nil, fmt.Errorf("unexpected redirect (status %s) from %q to %q", resp.Status, resp.Request.URL, loc))
 		}
+		if t == remoteErrorType && isProblemResponse(resp) {
+			return unmarshalProblem(resp)
+		}
 		errv := reflect.New(t)
 		if err := UnmarshalJSONResponse(resp, errv.Interface()); err != nil {
 			return errgo.NoteMask(err, fmt.Sprintf("cannot unmarshal error response (status %s)", resp.Status), isDecodeResponseError)
@@ -219,6 +937,12 @@ func ErrorUnmarshaler(template error) func(*http.Response) error {
 // If the response cannot be unmarshaled, an error of type
 // *DecodeResponseError will be returned.
 func UnmarshalJSONResponse(resp *http.Response, x interface{}) error {
+	return unmarshalJSONResponse(resp, x, nil)
+}
+
+// unmarshalJSONResponse is like UnmarshalJSONResponse except that it
+// unmarshals with jc if it is non-nil.
+func unmarshalJSONResponse(resp *http.Response, x interface{}, jc Codec) error {
 	if x == nil {
 		return nil
 	}
@@ -237,7 +961,18 @@ func UnmarshalJSONResponse(resp *http.Response, x interface{}) error {
 	}
 	if n < int64(maxErrorBodySize) {
 		// We've read all the data; unmarshal it.
-		if err := json.Unmarshal(bodyData, x); err != nil {
+		if err := jsonUnmarshal(jc, bodyData, x); err != nil {
+			return newDecodeResponseError(resp, bodyData, err)
+		}
+		return nil
+	}
+	if jc != nil {
+		// The codec needs all the data at once.
+		data, err := ioutil.ReadAll(io.MultiReader(&buf, resp.Body))
+		if err != nil {
+			return newDecodeResponseError(resp, bodyData, errgo.Notef(err, "error reading response body"))
+		}
+		if err := jc.Unmarshal(data, x); err != nil {
 			return newDecodeResponseError(resp, bodyData, err)
 		}
 		return nil
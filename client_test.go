@@ -11,6 +11,7 @@ import (
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/julienschmidt/httprouter"
@@ -181,6 +182,28 @@ func TestCall(t *testing.T) {
 	}
 }
 
+func TestPrecompile(t *testing.T) {
+	c := qt.New(t)
+
+	var client httprequest.Client
+	err := client.Precompile((*chM1Req)(nil), chM2Req{})
+	c.Assert(err, qt.IsNil)
+
+	err = client.Precompile((*chM1Req)(nil), &struct {
+		A string `httprequest:",path"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `type \*struct { A string "httprequest:\\",path\\"" } has no httprequest.Route field`)
+
+	err = client.Precompile(&struct {
+		httprequest.Route `httprequest:"GET /foo"`
+		A                 string `httprequest:",bogus"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `bad type \*struct .*: bad tag "httprequest:\\",bogus\\"" in field A: unknown tag flag "bogus"`)
+
+	err = client.Precompile(nil)
+	c.Assert(err, qt.ErrorMatches, `nil parameter type`)
+}
+
 func TestCallURLNoRequestPath(t *testing.T) {
 	c := qt.New(t)
 	defer c.Done()
@@ -639,13 +662,20 @@ func newServer() *httptest.Server {
 	f := func(p httprequest.Params) (clientHandlers, context.Context, error) {
 		return clientHandlers{}, p.Context, nil
 	}
-	handlers := testServer.Handlers(f)
-	router := httprouter.New()
-	for _, h := range handlers {
-		router.Handle(h.Method, h.Path, h.Handle)
-	}
+	return newHandlerServer(testServer.Handlers(f))
+}
+
+// newHandlerServer returns a test server that serves the given
+// handlers.
+func newHandlerServer(hs []httprequest.Handler) *httptest.Server {
+	return httptest.NewServer(newHandlerRouter(hs))
+}
 
-	return httptest.NewServer(router)
+// newHandlerRouter returns a router that serves the given handlers.
+func newHandlerRouter(hs []httprequest.Handler) *httprouter.Router {
+	router := httprouter.New()
+	httprequest.AddHandlers(router, hs)
+	return router
 }
 
 var appendURLTests = []struct {
@@ -886,3 +916,203 @@ func isRemoteError(err error) bool {
 	_, ok := err.(*httprequest.RemoteError)
 	return ok
 }
+
+func TestCallWithOptions(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	var gotHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotHeader = req.Header
+		httprequest.WriteJSON(w, http.StatusOK, chM1Resp{"ok"})
+	}))
+	c.Defer(srv.Close)
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp chM1Resp
+	err := client.CallWithOptions(context.Background(), &chM1Req{
+		P: "hello",
+	}, &resp,
+		httprequest.WithHeader("X-Foo", "bar"),
+		httprequest.WithIdempotencyKey("1234"),
+		httprequest.WithTimeout(time.Minute),
+	)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, chM1Resp{"ok"})
+	c.Assert(gotHeader.Get("X-Foo"), qt.Equals, "bar")
+	c.Assert(gotHeader.Get("Idempotency-Key"), qt.Equals, "1234")
+}
+
+func TestCallWithTimeout(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	unblock := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-unblock
+	}))
+	c.Defer(srv.Close)
+	c.Defer(func() {
+		close(unblock)
+	})
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.CallWithOptions(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil, httprequest.WithTimeout(10*time.Millisecond))
+	c.Assert(errgo.Cause(err), qt.ErrorMatches, `.*context deadline exceeded.*`)
+}
+
+func TestCallWithUnmarshalError(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	}))
+	c.Defer(srv.Close)
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.CallWithOptions(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil, httprequest.WithUnmarshalError(func(resp *http.Response) error {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		return errgo.Newf("custom error %d: %s", resp.StatusCode, data)
+	}))
+	c.Assert(err, qt.ErrorMatches, `Get http:.*/m1/hello: custom error 418: short and stout`)
+
+	// The client's own UnmarshalError is not affected.
+	err = client.Call(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Get http:.*/m1/hello: cannot unmarshal error response .*: unexpected content type .*`)
+}
+
+func TestCallEmptyResponse(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/nocontent":
+			w.WriteHeader(http.StatusNoContent)
+		case "/empty":
+			w.Header().Set("Content-Type", "application/json")
+			w.(http.Flusher).Flush()
+		}
+	}))
+	c.Defer(srv.Close)
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	for _, path := range []string{"/nocontent", "/empty"} {
+		resp := chM1Resp{"unchanged"}
+		err := client.Get(context.Background(), path, &resp)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(resp, qt.DeepEquals, chM1Resp{"unchanged"})
+	}
+
+	client.RequireResponseBody = true
+	for _, path := range []string{"/nocontent", "/empty"} {
+		var resp chM1Resp
+		err := client.Get(context.Background(), path, &resp)
+		c.Assert(err, qt.ErrorMatches, `Get http://.*`+path+`: unexpected empty response body`)
+		_, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+		c.Assert(ok, qt.Equals, true)
+
+		// A nil response value is still fine.
+		err = client.Get(context.Background(), path, nil)
+		c.Assert(err, qt.Equals, nil)
+	}
+}
+
+func TestCallWithTimeoutAndHTTPResponse(t *testing.T) {
+	c := qt.New(t)
+	defer c.Done()
+
+	srv := newServer()
+	c.Defer(srv.Close)
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp *http.Response
+	err := client.CallWithOptions(context.Background(), &chM1Req{
+		P: "foo",
+	}, &resp, httprequest.WithTimeout(time.Minute))
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, `{"P":"foo"}`)
+}
+
+func TestSigner(t *testing.T) {
+	c := qt.New(t)
+
+	var gotHeader http.Header
+	var gotBody []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotHeader = req.Header
+		gotBody, _ = ioutil.ReadAll(req.Body)
+	}))
+	defer srv.Close()
+
+	var signedBody []byte
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		Signer: httprequest.SignerFunc(func(req *http.Request, body []byte) error {
+			signedBody = body
+			req.Header.Set("X-Signature", fmt.Sprintf("%s %s %d", req.Method, req.URL.Path, len(body)))
+			return nil
+		}),
+	}
+	err := client.Call(context.Background(), &chM2Req{
+		P:    "hello",
+		Body: struct{ I int }{99},
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(signedBody), qt.Equals, `{"I":99}`)
+	c.Assert(string(gotBody), qt.Equals, `{"I":99}`)
+	c.Assert(gotHeader.Get("X-Signature"), qt.Equals, "POST /m2/hello 8")
+
+	// A request made with Do with a body that has
+	// no GetBody can still be signed.
+	req, err := http.NewRequest("PUT", "/foo", ioutil.NopCloser(strings.NewReader("data")))
+	c.Assert(err, qt.Equals, nil)
+	err = client.Do(context.Background(), req, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(signedBody), qt.Equals, "data")
+	c.Assert(string(gotBody), qt.Equals, "data")
+	c.Assert(gotHeader.Get("X-Signature"), qt.Equals, "PUT /foo 4")
+}
+
+func TestSignerError(t *testing.T) {
+	c := qt.New(t)
+
+	errFailed := errgo.New("no key")
+	client := httprequest.Client{
+		BaseURL: "http://0.1.2.3",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			c.Fatalf("unexpected request")
+			return nil, nil
+		}),
+		Signer: httprequest.SignerFunc(func(req *http.Request, body []byte) error {
+			return errFailed
+		}),
+	}
+	err := client.Get(context.Background(), "/foo", nil)
+	c.Assert(err, qt.ErrorMatches, `cannot sign request: no key`)
+	c.Assert(errgo.Cause(err), qt.Equals, errFailed)
+}
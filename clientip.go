@@ -0,0 +1,255 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// IPFilter restricts the client addresses that may make requests
+// to a server. A request is rejected if its client address is
+// in any of the Deny networks or, when Allow is not empty, if it is
+// not in any of the Allow networks.
+//
+// The client address is determined as described in
+// Server.TrustedProxies.
+type IPFilter struct {
+	// Allow holds the networks that are allowed access.
+	// If it is empty, all networks not in Deny are allowed.
+	Allow []*net.IPNet
+
+	// Deny holds the networks that are denied access.
+	Deny []*net.IPNet
+}
+
+// Allowed reports whether the given client IP address
+// is allowed by the filter.
+func (f *IPFilter) Allowed(ip net.IP) bool {
+	if ip == nil {
+		return len(f.Allow) == 0 && len(f.Deny) == 0
+	}
+	if containsIP(f.Deny, ip) {
+		return false
+	}
+	return len(f.Allow) == 0 || containsIP(f.Allow, ip)
+}
+
+// ParseCIDRs parses each of the given strings as a CIDR network
+// (for example "10.0.0.0/8"), as accepted by net.ParseCIDR. A plain IP
+// address is treated as a network containing only that address.
+func ParseCIDRs(ss ...string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errgo.Newf("invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{
+				IP:   ip,
+				Mask: net.CIDRMask(bits, bits),
+			})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+type clientIPKey struct{}
+
+// ClientIPFromContext returns the client IP address stored in the given
+// context. The context passed to handlers created by a Server
+// always holds the client IP address when it can be determined.
+func ClientIPFromContext(ctx context.Context) (net.IP, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(net.IP)
+	return ip, ok
+}
+
+// ContextWithClientIP returns a copy of ctx holding the
+// given client IP address.
+func ContextWithClientIP(ctx context.Context, ip net.IP) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// wrapClientIP returns a handler that records the client IP address
+// of the request and any information from trusted proxies in its
+// context and checks the address against srv.IPFilter before
+// calling h. It panics if srv.ForwardedHeader is invalid.
+func (srv *Server) wrapClientIP(h httprouter.Handle) httprouter.Handle {
+	header, err := srv.forwardedHeader()
+	if err != nil {
+		panic(errgo.Notef(err, "bad Server.ForwardedHeader"))
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ip, hops := clientIP(req, header, srv.TrustedProxies)
+		if srv.IPFilter != nil && !srv.IPFilter.Allowed(ip) {
+			srv.WriteError(req.Context(), w, Errorf(CodeForbidden, "client address not allowed"))
+			return
+		}
+		ctx := req.Context()
+		if ip != nil {
+			ctx = ContextWithClientIP(ctx, ip)
+		}
+		if hops >= 0 {
+			ctx = contextWithForwardedInfo(ctx, req, header, hops)
+		}
+		if ctx != req.Context() {
+			req = req.WithContext(ctx)
+		}
+		h(w, req, p)
+	}
+}
+
+// forwardedHeader returns the canonical name of the header that
+// srv.TrustedProxies use to report the client address.
+func (srv *Server) forwardedHeader() (string, error) {
+	switch header := http.CanonicalHeaderKey(srv.ForwardedHeader); header {
+	case "":
+		return "X-Forwarded-For", nil
+	case "X-Forwarded-For", "Forwarded":
+		return header, nil
+	default:
+		return "", errgo.Newf("unsupported header %q", srv.ForwardedHeader)
+	}
+}
+
+// clientIP returns the IP address of the client that made the given
+// request. When the request comes from one of the trusted proxies, the
+// address is taken from the given header, which must be Forwarded or
+// X-Forwarded-For, using the rightmost address that is not itself a
+// trusted proxy. It returns nil if no address could be determined.
+//
+// It also returns the number of trusted proxies that were skipped to
+// find the address, counting the hops recorded in the header from the
+// right, or -1 if the request did not come from a trusted proxy, so
+// that other information can be taken from the hop recorded by the
+// outermost trusted proxy (see contextWithForwardedInfo).
+func clientIP(req *http.Request, header string, trusted []*net.IPNet) (net.IP, int) {
+	ip := parseHostIP(req.RemoteAddr)
+	if ip == nil || !containsIP(trusted, ip) {
+		return ip, -1
+	}
+	addrs := forwardedFor(req.Header, header)
+	skipped := 0
+	for i := len(addrs) - 1; i >= 0; i-- {
+		hop := parseHostIP(addrs[i])
+		if hop == nil {
+			// An unknown or obfuscated address; the last
+			// proxy is the best we can do.
+			break
+		}
+		ip = hop
+		if !containsIP(trusted, ip) {
+			break
+		}
+		if i > 0 {
+			skipped++
+		}
+	}
+	return ip, skipped
+}
+
+// forwardedFor returns the client addresses recorded by proxies in
+// the given header, which must be Forwarded or X-Forwarded-For, in
+// the order that they were added.
+func forwardedFor(h http.Header, header string) []string {
+	elems := headerList(h, header)
+	if header == "Forwarded" {
+		for i, elem := range elems {
+			elems[i] = forwardedParam(elem, "for")
+		}
+	}
+	return elems
+}
+
+// headerList returns the items in the comma-separated lists held in
+// all the values of the given header, in order.
+func headerList(h http.Header, key string) []string {
+	var items []string
+	for _, v := range h[key] {
+		for _, item := range strings.Split(v, ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+	}
+	return items
+}
+
+// forwardedParam returns the value of the given parameter
+// in a single element of a Forwarded header (RFC 7239).
+func forwardedParam(elem, name string) string {
+	for _, pair := range strings.Split(elem, ";") {
+		pair = strings.TrimSpace(pair)
+		i := strings.Index(pair, "=")
+		if i == -1 || !strings.EqualFold(pair[:i], name) {
+			continue
+		}
+		return strings.Trim(pair[i+1:], `"`)
+	}
+	return ""
+}
+
+// parseHostIP parses an IP address with an optional port.
+// IPv6 addresses with a port must be enclosed in square brackets.
+func parseHostIP(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+var ipType = reflect.TypeOf(net.IP(nil))
+
+// unmarshalClientIP returns an unmarshaler that sets a net.IP or
+// string field to the client IP address of the request.
+func unmarshalClientIP(t reflect.Type) (unmarshaler, error) {
+	if t != ipType && t.Kind() != reflect.String {
+		return nil, errgo.Newf("invalid target type %s for client IP", t)
+	}
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		var ip net.IP
+		if p.Context != nil {
+			ip, _ = ClientIPFromContext(p.Context)
+		}
+		if ip == nil && p.Request != nil {
+			ip = parseHostIP(p.Request.RemoteAddr)
+		}
+		if ip == nil {
+			return nil
+		}
+		if t == ipType {
+			makeResult(v).Set(reflect.ValueOf(ip))
+		} else {
+			makeResult(v).SetString(ip.String())
+		}
+		return nil
+	}, nil
+}
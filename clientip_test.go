@@ -0,0 +1,211 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var clientIPTests = []struct {
+	about           string
+	trustedProxies  []string
+	forwardedHeader string
+	remoteAddr      string
+	header          http.Header
+	expectIP        string
+}{{
+	about:      "no proxies",
+	remoteAddr: "192.0.2.1:1234",
+	header: http.Header{
+		"X-Forwarded-For": {"198.51.100.1"},
+	},
+	expectIP: "192.0.2.1",
+}, {
+	about:          "untrusted proxy",
+	trustedProxies: []string{"10.0.0.0/8"},
+	remoteAddr:     "192.0.2.1:1234",
+	header: http.Header{
+		"X-Forwarded-For": {"198.51.100.1"},
+	},
+	expectIP: "192.0.2.1",
+}, {
+	about:          "trusted proxy",
+	trustedProxies: []string{"10.0.0.0/8"},
+	remoteAddr:     "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-For": {"203.0.113.9, 198.51.100.1"},
+	},
+	expectIP: "198.51.100.1",
+}, {
+	about:          "chain of trusted proxies",
+	trustedProxies: []string{"10.0.0.0/8"},
+	remoteAddr:     "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-For": {"203.0.113.9, 198.51.100.1", "10.1.1.1"},
+	},
+	expectIP: "198.51.100.1",
+}, {
+	about:          "spoofed forwarded header is ignored",
+	trustedProxies: []string{"10.0.0.0/8"},
+	remoteAddr:     "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded":       {"for=10.0.0.1"},
+		"X-Forwarded-For": {"198.51.100.1"},
+	},
+	expectIP: "198.51.100.1",
+}, {
+	about:           "forwarded header",
+	trustedProxies:  []string{"10.0.0.0/8"},
+	forwardedHeader: "forwarded",
+	remoteAddr:      "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded":       {`for=192.0.2.43, for="[2001:db8:cafe::17]:4711";proto=https`},
+		"X-Forwarded-For": {"198.51.100.1"},
+	},
+	expectIP: "2001:db8:cafe::17",
+}, {
+	about:           "spoofed x-forwarded-for header is ignored",
+	trustedProxies:  []string{"10.0.0.0/8"},
+	forwardedHeader: "Forwarded",
+	remoteAddr:      "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded":       {"for=192.0.2.43"},
+		"X-Forwarded-For": {"10.0.0.2"},
+	},
+	expectIP: "192.0.2.43",
+}, {
+	about:           "obfuscated forwarded address",
+	trustedProxies:  []string{"10.0.0.0/8"},
+	forwardedHeader: "Forwarded",
+	remoteAddr:      "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded": {`for=192.0.2.43, for=_hidden`},
+	},
+	expectIP: "10.0.0.1",
+}, {
+	about:          "all hops trusted",
+	trustedProxies: []string{"10.0.0.0/8"},
+	remoteAddr:     "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-For": {"10.0.0.2"},
+	},
+	expectIP: "10.0.0.2",
+}}
+
+type clientIPRequest struct {
+	httprequest.Route `httprequest:"GET /ip"`
+	IP                net.IP `httprequest:",clientip"`
+	IPString          string `httprequest:",clientip"`
+}
+
+func TestClientIP(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range clientIPTests {
+		c.Run(test.about, func(c *qt.C) {
+			trusted, err := httprequest.ParseCIDRs(test.trustedProxies...)
+			c.Assert(err, qt.Equals, nil)
+			srv := httprequest.Server{
+				TrustedProxies:  trusted,
+				ForwardedHeader: test.forwardedHeader,
+			}
+			h := srv.Handle(func(p httprequest.Params, r *clientIPRequest) ([]string, error) {
+				ctxIP, _ := httprequest.ClientIPFromContext(p.Context)
+				return []string{r.IP.String(), r.IPString, ctxIP.String()}, nil
+			})
+			req := httptest.NewRequest("GET", "/ip", nil)
+			req.RemoteAddr = test.remoteAddr
+			req.Header = test.header
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			qthttptest.AssertJSONResponse(c, rec, http.StatusOK, []string{test.expectIP, test.expectIP, test.expectIP})
+		})
+	}
+}
+
+func TestBadForwardedHeader(t *testing.T) {
+	c := qt.New(t)
+	srv := httprequest.Server{
+		ForwardedHeader: "X-Real-IP",
+	}
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, r *clientIPRequest) {})
+	}, qt.PanicMatches, `bad Server.ForwardedHeader: unsupported header "X-Real-IP"`)
+}
+
+var ipFilterTests = []struct {
+	about        string
+	allow        []string
+	deny         []string
+	remoteAddr   string
+	expectStatus int
+}{{
+	about:        "no lists",
+	remoteAddr:   "192.0.2.1:80",
+	expectStatus: http.StatusOK,
+}, {
+	about:        "allowed",
+	allow:        []string{"192.0.2.0/24"},
+	remoteAddr:   "192.0.2.1:80",
+	expectStatus: http.StatusOK,
+}, {
+	about:        "not in allow list",
+	allow:        []string{"192.0.2.0/24"},
+	remoteAddr:   "198.51.100.1:80",
+	expectStatus: http.StatusForbidden,
+}, {
+	about:        "denied",
+	deny:         []string{"192.0.2.1"},
+	remoteAddr:   "192.0.2.1:80",
+	expectStatus: http.StatusForbidden,
+}, {
+	about:        "deny takes precedence",
+	allow:        []string{"192.0.2.0/24"},
+	deny:         []string{"192.0.2.1"},
+	remoteAddr:   "192.0.2.1:80",
+	expectStatus: http.StatusForbidden,
+}}
+
+func TestIPFilter(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range ipFilterTests {
+		c.Run(test.about, func(c *qt.C) {
+			allow, err := httprequest.ParseCIDRs(test.allow...)
+			c.Assert(err, qt.Equals, nil)
+			deny, err := httprequest.ParseCIDRs(test.deny...)
+			c.Assert(err, qt.Equals, nil)
+			srv := httprequest.Server{
+				IPFilter: &httprequest.IPFilter{
+					Allow: allow,
+					Deny:  deny,
+				},
+			}
+			h := srv.Handle(func(p httprequest.Params, r *clientIPRequest) (string, error) {
+				return "ok", nil
+			})
+			req := httptest.NewRequest("GET", "/ip", nil)
+			req.RemoteAddr = test.remoteAddr
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			c.Assert(rec.Code, qt.Equals, test.expectStatus)
+		})
+	}
+}
+
+func TestParseCIDRsError(t *testing.T) {
+	c := qt.New(t)
+	_, err := httprequest.ParseCIDRs("10.0.0.0/8", "foo")
+	c.Assert(err, qt.ErrorMatches, `invalid IP address "foo"`)
+	_, err = httprequest.ParseCIDRs("10.0.0.0/99")
+	c.Assert(err, qt.ErrorMatches, `invalid CIDR address: 10.0.0.0/99`)
+}
@@ -0,0 +1,36 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Types in this package that depend on the current time or on random
+// choices have Now and Rand fields, so that tests of code that uses
+// them can be deterministic. A nil Now field means that time.Now is
+// used; a nil Rand field means that math/rand.Float64 is used.
+
+// nowFunc returns now, or time.Now if now is nil.
+func nowFunc(now func() time.Time) func() time.Time {
+	if now != nil {
+		return now
+	}
+	return time.Now
+}
+
+// randFunc returns r, or rand.Float64 if r is nil.
+func randFunc(r func() float64) func() float64 {
+	if r != nil {
+		return r
+	}
+	return rand.Float64
+}
+
+// sampled reports whether an event with the given probability
+// should happen, using r to make the choice.
+func sampled(rate float64, r func() float64) bool {
+	return rate > 0 && (rate >= 1 || randFunc(r)() < rate)
+}
@@ -0,0 +1,40 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/schemadiff"
+)
+
+// errBreaking is returned by diffSnapshots when failOnBreaking is set
+// and there are breaking changes.
+var errBreaking = errgo.New("breaking changes found")
+
+// diffSnapshots writes a JSON report of the differences between the
+// old and new snapshot files to standard output.
+func diffSnapshots(oldFile, newFile string, failOnBreaking bool) error {
+	old, err := schemadiff.ReadFile(oldFile)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	new, err := schemadiff.ReadFile(newFile)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	report := schemadiff.Diff(old, new)
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(report); err != nil {
+		return errgo.Notef(err, "cannot write report")
+	}
+	if failOnBreaking && report.Breaking() {
+		return errBreaking
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var updateFlag = flag.Bool("update", false, "update the golden files used by the tests")
+
+var generateFromSnapshotTests = []struct {
+	about      string
+	snapshot   string
+	urls       bool
+	serverType string
+	golden     string
+}{{
+	about:    "client",
+	snapshot: "api.json",
+	golden:   "client.golden",
+}, {
+	about:    "client with urls",
+	snapshot: "api.json",
+	urls:     true,
+	golden:   "client-urls.golden",
+}, {
+	about:    "error decoder with versioned import path",
+	snapshot: "versioned.json",
+	golden:   "versioned.golden",
+}, {
+	about:      "server stub",
+	snapshot:   "api.json",
+	serverType: "Server",
+	golden:     "server-stub.golden",
+}}
+
+func TestGenerateFromSnapshot(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range generateFromSnapshotTests {
+		c.Run(test.about, func(c *qt.C) {
+			oldURLs := *urlsFlag
+			*urlsFlag = test.urls
+			defer func() {
+				*urlsFlag = oldURLs
+			}()
+			out := newOutput(c)
+			err := generateFromSnapshot(filepath.Join("testdata", test.snapshot), "Client", test.serverType, out)
+			c.Assert(err, qt.IsNil)
+			assertGolden(c, out, test.golden)
+		})
+	}
+}
+
+// newOutput returns an output that writes the generated code to
+// out.go in a new package outside the current module, so that the
+// generated code can refer to the packages in testdata as if they
+// belonged to another module.
+func newOutput(c *qt.C) output {
+	dir := c.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/client\n"), 0644)
+	c.Assert(err, qt.IsNil)
+	wd, err := os.Getwd()
+	c.Assert(err, qt.IsNil)
+	rel, err := filepath.Rel(wd, dir)
+	c.Assert(err, qt.IsNil)
+	return output{
+		dir:      rel,
+		filename: "out.go",
+		pkgName:  "client",
+	}
+}
+
+// assertGolden checks that the code written to out matches the named
+// golden file in testdata. When the -update flag is set, the golden
+// file is written instead.
+func assertGolden(c *qt.C, out output, golden string) {
+	got, err := ioutil.ReadFile(filepath.Join(out.dir, out.filename))
+	c.Assert(err, qt.IsNil)
+	golden = filepath.Join("testdata", golden)
+	if *updateFlag {
+		err := ioutil.WriteFile(golden, got, 0644)
+		c.Assert(err, qt.IsNil)
+		return
+	}
+	want, err := ioutil.ReadFile(golden)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(got), qt.Equals, string(want))
+}
@@ -14,31 +14,134 @@ import (
 	"go/types"
 	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	"golang.org/x/tools/go/packages"
 	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/tags"
 )
 
-// TODO:
-// - generate exported types if the parameter/response types aren't exported?
-// - deal with literal interface and struct types.
-// - copy doc comments from server methods.
+var (
+	typeFlag   = flag.String("type", "", "comma-separated server types in the package in the current directory")
+	clientFlag = flag.String("client", "Client", "name of the client type to generate when -type is used")
+	outPkgFlag = flag.String("out-pkg", "", "directory of the package to generate the client in (default current directory)")
+	outFlag    = flag.String("o", "", "output file name, relative to the output package directory (default <client-type>_generated.go)")
+	pkgFlag    = flag.String("package", "", "package name to declare in the output file (default the name of the output package)")
+	aliasFlag  = flag.Bool("aliases", false, "generate documented local aliases for parameter and response types from other packages")
+	urlsFlag   = flag.Bool("urls", false, "generate a <method>URL method on the client for each method, returning the URL it would call")
+
+	snapshotFlag     = flag.String("snapshot", "", "write a snapshot of the server API schema to the named JSON file instead of generating code")
+	fromSnapshotFlag = flag.String("from-snapshot", "", "generate code from the named schema snapshot file instead of from server packages")
+	serverStubFlag   = flag.String("server-stub", "", "with -from-snapshot, generate a server stub type with the given name instead of a client")
+
+	diffFlag           = flag.Bool("diff", false, "write a JSON report of the route changes between two schema snapshot files instead of generating code")
+	failOnBreakingFlag = flag.Bool("fail-on-breaking", false, "with -diff, exit with status 3 if there are breaking changes")
+)
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: httprequest-generate server-package server-type client-type\n")
+		fmt.Fprintf(os.Stderr, "usage: httprequest-generate [flags] server-package server-type [server-package server-type...] client-type\n")
+		fmt.Fprintf(os.Stderr, "   or: httprequest-generate [flags] -type server-type[,server-type...] [-client client-type]\n")
+		fmt.Fprintf(os.Stderr, "   or: httprequest-generate [flags] -snapshot file server-package server-type [server-package server-type...]\n")
+		fmt.Fprintf(os.Stderr, "   or: httprequest-generate [flags] -from-snapshot file [-server-stub server-type] [client-type]\n")
+		fmt.Fprintf(os.Stderr, "   or: httprequest-generate [-fail-on-breaking] -diff old-snapshot new-snapshot\n")
+		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse()
-	if flag.NArg() != 3 {
+
+	if *diffFlag {
+		if flag.NArg() != 2 || *snapshotFlag != "" || *fromSnapshotFlag != "" || *typeFlag != "" {
+			flag.Usage()
+		}
+		err := diffSnapshots(flag.Arg(0), flag.Arg(1), *failOnBreakingFlag)
+		if err == errBreaking {
+			os.Exit(3)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *failOnBreakingFlag {
 		flag.Usage()
 	}
 
-	serverPkg, serverType, clientType := flag.Arg(0), flag.Arg(1), flag.Arg(2)
+	out := output{
+		dir:      *outPkgFlag,
+		filename: *outFlag,
+		pkgName:  *pkgFlag,
+	}
+	if *fromSnapshotFlag != "" {
+		if *typeFlag != "" || *snapshotFlag != "" || flag.NArg() > 1 {
+			flag.Usage()
+		}
+		clientType := *clientFlag
+		if flag.NArg() == 1 {
+			clientType = flag.Arg(0)
+		}
+		if err := generateFromSnapshot(*fromSnapshotFlag, clientType, *serverStubFlag, out); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *serverStubFlag != "" {
+		flag.Usage()
+	}
+
+	var servers []serverSpec
+	var clientType string
+	if *typeFlag != "" {
+		// The server types are in the current package, which
+		// makes it possible to use the command from a go:generate
+		// directive in the server package itself.
+		if flag.NArg() != 0 {
+			flag.Usage()
+		}
+		for _, name := range strings.Split(*typeFlag, ",") {
+			servers = append(servers, serverSpec{
+				pkg:  ".",
+				name: strings.TrimSpace(name),
+			})
+		}
+		clientType = *clientFlag
+	} else {
+		nargs := flag.NArg()
+		if *snapshotFlag == "" {
+			// The last argument is the client type.
+			nargs--
+		}
+		if nargs < 2 || nargs%2 != 0 {
+			flag.Usage()
+		}
+		// The arguments are server package and server
+		// type pairs, the methods of which are merged into a single
+		// client type.
+		for i := 0; i < nargs; i += 2 {
+			servers = append(servers, serverSpec{
+				pkg:  flag.Arg(i),
+				name: flag.Arg(i + 1),
+			})
+		}
+		clientType = flag.Arg(nargs)
+	}
 
-	if err := generate(serverPkg, serverType, clientType); err != nil {
+	var err error
+	if *snapshotFlag != "" {
+		err = writeSnapshot(servers, *snapshotFlag)
+	} else {
+		err = generate(servers, clientType, out)
+	}
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
@@ -47,8 +150,10 @@ func main() {
 type templateArg struct {
 	PkgName    string
 	Imports    []string
+	Types      []typeDecl
 	Methods    []method
 	ClientType string
+	URLs       bool
 }
 
 var code = template.Must(template.New("").Parse(`
@@ -61,85 +166,348 @@ import (
 	{{end}}
 )
 
+{{range .Types}}
+{{.Doc}}
+type {{.Name}} {{if .Alias}}= {{end}}{{.Type}}
+{{end}}
+
 type {{.ClientType}} struct {
 	Client httprequest.Client
 }
 
+// {{.ClientType}}Interface holds the methods implemented by {{.ClientType}}.
+type {{.ClientType}}Interface interface {
+{{- range .Methods}}
+	{{.Doc}}
+	{{- if .RespType}}
+	{{.Name}}(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) ({{.RespType}}, error)
+	{{- else}}
+	{{.Name}}(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) error
+	{{- end}}
+	{{- if .Async}}
+
+	// {{.Name}}AndWait is like {{.Name}} except that it waits for the
+	// operation it starts to finish.
+	{{.Name}}AndWait(ctx context.Context, p *{{.ParamType}}, interval time.Duration, resp interface{}, opts ...httprequest.CallOption) error
+	{{- end}}
+	{{- if $.URLs}}
+
+	// {{.Name}}URL returns the URL that {{.Name}} would call.
+	{{.Name}}URL(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) (*url.URL, error)
+	{{- end}}
+{{end -}}
+}
+
+var _ {{.ClientType}}Interface = (*{{.ClientType}})(nil)
+
 {{range .Methods}}
 {{if .RespType}}
 	{{.Doc}}
-	func (c *{{$.ClientType}}) {{.Name}}(ctx context.Context, p *{{.ParamType}}) ({{.RespType}}, error) {
+	func (c *{{$.ClientType}}) {{.Name}}(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) ({{.RespType}}, error) {
+		{{- if .ErrorDecoderExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithUnmarshalError({{.ErrorDecoderExpr}})}, opts...)
+		{{- end}}
+		{{- if .RetryExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithRetry({{.RetryExpr}})}, opts...)
+		{{- end}}
 		var r {{.RespType}}
-		err := c.Client.Call(ctx, p, &r)
+		err := c.Client.CallWithOptions(ctx, p, &r, opts...)
 		return r, err
 	}
 {{else}}
 	{{.Doc}}
-	func (c *{{$.ClientType}}) {{.Name}}(ctx context.Context, p *{{.ParamType}}) (error) {
-		return c.Client.Call(ctx, p, nil)
+	func (c *{{$.ClientType}}) {{.Name}}(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) (error) {
+		{{- if .ErrorDecoderExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithUnmarshalError({{.ErrorDecoderExpr}})}, opts...)
+		{{- end}}
+		{{- if .RetryExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithRetry({{.RetryExpr}})}, opts...)
+		{{- end}}
+		return c.Client.CallWithOptions(ctx, p, nil, opts...)
+	}
+{{end}}
+{{if .Async}}
+	// {{.Name}}AndWait is like {{.Name}} except that it waits for the
+	// operation it starts to finish, polling its status at the given
+	// interval, and unmarshals the result of the operation into resp.
+	func (c *{{$.ClientType}}) {{.Name}}AndWait(ctx context.Context, p *{{.ParamType}}, interval time.Duration, resp interface{}, opts ...httprequest.CallOption) error {
+		{{- if .ErrorDecoderExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithUnmarshalError({{.ErrorDecoderExpr}})}, opts...)
+		{{- end}}
+		{{- if .RetryExpr}}
+		opts = append([]httprequest.CallOption{httprequest.WithRetry({{.RetryExpr}})}, opts...)
+		{{- end}}
+		var op httprequest.Operation
+		if err := c.Client.CallWithOptions(ctx, p, &op, opts...); err != nil {
+			return err
+		}
+		return c.Client.WaitOperation(ctx, &op, interval, resp)
+	}
+{{end}}
+{{if $.URLs}}
+	// {{.Name}}URL returns the URL that {{.Name}} would call with the
+	// given parameters, without making the call.
+	func (c *{{$.ClientType}}) {{.Name}}URL(ctx context.Context, p *{{.ParamType}}, opts ...httprequest.CallOption) (*url.URL, error) {
+		return c.Client.URL(ctx, p, opts...)
 	}
 {{end}}
 {{end}}
 `))
 
-func generate(serverPkgPath, serverType, clientType string) error {
+// serverSpec identifies a server type to generate client methods for.
+type serverSpec struct {
+	pkg  string
+	name string
+}
+
+// output specifies where generated code is written.
+type output struct {
+	// dir holds the directory of the package to generate the code
+	// in. If it is empty, the current directory is used.
+	dir string
+
+	// filename holds the name of the output file. A relative name is
+	// interpreted relative to dir. If it is empty,
+	// <clienttype>_generated.go is used.
+	filename string
+
+	// pkgName holds the package name to declare in the output.
+	// If it is empty, the name of the package in dir is used.
+	pkgName string
+}
+
+// generate generates the client type for the given servers. The
+// server packages are resolved relative to the current directory.
+func generate(servers []serverSpec, clientType string, out output) error {
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return err
 	}
-	localPkg, err := build.Import(".", currentDir, 0)
+	outDir := filepath.Join(currentDir, out.dir)
+	localPkg, err := outputPackage(outDir)
 	if err != nil {
-		return errgo.Notef(err, "cannot open package in current directory")
+		return errgo.Mask(err)
 	}
-	serverPkg, err := build.Import(serverPkgPath, currentDir, 0)
-	if err != nil {
-		return errgo.Notef(err, "cannot open %q", serverPkgPath)
+	pkgName := localPkg.Name
+	if out.pkgName != "" {
+		pkgName = out.pkgName
 	}
-
-	methods, imports, err := serverMethods(serverPkg.ImportPath, serverType, localPkg.ImportPath)
+	imports := map[string]string{
+		"gopkg.in/httprequest.v1": "httprequest",
+		"context":                 "context",
+		localPkg.ImportPath:       "",
+	}
+	if *urlsFlag {
+		imports["net/url"] = "url"
+	}
+	methods, _, err := loadMethods(servers)
 	if err != nil {
 		return errgo.Mask(err)
 	}
+	gen := newTypeGen(localPkg.ImportPath, imports, *aliasFlag, clientType, clientType+"Interface")
+	for i := range methods {
+		m := &methods[i]
+		m.ParamType = gen.methodTypeStr(m.paramType, m.pkg, m.Name+"Params", "holds the parameters of the "+m.Name+" method.")
+		if m.respType != nil {
+			m.RespType = gen.methodTypeStr(m.respType, m.pkg, m.Name+"Response", "holds the response of the "+m.Name+" method.")
+		}
+	}
+	if err := resolveErrorDecoders(methods, imports); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := resolveRetries(methods, imports); err != nil {
+		return errgo.Mask(err)
+	}
+	addAsyncImports(methods, imports)
+	delete(imports, localPkg.ImportPath)
+	var allImports []string
+	for path := range imports {
+		allImports = append(allImports, path)
+	}
 	arg := templateArg{
-		Imports:    imports,
+		Imports:    allImports,
+		Types:      gen.decls(),
 		Methods:    methods,
-		PkgName:    localPkg.Name,
+		PkgName:    pkgName,
 		ClientType: clientType,
+		URLs:       *urlsFlag,
 	}
+	return writeCode(code, arg, outDir, out.filename, clientType)
+}
+
+// loadMethods returns the methods of all the given servers and the
+// import paths of their packages. Server packages are resolved
+// relative to the current directory.
+func loadMethods(servers []serverSpec) ([]method, []string, error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	var methods []method
+	var pkgPaths []string
+	// definedBy records the server type that defines each method name.
+	definedBy := make(map[string]string)
+	for _, server := range servers {
+		serverPkg, err := build.Import(server.pkg, currentDir, 0)
+		if err != nil {
+			return nil, nil, errgo.Notef(err, "cannot open %q", server.pkg)
+		}
+		if serverPkg.ImportPath == "." {
+			// The package is outside GOPATH.
+			serverPkg.ImportPath, err = moduleImportPath(serverPkg.Dir)
+			if err != nil {
+				return nil, nil, errgo.Notef(err, "cannot determine import path of %q", server.pkg)
+			}
+		}
+		ms, err := serverMethods(serverPkg.ImportPath, server.name)
+		if err != nil {
+			return nil, nil, errgo.Mask(err)
+		}
+		serverName := serverPkg.ImportPath + "." + server.name
+		for _, m := range ms {
+			if other, ok := definedBy[m.Name]; ok {
+				return nil, nil, errgo.Newf("method %s is defined by both %s and %s", m.Name, other, serverName)
+			}
+			definedBy[m.Name] = serverName
+		}
+		methods = append(methods, ms...)
+		pkgPaths = append(pkgPaths, serverPkg.ImportPath)
+	}
+	return methods, pkgPaths, nil
+}
+
+// writeCode executes the given template with the given argument and
+// writes the formatted result to the named file. A relative filename
+// is interpreted relative to dir; if it is empty, the file is named
+// after typeName.
+func writeCode(tmpl *template.Template, arg interface{}, dir, filename, typeName string) error {
 	var buf bytes.Buffer
-	if err := code.Execute(&buf, arg); err != nil {
+	if err := tmpl.Execute(&buf, arg); err != nil {
 		return errgo.Mask(err)
 	}
 	data, err := format.Source(buf.Bytes())
 	if err != nil {
 		return errgo.Notef(err, "cannot format source")
 	}
-	if err := writeOutput(data, clientType); err != nil {
+	if filename == "" {
+		filename = strings.ToLower(typeName) + "_generated.go"
+	}
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
 		return errgo.Mask(err)
 	}
 	return nil
 }
 
-func writeOutput(data []byte, clientType string) error {
-	filename := strings.ToLower(clientType) + "_generated.go"
-	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
-		return errgo.Mask(err)
+// outputPackage returns the package in the given directory, creating
+// the directory if needed. If the directory holds no Go files yet,
+// the package is named after the directory.
+func outputPackage(dir string) (*build.Package, error) {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	pkg, err := build.ImportDir(dir, 0)
+	if _, ok := err.(*build.NoGoError); ok {
+		pkg.Name = filepath.Base(dir)
+	} else if err != nil {
+		return nil, errgo.Notef(err, "cannot open package in %s", dir)
+	}
+	if pkg.ImportPath == "" || pkg.ImportPath == "." {
+		// The directory is outside GOPATH, so infer
+		// the import path from the enclosing module.
+		pkg.ImportPath, err = moduleImportPath(dir)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot determine import path of %s", dir)
+		}
 	}
-	return nil
+	return pkg, nil
+}
+
+// moduleImportPath returns the import path of the package in the
+// given directory by finding the go.mod file of the enclosing module.
+func moduleImportPath(dir string) (string, error) {
+	for modDir := dir; ; {
+		data, err := ioutil.ReadFile(filepath.Join(modDir, "go.mod"))
+		if err == nil {
+			modPath := modulePath(data)
+			if modPath == "" {
+				return "", errgo.Newf("no module directive in %s", filepath.Join(modDir, "go.mod"))
+			}
+			rel, err := filepath.Rel(modDir, dir)
+			if err != nil {
+				return "", errgo.Mask(err)
+			}
+			if rel == "." {
+				return modPath, nil
+			}
+			return path.Join(modPath, filepath.ToSlash(rel)), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", errgo.Mask(err)
+		}
+		parent := filepath.Dir(modDir)
+		if parent == modDir {
+			return "", errgo.New("not inside a module")
+		}
+		modDir = parent
+	}
+}
+
+// modulePath returns the module path declared in the given
+// go.mod file contents, or the empty string if there is none.
+func modulePath(data []byte) string {
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
 }
 
 type method struct {
-	Name      string
-	Doc       string
-	ParamType string
-	RespType  string
+	Name      string `json:"name"`
+	Doc       string `json:"doc,omitempty"`
+	ParamType string `json:"param-type"`
+	RespType  string `json:"resp-type,omitempty"`
+
+	// HTTPMethod and Path hold the route of the method
+	// as specified in its parameter type.
+	HTTPMethod string `json:"http-method"`
+	Path       string `json:"path"`
+
+	// ErrorDecoder holds the function specified by an
+	// error-decoder directive, if any, and ErrorDecoderExpr
+	// holds the expression used to refer to it in the
+	// generated code.
+	ErrorDecoder     string `json:"error-decoder,omitempty"`
+	ErrorDecoderExpr string `json:"-"`
+
+	// Retry holds the argument of the retry directive, if
+	// any, and RetryExpr holds the httprequest.RetryPolicy
+	// expression derived from it.
+	Retry     string `json:"retry,omitempty"`
+	RetryExpr string `json:"-"`
+
+	// Async holds whether the method has an async directive.
+	Async bool `json:"async,omitempty"`
+
+	// paramType and respType hold the parameter and response
+	// types. respType is nil if there is no response value.
+	paramType types.Type
+	respType  types.Type
+
+	// pkg holds the server package that the method was found in.
+	pkg *packages.Package
 }
 
-// serverMethods returns the list of server methods and required import packages
+// serverMethods returns the list of server methods
 // provided by the given server type within the given server package.
-//
-// The localPkg package will be the one that the code will be generated in.
-func serverMethods(serverPkg, serverType, localPkg string) ([]method, []string, error) {
+// The ParamType and RespType fields of the methods are
+// left to be filled in by the caller.
+func serverMethods(serverPkg, serverType string) ([]method, error) {
 	cfg := packages.Config{
 		Mode: packages.LoadAllSyntax,
 		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
@@ -148,30 +516,25 @@ func serverMethods(serverPkg, serverType, localPkg string) ([]method, []string,
 	}
 	pkgs, err := packages.Load(&cfg, serverPkg)
 	if err != nil {
-		return nil, nil, errgo.Notef(err, "cannot load %q", serverPkg)
+		return nil, errgo.Notef(err, "cannot load %q", serverPkg)
 	}
 	if len(pkgs) != 1 {
-		return nil, nil, errgo.Newf("packages.Load returned %d packages, not 1", len(pkgs))
+		return nil, errgo.Newf("packages.Load returned %d packages, not 1", len(pkgs))
 	}
 	pkgInfo := pkgs[0]
 	pkg := pkgInfo.Types
 
 	obj := pkg.Scope().Lookup(serverType)
 	if obj == nil {
-		return nil, nil, errgo.Newf("type %s not found in %s", serverType, serverPkg)
+		return nil, errgo.Newf("type %s not found in %s", serverType, serverPkg)
 	}
 	objTypeName, ok := obj.(*types.TypeName)
 	if !ok {
-		return nil, nil, errgo.Newf("%s is not a type", serverType)
+		return nil, errgo.Newf("%s is not a type", serverType)
 	}
 	// Use the pointer type to get as many methods as possible.
 	ptrObjType := types.NewPointer(objTypeName.Type())
 
-	imports := map[string]string{
-		"gopkg.in/httprequest.v1": "httprequest",
-		"context":                 "context",
-		localPkg:                  "",
-	}
 	var methods []method
 	mset := types.NewMethodSet(ptrObjType)
 	for i := 0; i < mset.Len(); i++ {
@@ -188,20 +551,22 @@ func serverMethods(serverPkg, serverType, localPkg string) ([]method, []string,
 			fmt.Fprintf(os.Stderr, "ignoring method %s: %v\n", name, err)
 			continue
 		}
-		comment := docComment(pkgInfo, sel)
+		comment, directives := parseDirectives(docComment(pkgInfo, sel))
+		httpMethod, path := paramRoute(ptype)
 		methods = append(methods, method{
-			Name:      name,
-			Doc:       comment,
-			ParamType: typeStr(ptype, imports),
-			RespType:  typeStr(rtype, imports),
+			Name:         name,
+			Doc:          comment,
+			HTTPMethod:   httpMethod,
+			Path:         path,
+			ErrorDecoder: directives["error-decoder"],
+			Retry:        directives["retry"],
+			Async:        hasDirective(directives, "async"),
+			paramType:    ptype,
+			respType:     rtype,
+			pkg:          pkgInfo,
 		})
 	}
-	delete(imports, localPkg)
-	var allImports []string
-	for path := range imports {
-		allImports = append(allImports, path)
-	}
-	return methods, allImports, nil
+	return methods, nil
 }
 
 // docComment returns the doc comment for the method referred to
@@ -240,6 +605,198 @@ func docComment(pkg *packages.Package, sel *types.Selection) string {
 	return comment
 }
 
+// directivePrefix is the prefix of directive comment lines in server
+// method doc comments. A directive has the form:
+//
+//	//httprequest:name argument
+//
+// The error-decoder directive names a function of type
+// func(*http.Response) error that the generated client method will
+// use to unmarshal error responses instead of Client.UnmarshalError.
+// The function is specified either as a qualified name
+// (for example example.com/api/errors.Unmarshal) or as
+// a name in the package of the generated code.
+//
+// The retry directive specifies that the method is safe to retry,
+// and how the generated client method retries it (see
+// httprequest.RetryPolicy). Its arguments are the maximum number of
+// attempts, optionally followed by the initial and maximum backoff
+// durations, for example:
+//
+//	//httprequest:retry 3 200ms 5s
+//
+// The async directive, which takes no arguments, specifies that the
+// method starts a long-running operation and responds with an
+// httprequest.Operation (see httprequest.Operations). In addition
+// to the usual client method, a method with the suffix AndWait is
+// generated that waits for the operation to finish with
+// httprequest.Client.WaitOperation.
+const directivePrefix = "//httprequest:"
+
+// parseDirectives returns the given doc comment with any directive
+// lines removed, and the directives found (map from directive name
+// to argument).
+func parseDirectives(doc string) (string, map[string]string) {
+	if !strings.Contains(doc, directivePrefix) {
+		return doc, nil
+	}
+	directives := make(map[string]string)
+	var lines []string
+	for _, line := range strings.Split(doc, "\n") {
+		if !strings.HasPrefix(line, directivePrefix) {
+			lines = append(lines, line)
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, directivePrefix))
+		if len(fields) == 0 {
+			continue
+		}
+		directives[fields[0]] = strings.Join(fields[1:], " ")
+	}
+	// Remove any trailing blank comment lines left behind.
+	for len(lines) > 0 && strings.TrimSpace(strings.TrimPrefix(lines[len(lines)-1], "//")) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n"), directives
+}
+
+// hasDirective reports whether the given directive is present.
+func hasDirective(directives map[string]string, name string) bool {
+	_, ok := directives[name]
+	return ok
+}
+
+// resolveErrorDecoders sets the ErrorDecoderExpr field of each method
+// with an error decoder, adding any needed import paths to the given
+// imports map (map from package path to package id).
+func resolveErrorDecoders(methods []method, imports map[string]string) error {
+	for i := range methods {
+		m := &methods[i]
+		if m.ErrorDecoder == "" {
+			continue
+		}
+		dot := strings.LastIndex(m.ErrorDecoder, ".")
+		if dot == -1 {
+			m.ErrorDecoderExpr = m.ErrorDecoder
+			continue
+		}
+		path, name := m.ErrorDecoder[:dot], m.ErrorDecoder[dot+1:]
+		if path == "" || name == "" {
+			return errgo.Newf("invalid error decoder %q for method %s", m.ErrorDecoder, m.Name)
+		}
+		id, ok := imports[path]
+		if !ok {
+			names, err := packageNames([]string{path})
+			if err != nil {
+				return errgo.Notef(err, "cannot resolve error decoder for method %s", m.Name)
+			}
+			id = names[path]
+			for oldPath, oldID := range imports {
+				if oldID == id {
+					return errgo.Newf("duplicate package name %s vs %s", path, oldPath)
+				}
+			}
+			imports[path] = id
+		}
+		if id == "" {
+			// The function is in the local package.
+			m.ErrorDecoderExpr = name
+		} else {
+			m.ErrorDecoderExpr = id + "." + name
+		}
+	}
+	return nil
+}
+
+// packageNames returns the names of the packages with the given
+// import paths (map from package path to package name), resolving
+// them relative to the current directory. The name of a package
+// cannot be derived from its path, which may end in a version suffix
+// (for example gopkg.in/errgo.v1).
+func packageNames(paths []string) (map[string]string, error) {
+	pkgs, err := packages.Load(&packages.Config{Mode: packages.NeedName}, paths...)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot load packages")
+	}
+	names := make(map[string]string)
+	for _, pkg := range pkgs {
+		if pkg.Name != "" {
+			names[pkg.PkgPath] = pkg.Name
+		}
+	}
+	for _, path := range paths {
+		if names[path] == "" {
+			return nil, errgo.Newf("cannot find package %q", path)
+		}
+	}
+	return names, nil
+}
+
+// resolveRetries sets the RetryExpr field of each method with a
+// retry directive, adding the time package to the given imports
+// map (map from package path to package id) if it is needed.
+func resolveRetries(methods []method, imports map[string]string) error {
+	for i := range methods {
+		m := &methods[i]
+		if m.Retry == "" {
+			continue
+		}
+		fields := strings.Fields(m.Retry)
+		if len(fields) > 3 {
+			return errgo.Newf("invalid retry directive %q for method %s", m.Retry, m.Name)
+		}
+		attempts, err := strconv.Atoi(fields[0])
+		if err != nil || attempts < 1 {
+			return errgo.Newf("invalid retry attempts %q for method %s", fields[0], m.Name)
+		}
+		expr := fmt.Sprintf("MaxAttempts: %d", attempts)
+		for j, name := range []string{"Backoff", "MaxBackoff"} {
+			if len(fields) < j+2 {
+				break
+			}
+			d, err := time.ParseDuration(fields[j+1])
+			if err != nil || d <= 0 {
+				return errgo.Newf("invalid retry %s %q for method %s", strings.ToLower(name), fields[j+1], m.Name)
+			}
+			expr += fmt.Sprintf(", %s: %s", name, durationExpr(d))
+			imports["time"] = "time"
+		}
+		m.RetryExpr = "httprequest.RetryPolicy{" + expr + "}"
+	}
+	return nil
+}
+
+// addAsyncImports adds the packages needed by the AndWait methods
+// generated for methods with an async directive to the given imports
+// map (map from package path to package id).
+func addAsyncImports(methods []method, imports map[string]string) {
+	for _, m := range methods {
+		if m.Async {
+			imports["time"] = "time"
+			return
+		}
+	}
+}
+
+// durationExpr returns a Go expression for the duration d.
+func durationExpr(d time.Duration) string {
+	for _, u := range []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "Hour"},
+		{time.Minute, "Minute"},
+		{time.Second, "Second"},
+		{time.Millisecond, "Millisecond"},
+		{time.Microsecond, "Microsecond"},
+	} {
+		if d%u.d == 0 {
+			return fmt.Sprintf("%d * time.%s", d/u.d, u.name)
+		}
+	}
+	return fmt.Sprintf("time.Duration(%d)", d)
+}
+
 func commentStr(c *ast.CommentGroup) string {
 	if c == nil {
 		return ""
@@ -279,6 +836,29 @@ func typeStr(t types.Type, imports map[string]string) string {
 	return types.TypeString(t, qualify)
 }
 
+// paramRoute returns the HTTP method and path specified by the
+// httprequest.Route field of the given parameter struct type,
+// or empty strings if there is none.
+func paramRoute(t types.Type) (method, path string) {
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok {
+		return "", ""
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		named, ok := f.Type().(*types.Named)
+		if !ok || !f.Anonymous() || named.Obj().Name() != "Route" || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "gopkg.in/httprequest.v1" {
+			continue
+		}
+		r, err := tags.ParseRoute(reflect.StructTag(st.Tag(i)))
+		if err != nil || r.Path == "" {
+			return "", ""
+		}
+		return r.Method, r.Path
+	}
+	return "", ""
+}
+
 func parseMethodType(t *types.Signature) (ptype, rtype types.Type, err error) {
 	mp := t.Params()
 	if mp.Len() != 1 && mp.Len() != 2 {
@@ -0,0 +1,208 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+
+	"gopkg.in/errgo.v1"
+)
+
+// snapshotVersion holds the current version of the snapshot format.
+const snapshotVersion = 1
+
+// snapshot holds the wire schema of a set of servers: the routes
+// they serve and the layouts of the types they use. It is
+// self-contained so that code can be generated from it without
+// access to the server source.
+type snapshot struct {
+	Version int `json:"version"`
+
+	// Imports holds the import paths of the packages
+	// referred to by Types and Methods.
+	Imports []string `json:"imports,omitempty"`
+
+	// Types holds the types declared for the schema. All the
+	// types defined in the server packages are declared here.
+	Types []typeDecl `json:"types"`
+
+	// Methods holds the server methods.
+	Methods []method `json:"methods"`
+}
+
+// writeSnapshot writes a snapshot of the schema of the given servers
+// to the named file.
+func writeSnapshot(servers []serverSpec, filename string) error {
+	methods, pkgPaths, err := loadMethods(servers)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	imports := make(map[string]string)
+	gen := newTypeGen("", imports, false)
+	gen.declarePkgs = make(map[string]bool)
+	for _, path := range pkgPaths {
+		gen.declarePkgs[path] = true
+	}
+	for i := range methods {
+		m := &methods[i]
+		m.ParamType = gen.methodTypeStr(m.paramType, m.pkg, m.Name+"Params", "holds the parameters of the "+m.Name+" method.")
+		if m.respType != nil {
+			m.RespType = gen.methodTypeStr(m.respType, m.pkg, m.Name+"Response", "holds the response of the "+m.Name+" method.")
+		}
+	}
+	snap := snapshot{
+		Version: snapshotVersion,
+		Types:   gen.decls(),
+		Methods: methods,
+	}
+	for path := range imports {
+		snap.Imports = append(snap.Imports, path)
+	}
+	sort.Strings(snap.Imports)
+	sort.Slice(snap.Methods, func(i, j int) bool {
+		return snap.Methods[i].Name < snap.Methods[j].Name
+	})
+	data, err := json.MarshalIndent(snap, "", "\t")
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	data = append(data, '\n')
+	if err := ioutil.WriteFile(filename, data, 0644); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// readSnapshot reads a snapshot written by writeSnapshot.
+func readSnapshot(filename string) (*snapshot, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, errgo.Notef(err, "cannot parse snapshot %s", filename)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, errgo.Newf("unsupported snapshot version %d in %s", snap.Version, filename)
+	}
+	return &snap, nil
+}
+
+// generateFromSnapshot generates code from the named snapshot file. If
+// serverType is empty, it generates a client type named clientType;
+// otherwise it generates a server stub type named serverType.
+func generateFromSnapshot(filename, clientType, serverType string, out output) error {
+	snap, err := readSnapshot(filename)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	outDir := filepath.Join(currentDir, out.dir)
+	localPkg, err := outputPackage(outDir)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	pkgName := localPkg.Name
+	if out.pkgName != "" {
+		pkgName = out.pkgName
+	}
+	typeName, reserved := clientType, []string{clientType, clientType + "Interface"}
+	tmpl := code
+	imports := []string{"context", "gopkg.in/httprequest.v1"}
+	if *urlsFlag {
+		imports = append(imports, "net/url")
+	}
+	if serverType != "" {
+		typeName, reserved = serverType, []string{serverType}
+		tmpl = serverStubCode
+		imports = []string{"errors"}
+	}
+	for _, t := range snap.Types {
+		for _, name := range reserved {
+			if t.Name == name {
+				return errgo.Newf("cannot generate %s: name is used by a type in the snapshot", name)
+			}
+		}
+	}
+	imported := make(map[string]bool)
+	for _, path := range imports {
+		imported[path] = true
+	}
+	for _, path := range snap.Imports {
+		if !imported[path] {
+			imports = append(imports, path)
+		}
+	}
+	if serverType == "" {
+		importIDs, err := packageNames(imports)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		importIDs[localPkg.ImportPath] = ""
+		if err := resolveErrorDecoders(snap.Methods, importIDs); err != nil {
+			return errgo.Mask(err)
+		}
+		if err := resolveRetries(snap.Methods, importIDs); err != nil {
+			return errgo.Mask(err)
+		}
+		addAsyncImports(snap.Methods, importIDs)
+		delete(importIDs, localPkg.ImportPath)
+		for path := range importIDs {
+			if !imported[path] {
+				imports = append(imports, path)
+			}
+		}
+	}
+	arg := templateArg{
+		PkgName:    pkgName,
+		Imports:    imports,
+		Types:      snap.Types,
+		Methods:    snap.Methods,
+		ClientType: typeName,
+		URLs:       *urlsFlag,
+	}
+	return writeCode(tmpl, arg, outDir, out.filename, typeName)
+}
+
+// serverStubCode generates a server type with methods that
+// correspond to those in a snapshot. The ClientType field of
+// the argument holds the name of the server type.
+var serverStubCode = template.Must(template.New("").Parse(`
+// The code in this file was generated by running httprequest-generate-client
+// from a schema snapshot. Copy it and fill in the method implementations.
+
+package {{.PkgName}}
+import (
+	{{range .Imports}}{{printf "%q" .}}
+	{{end}}
+)
+
+{{range .Types}}
+{{.Doc}}
+type {{.Name}} {{if .Alias}}= {{end}}{{.Type}}
+{{end}}
+
+type {{.ClientType}} struct{}
+
+{{range .Methods}}
+{{.Doc}}
+func ({{$.ClientType}}) {{.Name}}(p *{{.ParamType}}) ({{if .RespType}}{{.RespType}}, {{end}}error) {
+{{- if .RespType}}
+	var r {{.RespType}}
+	return r, errors.New("{{.Name}} not implemented")
+{{- else}}
+	return errors.New("{{.Name}} not implemented")
+{{- end}}
+}
+{{end}}
+`))
@@ -0,0 +1,12 @@
+// Package errors is used to test error decoders in packages with
+// versioned import paths.
+package errors
+
+import (
+	"net/http"
+)
+
+// Unmarshal unmarshals an error response.
+func Unmarshal(resp *http.Response) error {
+	return nil
+}
@@ -0,0 +1,349 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/packages"
+	"gopkg.in/errgo.v1"
+)
+
+// typeDecl describes a type declared in the generated code.
+type typeDecl struct {
+	Name string `json:"name"`
+	Doc  string `json:"doc,omitempty"`
+	// Type holds the type expression of the declared type.
+	Type string `json:"type"`
+	// Alias holds whether the declaration is a type alias.
+	Alias bool `json:"alias,omitempty"`
+}
+
+// typeGen generates the type expressions used in the generated code.
+// Types that cannot be referred to from the local package because
+// they are unexported or internal are re-declared there under
+// exported names. Optionally, local aliases are also declared for the
+// exported parameter and response types from other packages.
+//
+// No code is generated to convert between a re-declared type and the
+// original, which the local package cannot refer to. The re-declared
+// type has the same fields and tags as the original, so values are
+// converted by marshaling them in the request and unmarshaling them
+// on the server, and vice versa for responses. Methods of the
+// original type are not re-declared.
+type typeGen struct {
+	localPkg string
+	imports  map[string]string
+	aliases  bool
+
+	// declarePkgs holds the import paths of packages
+	// all of whose types are re-declared in the generated
+	// code, even if they could be referred to directly.
+	declarePkgs map[string]bool
+
+	// names holds the local names of the types declared
+	// in the generated code.
+	names map[*types.TypeName]string
+
+	// used holds all the names declared in the generated code.
+	used map[string]bool
+
+	typeDecls []typeDecl
+}
+
+// newTypeGen returns a typeGen that generates code in the package with
+// the given import path, adding any needed imports to the imports map
+// (map from package path to package id). If aliases is true,
+// methodTypeStr will declare aliases for types from other packages.
+// The reserved names are not used for any declared types.
+func newTypeGen(localPkg string, imports map[string]string, aliases bool, reserved ...string) *typeGen {
+	g := &typeGen{
+		localPkg: localPkg,
+		imports:  imports,
+		aliases:  aliases,
+		names:    make(map[*types.TypeName]string),
+		used:     make(map[string]bool),
+	}
+	for _, name := range reserved {
+		g.used[name] = true
+	}
+	return g
+}
+
+// decls returns all the types declared by g, ordered by name.
+func (g *typeGen) decls() []typeDecl {
+	decls := append([]typeDecl(nil), g.typeDecls...)
+	sort.Slice(decls, func(i, j int) bool {
+		return decls[i].Name < decls[j].Name
+	})
+	return decls
+}
+
+// methodTypeStr returns the type string to be used for the parameter
+// or response type t of a method found in the given package. If t is
+// (or points to) a struct or non-empty interface literal, a named type
+// is declared for it; litName and litDoc hold the name and doc comment
+// to use for that type.
+func (g *typeGen) methodTypeStr(t types.Type, pkg *packages.Package, litName, litDoc string) string {
+	if lit, ptr := literalType(t); lit != nil {
+		return ptr + g.declareLiteral(lit, pkg, litName, litDoc)
+	}
+	if !g.aliases || g.needsDecl(t) {
+		return g.typeStr(t, pkg)
+	}
+	ptr := ""
+	if t1, ok := t.(*types.Pointer); ok {
+		ptr, t = "*", t1.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return ptr + g.typeStr(t, pkg)
+	}
+	obj := named.Obj()
+	if name, ok := g.names[obj]; ok {
+		return ptr + name
+	}
+	if obj.Pkg() == nil || obj.Pkg().Path() == g.localPkg {
+		return ptr + g.typeStr(t, pkg)
+	}
+	if g.used[obj.Name()] {
+		fmt.Fprintf(os.Stderr, "not generating alias for %s.%s: name already used\n", obj.Pkg().Path(), obj.Name())
+		return ptr + g.typeStr(t, pkg)
+	}
+	g.used[obj.Name()] = true
+	g.names[obj] = obj.Name()
+	g.typeDecls = append(g.typeDecls, typeDecl{
+		Name:  obj.Name(),
+		Doc:   typeDocComment(pkg, obj),
+		Type:  typeStr(named, g.imports),
+		Alias: true,
+	})
+	return ptr + obj.Name()
+}
+
+// typeStr returns the type string to be used for the type t,
+// which was found in the given package, declaring local
+// types as needed.
+func (g *typeGen) typeStr(t types.Type, pkg *packages.Package) string {
+	if t == nil {
+		return ""
+	}
+	if _, ok := t.(*types.Struct); !ok && !g.needsDecl(t) {
+		return typeStr(t, g.imports)
+	}
+	switch t := t.(type) {
+	case *types.Named:
+		return g.declare(t, pkg)
+	case *types.Pointer:
+		return "*" + g.typeStr(t.Elem(), pkg)
+	case *types.Slice:
+		return "[]" + g.typeStr(t.Elem(), pkg)
+	case *types.Array:
+		return fmt.Sprintf("[%d]%s", t.Len(), g.typeStr(t.Elem(), pkg))
+	case *types.Map:
+		return fmt.Sprintf("map[%s]%s", g.typeStr(t.Key(), pkg), g.typeStr(t.Elem(), pkg))
+	case *types.Chan:
+		elem := g.typeStr(t.Elem(), pkg)
+		switch t.Dir() {
+		case types.SendOnly:
+			return "chan<- " + elem
+		case types.RecvOnly:
+			return "<-chan " + elem
+		}
+		return "chan " + elem
+	case *types.Struct:
+		var buf strings.Builder
+		buf.WriteString("struct {\n")
+		for i := 0; i < t.NumFields(); i++ {
+			f := t.Field(i)
+			if !f.Anonymous() {
+				buf.WriteString(f.Name())
+				buf.WriteString(" ")
+			}
+			buf.WriteString(g.typeStr(f.Type(), pkg))
+			if tag := t.Tag(i); tag != "" {
+				if strings.Contains(tag, "`") {
+					fmt.Fprintf(&buf, " %q", tag)
+				} else {
+					fmt.Fprintf(&buf, " `%s`", tag)
+				}
+			}
+			buf.WriteString("\n")
+		}
+		buf.WriteString("}")
+		return buf.String()
+	}
+	panic(errgo.Newf("cannot generate code for type %s", t))
+}
+
+// literalType returns the struct or non-empty interface literal type
+// that t is or points to, and "*" if it points to it. It returns nil
+// if t is not such a type.
+func literalType(t types.Type) (types.Type, string) {
+	ptr := ""
+	if t1, ok := t.(*types.Pointer); ok {
+		ptr, t = "*", t1.Elem()
+	}
+	switch t1 := t.(type) {
+	case *types.Struct:
+		return t1, ptr
+	case *types.Interface:
+		if t1.NumMethods() > 0 {
+			return t1, ptr
+		}
+	}
+	return nil, ""
+}
+
+// declareLiteral declares a named type for the given literal type
+// and returns its name. If the given name is already used, a numeric
+// suffix is added.
+func (g *typeGen) declareLiteral(t types.Type, pkg *packages.Package, name, doc string) string {
+	base := name
+	for i := 1; g.used[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	g.used[name] = true
+	g.typeDecls = append(g.typeDecls, typeDecl{
+		Name: name,
+		Doc:  "// " + name + " " + doc,
+		Type: g.typeStr(t, pkg),
+	})
+	return name
+}
+
+// declare declares a local exported equivalent of the given named
+// type and returns its name. The equivalent has the same underlying
+// type, with any types that it refers to declared in the same way,
+// but none of the methods of t.
+func (g *typeGen) declare(t *types.Named, pkg *packages.Package) string {
+	obj := t.Obj()
+	if name, ok := g.names[obj]; ok {
+		return name
+	}
+	name := exportedName(obj.Name())
+	if g.used[name] {
+		name = exportedName(obj.Pkg().Name()) + name
+	}
+	if g.used[name] {
+		panic(errgo.Newf("cannot generate local type for %s.%s: name %s already used", obj.Pkg().Path(), obj.Name(), name))
+	}
+	g.used[name] = true
+	if types.NewMethodSet(types.NewPointer(t)).Len() > 0 {
+		fmt.Fprintf(os.Stderr, "warning: methods of %s.%s are not available on generated type %s\n", obj.Pkg().Path(), obj.Name(), name)
+	}
+	// Register the name before generating the underlying type
+	// so that recursive types refer to it.
+	g.names[obj] = name
+	doc := typeDocComment(pkg, obj)
+	if strings.HasPrefix(doc, "// "+obj.Name()+" ") {
+		// Keep the doc comment consistent with the new name.
+		doc = "// " + name + strings.TrimPrefix(doc, "// "+obj.Name())
+	}
+	i := len(g.typeDecls)
+	g.typeDecls = append(g.typeDecls, typeDecl{
+		Name: name,
+		Doc:  doc,
+	})
+	g.typeDecls[i].Type = g.typeStr(t.Underlying(), pkg)
+	return name
+}
+
+// needsDecl reports whether the type t refers to any named type that
+// cannot be referred to directly from the local package.
+func (g *typeGen) needsDecl(t types.Type) bool {
+	switch t := t.(type) {
+	case *types.Named:
+		obj := t.Obj()
+		if obj.Pkg() == nil || obj.Pkg().Path() == g.localPkg {
+			return false
+		}
+		if !obj.Exported() || !canImport(g.localPkg, obj.Pkg().Path()) || g.declarePkgs[obj.Pkg().Path()] {
+			return true
+		}
+		return false
+	case *types.Pointer:
+		return g.needsDecl(t.Elem())
+	case *types.Slice:
+		return g.needsDecl(t.Elem())
+	case *types.Array:
+		return g.needsDecl(t.Elem())
+	case *types.Map:
+		return g.needsDecl(t.Key()) || g.needsDecl(t.Elem())
+	case *types.Chan:
+		return g.needsDecl(t.Elem())
+	case *types.Struct:
+		for i := 0; i < t.NumFields(); i++ {
+			if g.needsDecl(t.Field(i).Type()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// canImport reports whether the package with the import path
+// pkgPath may be imported by the package with the import path
+// fromPath, following the rules for internal packages.
+func canImport(fromPath, pkgPath string) bool {
+	i := strings.LastIndex("/"+pkgPath+"/", "/internal/")
+	if i == -1 {
+		return true
+	}
+	if i == 0 {
+		// A top-level internal package (for example in
+		// the standard library).
+		return false
+	}
+	// Allow for the leading slash added above.
+	parent := pkgPath[:i-1]
+	return fromPath == parent || strings.HasPrefix(fromPath, parent+"/")
+}
+
+// exportedName returns name with its first letter in upper case.
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
+
+// typeDocComment returns the doc comment for the given type
+// which must be declared in pkg or one of its dependencies.
+func typeDocComment(pkg *packages.Package, obj *types.TypeName) string {
+	comment := ""
+	packages.Visit([]*packages.Package{pkg}, func(pkg *packages.Package) bool {
+		if pkg.Types != obj.Pkg() {
+			return true
+		}
+		for _, f := range pkg.Syntax {
+			for _, decl := range f.Decls {
+				gdecl, ok := decl.(*ast.GenDecl)
+				if !ok || gdecl.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gdecl.Specs {
+					tspec := spec.(*ast.TypeSpec)
+					if tspec.Name.Pos() != obj.Pos() {
+						continue
+					}
+					doc := tspec.Doc
+					if doc == nil && len(gdecl.Specs) == 1 {
+						doc = gdecl.Doc
+					}
+					comment = commentStr(doc)
+					return false
+				}
+			}
+		}
+		return false
+	}, nil)
+	return comment
+}
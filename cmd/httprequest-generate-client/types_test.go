@@ -0,0 +1,86 @@
+//go:build go1.8
+// +build go1.8
+
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/tools/go/packages"
+)
+
+const internalSrc = `
+package api
+
+// thing holds a thing.
+type thing struct {
+	Name string ` + "`json:\"name\"`" + `
+	Next *thing ` + "`json:\"next\"`" + `
+}
+
+func (t *thing) String() string {
+	return t.Name
+}
+
+// Params holds some parameters.
+type Params struct {
+	Thing thing ` + "`httprequest:\",body\"`" + `
+}
+`
+
+func TestTypeGenDeclare(t *testing.T) {
+	c := qt.New(t)
+	pkg := checkPackage(c, "example.com/server/internal/api", internalSrc)
+	gen := newTypeGen("example.com/client", map[string]string{}, false, "Client")
+	params := pkg.Types.Scope().Lookup("Params").Type()
+
+	c.Assert(gen.methodTypeStr(types.NewPointer(params), pkg, "GetParams", "holds the parameters."), qt.Equals, "*Params")
+	c.Assert(gen.decls(), qt.DeepEquals, []typeDecl{{
+		Name: "Params",
+		Doc:  "// Params holds some parameters.",
+		Type: "struct {\nThing Thing `httprequest:\",body\"`\n}",
+	}, {
+		Name: "Thing",
+		Doc:  "// Thing holds a thing.",
+		Type: "struct {\nName string `json:\"name\"`\nNext *Thing `json:\"next\"`\n}",
+	}})
+}
+
+func TestTypeGenImportable(t *testing.T) {
+	c := qt.New(t)
+	pkg := checkPackage(c, "example.com/server/internal/api", internalSrc)
+	// The client is allowed to import the internal package, so
+	// only the unexported type is re-declared.
+	imports := map[string]string{}
+	gen := newTypeGen("example.com/server/client", imports, false, "Client")
+	params := pkg.Types.Scope().Lookup("Params").Type()
+
+	c.Assert(gen.methodTypeStr(params, pkg, "GetParams", "holds the parameters."), qt.Equals, "api.Params")
+	c.Assert(imports, qt.DeepEquals, map[string]string{
+		"example.com/server/internal/api": "api",
+	})
+	c.Assert(gen.decls(), qt.HasLen, 0)
+}
+
+// checkPackage type-checks the given source, which must not import
+// any packages, as the package with the given path.
+func checkPackage(c *qt.C, path, src string) *packages.Package {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "api.go", src, parser.ParseComments)
+	c.Assert(err, qt.IsNil)
+	var conf types.Config
+	tpkg, err := conf.Check(path, fset, []*ast.File{f}, nil)
+	c.Assert(err, qt.IsNil)
+	return &packages.Package{
+		PkgPath: path,
+		Name:    tpkg.Name(),
+		Fset:    fset,
+		Syntax:  []*ast.File{f},
+		Types:   tpkg,
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// The httprequest-vet command checks httprequest parameter structs
+// for common mistakes. It can be run directly or with
+// "go vet -vettool=$(which httprequest-vet)".
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"gopkg.in/httprequest.v1/tagcheck"
+)
+
+func main() {
+	singlechecker.Main(tagcheck.Analyzer)
+}
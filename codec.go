@@ -0,0 +1,373 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Codec is implemented by an encoding that can be used for the
+// results returned by handlers. See Server.Codecs and Client.Codecs.
+//
+// Codecs for other encodings, such as CBOR, can be registered by
+// implementing this interface.
+type Codec interface {
+	// ContentType returns the media type of the encoding,
+	// for example "application/xml".
+	ContentType() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values as JSON using encoding/json.
+	JSONCodec Codec = jsonCodec{}
+
+	// XMLCodec encodes values as XML using encoding/xml.
+	XMLCodec Codec = xmlCodec{}
+)
+
+// NewJSONCodec returns a JSON codec that uses the given functions,
+// which should behave like json.Marshal and json.Unmarshal. It can be
+// used to set Server.JSON and Client.JSON so that an alternative JSON
+// implementation, such as jsoniter or go-json, is used in place of
+// encoding/json.
+func NewJSONCodec(marshal func(v interface{}) ([]byte, error), unmarshal func(data []byte, v interface{}) error) Codec {
+	return funcJSONCodec{
+		marshal:   marshal,
+		unmarshal: unmarshal,
+	}
+}
+
+type funcJSONCodec struct {
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+func (funcJSONCodec) ContentType() string {
+	return "application/json"
+}
+
+func (c funcJSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return c.marshal(v)
+}
+
+func (c funcJSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.unmarshal(data, v)
+}
+
+// jsonMarshal marshals v as JSON with jc or, if it is nil, with
+// encoding/json.
+func jsonMarshal(jc Codec, v interface{}) ([]byte, error) {
+	if jc == nil {
+		return json.Marshal(v)
+	}
+	return jc.Marshal(v)
+}
+
+// jsonUnmarshal unmarshals JSON data into v with jc or, if it is
+// nil, with encoding/json.
+func jsonUnmarshal(jc Codec, data []byte, v interface{}) error {
+	if jc == nil {
+		return json.Unmarshal(data, v)
+	}
+	return jc.Unmarshal(data, v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) ContentType() string {
+	return "application/xml"
+}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	if ch, ok := v.(*CustomHeader); ok {
+		v = ch.Body
+	} else if ch, ok := v.(CustomHeader); ok {
+		v = ch.Body
+	}
+	return xml.Marshal(v)
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// negotiateCodec returns the codec in codecs that is most acceptable
+// according to the given Accept header value. Codecs earlier in the
+// slice are preferred when equally acceptable. If the header is empty,
+// the first codec is returned; if no codec is acceptable, the first
+// one that the header does not refuse is returned, or the first codec
+// if it refuses them all.
+func negotiateCodec(accept string, codecs []Codec) Codec {
+	if accept == "" {
+		return codecs[0]
+	}
+	ranges := parseAccept(accept)
+	var best Codec
+	bestQ := 0.0
+	for _, c := range codecs {
+		if q, _ := acceptQuality(ranges, c.ContentType()); q > bestQ {
+			best, bestQ = c, q
+		}
+	}
+	if best != nil {
+		return best
+	}
+	for _, c := range codecs {
+		if !refused(ranges, c.ContentType()) {
+			return c
+		}
+	}
+	return codecs[0]
+}
+
+// mediaRange holds a media range from an Accept header.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges in the given Accept header value,
+// ignoring any that are malformed.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, s := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(s))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qs, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(qs, 64)
+			if err != nil {
+				continue
+			}
+		}
+		ranges = append(ranges, mediaRange{
+			mediaType: mediaType,
+			q:         q,
+		})
+	}
+	return ranges
+}
+
+// acceptQuality returns the quality given to mediaType by the most
+// specific of the given media ranges that matches it, and reports
+// whether any does.
+func acceptQuality(ranges []mediaRange, mediaType string) (float64, bool) {
+	typ := mediaType
+	if i := strings.Index(typ, "/"); i >= 0 {
+		typ = typ[:i]
+	}
+	q, specificity := 0.0, 0
+	for _, r := range ranges {
+		s := 0
+		switch r.mediaType {
+		case mediaType:
+			s = 3
+		case typ + "/*":
+			s = 2
+		case "*/*":
+			s = 1
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q, specificity > 0
+}
+
+// refused reports whether the most specific of the given media ranges
+// that matches mediaType gives it a quality of zero, which means that
+// it is not acceptable (see RFC 7231, section 5.3.1).
+func refused(ranges []mediaRange, mediaType string) bool {
+	q, ok := acceptQuality(ranges, mediaType)
+	return ok && q == 0
+}
+
+// acceptHeader returns an Accept header value that lists the content
+// types of the given codecs in order of preference.
+func acceptHeader(codecs []Codec) string {
+	var buf strings.Builder
+	for i, c := range codecs {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(c.ContentType())
+		if i > 0 {
+			q := 1 - float64(i)/10
+			if q < 0.1 {
+				q = 0.1
+			}
+			fmt.Fprintf(&buf, ";q=%.1f", q)
+		}
+	}
+	return buf.String()
+}
+
+// writeCodec writes val as a response with the given status code
+// encoded with c.
+func writeCodec(w http.ResponseWriter, code int, val interface{}, c Codec) error {
+	data, err := c.Marshal(val)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	w.Header().Set("Content-Type", c.ContentType())
+	if headerSetter, ok := val.(HeaderSetter); ok {
+		headerSetter.SetHeader(w.Header())
+	}
+	w.WriteHeader(code)
+	w.Write(data)
+	return nil
+}
+
+// responseCodec returns the codec in codecs whose content type matches
+// that of the given response, or nil if there is none.
+func responseCodec(resp *http.Response, codecs []Codec) Codec {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil
+	}
+	for _, c := range codecs {
+		if c.ContentType() == mediaType {
+			return c
+		}
+	}
+	return nil
+}
+
+// unmarshalCodecResponse unmarshals the body of resp into x using c.
+func unmarshalCodecResponse(resp *http.Response, x interface{}, c Codec) error {
+	if x == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return newDecodeResponseError(resp, data, errgo.Notef(err, "error reading response body"))
+	}
+	if err := c.Unmarshal(data, x); err != nil {
+		return newDecodeResponseError(resp, data, err)
+	}
+	return nil
+}
+
+// JSONNumberCodec is a JSON codec, based on encoding/json, that
+// gives control over the handling of numbers, for example to avoid
+// losing precision when JSON bodies are decoded into interface{}
+// values. It can be used to set Server.JSON and Client.JSON.
+type JSONNumberCodec struct {
+	// UseNumber specifies that numbers decoded into interface{}
+	// values are represented as json.Number rather than float64
+	// (see json.Decoder.UseNumber).
+	UseNumber bool
+
+	// RejectNonFinite specifies that values that contain numbers
+	// too large to be represented as a float64, which would
+	// become infinite when converted, are rejected both when
+	// marshaling and unmarshaling. Such numbers can otherwise
+	// pass through json.Number values unnoticed.
+	RejectNonFinite bool
+}
+
+// ContentType implements Codec.ContentType.
+func (JSONNumberCodec) ContentType() string {
+	return "application/json"
+}
+
+// Marshal implements Codec.Marshal.
+func (c JSONNumberCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.RejectNonFinite {
+		if err := checkFiniteNumbers(data); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return data, nil
+}
+
+// Unmarshal implements Codec.Unmarshal.
+func (c JSONNumberCodec) Unmarshal(data []byte, v interface{}) error {
+	if c.RejectNonFinite {
+		if err := checkFiniteNumbers(data); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	if !c.UseNumber {
+		return json.Unmarshal(data, v)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	// Check for trailing data as json.Unmarshal does.
+	if _, err := dec.Token(); err != io.EOF {
+		return errgo.New("invalid data after top-level JSON value")
+	}
+	return nil
+}
+
+// checkFiniteNumbers returns an error if the JSON data contains a
+// number that cannot be represented as a finite float64.
+func checkFiniteNumbers(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			// Leave syntax errors to be reported by the
+			// decoder itself.
+			return nil
+		}
+		n, ok := tok.(json.Number)
+		if !ok {
+			continue
+		}
+		// ParseFloat returns an infinity when the number
+		// is too large and zero when it is too small, which
+		// is not an issue.
+		if f, _ := strconv.ParseFloat(string(n), 64); math.IsInf(f, 0) {
+			return errgo.Newf("number %s out of range", n)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type codecItem struct {
+	Name  string `json:"name" xml:"name"`
+	Count int    `json:"count" xml:"count"`
+}
+
+var codecNegotiationTests = []struct {
+	about             string
+	accept            string
+	expectContentType string
+	expectBody        string
+}{{
+	about:             "no accept header",
+	expectContentType: "application/json",
+	expectBody:        `{"name":"x","count":2}`,
+}, {
+	about:             "xml",
+	accept:            "application/xml",
+	expectContentType: "application/xml",
+	expectBody:        `<codecItem><name>x</name><count>2</count></codecItem>`,
+}, {
+	about:             "quality values",
+	accept:            "application/json;q=0.5, application/xml",
+	expectContentType: "application/xml",
+	expectBody:        `<codecItem><name>x</name><count>2</count></codecItem>`,
+}, {
+	about:             "wildcard",
+	accept:            "*/*",
+	expectContentType: "application/json",
+	expectBody:        `{"name":"x","count":2}`,
+}, {
+	about:             "more specific range wins",
+	accept:            "application/*;q=0.9, application/json;q=0.1",
+	expectContentType: "application/xml",
+	expectBody:        `<codecItem><name>x</name><count>2</count></codecItem>`,
+}, {
+	about:             "nothing acceptable",
+	accept:            "text/plain",
+	expectContentType: "application/json",
+	expectBody:        `{"name":"x","count":2}`,
+}, {
+	about:             "refused by more specific range",
+	accept:            "application/*, application/json;q=0",
+	expectContentType: "application/xml",
+	expectBody:        `<codecItem><name>x</name><count>2</count></codecItem>`,
+}, {
+	about:             "refused codec is not the default",
+	accept:            "text/plain, application/json;q=0",
+	expectContentType: "application/xml",
+	expectBody:        `<codecItem><name>x</name><count>2</count></codecItem>`,
+}}
+
+func TestServerCodecs(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		Codecs: []httprequest.Codec{httprequest.JSONCodec, httprequest.XMLCodec},
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /item"`
+	}) (*codecItem, error) {
+		return &codecItem{Name: "x", Count: 2}, nil
+	})
+	for _, test := range codecNegotiationTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("GET", "/item", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			c.Assert(rec.Code, qt.Equals, http.StatusOK)
+			c.Assert(rec.Header().Get("Content-Type"), qt.Equals, test.expectContentType)
+			c.Assert(rec.Body.String(), qt.Equals, test.expectBody)
+		})
+	}
+}
+
+func TestServerCodecsErrorIsJSON(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		Codecs: []httprequest.Codec{httprequest.XMLCodec},
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /item"`
+	}) (*codecItem, error) {
+		return nil, httprequest.Errorf(httprequest.CodeNotFound, "no item")
+	})
+	req := httptest.NewRequest("GET", "/item", nil)
+	req.Header.Set("Accept", "application/xml")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusNotFound)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+}
+
+func TestClientCodecs(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		Codecs: []httprequest.Codec{httprequest.JSONCodec, httprequest.XMLCodec},
+	}
+	var accept string
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /item"`
+	}) (*codecItem, error) {
+		accept = p.Request.Header.Get("Accept")
+		return &codecItem{Name: "x", Count: 2}, nil
+	})
+	hsrv := newHandlerServer([]httprequest.Handler{h})
+	defer hsrv.Close()
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+		Codecs:  []httprequest.Codec{httprequest.XMLCodec, httprequest.JSONCodec},
+	}
+	var item codecItem
+	err := client.Get(context.Background(), "/item", &item)
+	c.Assert(err, qt.IsNil)
+	c.Assert(accept, qt.Equals, "application/xml, application/json;q=0.9")
+	c.Assert(item, qt.DeepEquals, codecItem{Name: "x", Count: 2})
+
+	// A client without XML falls back to JSON.
+	client.Codecs = []httprequest.Codec{httprequest.JSONCodec}
+	item = codecItem{}
+	err = client.Get(context.Background(), "/item", &item)
+	c.Assert(err, qt.IsNil)
+	c.Assert(accept, qt.Equals, "application/json")
+	c.Assert(item, qt.DeepEquals, codecItem{Name: "x", Count: 2})
+}
+
+func TestClientCodecsDecodeError(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte("<codecItem><name>"))
+	}))
+	defer hsrv.Close()
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+		Codecs:  []httprequest.Codec{httprequest.XMLCodec},
+	}
+	var item codecItem
+	err := client.Get(context.Background(), "/item", &item)
+	c.Assert(err, qt.ErrorMatches, `Get "?http.*/item"?: XML syntax error.*`)
+	_, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.IsTrue)
+}
+
+type jsonCodecReq struct {
+	httprequest.Route `httprequest:"POST /items/:name"`
+	Name              string    `httprequest:"name,path"`
+	Body              codecItem `httprequest:",body"`
+}
+
+// newCountingJSONCodec returns a JSON codec that uses encoding/json
+// and counts how many times it is used.
+func newCountingJSONCodec(marshals, unmarshals *int) httprequest.Codec {
+	return httprequest.NewJSONCodec(func(v interface{}) ([]byte, error) {
+		*marshals++
+		return json.Marshal(v)
+	}, func(data []byte, v interface{}) error {
+		*unmarshals++
+		return json.Unmarshal(data, v)
+	})
+}
+
+func TestJSONCodec(t *testing.T) {
+	c := qt.New(t)
+
+	var srvMarshals, srvUnmarshals int
+	srv := &httprequest.Server{
+		JSON: newCountingJSONCodec(&srvMarshals, &srvUnmarshals),
+	}
+	hsrv := httptest.NewServer(srv.HTTPHandler([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *jsonCodecReq) (*codecItem, error) {
+			if req.Name == "bad" {
+				return nil, errgo.New("bad item")
+			}
+			req.Body.Name = req.Name
+			return &req.Body, nil
+		}),
+	}))
+	defer hsrv.Close()
+
+	var clientMarshals, clientUnmarshals int
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+		JSON:    newCountingJSONCodec(&clientMarshals, &clientUnmarshals),
+	}
+	var resp codecItem
+	err := client.Call(context.Background(), &jsonCodecReq{
+		Name: "x",
+		Body: codecItem{Count: 2},
+	}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, codecItem{Name: "x", Count: 2})
+	c.Assert(srvUnmarshals, qt.Equals, 1)
+	c.Assert(srvMarshals, qt.Equals, 1)
+	c.Assert(clientMarshals, qt.Equals, 1)
+	c.Assert(clientUnmarshals, qt.Equals, 1)
+
+	// Error responses are written with the codec too.
+	err = client.Call(context.Background(), &jsonCodecReq{
+		Name: "bad",
+	}, &resp)
+	c.Assert(err, qt.ErrorMatches, `Post "?http://.*/items/bad"?: bad item`)
+	c.Assert(srvMarshals, qt.Equals, 2)
+}
+
+var jsonNumberCodecUnmarshalTests = []struct {
+	about       string
+	codec       httprequest.JSONNumberCodec
+	data        string
+	expect      interface{}
+	expectError string
+}{{
+	about:  "float64 by default",
+	data:   `{"a":12345678901234567890}`,
+	expect: map[string]interface{}{"a": 12345678901234567890.0},
+}, {
+	about: "use number",
+	codec: httprequest.JSONNumberCodec{
+		UseNumber: true,
+	},
+	data:   `{"a":12345678901234567890,"b":[0.1]}`,
+	expect: map[string]interface{}{"a": json.Number("12345678901234567890"), "b": []interface{}{json.Number("0.1")}},
+}, {
+	about: "out of range number passes through json.Number",
+	codec: httprequest.JSONNumberCodec{
+		UseNumber: true,
+	},
+	data:   `{"a":1e400}`,
+	expect: map[string]interface{}{"a": json.Number("1e400")},
+}, {
+	about: "reject out of range number",
+	codec: httprequest.JSONNumberCodec{
+		UseNumber:       true,
+		RejectNonFinite: true,
+	},
+	data:        `{"a":[1,-1e400]}`,
+	expectError: `number -1e400 out of range`,
+}, {
+	about: "small numbers are allowed",
+	codec: httprequest.JSONNumberCodec{
+		UseNumber:       true,
+		RejectNonFinite: true,
+	},
+	data:   `{"a":1e-400}`,
+	expect: map[string]interface{}{"a": json.Number("1e-400")},
+}, {
+	about: "trailing data",
+	codec: httprequest.JSONNumberCodec{
+		UseNumber: true,
+	},
+	data:        `{"a":1} {}`,
+	expectError: `invalid data after top-level JSON value`,
+}}
+
+func TestJSONNumberCodecUnmarshal(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range jsonNumberCodecUnmarshalTests {
+		c.Run(test.about, func(c *qt.C) {
+			var v interface{}
+			err := test.codec.Unmarshal([]byte(test.data), &v)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(v, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestJSONNumberCodecMarshal(t *testing.T) {
+	c := qt.New(t)
+	v := map[string]interface{}{"a": json.Number("1e400")}
+	data, err := httprequest.JSONNumberCodec{}.Marshal(v)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, `{"a":1e400}`)
+
+	_, err = httprequest.JSONNumberCodec{RejectNonFinite: true}.Marshal(v)
+	c.Assert(err, qt.ErrorMatches, `number 1e400 out of range`)
+}
+
+type jsonNumberReq struct {
+	httprequest.Route `httprequest:"POST /echo"`
+	Body              map[string]interface{} `httprequest:",body"`
+}
+
+func TestServerJSONNumberCodec(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		JSON: httprequest.JSONNumberCodec{
+			UseNumber:       true,
+			RejectNonFinite: true,
+		},
+		ErrorMapper: testErrorMapper,
+	}
+	hsrv := httptest.NewServer(srv.HTTPHandler([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *jsonNumberReq) (map[string]interface{}, error) {
+			c.Check(req.Body["amount"], qt.Equals, json.Number("12345678901234567.89"))
+			return req.Body, nil
+		}),
+	}))
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+		JSON: httprequest.JSONNumberCodec{
+			UseNumber: true,
+		},
+	}
+	var resp map[string]interface{}
+	err := client.Call(context.Background(), &jsonNumberReq{
+		Body: map[string]interface{}{"amount": json.Number("12345678901234567.89")},
+	}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, map[string]interface{}{"amount": json.Number("12345678901234567.89")})
+
+	err = client.Call(context.Background(), &jsonNumberReq{
+		Body: map[string]interface{}{"amount": json.Number("1e400")},
+	}, &resp)
+	c.Assert(err, qt.ErrorMatches, `Post "?http://.*/echo"?: cannot unmarshal parameters: cannot unmarshal into field Body: cannot unmarshal request body: number 1e400 out of range`)
+}
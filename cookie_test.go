@@ -0,0 +1,92 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type cookieLoginRequest struct {
+	httprequest.Route `httprequest:"POST /login"`
+	User              string `httprequest:"user,form"`
+}
+
+type cookieLoginResponse struct {
+	Session string `httprequest:"session,cookie,secure,httponly" json:"-"`
+	Theme   string `httprequest:"theme,cookie,omitempty" json:"-"`
+}
+
+type cookieWhoAmIRequest struct {
+	httprequest.Route `httprequest:"GET /whoami"`
+	Session           string `httprequest:"session,cookie"`
+	Visits            int    `httprequest:"visits,cookie,omitempty"`
+}
+
+type cookieWhoAmIResponse struct {
+	Session string
+	Visits  int
+}
+
+func TestCookieFields(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *cookieLoginRequest) (*cookieLoginResponse, error) {
+			return &cookieLoginResponse{
+				Session: "s-" + req.User,
+			}, nil
+		}),
+		srv.Handle(func(p httprequest.Params, req *cookieWhoAmIRequest) (*cookieWhoAmIResponse, error) {
+			return &cookieWhoAmIResponse{
+				Session: req.Session,
+				Visits:  req.Visits,
+			}, nil
+		}),
+	})
+	hsrv := httptest.NewServer(router)
+	defer hsrv.Close()
+
+	// The response cookie is written with its attributes.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/login?user=bob", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header()["Set-Cookie"], qt.DeepEquals, []string{
+		"session=s-bob; Path=/; HttpOnly; Secure",
+	})
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var loginResp cookieLoginResponse
+	err := client.Call(context.Background(), &cookieLoginRequest{User: "alice"}, &loginResp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(loginResp.Session, qt.Equals, "s-alice")
+	c.Assert(loginResp.Theme, qt.Equals, "")
+
+	// The client sends request cookie fields in the Cookie header.
+	var whoResp cookieWhoAmIResponse
+	err = client.Call(context.Background(), &cookieWhoAmIRequest{
+		Session: loginResp.Session,
+		Visits:  3,
+	}, &whoResp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(whoResp, qt.DeepEquals, cookieWhoAmIResponse{
+		Session: "s-alice",
+		Visits:  3,
+	})
+
+	req, err := httprequest.Marshal("http://example.com", "GET", &cookieWhoAmIRequest{
+		Session: "x",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.Header.Get("Cookie"), qt.Equals, "session=x")
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// CORS holds a cross-origin resource sharing configuration. It is
+// enabled for all the handlers created by a Server by setting the
+// Server.CORS field.
+//
+// Preflight requests are answered by the OPTIONS handlers added by
+// Server.DeriveHandlers, which allow the methods declared for the
+// requested path that are also in AllowedMethods. They can be counted
+// by setting the Stats field.
+type CORS struct {
+	// AllowedOrigins holds the origins that are allowed to make
+	// cross-origin requests, for example "https://example.com".
+	// The origin "*" allows all origins; it cannot be used with
+	// AllowCredentials, as that would let any site make requests
+	// with the user's credentials, so creating a handler with
+	// such a configuration panics.
+	AllowedOrigins []string
+
+	// AllowOrigin, if non-nil, is called to check origins that are
+	// not in AllowedOrigins.
+	AllowOrigin func(origin string) bool
+
+	// AllowedMethods holds the methods that may be used in
+	// cross-origin requests. If it is empty, all the methods
+	// declared for a path are allowed. Responses to requests with
+	// other methods have no CORS headers, so browsers do not let
+	// scripts read them.
+	AllowedMethods []string
+
+	// AllowedHeaders holds the request headers that may be used in
+	// cross-origin requests. If it is empty, all the headers
+	// requested by a preflight request are allowed.
+	AllowedHeaders []string
+
+	// ExposedHeaders holds the response headers, other than the
+	// CORS-safelisted response headers, that may be read by
+	// scripts making cross-origin requests.
+	ExposedHeaders []string
+
+	// MaxAge holds how long the response to a preflight request may
+	// be cached. If it is zero, no Access-Control-Max-Age header is
+	// sent.
+	MaxAge time.Duration
+
+	// PathMaxAge holds values that override MaxAge for groups of
+	// routes, keyed by a prefix of their path patterns, for
+	// example "/api/v1/". The entry with the longest prefix of the
+	// route's path pattern applies. A negative value means that no
+	// Access-Control-Max-Age header is sent for the group.
+	PathMaxAge map[string]time.Duration
+
+	// Stats, if non-nil, is used to count the preflight requests
+	// answered for each route.
+	Stats *PreflightStats
+
+	// AllowCredentials specifies that cross-origin requests may
+	// include credentials such as cookies.
+	AllowCredentials bool
+}
+
+// allowedMethods returns the methods in methods that are allowed
+// by c.
+func (c *CORS) allowedMethods(methods []string) []string {
+	if len(c.AllowedMethods) == 0 {
+		return methods
+	}
+	var allowed []string
+	for _, m := range methods {
+		if containsString(c.AllowedMethods, m) {
+			allowed = append(allowed, m)
+		}
+	}
+	return allowed
+}
+
+// allowed reports whether the given origin is allowed by c.
+func (c *CORS) allowed(origin string) bool {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || o == origin {
+			return true
+		}
+	}
+	return c.AllowOrigin != nil && c.AllowOrigin(origin)
+}
+
+// wrap returns a handler that adds CORS headers as configured
+// by c to the responses to cross-origin requests before calling h.
+// It panics if c allows all origins with credentials.
+func (c *CORS) wrap(h httprouter.Handle) httprouter.Handle {
+	wildcard := containsString(c.AllowedOrigins, "*")
+	if wildcard && c.AllowCredentials {
+		panic(errgo.Newf(`CORS origin "*" cannot be used with AllowCredentials`))
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			h(w, req, p)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		methodAllowed := isPreflight(req) || len(c.AllowedMethods) == 0 || containsString(c.AllowedMethods, req.Method)
+		if methodAllowed && c.allowed(origin) {
+			if wildcard {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.AllowCredentials {
+				header.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if len(c.ExposedHeaders) > 0 && !isPreflight(req) {
+				header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+			}
+		}
+		h(w, req, p)
+	}
+}
+
+// preflight adds the headers for the response to the preflight
+// request req, to the route with the given path pattern, which has
+// the given methods.
+func (c *CORS) preflight(w http.ResponseWriter, req *http.Request, path string, methods []string) {
+	allowed := c.allowed(req.Header.Get("Origin"))
+	if c.Stats != nil {
+		c.Stats.add(path, allowed)
+	}
+	if !allowed {
+		return
+	}
+	header := w.Header()
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	if methods := c.allowedMethods(methods); len(methods) > 0 {
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+	}
+	if len(c.AllowedHeaders) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if reqHeaders := req.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", reqHeaders)
+	}
+	if maxAge := c.maxAge(path); maxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge/time.Second)))
+	}
+}
+
+// maxAge returns the maximum age of a preflight response for the
+// route with the given path pattern.
+func (c *CORS) maxAge(path string) time.Duration {
+	maxAge, prefixLen := c.MaxAge, -1
+	for prefix, d := range c.PathMaxAge {
+		if len(prefix) > prefixLen && strings.HasPrefix(path, prefix) {
+			maxAge, prefixLen = d, len(prefix)
+		}
+	}
+	return maxAge
+}
+
+// PreflightStats counts the CORS preflight requests answered for each
+// route, which can be a large part of the traffic of a service used
+// by browsers. Routes are identified by their path patterns, as
+// preflight requests are answered for all the methods of a path
+// together.
+//
+// A PreflightStats is enabled by setting the CORS.Stats field. The
+// same PreflightStats may be used by several servers.
+type PreflightStats struct {
+	// Observe, if non-nil, is called after each preflight
+	// request is counted. It can be used to export metrics.
+	Observe func(path string, allowed bool)
+
+	mu     sync.Mutex
+	counts map[string]PreflightCount
+}
+
+// PreflightCount holds the counts of the preflight requests for
+// a route.
+type PreflightCount struct {
+	// Allowed holds the number of preflight requests from
+	// allowed origins.
+	Allowed int64
+
+	// Rejected holds the number of preflight requests from
+	// origins that are not allowed.
+	Rejected int64
+}
+
+// Counts returns the counts of preflight requests for each route
+// that has received any, keyed by path pattern.
+func (s *PreflightStats) Counts() map[string]PreflightCount {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]PreflightCount, len(s.counts))
+	for path, count := range s.counts {
+		m[path] = count
+	}
+	return m
+}
+
+// add counts a preflight request for the given path pattern.
+func (s *PreflightStats) add(path string, allowed bool) {
+	s.mu.Lock()
+	if s.counts == nil {
+		s.counts = make(map[string]PreflightCount)
+	}
+	count := s.counts[path]
+	if allowed {
+		count.Allowed++
+	} else {
+		count.Rejected++
+	}
+	s.counts[path] = count
+	s.mu.Unlock()
+	if s.Observe != nil {
+		s.Observe(path, allowed)
+	}
+}
+
+// isPreflight reports whether req is a CORS preflight request.
+func isPreflight(req *http.Request) bool {
+	return req.Method == "OPTIONS" && req.Header.Get("Origin") != "" && req.Header.Get("Access-Control-Request-Method") != ""
+}
@@ -0,0 +1,354 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var corsTests = []struct {
+	about        string
+	cors         httprequest.CORS
+	method       string
+	path         string
+	header       http.Header
+	expectStatus int
+	expectHeader http.Header
+}{{
+	about: "same origin request",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+	},
+	method:       "GET",
+	path:         "/items",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+	},
+}, {
+	about: "allowed origin",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		ExposedHeaders: []string{"X-Count", "X-Other"},
+	},
+	method: "GET",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                  {"application/json"},
+		"Vary":                          {"Origin"},
+		"Access-Control-Allow-Origin":   {"https://example.com"},
+		"Access-Control-Expose-Headers": {"X-Count, X-Other"},
+	},
+}, {
+	about: "disallowed origin",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+	},
+	method: "GET",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://evil.example"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Origin"},
+	},
+}, {
+	about: "wildcard origin",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"*"},
+	},
+	method: "GET",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                {"application/json"},
+		"Vary":                        {"Origin"},
+		"Access-Control-Allow-Origin": {"*"},
+	},
+}, {
+	about: "allowed origin with credentials",
+	cors: httprequest.CORS{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowCredentials: true,
+	},
+	method: "GET",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                     {"application/json"},
+		"Vary":                             {"Origin"},
+		"Access-Control-Allow-Origin":      {"https://example.com"},
+		"Access-Control-Allow-Credentials": {"true"},
+	},
+}, {
+	about: "allowed method",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET"},
+	},
+	method: "GET",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"Content-Type":                {"application/json"},
+		"Vary":                        {"Origin"},
+		"Access-Control-Allow-Origin": {"https://example.com"},
+	},
+}, {
+	about: "disallowed method",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET"},
+	},
+	method: "DELETE",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://example.com"},
+	},
+	expectStatus: http.StatusForbidden,
+	expectHeader: http.Header{
+		"Content-Type": {"application/json"},
+		"Vary":         {"Origin"},
+	},
+}, {
+	about: "origin allowed by function",
+	cors: httprequest.CORS{
+		AllowOrigin: func(origin string) bool {
+			return origin == "https://other.example"
+		},
+	},
+	method: "DELETE",
+	path:   "/items",
+	header: http.Header{
+		"Origin": {"https://other.example"},
+	},
+	expectStatus: http.StatusForbidden,
+	expectHeader: http.Header{
+		"Content-Type":                {"application/json"},
+		"Vary":                        {"Origin"},
+		"Access-Control-Allow-Origin": {"https://other.example"},
+	},
+}, {
+	about: "preflight",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		ExposedHeaders: []string{"X-Count"},
+		MaxAge:         10 * time.Minute,
+	},
+	method: "OPTIONS",
+	path:   "/items",
+	header: http.Header{
+		"Origin":                         {"https://example.com"},
+		"Access-Control-Request-Method":  {"DELETE"},
+		"Access-Control-Request-Headers": {"X-Foo"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                        {"DELETE, GET, HEAD, OPTIONS"},
+		"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		"Access-Control-Allow-Origin":  {"https://example.com"},
+		"Access-Control-Allow-Methods": {"DELETE, GET, HEAD, OPTIONS"},
+		"Access-Control-Allow-Headers": {"X-Foo"},
+		"Access-Control-Max-Age":       {"600"},
+	},
+}, {
+	about: "preflight with allowed headers",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedHeaders: []string{"X-Bar", "Content-Type"},
+	},
+	method: "OPTIONS",
+	path:   "/items",
+	header: http.Header{
+		"Origin":                         {"https://example.com"},
+		"Access-Control-Request-Method":  {"DELETE"},
+		"Access-Control-Request-Headers": {"X-Foo"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                        {"DELETE, GET, HEAD, OPTIONS"},
+		"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		"Access-Control-Allow-Origin":  {"https://example.com"},
+		"Access-Control-Allow-Methods": {"DELETE, GET, HEAD, OPTIONS"},
+		"Access-Control-Allow-Headers": {"X-Bar, Content-Type"},
+	},
+}, {
+	about: "preflight with allowed methods",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+		AllowedMethods: []string{"GET", "HEAD", "POST"},
+	},
+	method: "OPTIONS",
+	path:   "/items",
+	header: http.Header{
+		"Origin":                        {"https://example.com"},
+		"Access-Control-Request-Method": {"DELETE"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow":                        {"DELETE, GET, HEAD, OPTIONS"},
+		"Vary":                         {"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"},
+		"Access-Control-Allow-Origin":  {"https://example.com"},
+		"Access-Control-Allow-Methods": {"GET, HEAD"},
+	},
+}, {
+	about: "preflight from disallowed origin",
+	cors: httprequest.CORS{
+		AllowedOrigins: []string{"https://example.com"},
+	},
+	method: "OPTIONS",
+	path:   "/items",
+	header: http.Header{
+		"Origin":                        {"https://evil.example"},
+		"Access-Control-Request-Method": {"DELETE"},
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow": {"DELETE, GET, HEAD, OPTIONS"},
+		"Vary":  {"Origin"},
+	},
+}}
+
+func TestCORS(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range corsTests {
+		c.Run(test.about, func(c *qt.C) {
+			cors := test.cors
+			srv := httprequest.Server{
+				CORS: &cors,
+			}
+			router := newHandlerRouter(srv.DeriveHandlers([]httprequest.Handler{
+				srv.Handle(func(_ *struct {
+					httprequest.Route `httprequest:"GET /items"`
+				}) ([]string, error) {
+					return []string{"a"}, nil
+				}),
+				srv.Handle(func(_ *struct {
+					httprequest.Route `httprequest:"DELETE /items"`
+				}) error {
+					return httprequest.Errorf(httprequest.CodeForbidden, "")
+				}),
+			}))
+			req := httptest.NewRequest(test.method, test.path, nil)
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			c.Assert(rec.Code, qt.Equals, test.expectStatus)
+			c.Assert(rec.Header(), qt.DeepEquals, test.expectHeader)
+		})
+	}
+}
+
+func TestCORSWildcardWithCredentials(t *testing.T) {
+	c := qt.New(t)
+	srv := httprequest.Server{
+		CORS: &httprequest.CORS{
+			AllowedOrigins:   []string{"*"},
+			AllowCredentials: true,
+		},
+	}
+	c.Assert(func() {
+		srv.Handle(func(_ *struct {
+			httprequest.Route `httprequest:"GET /items"`
+		}) error {
+			return nil
+		})
+	}, qt.PanicMatches, `CORS origin "\*" cannot be used with AllowCredentials`)
+}
+
+func TestCORSPathMaxAgeAndStats(t *testing.T) {
+	c := qt.New(t)
+
+	type observation struct {
+		path    string
+		allowed bool
+	}
+	var observed []observation
+	stats := &httprequest.PreflightStats{
+		Observe: func(path string, allowed bool) {
+			observed = append(observed, observation{path, allowed})
+		},
+	}
+	srv := httprequest.Server{
+		CORS: &httprequest.CORS{
+			AllowedOrigins: []string{"https://example.com"},
+			MaxAge:         time.Minute,
+			PathMaxAge: map[string]time.Duration{
+				"/api/":       time.Hour,
+				"/api/v2/":    24 * time.Hour,
+				"/api/admin/": -1,
+			},
+			Stats: stats,
+		},
+	}
+	var hs []httprequest.Handler
+	for _, path := range []string{"/api/v1/items", "/api/v2/items", "/api/admin/users", "/public/:id"} {
+		hs = append(hs, srv.Register("GET", path, func(p httprequest.Params, _ *struct{}) error {
+			return nil
+		}))
+	}
+	router := newHandlerRouter(srv.DeriveHandlers(hs))
+
+	preflight := func(path, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("OPTIONS", path, nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", "GET")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		c.Assert(rec.Code, qt.Equals, http.StatusNoContent)
+		return rec
+	}
+	for path, maxAge := range map[string]string{
+		"/api/v1/items":    "3600",
+		"/api/v2/items":    "86400",
+		"/api/admin/users": "",
+		"/public/1":        "60",
+	} {
+		rec := preflight(path, "https://example.com")
+		c.Check(rec.Header().Get("Access-Control-Max-Age"), qt.Equals, maxAge, qt.Commentf("path %s", path))
+	}
+	preflight("/public/2", "https://example.com")
+	preflight("/public/3", "https://evil.example")
+
+	// Requests that are not preflight requests are not counted.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/public/1", nil))
+	req := httptest.NewRequest("GET", "/public/1", nil)
+	req.Header.Set("Origin", "https://example.com")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	c.Assert(stats.Counts(), qt.DeepEquals, map[string]httprequest.PreflightCount{
+		"/api/v1/items":    {Allowed: 1},
+		"/api/v2/items":    {Allowed: 1},
+		"/api/admin/users": {Allowed: 1},
+		"/public/:id":      {Allowed: 2, Rejected: 1},
+	})
+	c.Assert(observed, qt.HasLen, 6)
+	c.Assert(observed[5], qt.Equals, observation{"/public/:id", false})
+}
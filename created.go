@@ -0,0 +1,51 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Created can be returned as the result of a handler (see
+// Server.Handle) that creates a resource. It is written as a response
+// with a 201 Created status and a Location header holding the URL of
+// the new resource, with Value written as the body as for any other
+// result. If Value is nil, the response has no body.
+//
+// Created can also be used as the response argument to Client.Call
+// and similar methods: Location is set to the URL in the Location
+// header of the response, resolved relative to the request URL, and
+// the response body is unmarshaled into Value, which should then be
+// nil or a pointer to the value to unmarshal into.
+type Created struct {
+	// Location holds the URL of the created resource.
+	Location string
+
+	// Value holds the response body.
+	Value interface{}
+}
+
+// write writes r as a response to req.
+func (r *Created) write(srv *Server, w http.ResponseWriter, req *http.Request, bufferSize int) error {
+	if r.Location != "" {
+		w.Header().Set("Location", r.Location)
+	}
+	if r.Value == nil {
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	}
+	return errgo.Mask(srv.writeResultBody(w, req, http.StatusCreated, r.Value, bufferSize), errgo.Any)
+}
+
+// unmarshalCreated sets r.Location from httpResp and returns the
+// value that the response body should be unmarshaled into.
+func unmarshalCreated(httpResp *http.Response, r *Created) interface{} {
+	r.Location = ""
+	if loc, err := httpResp.Location(); err == nil {
+		r.Location = loc.String()
+	}
+	return r.Value
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type createdItem struct {
+	ID   string
+	Name string
+}
+
+type createItemReq struct {
+	httprequest.Route `httprequest:"POST /items"`
+	Name              string `httprequest:"name,form"`
+}
+
+type createEmptyReq struct {
+	httprequest.Route `httprequest:"POST /empty"`
+}
+
+type createdHandlers struct{}
+
+func (createdHandlers) Create(req *createItemReq) (*httprequest.Created, error) {
+	return &httprequest.Created{
+		Location: "/items/42",
+		Value: createdItem{
+			ID:   "42",
+			Name: req.Name,
+		},
+	}, nil
+}
+
+func (createdHandlers) CreateEmpty(*createEmptyReq) (httprequest.Created, error) {
+	return httprequest.Created{
+		Location: "https://elsewhere.example.com/x",
+	}, nil
+}
+
+func TestCreated(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	router := newHandlerRouter(srv.Handlers(func(p httprequest.Params) (createdHandlers, context.Context, error) {
+		return createdHandlers{}, p.Context, nil
+	}))
+	hsrv := httptest.NewServer(router)
+	defer hsrv.Close()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/items?name=foo", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusCreated)
+	c.Assert(rec.Header().Get("Location"), qt.Equals, "/items/42")
+	c.Assert(rec.Body.String(), qt.Equals, `{"ID":"42","Name":"foo"}`)
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var item createdItem
+	created := httprequest.Created{
+		Value: &item,
+	}
+	err := client.Call(context.Background(), &createItemReq{Name: "bar"}, &created)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created.Location, qt.Equals, hsrv.URL+"/items/42")
+	c.Assert(item, qt.DeepEquals, createdItem{
+		ID:   "42",
+		Name: "bar",
+	})
+
+	// The Location is reported even when the body is ignored.
+	created = httprequest.Created{}
+	err = client.Call(context.Background(), &createItemReq{Name: "bar"}, &created)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created.Location, qt.Equals, hsrv.URL+"/items/42")
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", "/empty", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusCreated)
+	c.Assert(rec.Body.Len(), qt.Equals, 0)
+
+	err = client.Call(context.Background(), &createEmptyReq{}, &created)
+	c.Assert(err, qt.IsNil)
+	c.Assert(created.Location, qt.Equals, "https://elsewhere.example.com/x")
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// CSRFGuard protects handlers against cross-site request forgery using
+// the double-submit cookie pattern. Each response carries a cookie
+// holding a random token (issued if the request does not already have
+// one), and requests with a method that is not safe (anything other
+// than GET, HEAD, OPTIONS or TRACE) are rejected unless they also hold
+// the same token in a header or form field. A page from another site
+// can cause a browser to send the cookie but cannot read it, so it
+// cannot supply the matching value.
+//
+// JavaScript clients should copy the cookie value into the header;
+// HTML forms should include the token, as returned by
+// CSRFTokenFromContext, in a hidden form field.
+//
+// A CSRFGuard is enabled for all the handlers created by a Server by
+// setting the Server.CSRFGuard field.
+type CSRFGuard struct {
+	// CookieName holds the name of the cookie holding the token.
+	// If it is empty, "csrf_token" is used.
+	CookieName string
+
+	// HeaderName holds the name of the header that may hold the
+	// token. If it is empty, "X-CSRF-Token" is used.
+	HeaderName string
+
+	// FormField holds the name of the form field that may hold
+	// the token. If it is empty, "csrf_token" is used.
+	FormField string
+
+	// CookiePath holds the path of the cookie. If it is empty,
+	// "/" is used.
+	CookiePath string
+
+	// Secure specifies that the cookie should only be sent
+	// over HTTPS.
+	Secure bool
+
+	// Exempt holds the routes that are not checked, each in the
+	// form "METHOD /path/pattern" as found in Handler, for
+	// example "POST /webhooks/:id". Routes can also be exempted
+	// with a csrf:"exempt" tag on their Route field (see
+	// Server.Handle). Token cookies are still issued by exempt
+	// routes.
+	Exempt []string
+}
+
+// csrfTokenKey is the context key for the CSRF token.
+type csrfTokenKey struct{}
+
+// CSRFTokenFromContext returns the CSRF token for the request with the
+// given context, suitable for including in a form. It returns the
+// empty string if the request was not handled by a server with a
+// CSRFGuard.
+func CSRFTokenFromContext(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// wrap returns a handler that checks requests to the route with the
+// given method and path pattern as configured by g before calling h.
+// The exempt argument holds whether the route is exempted by its
+// csrf tag.
+func (g *CSRFGuard) wrap(srv *Server, method, pathPattern string, exempt bool, h httprouter.Handle) httprouter.Handle {
+	cookieName := g.CookieName
+	if cookieName == "" {
+		cookieName = "csrf_token"
+	}
+	headerName := g.HeaderName
+	if headerName == "" {
+		headerName = "X-CSRF-Token"
+	}
+	formField := g.FormField
+	if formField == "" {
+		formField = "csrf_token"
+	}
+	cookiePath := g.CookiePath
+	if cookiePath == "" {
+		cookiePath = "/"
+	}
+	for _, route := range g.Exempt {
+		if route == method+" "+pathPattern {
+			exempt = true
+		}
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		var token string
+		if c, err := req.Cookie(cookieName); err == nil && len(c.Value) == csrfTokenLen {
+			token = c.Value
+		} else {
+			token = newCSRFToken()
+			http.SetCookie(w, &http.Cookie{
+				Name:     cookieName,
+				Value:    token,
+				Path:     cookiePath,
+				Secure:   g.Secure,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+		if !exempt && !isSafeMethod(req.Method) {
+			submitted := req.Header.Get(headerName)
+			if submitted == "" {
+				submitted = req.PostFormValue(formField)
+			}
+			// Note: if the cookie was missing, the newly generated
+			// token cannot have been submitted.
+			if subtle.ConstantTimeCompare([]byte(submitted), []byte(token)) != 1 {
+				srv.WriteError(req.Context(), w, Errorf(CodeForbidden, "invalid CSRF token"))
+				return
+			}
+		}
+		h(w, req.WithContext(context.WithValue(req.Context(), csrfTokenKey{}, token)), p)
+	}
+}
+
+// csrfTokenLen holds the length of a CSRF token.
+var csrfTokenLen = len(newCSRFToken())
+
+func newCSRFToken() string {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(errgo.Notef(err, "cannot generate CSRF token"))
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+const csrfTestToken = "0123456789012345678901234567890123456789012"
+
+var csrfGuardTests = []struct {
+	about        string
+	method       string
+	path         string
+	cookie       string
+	header       string
+	form         url.Values
+	expectStatus int
+	expectError  string
+	expectCookie bool
+}{{
+	about:        "safe method without cookie",
+	method:       "GET",
+	path:         "/items",
+	expectStatus: http.StatusOK,
+	expectCookie: true,
+}, {
+	about:        "safe method with cookie",
+	method:       "GET",
+	path:         "/items",
+	cookie:       csrfTestToken,
+	expectStatus: http.StatusOK,
+}, {
+	about:        "token in header",
+	method:       "POST",
+	path:         "/items",
+	cookie:       csrfTestToken,
+	header:       csrfTestToken,
+	expectStatus: http.StatusOK,
+}, {
+	about:  "token in form",
+	method: "POST",
+	path:   "/items",
+	cookie: csrfTestToken,
+	form: url.Values{
+		"csrf_token": {csrfTestToken},
+	},
+	expectStatus: http.StatusOK,
+}, {
+	about:        "missing token",
+	method:       "POST",
+	path:         "/items",
+	cookie:       csrfTestToken,
+	expectStatus: http.StatusForbidden,
+	expectError:  "invalid CSRF token",
+}, {
+	about:        "mismatched token",
+	method:       "POST",
+	path:         "/items",
+	cookie:       csrfTestToken,
+	header:       strings.Replace(csrfTestToken, "0", "x", 1),
+	expectStatus: http.StatusForbidden,
+	expectError:  "invalid CSRF token",
+}, {
+	about:        "missing cookie",
+	method:       "POST",
+	path:         "/items",
+	header:       csrfTestToken,
+	expectStatus: http.StatusForbidden,
+	expectError:  "invalid CSRF token",
+	expectCookie: true,
+}, {
+	about:        "exempt route",
+	method:       "POST",
+	path:         "/hooks/x",
+	expectStatus: http.StatusOK,
+	expectCookie: true,
+}, {
+	about:        "route exempted by tag",
+	method:       "POST",
+	path:         "/callbacks",
+	expectStatus: http.StatusOK,
+	expectCookie: true,
+}}
+
+func TestCSRFGuard(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		CSRFGuard: &httprequest.CSRFGuard{
+			Exempt: []string{"POST /hooks/:id"},
+		},
+	}
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"GET /items"`
+		}) (string, error) {
+			return httprequest.CSRFTokenFromContext(p.Context), nil
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"POST /items"`
+		}) (string, error) {
+			return "created", nil
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"POST /hooks/:id"`
+		}) (string, error) {
+			return "hooked", nil
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"POST /callbacks" csrf:"exempt"`
+		}) (string, error) {
+			return "called", nil
+		}),
+	})
+	for _, test := range csrfGuardTests {
+		c.Run(test.about, func(c *qt.C) {
+			var req *http.Request
+			if test.form != nil {
+				req = httptest.NewRequest(test.method, test.path, strings.NewReader(test.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(test.method, test.path, nil)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{
+					Name:  "csrf_token",
+					Value: test.cookie,
+				})
+			}
+			if test.header != "" {
+				req.Header.Set("X-CSRF-Token", test.header)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if test.expectError != "" {
+				qthttptest.AssertJSONResponse(c, rec, test.expectStatus, &httprequest.RemoteError{
+					Code:    httprequest.CodeForbidden,
+					Message: test.expectError,
+				})
+			} else {
+				c.Assert(rec.Code, qt.Equals, test.expectStatus)
+			}
+			cookies := rec.Result().Cookies()
+			if !test.expectCookie {
+				c.Assert(cookies, qt.HasLen, 0)
+				if test.method == "GET" {
+					// The token is available to the handler.
+					c.Assert(rec.Body.String(), qt.Equals, `"`+test.cookie+`"`)
+				}
+				return
+			}
+			c.Assert(cookies, qt.HasLen, 1)
+			c.Assert(cookies[0].Name, qt.Equals, "csrf_token")
+			c.Assert(cookies[0].Value, qt.HasLen, len(csrfTestToken))
+			c.Assert(cookies[0].Value, qt.Not(qt.Equals), test.header)
+		})
+	}
+}
+
+func TestCSRFBadTag(t *testing.T) {
+	c := qt.New(t)
+	var srv httprequest.Server
+	c.Assert(func() {
+		srv.Handle(func(_ *struct {
+			httprequest.Route `httprequest:"POST /callbacks" csrf:"off"`
+		}) error {
+			return nil
+		})
+	}, qt.PanicMatches, `bad handler function: .*bad csrf tag "off"`)
+}
@@ -0,0 +1,59 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"io"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// CustomResponse can be returned as the result of a handler (see
+// Server.Handle) to write a response body that is not JSON, such as a
+// CSV export or a binary blob. It is written as is, regardless of the
+// server's Codecs.
+//
+// The response status and headers are sent when WriteBody first writes
+// to its argument. If WriteBody returns an error before that, the
+// error is written as an ordinary error response; if it returns an
+// error afterwards, the response is aborted (see http.ErrAbortHandler)
+// so that the client sees that it is incomplete.
+type CustomResponse struct {
+	// ContentType holds the value of the Content-Type
+	// header of the response.
+	ContentType string
+
+	// WriteBody writes the response body to w.
+	WriteBody func(w io.Writer) error
+}
+
+// SetHeader implements HeaderSetter by setting the
+// Content-Type header.
+func (r *CustomResponse) SetHeader(h http.Header) {
+	if r.ContentType != "" {
+		h.Set("Content-Type", r.ContentType)
+	}
+}
+
+// write writes r as a response with the given status code.
+func (r *CustomResponse) write(w http.ResponseWriter, code int) error {
+	bw := &thresholdWriter{
+		w:    w,
+		code: code,
+		setHeader: func() {
+			r.SetHeader(w.Header())
+		},
+	}
+	if r.WriteBody != nil {
+		if err := r.WriteBody(bw); err != nil {
+			if !bw.committed {
+				return errgo.Mask(err, errgo.Any)
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+	bw.finish()
+	return nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type csvReq struct {
+	httprequest.Route `httprequest:"GET /export"`
+	Rows              int  `httprequest:"rows,form"`
+	Fail              bool `httprequest:"fail,form"`
+}
+
+func csvHandler(req *csvReq) (*httprequest.CustomResponse, error) {
+	if req.Rows < 0 {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "negative rows")
+	}
+	return &httprequest.CustomResponse{
+		ContentType: "text/csv",
+		WriteBody: func(w io.Writer) error {
+			if req.Fail && req.Rows == 0 {
+				return errors.New("cannot export")
+			}
+			for i := 0; i < req.Rows; i++ {
+				fmt.Fprintf(w, "%d,row%d\n", i, i)
+			}
+			if req.Fail {
+				return errors.New("export interrupted")
+			}
+			return nil
+		},
+	}, nil
+}
+
+func TestCustomResponse(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		Codecs: []httprequest.Codec{httprequest.JSONCodec},
+	}
+	h := srv.Handle(csvHandler)
+
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/export?rows=2", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "text/csv")
+	c.Assert(rec.Body.String(), qt.Equals, "0,row0\n1,row1\n")
+
+	// An empty body still sends the headers.
+	rec = httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/export", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "text/csv")
+	c.Assert(rec.Body.String(), qt.Equals, "")
+
+	// Errors from the handler are mapped as usual.
+	rec = httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/export?rows=-1", nil), nil)
+	qthttptest.AssertJSONResponse(c, rec, http.StatusBadRequest, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "negative rows",
+	})
+}
+
+func TestCustomResponseWriteBodyError(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	h := srv.Handle(csvHandler)
+
+	// An error before anything is written produces an error response.
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/export?fail=1", nil), nil)
+	qthttptest.AssertJSONResponse(c, rec, http.StatusInternalServerError, &httprequest.RemoteError{
+		Message: "cannot export",
+	})
+
+	// An error after the body has started aborts the response.
+	rec = httptest.NewRecorder()
+	c.Assert(func() {
+		h.Handle(rec, httptest.NewRequest("GET", "/export?rows=1&fail=1", nil), nil)
+	}, qt.PanicMatches, http.ErrAbortHandler.Error())
+	c.Assert(rec.Body.String(), qt.Equals, "0,row0\n")
+}
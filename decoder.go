@@ -0,0 +1,79 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ResponseDecoder decodes the body of a successful response into x,
+// the response value passed to the Client call. It should not close
+// the body.
+type ResponseDecoder func(resp *http.Response, x interface{}) error
+
+// WithResponseDecoder returns a CallOption that decodes a successful
+// response with d instead of with Client.JSON or Client.Codecs, for
+// example to decode into memory managed by the caller.
+//
+// Everything else is as for other calls: error responses are still
+// unmarshaled with Client.UnmarshalError, response headers are still
+// unmarshaled into x, and responses with an empty body, and response
+// values of type *Created or **http.Response, are handled as usual.
+// An error returned by d is returned as a *DecodeResponseError, and
+// the body is limited by Client.MaxResponseSize as usual.
+func WithResponseDecoder(d ResponseDecoder) CallOption {
+	return func(o *callOptions) {
+		o.decoder = d
+	}
+}
+
+// decode calls d, making sure that any error it returns is a
+// *DecodeResponseError.
+func (d ResponseDecoder) decode(resp *http.Response, x interface{}) error {
+	if x == nil {
+		return nil
+	}
+	err := d(resp, x)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*DecodeResponseError); ok {
+		return err
+	}
+	return newDecodeResponseError(resp, nil, err)
+}
+
+// BufferDecoder returns a ResponseDecoder that reads the response
+// body into buf and then unmarshals it with unmarshal, which may be
+// json.Unmarshal or the Unmarshal method of a Codec. The buffer is
+// reset first, so a buffer can be reused for many calls to avoid
+// allocating memory for each response body; it must not be used by
+// more than one call at once.
+//
+// The unmarshal function must not retain the data it is given, as it
+// will be overwritten by the next response.
+func BufferDecoder(buf *bytes.Buffer, unmarshal func(data []byte, x interface{}) error) ResponseDecoder {
+	return func(resp *http.Response, x interface{}) error {
+		buf.Reset()
+		if resp.ContentLength > 0 {
+			buf.Grow(int(resp.ContentLength))
+		}
+		if _, err := buf.ReadFrom(resp.Body); err != nil {
+			return newDecodeResponseError(resp, copyBytes(buf.Bytes()), errgo.Notef(err, "error reading response body"))
+		}
+		if err := unmarshal(buf.Bytes(), x); err != nil {
+			return newDecodeResponseError(resp, copyBytes(buf.Bytes()), err)
+		}
+		return nil
+	}
+}
+
+// copyBytes returns a copy of data, so that a DecodeResponseError
+// does not refer to a buffer that will be reused.
+func copyBytes(data []byte) []byte {
+	return append([]byte{}, data...)
+}
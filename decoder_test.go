@@ -0,0 +1,161 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type decoderReq struct {
+	httprequest.Route `httprequest:"GET /things/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+type decoderResp struct {
+	Name  string
+	Count int
+}
+
+func newDecoderServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/things/bad":
+			httprequest.WriteJSON(w, http.StatusNotFound, &httprequest.RemoteError{
+				Message: "no such thing",
+				Code:    httprequest.CodeNotFound,
+			})
+		case "/things/big":
+			httprequest.WriteJSON(w, http.StatusOK, &decoderResp{
+				Name: string(bytes.Repeat([]byte("x"), 200)),
+			})
+		default:
+			httprequest.WriteJSON(w, http.StatusOK, &decoderResp{
+				Name:  req.URL.Path,
+				Count: 3,
+			})
+		}
+	}))
+}
+
+func TestBufferDecoder(t *testing.T) {
+	c := qt.New(t)
+	srv := newDecoderServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var buf bytes.Buffer
+	dec := httprequest.WithResponseDecoder(httprequest.BufferDecoder(&buf, json.Unmarshal))
+	for _, name := range []string{"a", "bb"} {
+		var resp decoderResp
+		err := client.CallWithOptions(context.Background(), &decoderReq{Name: name}, &resp, dec)
+		c.Assert(err, qt.IsNil)
+		c.Assert(resp, qt.DeepEquals, decoderResp{
+			Name:  "/things/" + name,
+			Count: 3,
+		})
+		// The buffer holds the body of the last response.
+		c.Assert(buf.String(), qt.Equals, `{"Name":"/things/`+name+`","Count":3}`)
+	}
+}
+
+func TestBufferDecoderUnmarshalError(t *testing.T) {
+	c := qt.New(t)
+	srv := newDecoderServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var buf bytes.Buffer
+	var resp int
+	err := client.CallWithOptions(context.Background(), &decoderReq{Name: "a"}, &resp, httprequest.WithResponseDecoder(httprequest.BufferDecoder(&buf, json.Unmarshal)))
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/things/a"?: json: cannot unmarshal object into Go value of type int`)
+	derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.Equals, true)
+
+	// The error holds its own copy of the body.
+	buf.Reset()
+	buf.WriteString("overwritten")
+	body, err := readResponseBody(derr.Response)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `{"Name":"/things/a","Count":3}`)
+}
+
+func TestResponseDecoderErrorResponse(t *testing.T) {
+	c := qt.New(t)
+	srv := newDecoderServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	called := false
+	dec := httprequest.WithResponseDecoder(func(resp *http.Response, x interface{}) error {
+		called = true
+		return nil
+	})
+	var resp decoderResp
+	err := client.CallWithOptions(context.Background(), &decoderReq{Name: "bad"}, &resp, dec)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Message: "no such thing",
+		Code:    httprequest.CodeNotFound,
+	})
+	c.Assert(called, qt.Equals, false)
+}
+
+func TestResponseDecoderError(t *testing.T) {
+	c := qt.New(t)
+	srv := newDecoderServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	dec := httprequest.WithResponseDecoder(func(resp *http.Response, x interface{}) error {
+		return errgo.New("decoder failure")
+	})
+	var resp decoderResp
+	err := client.CallWithOptions(context.Background(), &decoderReq{Name: "a"}, &resp, dec)
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/things/a"?: decoder failure`)
+	derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.Equals, true)
+	body, err := readResponseBody(derr.Response)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `{"Name":"/things/a","Count":3}`)
+}
+
+func TestBufferDecoderMaxResponseSize(t *testing.T) {
+	c := qt.New(t)
+	srv := newDecoderServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:         srv.URL,
+		MaxResponseSize: 100,
+	}
+	var buf bytes.Buffer
+	var resp decoderResp
+	err := client.CallWithOptions(context.Background(), &decoderReq{Name: "big"}, &resp, httprequest.WithResponseDecoder(httprequest.BufferDecoder(&buf, json.Unmarshal)))
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.ResponseTooLargeError{
+		Limit: 100,
+	})
+}
+
+func readResponseBody(resp *http.Response) (string, error) {
+	var buf bytes.Buffer
+	_, err := buf.ReadFrom(resp.Body)
+	return buf.String(), err
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type defaultReq struct {
+	Limit  int       `httprequest:"limit,form" default:"100"`
+	Order  string    `httprequest:"order,form" default:"asc"`
+	Lang   *string   `httprequest:"Accept-Language,header" default:"en"`
+	Since  time.Time `httprequest:"since,form" format:"date" default:"2020-01-01"`
+	Offset int       `httprequest:"offset,form"`
+}
+
+func TestUnmarshalDefault(t *testing.T) {
+	c := qt.New(t)
+
+	req := httptest.NewRequest("GET", "/x?order=desc", nil)
+	req.ParseForm()
+	var x defaultReq
+	err := httprequest.Unmarshal(httprequest.Params{Request: req}, &x)
+	c.Assert(err, qt.IsNil)
+	c.Assert(x.Limit, qt.Equals, 100)
+	c.Assert(x.Order, qt.Equals, "desc")
+	c.Assert(*x.Lang, qt.Equals, "en")
+	c.Assert(x.Since, qt.DeepEquals, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(x.Offset, qt.Equals, 0)
+
+	// An explicit zero value is not replaced by the default.
+	req = httptest.NewRequest("GET", "/x?limit=0", nil)
+	req.ParseForm()
+	x = defaultReq{}
+	err = httprequest.Unmarshal(httprequest.Params{Request: req}, &x)
+	c.Assert(err, qt.IsNil)
+	c.Assert(x.Limit, qt.Equals, 0)
+}
+
+func TestMarshalOmitsDefault(t *testing.T) {
+	c := qt.New(t)
+
+	en, fr := "en", "fr"
+	req, err := httprequest.Marshal("http://example.com/x", "GET", &defaultReq{
+		Limit: 100,
+		Order: "desc",
+		Lang:  &en,
+		Since: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.URL.String(), qt.Equals, "http://example.com/x?offset=0&order=desc")
+	c.Assert(req.Header.Get("Accept-Language"), qt.Equals, "")
+
+	req, err = httprequest.Marshal("http://example.com/x", "GET", &defaultReq{
+		Limit: 10,
+		Order: "asc",
+		Lang:  &fr,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.URL.String(), qt.Equals, "http://example.com/x?limit=10&offset=0&since=0001-01-01")
+	c.Assert(req.Header.Get("Accept-Language"), qt.Equals, "fr")
+}
+
+func TestBadDefault(t *testing.T) {
+	c := qt.New(t)
+
+	var x struct {
+		Limit int `httprequest:"limit,form" default:"many"`
+	}
+	err := httprequest.Unmarshal(httprequest.Params{Request: httptest.NewRequest("GET", "/", nil)}, &x)
+	c.Assert(err, qt.ErrorMatches, `bad type \*struct .*: bad default tag in field Limit: cannot parse "many" into int: expected integer`)
+
+	var y struct {
+		Tags []string `httprequest:"tag,form" default:"x"`
+	}
+	err = httprequest.Unmarshal(httprequest.Params{Request: httptest.NewRequest("GET", "/", nil)}, &y)
+	c.Assert(err, qt.ErrorMatches, `bad type \*struct .*: cannot use default tag with \[\]string`)
+}
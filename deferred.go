@@ -0,0 +1,144 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrBindTimeout is used as the cause of the error returned by
+// DeferredHandlers.Wait when the handlers were not bound within
+// DeferredHandlers.Timeout, and by a later call to
+// DeferredHandlers.Bind.
+var ErrBindTimeout = errgo.New("timed out waiting to bind handlers")
+
+// ErrAlreadyBound is used as the cause of the error returned by
+// DeferredHandlers.Bind when the handlers have already been bound.
+var ErrAlreadyBound = errgo.New("handlers already bound")
+
+// DeferredHandlers binds handlers to a router that is not available
+// until some time after startup, for example when a framework builds
+// its router or middleware chain asynchronously and reports when it
+// is ready with a callback. Bind should be called from that callback,
+// and Wait from the code that would otherwise have called
+// AddHandlers. For example:
+//
+//	d := &httprequest.DeferredHandlers{
+//		Handlers: srv.Handlers(newHandler),
+//		Timeout:  10 * time.Second,
+//	}
+//	app.OnRouterReady(func(r *httprouter.Router) {
+//		if err := d.Bind(r); err != nil {
+//			log.Print(err)
+//		}
+//	})
+//	if err := d.Wait(ctx); err != nil {
+//		log.Fatal(err)
+//	}
+//
+// The handlers are bound at most once, however many times Bind is
+// called, and not at all once Wait has given up.
+type DeferredHandlers struct {
+	// Handlers holds the handlers to bind.
+	Handlers []Handler
+
+	// Timeout holds the maximum time that Wait waits for the
+	// handlers to be bound. If it is zero, Wait waits until its
+	// context is done.
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	state bindState
+	err   error
+	done  chan struct{}
+}
+
+// bindState holds the state of a DeferredHandlers.
+type bindState int
+
+const (
+	bindPending bindState = iota
+	bindDone
+	bindAbandoned
+)
+
+// Bind adds d.Handlers to r. It returns an error with an
+// ErrAlreadyBound cause if the handlers have already been bound, or
+// an ErrBindTimeout cause if Wait has already given up waiting for
+// them. If r panics when a handler is added, as httprouter.Router
+// does for a conflicting route, the panic is returned as an error and
+// no further attempt is made to bind the handlers.
+func (d *DeferredHandlers) Bind(r Registrar) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch d.state {
+	case bindDone:
+		return errgo.WithCausef(nil, ErrAlreadyBound, "")
+	case bindAbandoned:
+		return errgo.WithCausef(nil, ErrBindTimeout, "cannot bind handlers after waiting has finished")
+	}
+	d.err = addHandlersRecover(r, d.Handlers)
+	d.state = bindDone
+	close(d.doneChan())
+	return d.err
+}
+
+// Wait waits until the handlers have been bound by Bind and returns
+// any error from binding them. If they are not bound within d.Timeout,
+// or before ctx is done, it returns an error (with an ErrBindTimeout
+// cause in the former case) and the handlers will not be bound by any
+// later call to Bind.
+func (d *DeferredHandlers) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	done := d.doneChan()
+	d.mu.Unlock()
+	var timeout <-chan time.Time
+	if d.Timeout > 0 {
+		t := time.NewTimer(d.Timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var err error
+	select {
+	case <-done:
+	case <-timeout:
+		err = errgo.WithCausef(nil, ErrBindTimeout, "handlers not bound after %v", d.Timeout)
+	case <-ctx.Done():
+		err = errgo.NoteMask(ctx.Err(), "handlers not bound", errgo.Any)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.state == bindDone {
+		// Bind might have been called after the timeout
+		// fired but before we acquired the lock.
+		return d.err
+	}
+	d.state = bindAbandoned
+	return err
+}
+
+// doneChan returns the channel that is closed when the handlers are
+// bound. It must be called with d.mu held.
+func (d *DeferredHandlers) doneChan() chan struct{} {
+	if d.done == nil {
+		d.done = make(chan struct{})
+	}
+	return d.done
+}
+
+// addHandlersRecover is like AddHandlers except that a panic from r
+// is returned as an error.
+func addHandlersRecover(r Registrar, hs []Handler) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = errgo.Newf("cannot bind handlers: %v", e)
+		}
+	}()
+	AddHandlers(r, hs)
+	return nil
+}
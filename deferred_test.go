@@ -0,0 +1,115 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type deferredReq struct {
+	httprequest.Route `httprequest:"GET /things/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func deferredHandlers() []httprequest.Handler {
+	var srv httprequest.Server
+	return []httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *deferredReq) (string, error) {
+			return req.ID, nil
+		}),
+	}
+}
+
+func TestDeferredHandlersBind(t *testing.T) {
+	c := qt.New(t)
+
+	d := &httprequest.DeferredHandlers{
+		Handlers: deferredHandlers(),
+		Timeout:  5 * time.Second,
+	}
+	router := httprouter.New()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(10 * time.Millisecond)
+		c.Check(d.Bind(router), qt.IsNil)
+		// Binding again, as a framework might do when its
+		// readiness callback fires twice, has no effect.
+		err := d.Bind(router)
+		c.Check(errgo.Cause(err), qt.Equals, httprequest.ErrAlreadyBound)
+		c.Check(err, qt.ErrorMatches, `handlers already bound`)
+	}()
+	err := d.Wait(context.Background())
+	c.Assert(err, qt.IsNil)
+	wg.Wait()
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/x", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"x"`)
+
+	// Waiting again returns immediately.
+	err = d.Wait(context.Background())
+	c.Assert(err, qt.IsNil)
+}
+
+func TestDeferredHandlersTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	d := &httprequest.DeferredHandlers{
+		Handlers: deferredHandlers(),
+		Timeout:  10 * time.Millisecond,
+	}
+	err := d.Wait(context.Background())
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrBindTimeout)
+	c.Assert(err, qt.ErrorMatches, `handlers not bound after 10ms`)
+
+	// The handlers are not bound once Wait has given up.
+	router := httprouter.New()
+	err = d.Bind(router)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrBindTimeout)
+	c.Assert(err, qt.ErrorMatches, `cannot bind handlers after waiting has finished`)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/x", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusNotFound)
+}
+
+func TestDeferredHandlersContextDone(t *testing.T) {
+	c := qt.New(t)
+
+	d := &httprequest.DeferredHandlers{
+		Handlers: deferredHandlers(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := d.Wait(ctx)
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+	c.Assert(err, qt.ErrorMatches, `handlers not bound: context canceled`)
+}
+
+func TestDeferredHandlersBindPanic(t *testing.T) {
+	c := qt.New(t)
+
+	router := httprouter.New()
+	httprequest.AddHandlers(router, deferredHandlers())
+	d := &httprequest.DeferredHandlers{
+		Handlers: deferredHandlers(),
+	}
+	err := d.Bind(router)
+	c.Assert(err, qt.ErrorMatches, `cannot bind handlers: .*`)
+	err = d.Wait(context.Background())
+	c.Assert(err, qt.ErrorMatches, `cannot bind handlers: .*`)
+}
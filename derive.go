@@ -0,0 +1,127 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// DeriveHandlers returns hs with handlers added for the HEAD and OPTIONS
+// methods:
+//
+// - for each GET route without a corresponding HEAD route, a HEAD
+// handler that calls the GET handler but discards the response body.
+//
+// - for each path without an OPTIONS route, an OPTIONS handler that
+// responds with a 204 No Content status and an Allow header listing
+// the methods available for the path. When Server.CORS is set, it
+// also answers CORS preflight requests. When Server.OptionsCapabilities
+// is set, it instead responds with a JSON Capabilities body
+// generated from the routes created by srv (see Client.Capabilities).
+//
+// As the Allow header is derived from the routes in hs, hs should hold
+// all the handlers that will be registered, and the result should be
+// registered instead of hs, for example:
+//
+//	httprequest.AddHandlers(router, srv.DeriveHandlers(hs))
+//
+// The OPTIONS handlers are wrapped with any additional behaviour
+// configured on srv, as for the handlers returned by Handle.
+func (srv *Server) DeriveHandlers(hs []Handler) []Handler {
+	methods := make(map[string][]string)
+	var paths []string
+	for _, h := range hs {
+		if methods[h.Path] == nil {
+			paths = append(paths, h.Path)
+		}
+		methods[h.Path] = append(methods[h.Path], h.Method)
+	}
+	sort.Strings(paths)
+	hs1 := append([]Handler(nil), hs...)
+	for _, h := range hs {
+		if h.Method == "GET" && !containsString(methods[h.Path], "HEAD") {
+			hs1 = append(hs1, Handler{
+				Method: "HEAD",
+				Path:   h.Path,
+				Handle: headHandle(h.Handle),
+			})
+			methods[h.Path] = append(methods[h.Path], "HEAD")
+		}
+	}
+	for _, path := range paths {
+		pathMethods := methods[path]
+		if containsString(pathMethods, "OPTIONS") {
+			continue
+		}
+		pathMethods = append(pathMethods, "OPTIONS")
+		sort.Strings(pathMethods)
+		hs1 = append(hs1, Handler{
+			Method: "OPTIONS",
+			Path:   path,
+			Handle: srv.wrapHandle(handlerFunc{
+				method:      "OPTIONS",
+				pathPattern: path,
+			}, srv.optionsHandle(path, pathMethods)),
+		})
+	}
+	return hs1
+}
+
+// headHandle returns a handler that calls h
+// and discards the response body.
+func headHandle(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		h(headResponseWriter{w}, req, p)
+	}
+}
+
+// optionsHandle returns a handler for the given path that responds
+// with an Allow header holding the given methods, also answering CORS
+// preflight requests when srv.CORS is set.
+func (srv *Server) optionsHandle(path string, methods []string) httprouter.Handle {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w.Header().Set("Allow", allow)
+		if srv.CORS != nil && isPreflight(req) {
+			srv.CORS.preflight(w, req, path, methods)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		var caps *Capabilities
+		if srv.OptionsCapabilities || srv.SchemaURL != nil {
+			caps = srv.capabilities(path, methods)
+		}
+		if caps != nil && caps.Schema != "" {
+			w.Header().Set("Link", "<"+caps.Schema+`>; rel="describedby"`)
+		}
+		if !srv.OptionsCapabilities {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err := WriteJSON(w, http.StatusOK, caps); err != nil {
+			srv.WriteError(req.Context(), w, err)
+		}
+	}
+}
+
+// headResponseWriter wraps an http.ResponseWriter
+// and discards the response body.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+// Flush implements http.Flusher.Flush.
+func (w headResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
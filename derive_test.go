@@ -0,0 +1,124 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type deriveHandlers struct{}
+
+func (deriveHandlers) List(p httprequest.Params, _ *struct {
+	httprequest.Route `httprequest:"GET /items"`
+}) ([]string, error) {
+	p.Response.Header().Set("X-Count", "2")
+	return []string{"a", "b"}, nil
+}
+
+func (deriveHandlers) Create(_ *struct {
+	httprequest.Route `httprequest:"POST /items"`
+}) error {
+	return nil
+}
+
+func (deriveHandlers) Get(_ *struct {
+	httprequest.Route `httprequest:"GET /items/:id"`
+}) (string, error) {
+	return "item", nil
+}
+
+func (deriveHandlers) Delete(_ *struct {
+	httprequest.Route `httprequest:"DELETE /items/:id"`
+}) error {
+	return nil
+}
+
+var deriveHandlersTests = []struct {
+	about        string
+	method       string
+	path         string
+	expectStatus int
+	expectHeader http.Header
+	expectBody   string
+}{{
+	about:        "derived HEAD",
+	method:       "HEAD",
+	path:         "/items",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"X-Count":      {"2"},
+		"Content-Type": {"application/json"},
+	},
+}, {
+	about:        "GET unaffected",
+	method:       "GET",
+	path:         "/items",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"X-Count":      {"2"},
+		"Content-Type": {"application/json"},
+	},
+	expectBody: `["a","b"]`,
+}, {
+	about:        "explicit HEAD",
+	method:       "HEAD",
+	path:         "/items/1",
+	expectStatus: http.StatusOK,
+	expectHeader: http.Header{
+		"X-Explicit": {"yes"},
+	},
+}, {
+	about:        "OPTIONS on collection",
+	method:       "OPTIONS",
+	path:         "/items",
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow": {"GET, HEAD, OPTIONS, POST"},
+	},
+}, {
+	about:        "OPTIONS on item",
+	method:       "OPTIONS",
+	path:         "/items/1",
+	expectStatus: http.StatusNoContent,
+	expectHeader: http.Header{
+		"Allow": {"DELETE, GET, HEAD, OPTIONS"},
+	},
+}}
+
+func TestDeriveHandlers(t *testing.T) {
+	c := qt.New(t)
+
+	var srv httprequest.Server
+	hs := srv.Handlers(func(p httprequest.Params) (deriveHandlers, context.Context, error) {
+		return deriveHandlers{}, p.Context, nil
+	})
+	// An explicit HEAD handler takes precedence.
+	hs = append(hs, httprequest.Handler{
+		Method: "HEAD",
+		Path:   "/items/:id",
+		Handle: func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+			w.Header().Set("X-Explicit", "yes")
+		},
+	})
+	hs = srv.DeriveHandlers(hs)
+	c.Assert(hs, qt.HasLen, 5+3)
+	router := newHandlerRouter(hs)
+	for _, test := range deriveHandlersTests {
+		c.Run(test.about, func(c *qt.C) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+			c.Assert(rec.Code, qt.Equals, test.expectStatus)
+			c.Assert(rec.Header(), qt.DeepEquals, test.expectHeader)
+			c.Assert(rec.Body.String(), qt.Equals, test.expectBody)
+		})
+	}
+}
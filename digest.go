@@ -0,0 +1,119 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// DigestAlgorithm names an algorithm used to compute a checksum of a
+// response body (see Server.ResponseDigests). The names are those
+// used in the Digest header (see RFC 3230).
+type DigestAlgorithm string
+
+const (
+	// DigestMD5 specifies an MD5 checksum. As well as being
+	// included in the Digest header, it is sent in the
+	// Content-MD5 header (see RFC 1864).
+	DigestMD5 DigestAlgorithm = "MD5"
+
+	// DigestSHA256 specifies a SHA-256 checksum.
+	DigestSHA256 DigestAlgorithm = "SHA-256"
+
+	// DigestSHA512 specifies a SHA-512 checksum.
+	DigestSHA512 DigestAlgorithm = "SHA-512"
+)
+
+// newHash returns a new hash for the algorithm.
+func (alg DigestAlgorithm) newHash() (hash.Hash, error) {
+	switch alg {
+	case DigestMD5:
+		return md5.New(), nil
+	case DigestSHA256:
+		return sha256.New(), nil
+	case DigestSHA512:
+		return sha512.New(), nil
+	}
+	return nil, errgo.Newf("unsupported digest algorithm %q", alg)
+}
+
+// checkDigestAlgorithms returns an error if any of the given
+// algorithms is not supported.
+func checkDigestAlgorithms(algs []DigestAlgorithm) error {
+	for _, alg := range algs {
+		if _, err := alg.newHash(); err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	return nil
+}
+
+// digestWriter buffers a response so that its length and checksums
+// can be sent in its header.
+type digestWriter struct {
+	http.ResponseWriter
+	algs []DigestAlgorithm
+	code int
+	buf  bytes.Buffer
+}
+
+func (w *digestWriter) WriteHeader(code int) {
+	if isInformational(code) {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *digestWriter) Write(data []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.buf.Write(data)
+}
+
+// finish sets the Content-Length and checksum headers of the response
+// and writes it. A response whose status does not allow a body is
+// written without them.
+func (w *digestWriter) finish() error {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	if !bodyAllowedForStatus(w.code) {
+		w.ResponseWriter.WriteHeader(w.code)
+		return nil
+	}
+	body := w.buf.Bytes()
+	digests := make([]string, 0, len(w.algs))
+	h := w.Header()
+	for _, alg := range w.algs {
+		hash, err := alg.newHash()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		hash.Write(body)
+		sum := base64.StdEncoding.EncodeToString(hash.Sum(nil))
+		if alg == DigestMD5 {
+			h.Set("Content-MD5", sum)
+		}
+		digests = append(digests, string(alg)+"="+sum)
+	}
+	h.Set("Digest", strings.Join(digests, ","))
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.ResponseWriter.WriteHeader(w.code)
+	w.ResponseWriter.Write(body)
+	return nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func b64(sum []byte) string {
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+func TestResponseDigests(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		ResponseDigests: []httprequest.DigestAlgorithm{httprequest.DigestSHA256, httprequest.DigestMD5},
+		// ResponseBufferSize is ignored.
+		ResponseBufferSize: 1,
+	}
+	h := srv.Handle(func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /d"`
+	}) ([]int, error) {
+		return []int{1, 2, 3}, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/d", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	body := rec.Body.Bytes()
+	c.Assert(string(body), qt.Equals, "[1,2,3]")
+	md5Sum := md5.Sum(body)
+	sha256Sum := sha256.Sum256(body)
+	c.Assert(rec.Header().Get("Content-Length"), qt.Equals, "7")
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(rec.Header().Get("Content-MD5"), qt.Equals, b64(md5Sum[:]))
+	c.Assert(rec.Header().Get("Digest"), qt.Equals, "SHA-256="+b64(sha256Sum[:])+",MD5="+b64(md5Sum[:]))
+}
+
+func TestResponseDigestsCustomResponse(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		ResponseDigests: []httprequest.DigestAlgorithm{httprequest.DigestSHA256},
+	}
+	h := srv.Handle(func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /d"`
+	}) (*httprequest.CustomResponse, error) {
+		return &httprequest.CustomResponse{
+			ContentType: "text/plain",
+			WriteBody: func(w io.Writer) error {
+				io.WriteString(w, "hello ")
+				io.WriteString(w, "world")
+				return nil
+			},
+		}, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/d", nil), nil)
+	sum := sha256.Sum256([]byte("hello world"))
+	c.Assert(rec.Body.String(), qt.Equals, "hello world")
+	c.Assert(rec.Header().Get("Content-Length"), qt.Equals, "11")
+	c.Assert(rec.Header().Get("Content-MD5"), qt.Equals, "")
+	c.Assert(rec.Header().Get("Digest"), qt.Equals, "SHA-256="+b64(sum[:]))
+}
+
+func TestResponseDigestsNoBody(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		ResponseDigests: []httprequest.DigestAlgorithm{httprequest.DigestSHA256, httprequest.DigestMD5},
+	}
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		c.Run(http.StatusText(code), func(c *qt.C) {
+			h := srv.Handle(func(p httprequest.Params, req *struct {
+				httprequest.Route `httprequest:"GET /d"`
+			}) (*httprequest.StatusResponse, error) {
+				return &httprequest.StatusResponse{
+					Code: code,
+				}, nil
+			})
+			rec := httptest.NewRecorder()
+			h.Handle(rec, httptest.NewRequest("GET", "/d", nil), nil)
+			c.Assert(rec.Code, qt.Equals, code)
+			c.Assert(rec.Body.String(), qt.Equals, "")
+			c.Assert(rec.Header().Get("Content-Length"), qt.Equals, "")
+			c.Assert(rec.Header().Get("Content-MD5"), qt.Equals, "")
+			c.Assert(rec.Header().Get("Digest"), qt.Equals, "")
+		})
+	}
+}
+
+func TestResponseDigestsUnsupportedAlgorithm(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		ResponseDigests: []httprequest.DigestAlgorithm{"CRC32"},
+	}
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, req *struct {
+			httprequest.Route `httprequest:"GET /d"`
+		}) (string, error) {
+			return "x", nil
+		})
+	}, qt.PanicMatches, `bad handler function: bad Server.ResponseDigests: unsupported digest algorithm "CRC32"`)
+}
@@ -0,0 +1,63 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"gopkg.in/errgo.v1"
+)
+
+// DuplicateParamPolicy specifies how a form parameter that is given
+// more than once is unmarshaled into a field that holds a single
+// value. Accepting either value silently can allow HTTP parameter
+// pollution, where a component in front of the server checks a
+// different value from the one that the handler sees.
+//
+// Fields of type []string and form map fields always receive all
+// the values.
+type DuplicateParamPolicy int
+
+const (
+	// FirstParam specifies that the first value is used. This is
+	// the default.
+	FirstParam DuplicateParamPolicy = iota
+
+	// LastParam specifies that the last value is used.
+	LastParam
+
+	// RejectDuplicateParams specifies that unmarshaling fails
+	// with an error with an ErrUnmarshal cause, which an
+	// ErrorMapper would usually map to a 400 Bad Request status
+	// as for other invalid parameters.
+	RejectDuplicateParams
+)
+
+// duplicateParamPolicies maps the attributes that can be specified
+// on a form field to the policy they select.
+var duplicateParamPolicies = map[string]DuplicateParamPolicy{
+	"first":  FirstParam,
+	"last":   LastParam,
+	"unique": RejectDuplicateParams,
+}
+
+// duplicateParamPolicy returns the policy for the form field with
+// the given tag: the one specified by its attributes, if any, or the
+// default held in p.
+func duplicateParamPolicy(t tag, p Params) DuplicateParamPolicy {
+	if t.duplicates != "" {
+		return duplicateParamPolicies[t.duplicates]
+	}
+	return p.duplicateParams
+}
+
+// checkDuplicateParam returns an error if the form parameter for the
+// given field has more than one value and duplicates are rejected.
+func checkDuplicateParam(f *field, p Params) error {
+	if !f.singleFormValue || len(p.Request.Form[f.tag.name]) < 2 {
+		return nil
+	}
+	if duplicateParamPolicy(f.tag, p) != RejectDuplicateParams {
+		return nil
+	}
+	return errgo.Newf("parameter %q specified more than once", f.tag.name)
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type duplicateParamsReq struct {
+	httprequest.Route `httprequest:"GET /dup"`
+	Default           string   `httprequest:"a,form"`
+	First             int      `httprequest:"b,form,first"`
+	Last              *int     `httprequest:"c,form,last"`
+	Unique            string   `httprequest:"d,form,unique"`
+	All               []string `httprequest:"e,form"`
+}
+
+var duplicateParamsTests = []struct {
+	about       string
+	policy      httprequest.DuplicateParamPolicy
+	query       string
+	expect      duplicateParamsReq
+	expectError string
+}{{
+	about: "single values",
+	query: "a=1&b=2&c=3&d=4&e=5",
+	expect: duplicateParamsReq{
+		Default: "1",
+		First:   2,
+		Last:    newInt(3),
+		Unique:  "4",
+		All:     []string{"5"},
+	},
+}, {
+	about: "default policy uses first",
+	query: "a=1&a=2&b=3&b=4&c=5&c=6&e=7&e=8",
+	expect: duplicateParamsReq{
+		Default: "1",
+		First:   3,
+		Last:    newInt(6),
+		All:     []string{"7", "8"},
+	},
+}, {
+	about:  "last policy",
+	policy: httprequest.LastParam,
+	query:  "a=1&a=2&b=3&b=4&c=5&c=6",
+	expect: duplicateParamsReq{
+		Default: "2",
+		First:   3,
+		Last:    newInt(6),
+	},
+}, {
+	about:       "reject policy",
+	policy:      httprequest.RejectDuplicateParams,
+	query:       "a=1&a=2",
+	expectError: `cannot unmarshal parameters: cannot unmarshal into field Default: parameter "a" specified more than once`,
+}, {
+	about:  "reject policy does not apply to overridden fields",
+	policy: httprequest.RejectDuplicateParams,
+	query:  "b=3&b=4&c=5&c=6&e=7&e=8",
+	expect: duplicateParamsReq{
+		First: 3,
+		Last:  newInt(6),
+		All:   []string{"7", "8"},
+	},
+}, {
+	about:       "unique field",
+	query:       "d=1&d=1",
+	expectError: `cannot unmarshal parameters: cannot unmarshal into field Unique: parameter "d" specified more than once`,
+}}
+
+func TestServerDuplicateParams(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range duplicateParamsTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := &httprequest.Server{
+				DuplicateParams: test.policy,
+				ErrorMapper:     testErrorMapper,
+			}
+			var got *duplicateParamsReq
+			h := srv.Handle(func(p httprequest.Params, req *duplicateParamsReq) {
+				got = req
+			})
+			rec := httptest.NewRecorder()
+			h.Handle(rec, httptest.NewRequest("GET", "/dup?"+test.query, nil), nil)
+			if test.expectError != "" {
+				c.Assert(rec.Code, qt.Equals, http.StatusBadRequest)
+				c.Assert(rec.Body.String(), qt.JSONEquals, &httprequest.RemoteError{
+					Code:    httprequest.CodeBadRequest,
+					Message: test.expectError,
+				})
+				c.Assert(got, qt.IsNil)
+				return
+			}
+			c.Assert(rec.Code, qt.Equals, http.StatusOK)
+			c.Assert(got, qt.Not(qt.IsNil))
+			got.Route = httprequest.Route{}
+			c.Assert(*got, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestUnmarshalDuplicateParams(t *testing.T) {
+	c := qt.New(t)
+
+	req := httptest.NewRequest("GET", "/dup?a=1&a=2&c=3&c=4&d=5&d=6", nil)
+	req.ParseForm()
+	var x duplicateParamsReq
+	err := httprequest.Unmarshal(httprequest.Params{
+		Request: req,
+	}, &x)
+	c.Assert(err, qt.ErrorMatches, `cannot unmarshal into field Unique: parameter "d" specified more than once`)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrUnmarshal)
+	c.Assert(x.Default, qt.Equals, "1")
+	c.Assert(*x.Last, qt.Equals, 4)
+}
+
+func TestHandleJSONDuplicateParams(t *testing.T) {
+	c := qt.New(t)
+	srv := &httprequest.Server{
+		DuplicateParams: httprequest.LastParam,
+	}
+	h := srv.HandleJSON(func(p httprequest.Params) (interface{}, error) {
+		p.Request.ParseForm()
+		var req duplicateParamsReq
+		if err := httprequest.Unmarshal(p, &req); err != nil {
+			return nil, err
+		}
+		return req.Default, nil
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/dup?a=1&a=2", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"2"`)
+}
+
+func TestHandleErrorsDuplicateParams(t *testing.T) {
+	c := qt.New(t)
+	srv := &httprequest.Server{
+		DuplicateParams: httprequest.RejectDuplicateParams,
+		ErrorMapper:     testErrorMapper,
+	}
+	h := srv.HandleErrors(func(p httprequest.Params) error {
+		p.Request.ParseForm()
+		var req duplicateParamsReq
+		return httprequest.Unmarshal(p, &req)
+	})
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest("GET", "/dup?a=1&a=2", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusBadRequest)
+	c.Assert(rec.Body.String(), qt.JSONEquals, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `cannot unmarshal into field Default: parameter "a" specified more than once`,
+	})
+}
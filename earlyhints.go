@@ -0,0 +1,51 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+)
+
+// EarlyHints sends a 103 (Early Hints) informational response holding
+// the given Link header values (see RFC 8297), so that the client can
+// start to preload the resources they refer to while the handler
+// prepares the final response. For example:
+//
+//	p.EarlyHints(
+//		"</style.css>; rel=preload; as=style",
+//		"</app.js>; rel=preload; as=script",
+//	)
+//
+// The links are also sent with the final response. EarlyHints must be
+// called before the final response has been started; it does nothing
+// if there are no links or if the request was made with HTTP/1.0,
+// which does not allow informational responses.
+//
+// Early hints can also be specified for a route with an "earlyhints"
+// tag on its Route field holding a Link header value, in which case
+// they are sent before the handler is called. For example:
+//
+//	type PageRequest struct {
+//		httprequest.Route `httprequest:"GET /page" earlyhints:"</style.css>; rel=preload; as=style"`
+//	}
+func (p Params) EarlyHints(links ...string) {
+	if len(links) == 0 || p.Response == nil {
+		return
+	}
+	if p.Request != nil && !p.Request.ProtoAtLeast(1, 1) {
+		return
+	}
+	h := p.Response.Header()
+	for _, link := range links {
+		h.Add("Link", link)
+	}
+	p.Response.WriteHeader(http.StatusEarlyHints)
+}
+
+// isInformational reports whether code is the status of an
+// informational (1xx) response, which may be sent before the final
+// response and does not start it.
+func isInformational(code int) bool {
+	return code >= 100 && code < 200
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+const (
+	styleLink  = "</style.css>; rel=preload; as=style"
+	scriptLink = "</app.js>; rel=preload; as=script"
+)
+
+var earlyHintsTests = []struct {
+	about        string
+	server       httprequest.Server
+	handler      interface{}
+	expectHints  [][]string
+	expectStatus int
+	expectBody   string
+}{{
+	about: "hints from handler",
+	handler: func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /page"`
+	}) (string, error) {
+		p.EarlyHints(styleLink, scriptLink)
+		return "page", nil
+	},
+	expectHints:  [][]string{{styleLink, scriptLink}},
+	expectStatus: http.StatusOK,
+	expectBody:   `"page"`,
+}, {
+	about: "hints from route tag",
+	handler: func(req *struct {
+		httprequest.Route `httprequest:"GET /page" earlyhints:"</style.css>; rel=preload; as=style"`
+	}) (string, error) {
+		return "page", nil
+	},
+	expectHints:  [][]string{{styleLink}},
+	expectStatus: http.StatusOK,
+	expectBody:   `"page"`,
+}, {
+	about: "hints from route tag and handler",
+	handler: func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /page" earlyhints:"</style.css>; rel=preload; as=style"`
+	}) (string, error) {
+		p.EarlyHints(scriptLink)
+		return "page", nil
+	},
+	expectHints:  [][]string{{styleLink}, {styleLink, scriptLink}},
+	expectStatus: http.StatusOK,
+	expectBody:   `"page"`,
+}, {
+	about: "hints do not start the response",
+	server: httprequest.Server{
+		NoContent:     true,
+		RecoverPanics: true,
+	},
+	handler: func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /page"`
+	}) {
+		p.EarlyHints(styleLink)
+	},
+	expectHints:  [][]string{{styleLink}},
+	expectStatus: http.StatusNoContent,
+}, {
+	about: "hints before an error",
+	handler: func(req *struct {
+		httprequest.Route `httprequest:"GET /page" earlyhints:"</style.css>; rel=preload; as=style"`
+	}) (string, error) {
+		return "", httprequest.Errorf(httprequest.CodeNotFound, "no page")
+	},
+	expectHints:  [][]string{{styleLink}},
+	expectStatus: http.StatusNotFound,
+	expectBody:   `{"Message":"no page","Code":"not found"}`,
+}, {
+	about: "no hints",
+	handler: func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /page"`
+	}) (string, error) {
+		p.EarlyHints()
+		return "page", nil
+	},
+	expectStatus: http.StatusOK,
+	expectBody:   `"page"`,
+}}
+
+func TestEarlyHints(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range earlyHintsTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := test.server
+			server := newHandlerServer([]httprequest.Handler{srv.Handle(test.handler)})
+			defer server.Close()
+
+			var hints [][]string
+			trace := &httptrace.ClientTrace{
+				Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+					c.Check(code, qt.Equals, http.StatusEarlyHints)
+					hints = append(hints, header["Link"])
+					return nil
+				},
+			}
+			req, err := http.NewRequest("GET", server.URL+"/page", nil)
+			c.Assert(err, qt.IsNil)
+			req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.IsNil)
+			defer resp.Body.Close()
+			c.Assert(hints, qt.DeepEquals, test.expectHints)
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+			body, err := readResponseBody(resp)
+			c.Assert(err, qt.IsNil)
+			c.Assert(body, qt.Equals, test.expectBody)
+			// The links are also sent with the final response.
+			var expectLinks []string
+			if len(test.expectHints) > 0 {
+				expectLinks = test.expectHints[len(test.expectHints)-1]
+			}
+			c.Assert(resp.Header["Link"], qt.DeepEquals, expectLinks)
+		})
+	}
+}
+
+func TestEarlyHintsLoggedStatus(t *testing.T) {
+	c := qt.New(t)
+	var status int
+	srv := &httprequest.Server{
+		Logger: httprequest.LoggerFunc(func(ctx context.Context, entry *httprequest.RequestLogEntry) {
+			status = entry.Status
+		}),
+	}
+	server := newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *struct {
+			httprequest.Route `httprequest:"GET /page"`
+		}) (string, error) {
+			p.EarlyHints(styleLink)
+			return "page", nil
+		}),
+	})
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/page")
+	c.Assert(err, qt.IsNil)
+	resp.Body.Close()
+	c.Assert(status, qt.Equals, http.StatusOK)
+}
+
+func TestEarlyHintsHTTP10(t *testing.T) {
+	c := qt.New(t)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/page", nil)
+	req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+	p := httprequest.Params{
+		Response: rec,
+		Request:  req,
+	}
+	p.EarlyHints(styleLink)
+	c.Assert(rec.Header().Get("Link"), qt.Equals, "")
+}
+
+func TestBadEarlyHintsTag(t *testing.T) {
+	c := qt.New(t)
+	var srv httprequest.Server
+	c.Assert(func() {
+		srv.Handle(func(*struct {
+			httprequest.Route `httprequest:"GET /page" earlyhints:" "`
+		}) error {
+			return nil
+		})
+	}, qt.PanicMatches, `bad handler function: last argument cannot be used for Unmarshal: bad route tag .*: bad earlyhints tag " "`)
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Endpoint describes a handler created by a Server in a form that does
+// not depend on any particular router, so that it can be registered
+// with any router or custom mux.
+//
+// The HandlerFunc field finds the values of path parameters in the
+// request context as stored by httprouter (see
+// httprouter.ParamsFromContext); routers other than httprouter should
+// add them to the request with WithPathParams before calling it.
+type Endpoint struct {
+	// Method holds the HTTP method of the endpoint.
+	Method string
+
+	// Path holds the path pattern of the endpoint in
+	// httprouter syntax, for example "/items/:id/*rest".
+	Path string
+
+	// PathParams holds the names of the parameters
+	// in Path, in order.
+	PathParams []string
+
+	// HandlerFunc handles requests to the endpoint.
+	HandlerFunc http.HandlerFunc
+
+	// Name holds the name of the method that implements
+	// the endpoint when it was created by Server.Endpoints,
+	// and is empty otherwise.
+	Name string
+
+	// ArgType holds the type of the argument struct of the
+	// handler function (ArgT in Server.Handle).
+	ArgType reflect.Type
+
+	// ResultType holds the type of the result of the handler
+	// function (ResultT in Server.Handle), or nil if it
+	// returns no result.
+	ResultType reflect.Type
+
+	// Scopes holds the scopes required by the endpoint,
+	// if any (see Server.Handle).
+	Scopes []string
+
+	// Lifecycle holds the lifecycle of the endpoint as specified
+	// by the tags on its Route field, or nil if there is none.
+	Lifecycle *Lifecycle
+
+	// Version holds the API version of the endpoint as specified
+	// by the version tag on its Route field, if any.
+	Version string
+
+	// handle holds the handler in the form used by Handler.
+	handle httprouter.Handle
+}
+
+func newEndpoint(hf handlerFunc, name string, h httprouter.Handle) Endpoint {
+	return Endpoint{
+		Method:     hf.method,
+		Path:       hf.pathPattern,
+		PathParams: pathParamNames(hf.pathPattern),
+		HandlerFunc: func(w http.ResponseWriter, req *http.Request) {
+			h(w, req, httprouter.ParamsFromContext(req.Context()))
+		},
+		Name:       name,
+		ArgType:    hf.argType,
+		ResultType: hf.resultType,
+		Scopes:     hf.scopes,
+		Lifecycle:  hf.lifecycle,
+		Version:    hf.version,
+		handle:     h,
+	}
+}
+
+// handler returns e as a Handler.
+func (e Endpoint) handler() Handler {
+	return Handler{
+		Method:  e.Method,
+		Path:    e.Path,
+		Handle:  e.handle,
+		Version: e.Version,
+	}
+}
+
+// WithPathParams returns a shallow copy of req with the given path
+// parameter values added to its context, so that they are available
+// to Endpoint.HandlerFunc.
+func WithPathParams(req *http.Request, params map[string]string) *http.Request {
+	ps := make(httprouter.Params, 0, len(params))
+	for k, v := range params {
+		ps = append(ps, httprouter.Param{
+			Key:   k,
+			Value: v,
+		})
+	}
+	sort.Slice(ps, func(i, j int) bool {
+		return ps[i].Key < ps[j].Key
+	})
+	return req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, ps))
+}
+
+// pathParamNames returns the names of the parameters
+// in the given httprouter path pattern.
+func pathParamNames(pattern string) []string {
+	var names []string
+	for _, seg := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			names = append(names, seg[1:])
+		}
+	}
+	return names
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type endpointItemReq struct {
+	httprequest.Route `httprequest:"GET /items/:id/*rest"`
+	ID                string `httprequest:"id,path"`
+	Rest              string `httprequest:"rest,path"`
+}
+
+type endpointHandlers struct{}
+
+func (endpointHandlers) Item(req *endpointItemReq) (string, error) {
+	return req.ID + req.Rest, nil
+}
+
+func (endpointHandlers) Delete(*struct {
+	httprequest.Route `httprequest:"DELETE /items/:id"`
+}) error {
+	return nil
+}
+
+func TestServerEndpoints(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	es := srv.Endpoints(func(p httprequest.Params) (endpointHandlers, context.Context, error) {
+		return endpointHandlers{}, p.Context, nil
+	})
+	c.Assert(es, qt.HasLen, 2)
+
+	c.Assert(es[0].Name, qt.Equals, "Delete")
+	c.Assert(es[0].Method, qt.Equals, "DELETE")
+	c.Assert(es[0].Path, qt.Equals, "/items/:id")
+	c.Assert(es[0].PathParams, qt.DeepEquals, []string{"id"})
+	c.Assert(es[0].ResultType, qt.IsNil)
+
+	e := es[1]
+	c.Assert(e.Name, qt.Equals, "Item")
+	c.Assert(e.Method, qt.Equals, "GET")
+	c.Assert(e.Path, qt.Equals, "/items/:id/*rest")
+	c.Assert(e.PathParams, qt.DeepEquals, []string{"id", "rest"})
+	c.Assert(e.ArgType, qt.Equals, reflect.TypeOf(endpointItemReq{}))
+	c.Assert(e.ResultType, qt.Equals, reflect.TypeOf(""))
+
+	// Register the endpoint with a mux that knows nothing
+	// about httprouter.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/items/", func(w http.ResponseWriter, req *http.Request) {
+		req = httprequest.WithPathParams(req, map[string]string{
+			"id":   "42",
+			"rest": "/x/y",
+		})
+		e.HandlerFunc(w, req)
+	})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/items/42/x/y", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"42/x/y"`)
+}
+
+func TestServerEndpoint(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	e := srv.Endpoint(func(p httprequest.Params, req *endpointItemReq) (string, error) {
+		return req.ID, nil
+	})
+	c.Assert(e.Name, qt.Equals, "")
+	c.Assert(e.Method, qt.Equals, "GET")
+	c.Assert(e.PathParams, qt.DeepEquals, []string{"id", "rest"})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/items/7/z", nil)
+	e.HandlerFunc(rec, httprequest.WithPathParams(req, map[string]string{"id": "7", "rest": "/z"}))
+	c.Assert(rec.Body.String(), qt.Equals, `"7"`)
+}
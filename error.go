@@ -12,10 +12,15 @@ import (
 // These constants are recognized by DefaultErrorMapper
 // as mapping to the similarly named HTTP status codes.
 const (
-	CodeBadRequest   = "bad request"
-	CodeUnauthorized = "unauthorized"
-	CodeForbidden    = "forbidden"
-	CodeNotFound     = "not found"
+	CodeBadRequest         = "bad request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not found"
+	CodeConflict           = "conflict"
+	CodeTooManyRequests    = "too many requests"
+	CodeServiceUnavailable = "service unavailable"
+	CodeNotAcceptable      = "not acceptable"
+	CodePreconditionFailed = "precondition failed"
 )
 
 // DefaultErrorUnmarshaler is the default error unmarshaler
@@ -27,26 +32,55 @@ var DefaultErrorUnmarshaler = ErrorUnmarshaler(new(RemoteError))
 // ErrorCoder interface, the Code field will be set accordingly; some
 // codes will map to specific HTTP status codes (for example, if
 // ErrorCode returns CodeBadRequest, the resulting HTTP status will be
-// http.StatusBadRequest).
+// http.StatusBadRequest), and an error that implements StatusCoder
+// determines the status itself. The RequestID field is set to the ID
+// of the request when there is one (see Server.RequestID).
 var DefaultErrorMapper = defaultErrorMapper
 
 func defaultErrorMapper(ctx context.Context, err error) (status int, body interface{}) {
 	errorBody := errorResponseBody(err)
-	switch errorBody.Code {
-	case CodeBadRequest:
-		status = http.StatusBadRequest
-	case CodeUnauthorized:
-		status = http.StatusUnauthorized
-	case CodeForbidden:
-		status = http.StatusForbidden
-	case CodeNotFound:
-		status = http.StatusNotFound
-	default:
+	if id := RequestIDFromContext(ctx); id != "" {
+		errorBody.RequestID = id
+	}
+	status, ok := errorCodeStatuses[errorBody.Code]
+	if !ok {
 		status = http.StatusInternalServerError
 	}
+	if coder, ok := errgo.Cause(err).(StatusCoder); ok {
+		if s := coder.StatusCode(); s != 0 {
+			status = s
+		}
+	}
 	return status, errorBody
 }
 
+// errorCodeStatuses holds the statuses of the responses written by
+// DefaultErrorMapper for errors with the given codes.
+var errorCodeStatuses = map[string]int{
+	CodeBadRequest:         http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeTooManyRequests:    http.StatusTooManyRequests,
+	CodeServiceUnavailable: http.StatusServiceUnavailable,
+	CodeNotAcceptable:      http.StatusNotAcceptable,
+	CodePreconditionFailed: http.StatusPreconditionFailed,
+}
+
+// StatusErrorCode returns the error code that DefaultErrorMapper
+// maps to the given status, or the empty string if there is none.
+// It can be used to find the code of an error response that does
+// not specify one.
+func StatusErrorCode(status int) string {
+	for code, s := range errorCodeStatuses {
+		if s == status {
+			return code
+		}
+	}
+	return ""
+}
+
 // errorResponse returns an appropriate error
 // response for the provided error.
 func errorResponseBody(err error) *RemoteError {
@@ -75,6 +109,14 @@ type ErrorCoder interface {
 	ErrorCode() string
 }
 
+// StatusCoder may be implemented by an error to cause
+// DefaultErrorMapper to respond with a particular HTTP
+// status. It takes precedence over the status implied by
+// the error code. A zero status is ignored.
+type StatusCoder interface {
+	StatusCode() int
+}
+
 // RemoteError holds the default type of a remote error
 // used by Client when no custom error unmarshaler
 // is set. This type is also used by DefaultErrorMapper
@@ -88,6 +130,10 @@ type RemoteError struct {
 
 	// Info holds any other information associated with the error.
 	Info *json.RawMessage `json:",omitempty"`
+
+	// RequestID holds the ID of the request that failed, if known
+	// (see Server.RequestID).
+	RequestID string `json:",omitempty"`
 }
 
 // Error implements the error interface.
@@ -0,0 +1,89 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestClientMaxErrorBodySize(t *testing.T) {
+	c := qt.New(t)
+
+	page := "<html><body><p>" + strings.Repeat("x", 1024*1024) + "</p></body></html>"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(page))
+	}))
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:          srv.URL,
+		MaxErrorBodySize: 20,
+	}
+	err := client.Get(context.Background(), "/", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/: cannot unmarshal error response \(status 502 Bad Gateway\): unexpected content type text/html; want application/json; content: xxxxx`)
+	derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.IsTrue, qt.Commentf("error %#v", err))
+	data, err := ioutil.ReadAll(derr.Response.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, "<html><body><p>xxxxx")
+}
+
+func TestClientMaxErrorBodySizeTruncatesJSON(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusBadRequest, &httprequest.RemoteError{
+			Code:    httprequest.CodeBadRequest,
+			Message: strings.Repeat("m", 100),
+		})
+	}))
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:          srv.URL,
+		MaxErrorBodySize: 1000,
+	}
+	err := client.Get(context.Background(), "/", nil)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: strings.Repeat("m", 100),
+	})
+
+	client.MaxErrorBodySize = 50
+	err = client.Get(context.Background(), "/", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/: cannot unmarshal error response \(status 400 Bad Request\): unexpected end of JSON input`)
+	_, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.IsTrue)
+}
+
+func TestStatusErrorCode(t *testing.T) {
+	c := qt.New(t)
+	for _, code := range []string{
+		httprequest.CodeBadRequest,
+		httprequest.CodeUnauthorized,
+		httprequest.CodeForbidden,
+		httprequest.CodeNotFound,
+		httprequest.CodeConflict,
+		httprequest.CodeTooManyRequests,
+		httprequest.CodeServiceUnavailable,
+		httprequest.CodeNotAcceptable,
+		httprequest.CodePreconditionFailed,
+	} {
+		status, _ := httprequest.DefaultErrorMapper(context.Background(), httprequest.Errorf(code, "x"))
+		c.Check(httprequest.StatusErrorCode(status), qt.Equals, code)
+	}
+	c.Assert(httprequest.StatusErrorCode(http.StatusTeapot), qt.Equals, "")
+}
@@ -1,4 +1,14 @@
 package httprequest
 
+import "bytes"
+
 var AppendURL = appendURL
 var MaxErrorBodySize = &maxErrorBodySize
+
+func BufferPoolGet(p *BufferPool) *bytes.Buffer {
+	return p.get()
+}
+
+func BufferPoolPut(p *BufferPool, buf *bytes.Buffer) {
+	p.put(buf)
+}
@@ -0,0 +1,120 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// forwardedInfo holds the external view of a request as reported
+// by a trusted proxy.
+type forwardedInfo struct {
+	scheme string
+	host   string
+	prefix string
+}
+
+type forwardedInfoKey struct{}
+
+// ExternalURL returns the URL of the given request as seen by the
+// client, including the scheme and host.
+//
+// When the request has been received by a handler created by a Server
+// from one of its TrustedProxies, the scheme and host are taken from
+// the X-Forwarded-Proto and X-Forwarded-Host headers or, when the
+// Server's ForwardedHeader is "Forwarded", from the proto and host
+// parameters of the Forwarded header, and the path prefix is taken
+// from the X-Forwarded-Prefix header. The values recorded by the
+// outermost trusted proxy are used, so that they cannot be spoofed by
+// the client. Otherwise they are taken from the request itself.
+//
+// The returned URL should be used when constructing links, including
+// Location headers, that are returned to the client.
+func ExternalURL(req *http.Request) *url.URL {
+	u := *req.URL
+	u.Scheme = "http"
+	if req.TLS != nil {
+		u.Scheme = "https"
+	}
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if fwd, ok := req.Context().Value(forwardedInfoKey{}).(forwardedInfo); ok {
+		if fwd.scheme != "" {
+			u.Scheme = fwd.scheme
+		}
+		if fwd.host != "" {
+			u.Host = fwd.host
+		}
+		if fwd.prefix != "" {
+			u.Path = fwd.prefix + u.Path
+			if u.RawPath != "" {
+				u.RawPath = fwd.prefix + u.RawPath
+			}
+		}
+	}
+	return &u
+}
+
+// contextWithForwardedInfo returns a copy of ctx holding the
+// external view of req as reported by the proxy headers in it.
+// It should only be called when req has come from a trusted proxy.
+//
+// The header argument holds the header that the trusted proxies use
+// to report the client address (see Server.ForwardedHeader), and the
+// skipped argument holds the number of trusted proxies that recorded
+// hops after the client (see clientIP). The information is taken from
+// the hop recorded by the outermost trusted proxy, counting the items
+// of each header from the right in the same way, so that values added
+// by the client are ignored. When the header is Forwarded, the
+// X-Forwarded-Proto and X-Forwarded-Host headers are not used.
+func contextWithForwardedInfo(ctx context.Context, req *http.Request, header string, skipped int) context.Context {
+	var fwd forwardedInfo
+	if header == "Forwarded" {
+		elem := trustedHeaderItem(req.Header, "Forwarded", skipped)
+		fwd.scheme = forwardedParam(elem, "proto")
+		fwd.host = forwardedParam(elem, "host")
+	} else {
+		fwd.scheme = trustedHeaderItem(req.Header, "X-Forwarded-Proto", skipped)
+		fwd.host = trustedHeaderItem(req.Header, "X-Forwarded-Host", skipped)
+	}
+	fwd.prefix = trustedHeaderItem(req.Header, "X-Forwarded-Prefix", skipped)
+
+	fwd.scheme = strings.ToLower(fwd.scheme)
+	if fwd.scheme != "http" && fwd.scheme != "https" {
+		fwd.scheme = ""
+	}
+	if strings.ContainsAny(fwd.host, "/?#@ ") {
+		fwd.host = ""
+	}
+	if fwd.prefix != "" {
+		fwd.prefix = "/" + strings.Trim(fwd.prefix, "/")
+		if fwd.prefix == "/" || strings.ContainsAny(fwd.prefix, "?#") {
+			fwd.prefix = ""
+		}
+	}
+	if fwd == (forwardedInfo{}) {
+		return ctx
+	}
+	return context.WithValue(ctx, forwardedInfoKey{}, fwd)
+}
+
+// trustedHeaderItem returns the item in the comma-separated list held
+// in the given header that comes the given number of items before the
+// last one. When the list is shorter than that, some proxies have set
+// the header rather than adding to it, so the first item is returned.
+func trustedHeaderItem(h http.Header, key string, skipped int) string {
+	items := headerList(h, key)
+	if len(items) == 0 {
+		return ""
+	}
+	i := len(items) - 1 - skipped
+	if i < 0 {
+		i = 0
+	}
+	return items[i]
+}
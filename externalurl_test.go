@@ -0,0 +1,136 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var externalURLTests = []struct {
+	about           string
+	forwardedHeader string
+	remoteAddr      string
+	header          http.Header
+	expectURL       string
+}{{
+	about:      "direct request",
+	remoteAddr: "192.0.2.1:1234",
+	expectURL:  "http://example.com/things/x?a=b",
+}, {
+	about:      "untrusted proxy headers are ignored",
+	remoteAddr: "192.0.2.1:1234",
+	header: http.Header{
+		"X-Forwarded-Proto":  {"https"},
+		"X-Forwarded-Host":   {"api.example.org"},
+		"X-Forwarded-Prefix": {"/v1"},
+	},
+	expectURL: "http://example.com/things/x?a=b",
+}, {
+	about:      "trusted proxy",
+	remoteAddr: "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-Proto":  {"https"},
+		"X-Forwarded-Host":   {"api.example.org"},
+		"X-Forwarded-Prefix": {"/v1/"},
+	},
+	expectURL: "https://api.example.org/v1/things/x?a=b",
+}, {
+	about:      "multiple proxies",
+	remoteAddr: "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-For":   {"192.0.2.43, 10.1.1.1"},
+		"X-Forwarded-Proto": {"https, http"},
+		"X-Forwarded-Host":  {"api.example.org, lb.internal"},
+	},
+	expectURL: "https://api.example.org/things/x?a=b",
+}, {
+	about:      "spoofed values are ignored",
+	remoteAddr: "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-For":    {"10.1.1.2, 192.0.2.43"},
+		"X-Forwarded-Proto":  {"http, https"},
+		"X-Forwarded-Host":   {"evil.example.org, api.example.org"},
+		"X-Forwarded-Prefix": {"/evil, /v1"},
+	},
+	expectURL: "https://api.example.org/v1/things/x?a=b",
+}, {
+	about:      "spoofed forwarded header is ignored",
+	remoteAddr: "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded":         {`for=10.1.1.2;proto=http;host=evil.example.org`},
+		"X-Forwarded-For":   {"192.0.2.43"},
+		"X-Forwarded-Proto": {"https"},
+		"X-Forwarded-Host":  {"api.example.org"},
+	},
+	expectURL: "https://api.example.org/things/x?a=b",
+}, {
+	about:           "forwarded header",
+	forwardedHeader: "Forwarded",
+	remoteAddr:      "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded":         {`for=192.0.2.43;proto=https;host="api.example.org:8443", for=10.1.1.1`},
+		"X-Forwarded-Proto": {"http"},
+		"X-Forwarded-Host":  {"other.example.org"},
+	},
+	expectURL: "https://api.example.org:8443/things/x?a=b",
+}, {
+	about:           "spoofed forwarded element is ignored",
+	forwardedHeader: "Forwarded",
+	remoteAddr:      "10.0.0.1:1234",
+	header: http.Header{
+		"Forwarded": {`for=10.1.1.2;host=evil.example.org, for=192.0.2.43;proto=https;host=api.example.org`},
+	},
+	expectURL: "https://api.example.org/things/x?a=b",
+}, {
+	about:           "untrusted forwarded header is ignored",
+	forwardedHeader: "Forwarded",
+	remoteAddr:      "192.0.2.1:1234",
+	header: http.Header{
+		"Forwarded": {`for=192.0.2.43;proto=https;host=api.example.org`},
+	},
+	expectURL: "http://example.com/things/x?a=b",
+}, {
+	about:      "invalid values are ignored",
+	remoteAddr: "10.0.0.1:1234",
+	header: http.Header{
+		"X-Forwarded-Proto": {"javascript"},
+		"X-Forwarded-Host":  {"evil.example.org/path"},
+	},
+	expectURL: "http://example.com/things/x?a=b",
+}}
+
+func TestExternalURL(t *testing.T) {
+	c := qt.New(t)
+
+	trusted, err := httprequest.ParseCIDRs("10.0.0.0/8")
+	c.Assert(err, qt.Equals, nil)
+	for _, test := range externalURLTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := httprequest.Server{
+				TrustedProxies:  trusted,
+				ForwardedHeader: test.forwardedHeader,
+			}
+			h := srv.Handle(func(p httprequest.Params, _ *struct {
+				httprequest.Route `httprequest:"GET /things/:id"`
+			}) (string, error) {
+				return httprequest.ExternalURL(p.Request).String(), nil
+			})
+			req := httptest.NewRequest("GET", "/things/x?a=b", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.header != nil {
+				req.Header = test.header
+			}
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			qthttptest.AssertJSONResponse(c, rec, http.StatusOK, test.expectURL)
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"mime"
 	"net/http"
 	"strings"
+	"sync"
 	"unicode"
 
 	"golang.org/x/net/html"
@@ -100,6 +101,12 @@ type fancyDecodeError struct {
 	// body holds up to maxErrorBodySize saved bytes of the
 	// request or response body.
 	body []byte
+
+	// once guards msg, which holds the error message. It is
+	// derived from body when first needed, as extracting text
+	// from a large HTML page can be expensive.
+	once sync.Once
+	msg  string
 }
 
 func newFancyDecodeError(h http.Header, body io.Reader) *fancyDecodeError {
@@ -152,6 +159,14 @@ func isJSONMediaType(header http.Header) bool {
 // Error implements error.Error by trying to produce a decent
 // error message derived from the body content.
 func (e *fancyDecodeError) Error() string {
+	e.once.Do(func() {
+		e.msg = e.message()
+	})
+	return e.msg
+}
+
+// message returns the message returned by Error.
+func (e *fancyDecodeError) message() string {
 	mediaType, _, err := mime.ParseMediaType(e.contentType)
 	if err != nil {
 		// Even if there's no media type, we want to see something useful.
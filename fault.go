@@ -0,0 +1,204 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrInjectedFault is the cause of errors returned by the client when
+// a FaultInjector drops a request.
+var ErrInjectedFault = errgo.New("injected fault")
+
+// Faults describes the faults that a FaultInjector injects into
+// requests to a route. Each probability is between 0 (never) and 1
+// (always) and is applied independently to each request.
+type Faults struct {
+	// LatencyProbability holds the probability that Latency is
+	// added before the request is handled.
+	LatencyProbability float64
+
+	// Latency holds the latency to add.
+	Latency time.Duration
+
+	// DropProbability holds the probability that the connection
+	// is dropped without a response. On the client, the request
+	// fails with an error with an ErrInjectedFault cause without
+	// being sent.
+	DropProbability float64
+
+	// ErrorProbability holds the probability that the request
+	// fails with a 500 Internal Server Error response instead of
+	// being handled.
+	ErrorProbability float64
+}
+
+// FaultInjector injects latency, dropped connections and errors into
+// requests so that the resilience of services and their clients can
+// be tested without an external proxy. It can be used by both
+// Server and Client (see their FaultInjector fields) and the faults
+// can be changed while it is in use.
+//
+// A FaultInjector injects no faults until SetFaults is called.
+type FaultInjector struct {
+	// Rand returns a random number in [0, 1) that is used to
+	// choose which requests have faults injected. If it is nil,
+	// math/rand.Float64 is used.
+	Rand func() float64
+
+	// Sleep is used to add latency. If it is nil, the request
+	// waits for the latency or until its context is done.
+	Sleep func(ctx context.Context, d time.Duration)
+
+	mu     sync.RWMutex
+	faults map[string]Faults
+}
+
+// SetFaults sets the faults injected into requests to the route with
+// the given method and path pattern (as found in Handler). If both
+// method and pathPattern are empty, the faults apply to all routes
+// that have no faults of their own. Setting the zero Faults removes
+// any faults for the route.
+func (f *FaultInjector) SetFaults(method, pathPattern string, faults Faults) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := faultKey(method, pathPattern)
+	if faults == (Faults{}) {
+		delete(f.faults, key)
+		return
+	}
+	if f.faults == nil {
+		f.faults = make(map[string]Faults)
+	}
+	f.faults[key] = faults
+}
+
+// Faults returns the faults currently injected into requests
+// to the given route.
+func (f *FaultInjector) Faults(method, pathPattern string) Faults {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if faults, ok := f.faults[faultKey(method, pathPattern)]; ok {
+		return faults
+	}
+	return f.faults[""]
+}
+
+// Reset removes all faults.
+func (f *FaultInjector) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faults = nil
+}
+
+func faultKey(method, pathPattern string) string {
+	if method == "" && pathPattern == "" {
+		return ""
+	}
+	return method + " " + pathPattern
+}
+
+// fault holds the faults chosen for a single request.
+type fault struct {
+	latency time.Duration
+	drop    bool
+	error   bool
+}
+
+// choose chooses the faults to inject into a request to the route
+// with the given key.
+func (f *FaultInjector) choose(key string) fault {
+	f.mu.RLock()
+	faults, ok := f.faults[key]
+	if !ok {
+		faults = f.faults[""]
+	}
+	f.mu.RUnlock()
+	var ft fault
+	if sampled(faults.LatencyProbability, f.Rand) {
+		ft.latency = faults.Latency
+	}
+	ft.drop = sampled(faults.DropProbability, f.Rand)
+	ft.error = !ft.drop && sampled(faults.ErrorProbability, f.Rand)
+	return ft
+}
+
+// sleep waits for d or until ctx is done.
+func (f *FaultInjector) sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	if f.Sleep != nil {
+		f.Sleep(ctx, d)
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// wrap returns a handler that injects faults into requests to h as
+// configured by f.
+func (f *FaultInjector) wrap(srv *Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	key := faultKey(method, pathPattern)
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ft := f.choose(key)
+		f.sleep(req.Context(), ft.latency)
+		switch {
+		case ft.drop:
+			// The server recovers this panic and closes
+			// the connection without responding.
+			panic(http.ErrAbortHandler)
+		case ft.error:
+			srv.WriteError(req.Context(), w, ErrInjectedFault)
+		default:
+			h(w, req, p)
+		}
+	}
+}
+
+// injectFault injects any faults chosen by c.FaultInjector into req.
+// If it returns a non-nil response or error, the request should not
+// be sent.
+func (c *Client) injectFault(ctx context.Context, req *http.Request) (*http.Response, error) {
+	ft := c.FaultInjector.choose(requestEndpoint(ctx, req))
+	c.FaultInjector.sleep(ctx, ft.latency)
+	switch {
+	case ft.drop:
+		return nil, urlError(errgo.WithCausef(nil, ErrInjectedFault, "connection dropped"), req)
+	case ft.error:
+		data, err := json.Marshal(&RemoteError{
+			Message: ErrInjectedFault.Error(),
+		})
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		return &http.Response{
+			Status:     "500 Internal Server Error",
+			StatusCode: http.StatusInternalServerError,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header: http.Header{
+				"Content-Type": {"application/json"},
+			},
+			Body:          ioutil.NopCloser(bytes.NewReader(data)),
+			ContentLength: int64(len(data)),
+			Request:       req,
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type faultReq struct {
+	httprequest.Route `httprequest:"GET /fault"`
+}
+
+type otherFaultReq struct {
+	httprequest.Route `httprequest:"GET /other"`
+}
+
+func newFaultServer(f *httprequest.FaultInjector) *httptest.Server {
+	srv := &httprequest.Server{
+		FaultInjector: f,
+	}
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *faultReq) (string, error) {
+			return "ok", nil
+		}),
+		srv.Handle(func(p httprequest.Params, req *otherFaultReq) (string, error) {
+			return "other", nil
+		}),
+	})
+}
+
+func TestServerFaultInjector(t *testing.T) {
+	c := qt.New(t)
+
+	var slept []time.Duration
+	f := &httprequest.FaultInjector{
+		Rand: func() float64 { return 0.5 },
+		Sleep: func(ctx context.Context, d time.Duration) {
+			slept = append(slept, d)
+		},
+	}
+	hsrv := newFaultServer(f)
+	defer hsrv.Close()
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	ctx := context.Background()
+
+	// No faults by default.
+	var s string
+	err := client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "ok")
+
+	// A fault with a probability below the random value
+	// is not injected.
+	f.SetFaults("GET", "/fault", httprequest.Faults{
+		ErrorProbability: 0.25,
+	})
+	err = client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.IsNil)
+
+	f.SetFaults("GET", "/fault", httprequest.Faults{
+		LatencyProbability: 1,
+		Latency:            time.Second,
+		ErrorProbability:   0.75,
+	})
+	err = client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/fault: injected fault`)
+	c.Assert(errgo.Cause(err).(*httprequest.RemoteError).Message, qt.Equals, "injected fault")
+	c.Assert(slept, qt.DeepEquals, []time.Duration{time.Second})
+
+	// Other routes are not affected.
+	err = client.Call(ctx, &otherFaultReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "other")
+
+	// Default faults apply to all routes without their own.
+	f.SetFaults("", "", httprequest.Faults{
+		DropProbability: 1,
+	})
+	err = client.Call(ctx, &otherFaultReq{}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/other"?: .*EOF`)
+
+	f.Reset()
+	err = client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(f.Faults("GET", "/fault"), qt.Equals, httprequest.Faults{})
+}
+
+func TestClientFaultInjector(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv := newFaultServer(nil)
+	defer hsrv.Close()
+	f := &httprequest.FaultInjector{}
+	client := &httprequest.Client{
+		BaseURL:       hsrv.URL,
+		FaultInjector: f,
+	}
+	ctx := context.Background()
+
+	f.SetFaults("GET", "/fault", httprequest.Faults{
+		DropProbability: 1,
+	})
+	var s string
+	err := client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/fault: connection dropped`)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrInjectedFault)
+
+	f.SetFaults("GET", "/fault", httprequest.Faults{
+		ErrorProbability: 1,
+	})
+	err = client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/fault: injected fault`)
+
+	// Latency is cut short when the context is done.
+	f.SetFaults("GET", "/fault", httprequest.Faults{
+		LatencyProbability: 1,
+		Latency:            time.Hour,
+	})
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err = client.Call(ctx, &faultReq{}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/fault"?: context deadline exceeded`)
+
+	f.SetFaults("GET", "/fault", httprequest.Faults{})
+	err = client.Call(context.Background(), &faultReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "ok")
+}
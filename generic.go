@@ -0,0 +1,30 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"reflect"
+)
+
+// Handle returns a handler that serves the given route by calling fn,
+// so that handlers can be registered programmatically as plain
+// functions rather than being found by Server.Handlers or checked by
+// reflection when Server.Handle is called.
+//
+// The route is specified in the same form as the httprequest tag of a
+// Route field, for example "GET /things/:id". If it is empty, the
+// route is taken from the Route field of Req, which must then have
+// one; otherwise Req must not have a route of its own. Req is
+// unmarshaled from the request as for Server.Handle and a non-nil
+// result is written as JSON.
+//
+// Handle panics if Req cannot be used with Unmarshal or the route
+// is not valid.
+func Handle[Req, Resp any](srv *Server, route string, fn func(ctx context.Context, req *Req) (*Resp, error)) Handler {
+	f := func(p Params, req *Req) (*Resp, error) {
+		return fn(p.Context, req)
+	}
+	return srv.endpoint(reflect.ValueOf(f), route).handler()
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type genericThingReq struct {
+	ID    string `httprequest:"id,path"`
+	Limit int    `httprequest:"limit,form,omitempty"`
+}
+
+type genericThing struct {
+	ID    string
+	Limit int
+}
+
+type genericRoutedReq struct {
+	httprequest.Route `httprequest:"GET /routed/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func TestGenericHandle(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	router := newHandlerRouter([]httprequest.Handler{
+		httprequest.Handle(srv, "GET /things/:id", func(ctx context.Context, req *genericThingReq) (*genericThing, error) {
+			if req.ID == "missing" {
+				return nil, httprequest.Errorf(httprequest.CodeNotFound, "no thing %q", req.ID)
+			}
+			return &genericThing{
+				ID:    req.ID,
+				Limit: req.Limit,
+			}, nil
+		}),
+		httprequest.Handle(srv, "", func(ctx context.Context, req *genericRoutedReq) (*genericThing, error) {
+			return &genericThing{ID: req.ID}, nil
+		}),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/a?limit=3", nil))
+	c.Assert(rec.Code, qt.Equals, 200)
+	c.Assert(rec.Body.String(), qt.Equals, `{"ID":"a","Limit":3}`)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/missing", nil))
+	c.Assert(rec.Code, qt.Equals, 404)
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/routed/b", nil))
+	c.Assert(rec.Body.String(), qt.Equals, `{"ID":"b","Limit":0}`)
+}
+
+func TestGenericHandleBadRoute(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	c.Assert(func() {
+		httprequest.Handle(srv, "GET /other/:id", func(ctx context.Context, req *genericRoutedReq) (*genericThing, error) {
+			return nil, nil
+		})
+	}, qt.PanicMatches, `bad handler function: route "GET /other/:id" specified for argument that has its own route`)
+	c.Assert(func() {
+		httprequest.Handle(srv, "FETCH /things", func(ctx context.Context, req *genericThingReq) (*genericThing, error) {
+			return nil, nil
+		})
+	}, qt.PanicMatches, `bad route "FETCH /things": invalid method`)
+}
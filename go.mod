@@ -1,6 +1,7 @@
 module gopkg.in/httprequest.v1
 
-go 1.15
+// Go 1.18 is required for the generic Handle function (see generic.go).
+go 1.18
 
 require (
 	github.com/frankban/quicktest v1.10.0
@@ -8,6 +9,17 @@ require (
 	github.com/juju/qthttptest v0.1.1
 	github.com/julienschmidt/httprouter v1.3.0
 	golang.org/x/net v0.0.0-20200505041828-1ed23360d12c
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	golang.org/x/tools v0.0.0-20200505023115-26f46d2f7ef8
 	gopkg.in/errgo.v1 v1.0.0
 )
+
+require (
+	github.com/golang/protobuf v1.2.0 // indirect
+	github.com/kr/pretty v0.2.0 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	google.golang.org/appengine v1.4.0 // indirect
+	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22 // indirect
+	gopkg.in/yaml.v2 v2.2.7 // indirect
+)
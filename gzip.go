@@ -0,0 +1,243 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// GzipCompressor compresses the responses of handlers with gzip when
+// the client accepts it (see the Accept-Encoding header). Responses
+// that already have a Content-Encoding header, and responses without
+// a body, are sent as they are.
+//
+// Compressed data is usually held back by the compressor until it has
+// enough to compress well, which would delay the events of a streamed
+// response (see JSONArrayStream and NDJSONStream) indefinitely. So
+// when a handler flushes its response (see http.Flusher), the
+// compressor is flushed too, as controlled by FlushEvery.
+//
+// A GzipCompressor implements Middleware, so it can be enabled for the
+// handlers created by a Server by adding it to Server.Middleware. The
+// zero value is ready to use.
+type GzipCompressor struct {
+	// Level holds the compression level to use (see
+	// compress/gzip). If it is zero, gzip.DefaultCompression is
+	// used.
+	Level int
+
+	// FlushEvery holds how many times a handler must flush its
+	// response before the compressed data is flushed to the
+	// client. If it is zero, the compressor is flushed every time,
+	// so that each event of a streamed response is sent as soon as
+	// it is written. Larger values trade latency for better
+	// compression. If it is negative, flushing a response sends
+	// only the data that the compressor has already produced.
+	FlushEvery int
+
+	pool sync.Pool
+}
+
+var _ Middleware = (*GzipCompressor)(nil)
+
+// Wrap implements Middleware.Wrap.
+func (g *GzipCompressor) Wrap(srv *Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+			h(w, req, p)
+			return
+		}
+		w1 := &gzipResponseWriter{
+			ResponseWriter: w,
+			compressor:     g,
+		}
+		h(w1, req, p)
+		// Note that if h panics, the response is left
+		// incomplete, so that the client does not see a
+		// truncated body as complete.
+		w1.close()
+	}
+}
+
+// writer returns a gzip writer that writes to w.
+func (g *GzipCompressor) writer(w http.ResponseWriter) (*gzip.Writer, error) {
+	if gw, ok := g.pool.Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return gw, nil
+	}
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// acceptsGzip reports whether the given Accept-Encoding header value
+// allows a gzip-encoded response.
+func acceptsGzip(accept string) bool {
+	gzipQ, anyQ := -1.0, -1.0
+	for _, s := range strings.Split(accept, ",") {
+		coding, params := s, ""
+		if i := strings.Index(s, ";"); i >= 0 {
+			coding, params = s[:i], s[i+1:]
+		}
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(params[len("q="):], 64)
+			if err != nil {
+				continue
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return anyQ > 0
+}
+
+// gzipResponseWriter compresses the body written to it. The decision
+// whether to compress is made when the body is first written or
+// flushed, so that the handler can set headers that affect it after
+// calling WriteHeader.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	compressor *GzipCompressor
+
+	// code holds the status code passed to WriteHeader, or zero if
+	// it has not been called.
+	code int
+
+	// started holds whether the header has been written to the
+	// underlying ResponseWriter.
+	started bool
+
+	// gw holds the writer used to compress the body, or nil if
+	// the body is not being compressed.
+	gw *gzip.Writer
+
+	// flushes counts the calls to Flush.
+	flushes int
+
+	// err holds any error from creating the gzip writer.
+	err error
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.started || w.code != 0 {
+		return
+	}
+	if isInformational(code) {
+		// Informational responses are sent immediately and
+		// do not affect the final response.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.code = code
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if !w.started {
+		if w.Header().Get("Content-Type") == "" {
+			// Sniff the content type of the uncompressed data,
+			// as the ResponseWriter would.
+			w.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		w.start(true)
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.gw == nil {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.gw.Write(data)
+}
+
+// Flush implements http.Flusher by flushing the compressor as
+// specified by GzipCompressor.FlushEvery and then flushing the
+// underlying ResponseWriter if it supports it.
+func (w *gzipResponseWriter) Flush() {
+	if !w.started {
+		w.start(true)
+	}
+	if w.gw != nil {
+		w.flushes++
+		n := w.compressor.FlushEvery
+		if n == 0 {
+			n = 1
+		}
+		if n > 0 && w.flushes%n == 0 {
+			w.gw.Flush()
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// start writes the header to the underlying ResponseWriter,
+// compressing the body if possible. The hasBody argument holds
+// whether the body is to be written.
+func (w *gzipResponseWriter) start(hasBody bool) {
+	w.started = true
+	code := w.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	h := w.Header()
+	if hasBody && bodyAllowedForStatus(code) && h.Get("Content-Encoding") == "" {
+		w.gw, w.err = w.compressor.writer(w.ResponseWriter)
+		if w.err == nil {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// close finishes the response, returning the gzip writer to the pool.
+func (w *gzipResponseWriter) close() {
+	if !w.started {
+		if w.code == 0 {
+			// Nothing has been written, so leave the
+			// ResponseWriter to send its default response.
+			return
+		}
+		w.start(false)
+	}
+	if w.gw == nil {
+		return
+	}
+	if err := w.gw.Close(); err == nil {
+		w.compressor.pool.Put(w.gw)
+	}
+	w.gw = nil
+}
+
+// bodyAllowedForStatus reports whether a response with the given
+// status may have a body.
+func bodyAllowedForStatus(code int) bool {
+	switch {
+	case code >= 100 && code < 200:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type gzipReq struct {
+	httprequest.Route `httprequest:"GET /gzip/:kind"`
+	Kind              string `httprequest:"kind,path"`
+}
+
+func newGzipServer(g *httprequest.GzipCompressor, events chan int) *httptest.Server {
+	srv := &httprequest.Server{
+		Middleware: []httprequest.Middleware{g},
+	}
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *gzipReq) (interface{}, error) {
+			switch req.Kind {
+			case "events":
+				return httprequest.StreamNDJSONChannel(events), nil
+			case "empty":
+				return &httprequest.StatusResponse{
+					Code: http.StatusNoContent,
+				}, nil
+			case "encoded":
+				p.Response.Header().Set("Content-Encoding", "identity")
+			case "bad":
+				return nil, httprequest.Errorf(httprequest.CodeBadRequest, "%s", strings.Repeat("bad ", 10))
+			}
+			return strings.Repeat("x", 100), nil
+		}),
+	})
+}
+
+var gzipAcceptTests = []struct {
+	acceptEncoding string
+	expectGzip     bool
+}{{
+	acceptEncoding: "",
+	expectGzip:     false,
+}, {
+	acceptEncoding: "gzip",
+	expectGzip:     true,
+}, {
+	acceptEncoding: "deflate, GZIP;q=0.5",
+	expectGzip:     true,
+}, {
+	acceptEncoding: "gzip;q=0",
+	expectGzip:     false,
+}, {
+	acceptEncoding: "*",
+	expectGzip:     true,
+}, {
+	acceptEncoding: "*, gzip;q=0",
+	expectGzip:     false,
+}, {
+	acceptEncoding: "br",
+	expectGzip:     false,
+}}
+
+func TestGzipCompressor(t *testing.T) {
+	c := qt.New(t)
+	srv := newGzipServer(&httprequest.GzipCompressor{}, nil)
+	defer srv.Close()
+
+	for _, test := range gzipAcceptTests {
+		c.Run(test.acceptEncoding, func(c *qt.C) {
+			resp := gzipGet(c, srv.URL+"/gzip/plain", test.acceptEncoding)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+			c.Assert(resp.Header.Get("Vary"), qt.Equals, "Accept-Encoding")
+			c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+			body := resp.Body
+			if test.expectGzip {
+				c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+				var err error
+				body, err = gzip.NewReader(resp.Body)
+				c.Assert(err, qt.IsNil)
+			} else {
+				c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "")
+			}
+			data, err := ioutil.ReadAll(body)
+			c.Assert(err, qt.IsNil)
+			c.Assert(string(data), qt.Equals, `"`+strings.Repeat("x", 100)+`"`)
+		})
+	}
+}
+
+func TestGzipCompressorErrorResponse(t *testing.T) {
+	c := qt.New(t)
+	srv := newGzipServer(&httprequest.GzipCompressor{}, nil)
+	defer srv.Close()
+
+	resp := gzipGet(c, srv.URL+"/gzip/bad", "gzip")
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusBadRequest)
+	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+	r, err := gzip.NewReader(resp.Body)
+	c.Assert(err, qt.IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Matches, `\{"Message":"(bad )+","Code":"bad request"\}`)
+}
+
+func TestGzipCompressorUncompressed(t *testing.T) {
+	c := qt.New(t)
+	srv := newGzipServer(&httprequest.GzipCompressor{}, nil)
+	defer srv.Close()
+
+	// A response without a body is sent as it is.
+	resp := gzipGet(c, srv.URL+"/gzip/empty", "gzip")
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNoContent)
+	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "")
+
+	// So is a response that the handler has already encoded.
+	resp = gzipGet(c, srv.URL+"/gzip/encoded", "gzip")
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "identity")
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, `"`+strings.Repeat("x", 100)+`"`)
+}
+
+func TestGzipCompressorFlushesEvents(t *testing.T) {
+	c := qt.New(t)
+	events := make(chan int)
+	srv := newGzipServer(&httprequest.GzipCompressor{}, events)
+	defer srv.Close()
+
+	// The response header is sent with the first event.
+	go func() {
+		events <- 1
+	}()
+	resp := gzipGet(c, srv.URL+"/gzip/events", "gzip")
+	defer resp.Body.Close()
+	c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/x-ndjson")
+
+	// Each event can be read as soon as it has been sent, although
+	// the stream is still open.
+	r, err := gzip.NewReader(resp.Body)
+	c.Assert(err, qt.IsNil)
+	br := bufio.NewReader(r)
+	for i := 1; i <= 3; i++ {
+		if i > 1 {
+			events <- i
+		}
+		line, err := br.ReadString('\n')
+		c.Assert(err, qt.IsNil)
+		c.Assert(line, qt.Equals, strconv.Itoa(i)+"\n")
+	}
+	close(events)
+	rest, err := ioutil.ReadAll(br)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(rest), qt.Equals, "")
+}
+
+func gzipGet(c *qt.C, url, acceptEncoding string) *http.Response {
+	req, err := http.NewRequest("GET", url, nil)
+	c.Assert(err, qt.IsNil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			// Stop the transport asking for and decompressing
+			// gzip responses itself.
+			DisableCompression: true,
+		},
+	}
+	resp, err := client.Do(req)
+	c.Assert(err, qt.IsNil)
+	return resp
+}
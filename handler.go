@@ -8,11 +8,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
 
 	"github.com/julienschmidt/httprouter"
 	errgo "gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/tags"
 )
 
 // Server represents the server side of an HTTP servers, and can be
@@ -34,8 +39,251 @@ type Server struct {
 	// field is set, ErrorMapper will be ignored and any returned
 	// errors will be passed to ErrorWriter, which should use
 	// w to set the HTTP status and write an appropriate
-	// error response.
+	// error response. ProblemErrorWriter can be used to write
+	// errors in the RFC 7807 problem details format.
 	ErrorWriter func(ctx context.Context, w http.ResponseWriter, err error)
+
+	// HARSampler, if non-nil, is used to record a sample of the
+	// requests made to handlers created by the server.
+	HARSampler *HARSampler
+
+	// FaultInjector, if non-nil, is used to inject faults into
+	// requests to handlers created by the server, for testing
+	// the resilience of its clients.
+	FaultInjector *FaultInjector
+
+	// ReplayGuard, if non-nil, is used to reject replayed requests
+	// before they reach any handler created by the server.
+	ReplayGuard *ReplayGuard
+
+	// IdempotencyGuard, if non-nil, is used to send the recorded
+	// response to requests that repeat the idempotency key of an
+	// earlier request rather than handling them again.
+	IdempotencyGuard *IdempotencyGuard
+
+	// CSRFGuard, if non-nil, is used to protect the handlers
+	// created by the server against cross-site request forgery.
+	CSRFGuard *CSRFGuard
+
+	// NoContent specifies that handlers that have no result value
+	// and do not write a response themselves will respond with a
+	// 204 No Content status when they succeed, rather than 200 OK
+	// with an empty body.
+	NoContent bool
+
+	// TrustedProxies holds the networks of the proxies that are
+	// trusted to report the address of the client making a request.
+	// When a request arrives from a trusted proxy, the client
+	// address is taken from the header named by ForwardedHeader
+	// rather than from the connection, and the external scheme, host
+	// and path prefix reported by the proxy are used by ExternalURL.
+	//
+	// The client address is made available to handlers
+	// with ClientIPFromContext and with the "clientip" tag.
+	TrustedProxies []*net.IPNet
+
+	// ForwardedHeader holds the name of the header that
+	// TrustedProxies add the client address to: either
+	// "X-Forwarded-For" or "Forwarded" (see RFC 7239). Only that
+	// header is read, because a proxy usually passes the other
+	// through from the client unchanged. If it is empty,
+	// "X-Forwarded-For" is used.
+	ForwardedHeader string
+
+	// InternalHeaderPrefix, if non-empty, holds the prefix of the
+	// names of headers that are added by a gateway in front of the
+	// server, for example "X-Internal-" for headers holding the
+	// identity of an authenticated user. Such headers are removed
+	// from requests that do not come directly from one of
+	// TrustedProxies, so that they cannot be spoofed by clients.
+	// The headers of other requests are made available with
+	// InternalHeadersFromContext. The prefix is matched without
+	// regard to case.
+	InternalHeaderPrefix string
+
+	// IPFilter, if non-nil, is used to reject requests based
+	// on the client address.
+	IPFilter *IPFilter
+
+	// RateLimiter, if non-nil, is used to limit the rate of
+	// requests to the handlers created by the server.
+	RateLimiter RateLimiter
+
+	// Prioritize, if non-nil, is called before each request is
+	// handled with the priority sent by the client in the Priority
+	// header (see RFC 9218), or DefaultPriority if it sent none or
+	// the header is invalid. It can be used, for example, to
+	// schedule or shed requests to shared back ends consistently.
+	// The context it returns is used for the rest of the request;
+	// if it returns an error, the error is written as the response.
+	//
+	// The context passed to Prioritize already holds the priority
+	// (see PriorityFromContext), so calls made by the handler with
+	// a Client send the same priority.
+	Prioritize func(ctx context.Context, method, pathPattern string, pri Priority) (context.Context, error)
+
+	// RateLimitKey is used to find the key that identifies the
+	// caller of a request to RateLimiter, for example the name of
+	// the authenticated user. If it is nil, the client IP address
+	// is used (see TrustedProxies).
+	RateLimitKey func(req *http.Request) string
+
+	// Logger, if non-nil, is used to log every request made
+	// to the handlers created by the server.
+	Logger Logger
+
+	// RecoverPanics specifies that a panic in a handler created
+	// by the server is recovered and written as an error response
+	// with a *PanicError, so that it goes through ErrorMapper and
+	// is seen by the server's middleware as an ordinary response.
+	// If the handler has already started writing the response,
+	// the response is aborted instead.
+	RecoverPanics bool
+
+	// OnPanic, if non-nil, is called with the request and the
+	// error, including the stack trace, when a panic is
+	// recovered because RecoverPanics is set.
+	OnPanic func(req *http.Request, err *PanicError)
+
+	// APIKeyStore is used to check the API keys held in fields with
+	// the "apikey" attribute (see Unmarshal). It must be set if any
+	// handler argument has such a field.
+	APIKeyStore APIKeyStore
+
+	// Scopes is used to find the scopes granted to a request, for
+	// example from the claims of its bearer token. It must be set if
+	// any route has a scope tag (see Handle). An error returned
+	// by Scopes is written as the response.
+	Scopes func(ctx context.Context, req *http.Request) ([]string, error)
+
+	// CORS, if non-nil, holds the cross-origin resource sharing
+	// configuration used by the handlers created by the server.
+	CORS *CORS
+
+	// RequestID specifies that each request is assigned an ID,
+	// taken from the X-Request-ID header of the request when
+	// present or generated otherwise. The ID is sent in the
+	// X-Request-ID header of the response, is available to
+	// handlers with RequestIDFromContext and is included in
+	// error responses written by DefaultErrorMapper.
+	RequestID bool
+
+	// TraceContext specifies that the W3C trace context of each
+	// request is determined from its traceparent and tracestate
+	// headers. Each request is given a new span ID, in a new trace
+	// when the request has no valid traceparent header. The trace
+	// context is available to handlers with
+	// TraceContextFromContext, is included in the entries passed
+	// to Logger and can be forwarded by a Client (see
+	// Client.ForwardTraceContext).
+	TraceContext bool
+
+	// ResponseBufferSize controls how handler results are written.
+	// If it is zero, a result is marshaled in full before the
+	// response status is written, so a marshaling error always
+	// produces a clean error response, however large the result.
+	//
+	// If it is positive, a result that is a slice or array is
+	// marshaled an element at a time, and the response status and
+	// body are sent as soon as more than ResponseBufferSize bytes
+	// have been marshaled. A marshaling error before that point
+	// still produces a clean error response, but an error after it
+	// aborts the response (see http.ErrAbortHandler) so that the
+	// client sees an incomplete response rather than a truncated
+	// one with a success status.
+	ResponseBufferSize int
+
+	// ResponseDigests, if non-empty, specifies that the results of
+	// handlers are buffered in full, so that the response can be
+	// sent with an accurate Content-Length header and a Digest
+	// header (see RFC 3230) holding a checksum of the body computed
+	// with each of the given algorithms. If DigestMD5 is included,
+	// a Content-MD5 header is also sent. Responses whose status
+	// does not allow a body, such as 204 No Content, are sent
+	// without these headers. ResponseBufferSize is ignored when
+	// ResponseDigests is set. Creating a handler panics if any
+	// of the algorithms is not supported.
+	ResponseDigests []DigestAlgorithm
+
+	// TimeFormat, if non-nil, specifies how time.Time values are
+	// serialized in the JSON responses written by handlers created
+	// by the server.
+	TimeFormat *TimeFormat
+
+	// JSON, if non-nil, is used in place of encoding/json to
+	// unmarshal JSON request bodies and to marshal the JSON
+	// responses written by handlers created by the server,
+	// including error responses written by WriteError. See
+	// NewJSONCodec.
+	JSON Codec
+
+	// BufferPool, if non-nil, holds a pool of buffers used to
+	// marshal the JSON responses written by handlers created by
+	// the server, including error responses written by
+	// WriteError, instead of allocating a new buffer for each
+	// response.
+	BufferPool *BufferPool
+
+	// DuplicateParams specifies how a form parameter that is given
+	// more than once is unmarshaled into a field that holds a
+	// single value. It can be overridden for a field with the
+	// "first", "last" or "unique" attribute (see Unmarshal).
+	DuplicateParams DuplicateParamPolicy
+
+	// Middleware holds values that wrap each handler created by
+	// the server, the first being outermost. They are called after
+	// the server's own request checks, such as rate limiting and
+	// CSRF protection, but before any API key or scope checks, so
+	// that, for example, Scopes can use values they add to the
+	// request context.
+	Middleware []Middleware
+
+	// Codecs holds the encodings that handlers created by the
+	// server may use for their results. If it is non-empty, each
+	// result is encoded with the codec that best matches the Accept
+	// header of the request, or with the first codec if the header
+	// is absent or matches none of them. If it is empty, results
+	// are always encoded as JSON.
+	//
+	// TimeFormat and ResponseBufferSize apply only when JSONCodec
+	// is chosen. Errors are always written as JSON.
+	Codecs []Codec
+
+	// BuiltinRouter specifies that HTTPHandler uses the router
+	// built into this package (see Router) rather than
+	// httprouter.Router.
+	BuiltinRouter bool
+
+	// MediaType holds the vendor media type of the API served by
+	// the server, for example "application/vnd.myapp". It is used
+	// by Versioned to negotiate between versions of a route.
+	MediaType string
+
+	// OptionsCapabilities specifies that the OPTIONS handlers
+	// added by DeriveHandlers respond with a 200 status and a JSON
+	// Capabilities body describing the methods available on the
+	// resource, including the content types they accept and
+	// produce, rather than with an empty 204 response. CORS
+	// preflight requests are answered as before.
+	OptionsCapabilities bool
+
+	// SchemaURL, if non-nil, is used to find the URL of a schema
+	// describing the resource with the given path pattern. The URL
+	// is included in capabilities responses (see
+	// OptionsCapabilities) and sent in a Link header with the
+	// "describedby" relation by the OPTIONS handlers added by
+	// DeriveHandlers. If it returns the empty string, no schema is
+	// reported.
+	SchemaURL func(pathPattern string) string
+
+	// shutdown holds the state used by Shutdown. It is
+	// created when first needed; see Server.shutdownState.
+	shutdown *shutdownState
+
+	// routes holds the capabilities of the routes of the
+	// handlers created by the server. It is created when
+	// first needed; see Server.routeState.
+	routes *routeState
 }
 
 // Handler defines a HTTP handler that will handle the
@@ -44,6 +292,11 @@ type Handler struct {
 	Method string
 	Path   string
 	Handle httprouter.Handle
+
+	// Version holds the API version of the handler as specified
+	// by the version tag of its Route field, if any. See
+	// Server.Versioned.
+	Version string
 }
 
 // handlerFunc represents a function that can handle an HTTP request.
@@ -63,6 +316,34 @@ type handlerFunc struct {
 	// pathPattern holds the path pattern the function will
 	// be registered for.
 	pathPattern string
+
+	// scopes holds the scopes required by the route.
+	scopes []string
+
+	// optionalParam holds the name of the final path
+	// parameter if it is optional, or the empty string.
+	optionalParam string
+
+	// apiKey holds the API key field of the
+	// argument, if any.
+	apiKey *apiKeyField
+
+	// lifecycle holds the lifecycle of the route, if any.
+	lifecycle *Lifecycle
+
+	// csrfExempt holds whether the route is exempt
+	// from CSRF checks.
+	csrfExempt bool
+
+	// version holds the API version of the route, if any.
+	version string
+
+	// argType holds the type of the argument struct.
+	argType reflect.Type
+
+	// resultType holds the type of the result, or nil
+	// if there is none.
+	resultType reflect.Type
 }
 
 var (
@@ -76,7 +357,7 @@ var (
 )
 
 // AddHandlers adds all the handlers in the given slice to r.
-func AddHandlers(r *httprouter.Router, hs []Handler) {
+func AddHandlers(r Registrar, hs []Handler) {
 	for _, h := range hs {
 		r.Handle(h.Method, h.Path, h.Handle)
 	}
@@ -108,43 +389,137 @@ func AddHandlers(r *httprouter.Router, hs []Handler) {
 // to use for the request. If this is given, the returned handler will
 // hold that method and path, otherwise they will be empty.
 //
+// A path parameter in the route may be followed by a constraint in
+// parentheses, for example "/users/:id(int)/posts". The constraint is
+// not part of the path of the returned handler; instead, a request
+// whose parameter value does not satisfy the constraint fails to
+// unmarshal, with an error that has an ErrUnmarshal cause. The available
+// constraints are:
+//
+//	int - a decimal integer
+//	uint - an unsigned decimal integer
+//	uuid - a UUID in its canonical textual form
+//	alpha - one or more ASCII letters
+//	alnum - one or more ASCII letters or digits
+//
+// The final parameter in the path may be marked as optional with a
+// trailing "?", for example "/things/:id?", so that one method can
+// serve both a collection and its items. Handlers and Endpoints then
+// return two handlers for the method, one for the path with the
+// parameter and one for the path without it ("/things"); in the
+// latter case, the field for the parameter is left as its zero value.
+// Marshal omits the parameter from the path when its value is empty.
+// Handle and Endpoint panic if the route has an optional parameter.
+//
+// A "scope" tag on the Route field holds a space- or comma-separated
+// list of scopes that are all required to call the route, for example
+// `scope:"things:read things:write"`. The scopes granted to a request
+// are found with Server.Scopes, and a request without all the
+// required scopes fails with a CodeForbidden error.
+//
+// A "csrf" tag on the Route field with the value "exempt" exempts the
+// route from the checks made by Server.CSRFGuard, for example for a
+// webhook that is called by another service rather than a browser.
+//
 // If an error is returned from f, it is passed through the error mapper
 // before writing as a JSON response.
 //
 // In the third form, when no error is returned, the result is written
-// as a JSON response with status http.StatusOK. Also in this case, any
+// as a JSON response with status http.StatusOK, unless the route
+// specifies otherwise (see below). Also in this case, any
 // calls to Params.Response.Write or Params.Response.WriteHeader will be
 // ignored, as the response code and data should be defined entirely by
-// the returned result and error.
+// the returned result and error. A result of type *CustomResponse
+// writes its own response body instead of being marshaled as JSON,
+// and a result of type *Created is written with a 201 Created status
+// and a Location header. A result of type *StatusResponse is written
+// with the status it specifies.
+//
+// A "status" tag on the Route field specifies the status of successful
+// responses, which must be between 200 and 299, for example
+// `status:"202"`. The default is 200 OK or, for a handler with no
+// result value, 204 No Content when Server.NoContent is set. A
+// handler with no result value that does not write a response itself
+// responds with the given status. When the status is 204 No Content, any result
+// value is used only for its header fields.
+//
+// The "deprecated", "sunset" and "deprecationlink" tags on the Route
+// field mark the route as deprecated; see Lifecycle. A "version" tag
+// gives the API version of the route; see VersionMediaType.
+//
+// If ResultT is a struct or pointer to struct, any of its fields with
+// the "header" or "cookie" attribute (see Unmarshal) are written as
+// headers or cookies of the response. Such fields will usually also be tagged `json:"-"` so
+// that they are not included in the body too.
 //
 // Handle will panic if the provided function is not in one of the above
 // forms.
 func (srv *Server) Handle(f interface{}) Handler {
-	fv := reflect.ValueOf(f)
+	return srv.Endpoint(f).handler()
+}
+
+// Register is like Handle except that the route is given by the
+// method and path arguments rather than by a Route field in ArgT,
+// which must not have one. It can be used to build routes
+// dynamically, for example to add endpoints provided by plugins or
+// enabled by feature flags. Requests are unmarshaled and results and
+// errors written exactly as for Handle.
+//
+// The path may hold path parameters in httprouter syntax, for
+// example "/things/:id", but not path constraints or optional
+// parameters, which can be specified only in a Route field.
+//
+// Register will panic if the method or path are not valid or f is
+// not in one of the forms accepted by Handle.
+func (srv *Server) Register(method, path string, f interface{}) Handler {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, " \t") {
+		panic(errgo.Newf("bad path %q", path))
+	}
+	return srv.endpoint(reflect.ValueOf(f), method+" "+path).handler()
+}
+
+// Endpoint is like Handle except that it returns a router-agnostic
+// description of the handler. See Endpoint for details.
+func (srv *Server) Endpoint(f interface{}) Endpoint {
+	return srv.endpoint(reflect.ValueOf(f), "")
+}
+
+// endpoint implements Endpoint. If route is non-empty, it holds the
+// method and path of the route in the same form as the httprequest
+// tag of a Route field, and the argument type of fv must not specify
+// a route itself.
+func (srv *Server) endpoint(fv reflect.Value, route string) Endpoint {
 	hf, err := srv.handlerFunc(fv.Type(), nil)
 	if err != nil {
 		panic(errgo.Notef(err, "bad handler function"))
 	}
-	return Handler{
-		Method: hf.method,
-		Path:   hf.pathPattern,
-		Handle: func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
-			ctx := req.Context()
-			p1 := Params{
-				Response:    w,
-				Request:     req,
-				PathVar:     p,
-				PathPattern: hf.pathPattern,
-				Context:     ctx,
-			}
-			argv, err := hf.unmarshal(p1)
-			if err != nil {
-				srv.WriteError(ctx, w, err)
-				return
-			}
-			hf.call(fv, argv, p1)
-		},
+	if route != "" {
+		if hf.method != "" {
+			panic(errgo.Newf("bad handler function: route %q specified for argument that has its own route", route))
+		}
+		r, err := tags.ParseRoute(reflect.StructTag("httprequest:" + strconv.Quote(route)))
+		if err != nil {
+			panic(errgo.Notef(err, "bad route %q", route))
+		}
+		if len(r.Constraints) > 0 || r.OptionalParam != "" {
+			panic(errgo.Newf("bad route %q: path constraints and optional parameters must be specified in a Route field", route))
+		}
+		hf.method, hf.pathPattern = r.Method, r.Path
+	}
+	if hf.optionalParam != "" {
+		panic(errgo.Newf("bad handler function: route %q has an optional path parameter; use Handlers instead", hf.pathPattern))
 	}
+	srv.recordRoute(hf)
+	return newEndpoint(hf, "", srv.wrapHandle(hf, func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ctx := req.Context()
+		p1 := srv.newParams(w, req, p, hf.pathPattern)
+		argv, err := hf.unmarshal(p1)
+		if err != nil {
+			srv.WriteError(ctx, w, err)
+			return
+		}
+		hf.call(fv, argv, p1)
+	}))
 }
 
 // Handlers returns a list of handlers that will be handled by the value
@@ -175,13 +550,24 @@ func (srv *Server) Handle(f interface{}) Handler {
 // If T implements io.Closer, its Close method will be called
 // after the request is completed.
 func (srv *Server) Handlers(f interface{}) []Handler {
+	es := srv.Endpoints(f)
+	hs := make([]Handler, len(es))
+	for i, e := range es {
+		hs[i] = e.handler()
+	}
+	return hs
+}
+
+// Endpoints is like Handlers except that it returns router-agnostic
+// descriptions of the handlers. See Endpoint for details.
+func (srv *Server) Endpoints(f interface{}) []Endpoint {
 	rootv := reflect.ValueOf(f)
 	wt, argInterfacet, err := checkHandlersWrapperFunc(rootv)
 	if err != nil {
 		panic(errgo.Notef(err, "bad handler function"))
 	}
 	hasClose := wt.Implements(ioCloserType)
-	hs := make([]Handler, 0, wt.NumMethod())
+	es := make([]Endpoint, 0, wt.NumMethod())
 	for i := 0; i < wt.NumMethod(); i++ {
 		i := i
 		m := wt.Method(i)
@@ -201,35 +587,52 @@ func (srv *Server) Handlers(f interface{}) []Handler {
 			// so we hide it.
 			m.Type = withoutReceiver(m.Type)
 		}
-		h, err := srv.methodHandler(m, rootv, argInterfacet, hasClose)
+		mes, err := srv.methodHandlers(m, rootv, argInterfacet, hasClose)
 		if err != nil {
 			panic(err)
 		}
-		hs = append(hs, h)
+		es = append(es, mes...)
 	}
-	if len(hs) == 0 {
+	if len(es) == 0 {
 		panic(errgo.Newf("no exported methods defined on %s", wt))
 	}
-	return hs
+	return es
 }
 
-func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInterfacet reflect.Type, hasClose bool) (Handler, error) {
+// methodHandlers returns the endpoints for the method m. There is
+// one endpoint unless the route has an optional final path parameter,
+// in which case there is also one for the path without it.
+func (srv *Server) methodHandlers(m reflect.Method, rootv reflect.Value, argInterfacet reflect.Type, hasClose bool) ([]Endpoint, error) {
 	hf, err := srv.handlerFunc(m.Type, argInterfacet)
 	if err != nil {
-		return Handler{}, errgo.Notef(err, "bad type for method %s", m.Name)
+		return nil, errgo.Notef(err, "bad type for method %s", m.Name)
 	}
 	if hf.method == "" || hf.pathPattern == "" {
-		return Handler{}, errgo.Notef(err, "method %s does not specify route method and path", m.Name)
+		return nil, errgo.Notef(err, "method %s does not specify route method and path", m.Name)
+	}
+	es := []Endpoint{srv.methodHandler(m, hf, rootv, argInterfacet, hasClose)}
+	if hf.optionalParam != "" {
+		hf.pathPattern = trimOptionalParam(hf.pathPattern, hf.optionalParam)
+		es = append(es, srv.methodHandler(m, hf, rootv, argInterfacet, hasClose))
 	}
+	return es, nil
+}
+
+// trimOptionalParam returns the given path without its final
+// optional parameter and the slash that precedes it. If that leaves
+// nothing, for example for "/:id", it returns "/".
+func trimOptionalParam(path, param string) string {
+	path = strings.TrimSuffix(path, "/:"+param)
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func (srv *Server) methodHandler(m reflect.Method, hf handlerFunc, rootv reflect.Value, argInterfacet reflect.Type, hasClose bool) Endpoint {
 	handler := func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		ctx := req.Context()
-		p1 := Params{
-			Response:    w,
-			Request:     req,
-			PathVar:     p,
-			PathPattern: hf.pathPattern,
-			Context:     ctx,
-		}
+		p1 := srv.newParams(w, req, p, hf.pathPattern)
 		inv, err := hf.unmarshal(p1)
 		if err != nil {
 			srv.WriteError(ctx, w, err)
@@ -264,19 +667,87 @@ func (srv *Server) methodHandler(m reflect.Method, rootv reflect.Value, argInter
 		if hasClose {
 			defer tv.Interface().(io.Closer).Close()
 		}
-		hf.call(tv.Method(m.Index), inv, Params{
-			Response:    w,
-			Request:     req,
-			PathVar:     p,
-			PathPattern: hf.pathPattern,
-			Context:     ctx,
-		})
-	}
-	return Handler{
-		Method: hf.method,
-		Path:   hf.pathPattern,
-		Handle: handler,
-	}, nil
+		p2 := p1
+		p2.Context = ctx
+		hf.call(tv.Method(m.Index), inv, p2)
+	}
+	srv.recordRoute(hf)
+	return newEndpoint(hf, m.Name, srv.wrapHandle(hf, handler))
+}
+
+// newParams returns the Params passed to a handler that is serving
+// the given request with the given path pattern.
+func (srv *Server) newParams(w http.ResponseWriter, req *http.Request, p httprouter.Params, pathPattern string) Params {
+	return Params{
+		Response:        w,
+		Request:         req,
+		PathVar:         p,
+		PathPattern:     pathPattern,
+		Context:         req.Context(),
+		provided:        new(providedFields),
+		duplicateParams: srv.DuplicateParams,
+		json:            srv.JSON,
+	}
+}
+
+// wrapHandle wraps the handler for the route of hf with any
+// additional behaviour configured on srv.
+func (srv *Server) wrapHandle(hf handlerFunc, h httprouter.Handle) httprouter.Handle {
+	method, pathPattern := hf.method, hf.pathPattern
+	// Note: the wrappers are applied from the innermost outwards.
+	if srv.RecoverPanics {
+		h = srv.wrapRecover(h)
+	}
+	if srv.IdempotencyGuard != nil {
+		h = srv.IdempotencyGuard.wrap(srv, h)
+	}
+	if len(hf.scopes) > 0 {
+		h = srv.wrapScopes(hf.scopes, h)
+	}
+	if hf.apiKey != nil {
+		h = srv.wrapAPIKey(hf.apiKey, h)
+	}
+	if len(srv.Middleware) > 0 {
+		h = srv.wrapMiddleware(method, pathPattern, h)
+	}
+	if srv.CSRFGuard != nil {
+		h = srv.CSRFGuard.wrap(srv, method, pathPattern, hf.csrfExempt, h)
+	}
+	if srv.ReplayGuard != nil {
+		h = srv.ReplayGuard.wrap(srv, h)
+	}
+	if srv.RateLimiter != nil {
+		h = srv.wrapRateLimit(method, pathPattern, h)
+	}
+	if srv.Prioritize != nil {
+		h = srv.wrapPriority(method, pathPattern, h)
+	}
+	if srv.InternalHeaderPrefix != "" {
+		h = srv.wrapInternalHeaders(h)
+	}
+	if hf.lifecycle != nil {
+		h = wrapLifecycle(*hf.lifecycle, h)
+	}
+	if srv.FaultInjector != nil {
+		h = srv.FaultInjector.wrap(srv, method, pathPattern, h)
+	}
+	h = srv.wrapClientIP(h)
+	if srv.HARSampler != nil {
+		h = srv.HARSampler.wrap(method, pathPattern, h)
+	}
+	if srv.Logger != nil {
+		h = srv.wrapLogger(method, pathPattern, h)
+	}
+	if srv.TraceContext {
+		h = srv.wrapTraceContext(h)
+	}
+	if srv.RequestID {
+		h = srv.wrapRequestID(h)
+	}
+	if srv.CORS != nil {
+		h = srv.CORS.wrap(h)
+	}
+	return srv.wrapShutdown(h)
 }
 
 func checkHandlersWrapperFunc(fv reflect.Value) (returnt, argInterfacet reflect.Type, err error) {
@@ -356,11 +827,32 @@ func (srv *Server) handlerFunc(ft, argInterfacet reflect.Type) (handlerFunc, err
 	if err != nil {
 		return handlerFunc{}, errgo.Mask(err)
 	}
+	if len(rt.scopes) > 0 && srv.Scopes == nil {
+		return handlerFunc{}, errgo.Newf("route requires scopes but Server.Scopes is nil")
+	}
+	if rt.apiKey != nil && srv.APIKeyStore == nil {
+		return handlerFunc{}, errgo.Newf("route requires API key but Server.APIKeyStore is nil")
+	}
+	if err := checkDigestAlgorithms(srv.ResponseDigests); err != nil {
+		return handlerFunc{}, errgo.Notef(err, "bad Server.ResponseDigests")
+	}
+	var resultType reflect.Type
+	if ft.NumOut() > 1 {
+		resultType = ft.Out(0)
+	}
 	return handlerFunc{
-		unmarshal:   handlerUnmarshaler(ft, rt),
-		call:        srv.handlerCaller(ft, rt),
-		method:      rt.method,
-		pathPattern: rt.path,
+		argType:       ft.In(ft.NumIn() - 1).Elem(),
+		resultType:    resultType,
+		unmarshal:     handlerUnmarshaler(ft, rt),
+		call:          srv.handlerCaller(ft, rt),
+		method:        rt.method,
+		pathPattern:   rt.path,
+		scopes:        rt.scopes,
+		optionalParam: rt.optionalParam,
+		apiKey:        rt.apiKey,
+		lifecycle:     rt.lifecycle,
+		csrfExempt:    rt.csrfExempt,
+		version:       rt.version,
 	}, nil
 }
 
@@ -387,13 +879,27 @@ func (srv *Server) handlerCaller(
 ) func(fv, argv reflect.Value, p Params) {
 	returnJSON := ft.NumOut() > 1
 	needsParams := ft.In(0) == paramsType
-	respond := srv.handlerResponder(ft)
+	noContent := (srv.NoContent || rt.status != 0) && !returnJSON
+	respond := srv.handlerResponder(ft, rt.status)
 	return func(fv, argv reflect.Value, p Params) {
+		if rt.earlyHints != "" {
+			p.EarlyHints(rt.earlyHints)
+		}
+		var w *responseWriter
+		if noContent {
+			w = &responseWriter{
+				ResponseWriter: p.Response,
+			}
+			p.Response = w
+		}
 		var rv []reflect.Value
 		if needsParams {
 			p := p
 			if returnJSON {
-				p.Response = headerOnlyResponseWriter{p.Response.Header()}
+				p.Response = headerOnlyResponseWriter{
+					h: p.Response.Header(),
+					w: p.Response,
+				}
 			}
 			rv = fv.Call([]reflect.Value{
 				reflect.ValueOf(p),
@@ -405,12 +911,23 @@ func (srv *Server) handlerCaller(
 			})
 		}
 		respond(p, rv)
+		if w != nil && !w.headerWritten {
+			status := rt.status
+			if status == 0 {
+				status = http.StatusNoContent
+			}
+			w.WriteHeader(status)
+		}
 	}
 }
 
 // handlerResponder handles the marshaling of the result values from the call to a function
 // of type ft. The returned function accepts the values returned by the handler.
-func (srv *Server) handlerResponder(ft reflect.Type) func(p Params, outv []reflect.Value) {
+// A result is written with the given status, or http.StatusOK if it is zero.
+func (srv *Server) handlerResponder(ft reflect.Type, status int) func(p Params, outv []reflect.Value) {
+	if status == 0 {
+		status = http.StatusOK
+	}
 	switch ft.NumOut() {
 	case 0:
 		// func(...)
@@ -429,7 +946,7 @@ func (srv *Server) handlerResponder(ft reflect.Type) func(p Params, outv []refle
 				srv.WriteError(p.Context, p.Response, err.(error))
 				return
 			}
-			if err := WriteJSON(p.Response, http.StatusOK, outv[0].Interface()); err != nil {
+			if err := srv.writeResult(p.Response, p.Request, status, outv[0].Interface()); err != nil {
 				srv.WriteError(p.Context, p.Response, err)
 			}
 		}
@@ -461,26 +978,26 @@ type ErrorHandler func(Params) error
 
 // HandleJSON returns a handler that writes the return value of handle
 // as a JSON response. If handle returns an error, it is passed through
-// the error mapper.
+// the error mapper. The value is written in the same way as the result
+// of a handler created by Handle, so the settings of srv such as
+// JSON, TimeFormat and BufferPool apply to it.
 //
 // Note that the Params argument passed to handle will not
 // have its PathPattern set as that information is not available.
 func (srv *Server) HandleJSON(handle JSONHandler) httprouter.Handle {
-	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	return srv.wrapShutdown(func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		ctx := req.Context()
-		val, err := handle(Params{
-			Response: headerOnlyResponseWriter{w.Header()},
-			Request:  req,
-			PathVar:  p,
-			Context:  ctx,
-		})
+		val, err := handle(srv.newParams(headerOnlyResponseWriter{
+			h: w.Header(),
+			w: w,
+		}, req, p, ""))
 		if err == nil {
-			if err = WriteJSON(w, http.StatusOK, val); err == nil {
+			if err = srv.writeResult(w, req, http.StatusOK, val); err == nil {
 				return
 			}
 		}
 		srv.WriteError(ctx, w, err)
-	}
+	})
 }
 
 // HandleErrors returns a handler that passes any non-nil error returned
@@ -489,17 +1006,12 @@ func (srv *Server) HandleJSON(handle JSONHandler) httprouter.Handle {
 // Note that the Params argument passed to handle will not
 // have its PathPattern set as that information is not available.
 func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
-	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+	return srv.wrapShutdown(func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
 		w1 := responseWriter{
 			ResponseWriter: w,
 		}
 		ctx := req.Context()
-		if err := handle(Params{
-			Response: &w1,
-			Request:  req,
-			PathVar:  p,
-			Context:  ctx,
-		}); err != nil {
+		if err := handle(srv.newParams(&w1, req, p, "")); err != nil {
 			if w1.headerWritten {
 				// The header has already been written,
 				// so we can't set the appropriate error
@@ -512,7 +1024,7 @@ func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
 			}
 			srv.WriteError(ctx, w, err)
 		}
-	}
+	})
 }
 
 // WriteError writes an error to a ResponseWriter and sets the HTTP
@@ -523,6 +1035,7 @@ func (srv *Server) HandleErrors(handle ErrorHandler) httprouter.Handle {
 // ErrorMapper so it is possible to add custom headers to the HTTP error
 // response by implementing HeaderSetter.
 func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err error) {
+	logError(ctx, err)
 	if srv.ErrorWriter != nil {
 		srv.ErrorWriter(ctx, w, err)
 		return
@@ -532,7 +1045,7 @@ func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err er
 		errorMapper = DefaultErrorMapper
 	}
 	status, resp := errorMapper(ctx, err)
-	err1 := WriteJSON(w, status, resp)
+	err1 := writeJSON(w, status, resp, srv.TimeFormat, srv.JSON, srv.BufferPool)
 	if err1 == nil {
 		return
 	}
@@ -559,12 +1072,30 @@ func (srv *Server) WriteError(ctx context.Context, w http.ResponseWriter, err er
 // has been added, so can be used to override the content type
 // if required.
 func WriteJSON(w http.ResponseWriter, code int, val interface{}) error {
+	return writeJSON(w, code, val, nil, nil, nil)
+}
+
+// writeJSON is like WriteJSON except that time.Time values
+// in val are formatted as specified by tf, and val is marshaled
+// with jc and into a buffer from pool if they are non-nil.
+func writeJSON(w http.ResponseWriter, code int, val interface{}, tf *TimeFormat, jc Codec, pool *BufferPool) error {
 	// TODO consider marshalling directly to w using json.NewEncoder.
 	// pro: this will not require a full buffer allocation.
 	// con: if there's an error after the first write, it will be lost.
-	data, err := json.Marshal(val)
-	if err != nil {
-		return errgo.Mask(err)
+	var data []byte
+	if pool != nil {
+		buf := pool.get()
+		defer pool.put(buf)
+		if err := tf.marshalTo(buf, val, jc); err != nil {
+			return errgo.Mask(err)
+		}
+		data = buf.Bytes()
+	} else {
+		var err error
+		data, err = tf.marshal(val, jc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
 	}
 	w.Header().Set("content-type", "application/json")
 	if headerSetter, ok := val.(HeaderSetter); ok {
@@ -623,7 +1154,9 @@ func (w *responseWriter) Write(data []byte) (int, error) {
 }
 
 func (w *responseWriter) WriteHeader(code int) {
-	w.headerWritten = true
+	if !isInformational(code) {
+		w.headerWritten = true
+	}
 	w.ResponseWriter.WriteHeader(code)
 }
 
@@ -637,6 +1170,10 @@ func (w *responseWriter) Flush() {
 
 type headerOnlyResponseWriter struct {
 	h http.Header
+
+	// w holds the ResponseWriter that informational responses
+	// (see Params.EarlyHints) are written to, if any.
+	w http.ResponseWriter
 }
 
 func (w headerOnlyResponseWriter) Header() http.Header {
@@ -649,6 +1186,10 @@ func (w headerOnlyResponseWriter) Write([]byte) (int, error) {
 }
 
 func (w headerOnlyResponseWriter) WriteHeader(code int) {
+	if isInformational(code) && w.w != nil {
+		w.w.WriteHeader(code)
+		return
+	}
 	// TODO log or panic when this happens?
 }
 
@@ -1355,6 +1355,51 @@ func TestHandleJSON(t *testing.T) {
 	c.Assert(rec.Header().Get("Some-Header"), qt.Equals, "value")
 }
 
+func TestHandleNoContent(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		NoContent: true,
+	}
+	type req struct {
+		httprequest.Route `httprequest:"DELETE /x/:p"`
+		P                 string `httprequest:"p,path"`
+	}
+	h := srv.Handle(func(p httprequest.Params, r *req) error {
+		switch r.P {
+		case "fail":
+			return httprequest.Errorf(httprequest.CodeNotFound, "not found")
+		case "write":
+			p.Response.WriteHeader(http.StatusAccepted)
+		}
+		return nil
+	})
+	for _, test := range []struct {
+		p            string
+		expectStatus int
+	}{
+		{"ok", http.StatusNoContent},
+		{"fail", http.StatusNotFound},
+		{"write", http.StatusAccepted},
+	} {
+		rec := httptest.NewRecorder()
+		h.Handle(rec, httptest.NewRequest("DELETE", "/x/"+test.p, nil), httprouter.Params{{
+			Key:   "p",
+			Value: test.p,
+		}})
+		c.Assert(rec.Code, qt.Equals, test.expectStatus, qt.Commentf("%s", test.p))
+	}
+
+	// Handlers with a result value are not affected.
+	h = srv.Handle(func(p httprequest.Params, r *req) (interface{}, error) {
+		return nil, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("DELETE", "/x/ok", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, "null")
+}
+
 var requestEquals = qt.CmpEquals(cmpopts.IgnoreUnexported(http.Request{}))
 
 type handlersWithRequestMethod struct{}
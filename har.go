@@ -0,0 +1,574 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// The types below hold the subset of the HTTP Archive (HAR) 1.2
+// format that is produced by this package. See
+// http://www.softwareishard.com/blog/har-12-spec/ for details.
+
+// HAR holds an HTTP Archive document.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog holds the log of an HTTP Archive.
+type HARLog struct {
+	Version string      `json:"version"`
+	Creator HARCreator  `json:"creator"`
+	Entries []*HAREntry `json:"entries"`
+}
+
+// HARCreator holds information on the application that created
+// an HTTP Archive.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry holds a single HTTP request and its response.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// HARRequest holds information on a request in an HTTP Archive.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse holds information on a response in an HTTP Archive.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue holds a named value such as a header, cookie or
+// query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData holds the body of a request.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+// HARContent holds the body of a response.
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+// HARTimings holds the timings of an HTTP Archive entry in
+// milliseconds.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// HARArchive collects HAR entries. It is safe to use concurrently.
+// The zero value is ready to use.
+type HARArchive struct {
+	mu      sync.Mutex
+	entries []*HAREntry
+}
+
+// Add adds an entry to the archive.
+func (a *HARArchive) Add(e *HAREntry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = append(a.entries, e)
+}
+
+// Entries returns a copy of all the entries that have been added
+// to the archive.
+func (a *HARArchive) Entries() []*HAREntry {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]*HAREntry(nil), a.entries...)
+}
+
+// Reset removes all entries from the archive.
+func (a *HARArchive) Reset() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = nil
+}
+
+// HAR returns an HTTP Archive document holding all the
+// entries in the archive.
+func (a *HARArchive) HAR() *HAR {
+	return &HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{
+				Name:    "gopkg.in/httprequest.v1",
+				Version: "1",
+			},
+			Entries: a.Entries(),
+		},
+	}
+}
+
+// WriteTo implements io.WriterTo by writing the archive
+// as a JSON-encoded HAR document.
+func (a *HARArchive) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.MarshalIndent(a.HAR(), "", "\t")
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	n, err := w.Write(data)
+	return int64(n), errgo.Mask(err)
+}
+
+// WriteFile writes the archive to the named file as a JSON-encoded
+// HAR document.
+func (a *HARArchive) WriteFile(filename string) error {
+	var buf bytes.Buffer
+	if _, err := a.WriteTo(&buf); err != nil {
+		return errgo.Mask(err)
+	}
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0600); err != nil {
+		return errgo.Mask(err)
+	}
+	return nil
+}
+
+// RedactHeaders returns a function suitable for use as a HAR redaction
+// function that replaces the values of all the given request and
+// response headers (and all cookies if Cookie or Set-Cookie are
+// mentioned) with "REDACTED".
+func RedactHeaders(names ...string) func(*HAREntry) {
+	redact := make(map[string]bool)
+	for _, name := range names {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+	redactValues := func(nvs []HARNameValue) {
+		for i := range nvs {
+			if redact[http.CanonicalHeaderKey(nvs[i].Name)] {
+				nvs[i].Value = "REDACTED"
+			}
+		}
+	}
+	redactCookies := func(nvs []HARNameValue) {
+		for i := range nvs {
+			nvs[i].Value = "REDACTED"
+		}
+	}
+	return func(e *HAREntry) {
+		redactValues(e.Request.Headers)
+		redactValues(e.Response.Headers)
+		if redact["Cookie"] {
+			redactCookies(e.Request.Cookies)
+		}
+		if redact["Set-Cookie"] {
+			redactCookies(e.Response.Cookies)
+		}
+	}
+}
+
+// maxHARBodySize holds the maximum number of bytes of a request or
+// response body that will be recorded in a HAR entry.
+var maxHARBodySize = 1024 * 1024
+
+// HARSampler records a sample of the requests handled by a Server as
+// HAR entries. Sampling is configured per route and may be changed
+// at any time, so capture can be turned on and off while a server is
+// running.
+//
+// A HARSampler is enabled by setting the Server.HARSampler field.
+type HARSampler struct {
+	// Sink is called with each recorded entry. It must be
+	// safe to call concurrently. HARArchive.Add is a suitable
+	// implementation.
+	Sink func(*HAREntry)
+
+	// Redact, if non-nil, is called on each entry before it is passed
+	// to Sink. It can be used to remove sensitive information such
+	// as credentials. See RedactHeaders.
+	Redact func(*HAREntry)
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	// Rand returns a random number in [0, 1) that is used to
+	// choose which requests are sampled. If it is nil,
+	// math/rand.Float64 is used.
+	Rand func() float64
+
+	mu    sync.RWMutex
+	rates map[string]float64
+}
+
+// SetRate sets the fraction of requests to the route with the given
+// method and path pattern (as found in Handler) that will be
+// recorded. A rate of 0 turns off recording for the route; a rate of 1
+// records every request.
+func (s *HARSampler) SetRate(method, pathPattern string, rate float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rates == nil {
+		s.rates = make(map[string]float64)
+	}
+	key := method + " " + pathPattern
+	if rate <= 0 {
+		delete(s.rates, key)
+		return
+	}
+	s.rates[key] = rate
+}
+
+// Rate returns the current sampling rate for the given route.
+func (s *HARSampler) Rate(method, pathPattern string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.rates[method+" "+pathPattern]
+}
+
+// sample reports whether a request to the given route should
+// be recorded.
+func (s *HARSampler) sample(method, pathPattern string) bool {
+	return sampled(s.Rate(method, pathPattern), s.Rand)
+}
+
+// wrap returns a handler that records requests to h as
+// configured by s.
+func (s *HARSampler) wrap(method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if !s.sample(method, pathPattern) {
+			h(w, req, p)
+			return
+		}
+		now := nowFunc(s.Now)
+		start := now()
+		var reqBody bytes.Buffer
+		if req.Body != nil {
+			req.Body = teeReadCloser{
+				Reader: io.TeeReader(req.Body, &limitedWriter{w: &reqBody, n: maxHARBodySize}),
+				Closer: req.Body,
+			}
+		}
+		w1 := &harResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		h(w1, req, p)
+		e := &HAREntry{
+			StartedDateTime: start,
+			Time:            msSince(start, now),
+			Request:         newHARRequest(req, reqBody.Bytes()),
+			Response:        newHARResponse(req.Proto, w1.status, w.Header(), w1.body.Bytes(), w1.size),
+		}
+		e.Timings.Wait = e.Time
+		if s.Redact != nil {
+			s.Redact(e)
+		}
+		if s.Sink != nil {
+			s.Sink(e)
+		}
+	}
+}
+
+// harResponseWriter wraps an http.ResponseWriter and records
+// the response written to it.
+type harResponseWriter struct {
+	http.ResponseWriter
+	status        int
+	headerWritten bool
+	body          bytes.Buffer
+	size          int
+}
+
+func (w *harResponseWriter) WriteHeader(code int) {
+	if !w.headerWritten && !isInformational(code) {
+		w.status = code
+		w.headerWritten = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *harResponseWriter) Write(data []byte) (int, error) {
+	w.headerWritten = true
+	n, err := w.ResponseWriter.Write(data)
+	w.size += n
+	(&limitedWriter{w: &w.body, n: maxHARBodySize - w.body.Len()}).Write(data[:n])
+	return n, err
+}
+
+// Flush implements http.Flusher.Flush.
+func (w *harResponseWriter) Flush() {
+	w.headerWritten = true
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// limitedWriter writes at most n bytes to w, silently discarding
+// the rest.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (w *limitedWriter) Write(data []byte) (int, error) {
+	n := len(data)
+	if len(data) > w.n {
+		data = data[:w.n]
+	}
+	if len(data) > 0 {
+		w.w.Write(data)
+		w.n -= len(data)
+	}
+	return n, nil
+}
+
+type teeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newHARRequest(req *http.Request, body []byte) HARRequest {
+	u := *req.URL
+	if u.Host == "" {
+		u.Host = req.Host
+	}
+	if u.Scheme == "" {
+		u.Scheme = "http"
+		if req.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+	hr := HARRequest{
+		Method:      req.Method,
+		URL:         u.String(),
+		HTTPVersion: protoOrDefault(req.Proto),
+		Headers:     harNameValues(req.Header),
+		QueryString: harNameValues(u.Query()),
+		Cookies:     []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+	for _, c := range req.Cookies() {
+		hr.Cookies = append(hr.Cookies, HARNameValue{
+			Name:  c.Name,
+			Value: c.Value,
+		})
+	}
+	if len(body) > 0 {
+		hr.PostData = &HARPostData{
+			MimeType: req.Header.Get("Content-Type"),
+			Text:     string(body),
+		}
+	}
+	return hr
+}
+
+func newHARResponse(proto string, status int, h http.Header, body []byte, size int) HARResponse {
+	hr := HARResponse{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: protoOrDefault(proto),
+		Headers:     harNameValues(h),
+		Cookies:     []HARNameValue{},
+		Content: HARContent{
+			Size:     size,
+			MimeType: h.Get("Content-Type"),
+			Text:     string(body),
+		},
+		RedirectURL: h.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    size,
+	}
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		hr.Cookies = append(hr.Cookies, HARNameValue{
+			Name:  c.Name,
+			Value: c.Value,
+		})
+	}
+	return hr
+}
+
+// harNameValues returns the contents of m (usually http.Header or
+// url.Values) as a slice of name-value pairs sorted by name.
+func harNameValues(m map[string][]string) []HARNameValue {
+	nvs := []HARNameValue{}
+	for name, vals := range m {
+		for _, val := range vals {
+			nvs = append(nvs, HARNameValue{
+				Name:  name,
+				Value: val,
+			})
+		}
+	}
+	sort.SliceStable(nvs, func(i, j int) bool {
+		return nvs[i].Name < nvs[j].Name
+	})
+	return nvs
+}
+
+func protoOrDefault(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+	return proto
+}
+
+// msSince returns the number of milliseconds since t
+// according to the given clock.
+func msSince(t time.Time, now func() time.Time) float64 {
+	return float64(now().Sub(t)) / float64(time.Millisecond)
+}
+
+// HARRecorder records the calls made by a Client as HAR entries.
+//
+// A HARRecorder is enabled by setting the Client.HARRecorder field.
+type HARRecorder struct {
+	// Sink is called with each recorded entry. It must be safe to
+	// call concurrently. HARArchive.Add is a suitable
+	// implementation.
+	//
+	// An entry is recorded when the response body has been closed
+	// (which Client does automatically unless the caller has asked
+	// for the *http.Response), or immediately if the request
+	// failed without a response.
+	Sink func(*HAREntry)
+
+	// Redact, if non-nil, is called on each entry before it is passed
+	// to Sink. See RedactHeaders.
+	Redact func(*HAREntry)
+
+	// FailuresOnly specifies that only calls that fail to return a
+	// response or return a non-2xx status should be recorded.
+	FailuresOnly bool
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+}
+
+// do sends req using the given function, recording the
+// exchange as configured by r.
+func (r *HARRecorder) do(req *http.Request, do func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	var reqBody bytes.Buffer
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			io.Copy(&limitedWriter{w: &reqBody, n: maxHARBodySize}, body)
+			body.Close()
+		}
+	} else if req.Body != nil {
+		req.Body = teeReadCloser{
+			Reader: io.TeeReader(req.Body, &limitedWriter{w: &reqBody, n: maxHARBodySize}),
+			Closer: req.Body,
+		}
+	}
+	now := nowFunc(r.Now)
+	start := now()
+	resp, err := do(req)
+	wait := msSince(start, now)
+	e := &HAREntry{
+		StartedDateTime: start,
+		Request:         newHARRequest(req, reqBody.Bytes()),
+		Timings: HARTimings{
+			Wait: wait,
+		},
+	}
+	if err != nil {
+		e.Time = wait
+		e.Response = newHARResponse(req.Proto, 0, http.Header{}, nil, 0)
+		e.Comment = err.Error()
+		r.record(e)
+		return nil, err
+	}
+	if r.FailuresOnly && 200 <= resp.StatusCode && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	resp.Body = &harResponseBody{
+		ReadCloser: resp.Body,
+		recorder:   r,
+		resp:       resp,
+		entry:      e,
+		received:   now(),
+	}
+	return resp, nil
+}
+
+func (r *HARRecorder) record(e *HAREntry) {
+	if r.Redact != nil {
+		r.Redact(e)
+	}
+	if r.Sink != nil {
+		r.Sink(e)
+	}
+}
+
+// harResponseBody wraps a response body and records the
+// HAR entry for the response when it is closed.
+type harResponseBody struct {
+	io.ReadCloser
+	recorder *HARRecorder
+	resp     *http.Response
+	entry    *HAREntry
+	received time.Time
+	body     bytes.Buffer
+	size     int
+	closed   bool
+}
+
+func (b *harResponseBody) Read(buf []byte) (int, error) {
+	n, err := b.ReadCloser.Read(buf)
+	b.size += n
+	(&limitedWriter{w: &b.body, n: maxHARBodySize - b.body.Len()}).Write(buf[:n])
+	return n, err
+}
+
+func (b *harResponseBody) Close() error {
+	err := b.ReadCloser.Close()
+	if b.closed {
+		return err
+	}
+	b.closed = true
+	e := b.entry
+	e.Response = newHARResponse(b.resp.Proto, b.resp.StatusCode, b.resp.Header, b.body.Bytes(), b.size)
+	e.Timings.Receive = msSince(b.received, nowFunc(b.recorder.Now))
+	e.Time = e.Timings.Wait + e.Timings.Receive
+	b.recorder.record(e)
+	return err
+}
@@ -0,0 +1,256 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type harHandlers struct{}
+
+type harPostRequest struct {
+	httprequest.Route `httprequest:"POST /har/:id"`
+	ID                string `httprequest:"id,path"`
+	Body              struct {
+		N int
+	} `httprequest:",body"`
+}
+
+func (harHandlers) Post(p *harPostRequest) (int, error) {
+	return p.Body.N + 1, nil
+}
+
+type harGetRequest struct {
+	httprequest.Route `httprequest:"GET /har"`
+}
+
+func (harHandlers) Get(p *harGetRequest) (string, error) {
+	return "ok", nil
+}
+
+func newHARServer(srv *httprequest.Server) *httptest.Server {
+	return newHandlerServer(srv.Handlers(func(p httprequest.Params) (harHandlers, context.Context, error) {
+		return harHandlers{}, p.Context, nil
+	}))
+}
+
+func TestHARSampler(t *testing.T) {
+	c := qt.New(t)
+
+	var archive httprequest.HARArchive
+	sampler := &httprequest.HARSampler{
+		Sink:   archive.Add,
+		Redact: httprequest.RedactHeaders("Authorization"),
+	}
+	sampler.SetRate("POST", "/har/:id", 1)
+	srv := newHARServer(&httprequest.Server{
+		HARSampler: sampler,
+	})
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/har/x?q=1", strings.NewReader(`{"N":41}`))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+
+	// The GET route isn't sampled.
+	resp, err = http.Get(srv.URL + "/har")
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+
+	entries := archive.Entries()
+	c.Assert(entries, qt.HasLen, 1)
+	e := entries[0]
+	c.Assert(e.Request.Method, qt.Equals, "POST")
+	c.Assert(e.Request.URL, qt.Equals, srv.URL+"/har/x?q=1")
+	c.Assert(e.Request.QueryString, qt.DeepEquals, []httprequest.HARNameValue{{
+		Name:  "q",
+		Value: "1",
+	}})
+	c.Assert(e.Request.PostData, qt.DeepEquals, &httprequest.HARPostData{
+		MimeType: "application/json",
+		Text:     `{"N":41}`,
+	})
+	c.Assert(harHeader(e.Request.Headers, "Authorization"), qt.Equals, "REDACTED")
+	c.Assert(e.Response.Status, qt.Equals, http.StatusOK)
+	c.Assert(e.Response.Content.Text, qt.Equals, "42")
+	c.Assert(e.Response.Content.MimeType, qt.Equals, "application/json")
+
+	// Turning off sampling stops recording.
+	sampler.SetRate("POST", "/har/:id", 0)
+	archive.Reset()
+	resp, err = http.Post(srv.URL+"/har/x", "application/json", strings.NewReader(`{"N":1}`))
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(archive.Entries(), qt.HasLen, 0)
+}
+
+func TestHARSamplerClockAndRand(t *testing.T) {
+	c := qt.New(t)
+
+	t0 := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	now := t0
+	rands := []float64{0.6, 0.4}
+	var archive httprequest.HARArchive
+	sampler := &httprequest.HARSampler{
+		Sink: archive.Add,
+		Now: func() time.Time {
+			t := now
+			now = now.Add(10 * time.Millisecond)
+			return t
+		},
+		Rand: func() float64 {
+			r := rands[0]
+			rands = rands[1:]
+			return r
+		},
+	}
+	sampler.SetRate("POST", "/har/:id", 0.5)
+	srv := newHARServer(&httprequest.Server{
+		HARSampler: sampler,
+	})
+	defer srv.Close()
+
+	// The first request is not sampled; the second is.
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(srv.URL+"/har/x", "application/json", strings.NewReader(`{"N":1}`))
+		c.Assert(err, qt.Equals, nil)
+		resp.Body.Close()
+	}
+	entries := archive.Entries()
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].StartedDateTime.Equal(t0), qt.Equals, true)
+	c.Assert(entries[0].Time, qt.Equals, 10.0)
+	c.Assert(entries[0].Timings.Wait, qt.Equals, 10.0)
+}
+
+func TestHARArchiveWriteTo(t *testing.T) {
+	c := qt.New(t)
+
+	var archive httprequest.HARArchive
+	archive.Add(&httprequest.HAREntry{
+		Request: httprequest.HARRequest{
+			Method: "GET",
+			URL:    "http://example.com",
+		},
+	})
+	var buf bytes.Buffer
+	_, err := archive.WriteTo(&buf)
+	c.Assert(err, qt.Equals, nil)
+	var har httprequest.HAR
+	err = json.Unmarshal(buf.Bytes(), &har)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(har.Log.Version, qt.Equals, "1.2")
+	c.Assert(har.Log.Entries, qt.HasLen, 1)
+	c.Assert(har.Log.Entries[0].Request.URL, qt.Equals, "http://example.com")
+}
+
+func harHeader(nvs []httprequest.HARNameValue, name string) string {
+	for _, nv := range nvs {
+		if nv.Name == name {
+			return nv.Value
+		}
+	}
+	return ""
+}
+
+func TestHARRecorder(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newServer()
+	defer srv.Close()
+
+	var archive httprequest.HARArchive
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		HARRecorder: &httprequest.HARRecorder{
+			Sink: archive.Add,
+		},
+	}
+	var resp chM2Resp
+	err := client.Call(context.Background(), &chM2Req{
+		P:    "hello",
+		Body: struct{ I int }{999},
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	err = client.Call(context.Background(), &chM3Req{}, nil)
+	c.Assert(err, qt.ErrorMatches, `.*m3 error`)
+
+	entries := archive.Entries()
+	c.Assert(entries, qt.HasLen, 2)
+	c.Assert(entries[0].Request.Method, qt.Equals, "POST")
+	c.Assert(entries[0].Request.URL, qt.Equals, srv.URL+"/m2/hello")
+	c.Assert(entries[0].Request.PostData, qt.DeepEquals, &httprequest.HARPostData{
+		MimeType: "application/json",
+		Text:     `{"I":999}`,
+	})
+	c.Assert(entries[0].Response.Status, qt.Equals, http.StatusOK)
+	c.Assert(entries[0].Response.Content.Text, qt.Equals, `{"P":"hello","Arg":999}`)
+	c.Assert(entries[1].Request.URL, qt.Equals, srv.URL+"/m3")
+	c.Assert(entries[1].Response.Status, qt.Equals, http.StatusInternalServerError)
+	c.Assert(entries[1].Response.Content.Text, qt.Equals, `{"Message":"m3 error"}`)
+}
+
+func TestHARRecorderFailuresOnly(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newServer()
+	defer srv.Close()
+
+	var archive httprequest.HARArchive
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		HARRecorder: &httprequest.HARRecorder{
+			Sink:         archive.Add,
+			FailuresOnly: true,
+		},
+	}
+	err := client.Call(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	err = client.Call(context.Background(), &chM3Req{}, nil)
+	c.Assert(err, qt.ErrorMatches, `.*m3 error`)
+
+	entries := archive.Entries()
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Request.URL, qt.Equals, srv.URL+"/m3")
+}
+
+func TestHARRecorderRequestError(t *testing.T) {
+	c := qt.New(t)
+
+	var archive httprequest.HARArchive
+	client := httprequest.Client{
+		BaseURL: "http://0.1.2.3",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, errgo.New("no route to host")
+		}),
+		HARRecorder: &httprequest.HARRecorder{
+			Sink:         archive.Add,
+			FailuresOnly: true,
+		},
+	}
+	err := client.Get(context.Background(), "/foo", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://0.1.2.3/foo: no route to host`)
+	entries := archive.Entries()
+	c.Assert(entries, qt.HasLen, 1)
+	c.Assert(entries[0].Response.Status, qt.Equals, 0)
+	c.Assert(entries[0].Comment, qt.Equals, "no route to host")
+}
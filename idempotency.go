@@ -0,0 +1,225 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// NewIdempotencyKey returns a new random idempotency key in the form
+// of a version 4 UUID. It is suitable for use as the
+// Client.IdempotencyKey field.
+func NewIdempotencyKey() string {
+	return newUUID()
+}
+
+// newUUID returns a new random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(errgo.Notef(err, "cannot generate random UUID"))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// ErrIdempotencyKeyInUse is returned by IdempotencyStore.Begin
+// when another request with the same key is in progress.
+var ErrIdempotencyKeyInUse = errgo.New("idempotency key in use")
+
+// IdempotencyStore is used by IdempotencyGuard to record the
+// responses to requests.
+type IdempotencyStore interface {
+	// Begin is called when a request with the given key is
+	// received. If a response has been recorded for the key,
+	// Begin returns it. Otherwise it marks the key as in progress
+	// and returns a nil response; if the key is already in
+	// progress, it returns an error with an ErrIdempotencyKeyInUse
+	// cause.
+	Begin(ctx context.Context, key string) (*RecordedResponse, error)
+
+	// Finish is called when a request begun with the given key
+	// has completed. If resp is non-nil, it should be returned by
+	// Begin for the key until the given expiry time; if it is nil,
+	// the key should be forgotten.
+	Finish(ctx context.Context, key string, resp *RecordedResponse, expires time.Time) error
+}
+
+// IdempotencyGuard makes requests that have an idempotency key safe to
+// retry. The first request with a given key is handled as usual and its
+// response recorded; later requests with the same key are not passed
+// to the handler but are sent the recorded response.
+//
+// Responses with a 5xx status are not recorded, so a request
+// that fails because of a server error can be retried.
+//
+// An IdempotencyGuard is enabled for all the handlers created by a
+// Server by setting the Server.IdempotencyGuard field.
+type IdempotencyGuard struct {
+	// Store holds the store used to record responses.
+	Store IdempotencyStore
+
+	// Header holds the name of the header holding the
+	// idempotency key. If it is empty, "Idempotency-Key" is used.
+	Header string
+
+	// Expiry holds how long a response is recorded for. If it is
+	// zero, 24 hours is used.
+	Expiry time.Duration
+
+	// Scope, if non-nil, is called to find a string that scopes the
+	// idempotency key of a request, for example the name of the
+	// authenticated user, so that clients cannot see each others'
+	// responses. The key is always scoped to the method and path
+	// of the request.
+	Scope func(req *http.Request) string
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+}
+
+// wrap returns a handler that handles requests with an idempotency key
+// as configured by g before calling h.
+func (g *IdempotencyGuard) wrap(srv *Server, h httprouter.Handle) httprouter.Handle {
+	header := g.Header
+	if header == "" {
+		header = "Idempotency-Key"
+	}
+	expiry := g.Expiry
+	if expiry == 0 {
+		expiry = 24 * time.Hour
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		key := req.Header.Get(header)
+		if key == "" || isSafeMethod(req.Method) {
+			h(w, req, p)
+			return
+		}
+		key = req.Method + " " + req.URL.Path + " " + key
+		if g.Scope != nil {
+			key = g.Scope(req) + " " + key
+		}
+		ctx := req.Context()
+		resp, err := g.Store.Begin(ctx, key)
+		if err != nil {
+			if errgo.Cause(err) == ErrIdempotencyKeyInUse {
+				err = Errorf(CodeConflict, "a request with the same idempotency key is in progress")
+			}
+			srv.WriteError(ctx, w, errgo.Mask(err, errgo.Any))
+			return
+		}
+		if resp != nil {
+			for k, v := range resp.Header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.Status)
+			w.Write(resp.Body)
+			return
+		}
+		// Make sure that the key is released if the handler panics.
+		recorded := false
+		defer func() {
+			if !recorded {
+				g.Store.Finish(ctx, key, nil, time.Time{})
+			}
+		}()
+		w1 := &harResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		h(w1, req, p)
+		recorded = true
+		if w1.status >= 500 || w1.size > w1.body.Len() {
+			// Server errors are not recorded so that the
+			// request can be retried, and nor are responses
+			// too large to record in full.
+			g.Store.Finish(ctx, key, nil, time.Time{})
+			return
+		}
+		g.Store.Finish(ctx, key, &RecordedResponse{
+			Status: w1.status,
+			Header: w.Header().Clone(),
+			Body:   w1.body.Bytes(),
+		}, nowFunc(g.Now)().Add(expiry))
+	}
+}
+
+// isSafeMethod reports whether the given HTTP method is
+// defined to be safe, and so never needs an idempotency key.
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return true
+	}
+	return false
+}
+
+// MemIdempotencyStore is an in-memory implementation of
+// IdempotencyStore. The zero value is ready to use.
+type MemIdempotencyStore struct {
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*idempotencyEntry
+	nextPurge time.Time
+}
+
+type idempotencyEntry struct {
+	// resp holds the recorded response, or nil
+	// if the request is in progress.
+	resp    *RecordedResponse
+	expires time.Time
+}
+
+// Begin implements IdempotencyStore.Begin.
+func (s *MemIdempotencyStore) Begin(ctx context.Context, key string) (*RecordedResponse, error) {
+	now := nowFunc(s.Now)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]*idempotencyEntry)
+	}
+	if now.After(s.nextPurge) {
+		for k, e := range s.entries {
+			if e.resp != nil && now.After(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		s.nextPurge = now.Add(time.Minute)
+	}
+	e := s.entries[key]
+	switch {
+	case e == nil || e.resp != nil && now.After(e.expires):
+		s.entries[key] = &idempotencyEntry{}
+		return nil, nil
+	case e.resp == nil:
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return e.resp, nil
+}
+
+// Finish implements IdempotencyStore.Finish.
+func (s *MemIdempotencyStore) Finish(ctx context.Context, key string, resp *RecordedResponse, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if resp == nil {
+		delete(s.entries, key)
+		return nil
+	}
+	s.entries[key] = &idempotencyEntry{
+		resp:    resp,
+		expires: expires,
+	}
+	return nil
+}
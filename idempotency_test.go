@@ -0,0 +1,200 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type idempotencyReq struct {
+	httprequest.Route `httprequest:"POST /items"`
+	Body              struct {
+		Name string
+	} `httprequest:",body"`
+}
+
+type idempotencyResp struct {
+	N    int
+	Name string
+}
+
+// newIdempotencyServer returns a server with an idempotency guard
+// that counts the items it creates in *n. If fail is true, requests
+// fail with an internal server error.
+func newIdempotencyServer(g *httprequest.IdempotencyGuard, n *int, fail *bool) *httptest.Server {
+	srv := httprequest.Server{
+		IdempotencyGuard: g,
+	}
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *idempotencyReq) (*idempotencyResp, error) {
+			if *fail {
+				return nil, errgo.New("failure")
+			}
+			*n++
+			return &idempotencyResp{
+				N:    *n,
+				Name: req.Body.Name,
+			}, nil
+		}),
+	})
+}
+
+func TestIdempotencyGuard(t *testing.T) {
+	c := qt.New(t)
+
+	n := 0
+	fail := false
+	srv := newIdempotencyServer(&httprequest.IdempotencyGuard{
+		Store: new(httprequest.MemIdempotencyStore),
+	}, &n, &fail)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	call := func(key string, name string) (*idempotencyResp, error) {
+		req := &idempotencyReq{}
+		req.Body.Name = name
+		var resp *idempotencyResp
+		var opts []httprequest.CallOption
+		if key != "" {
+			opts = append(opts, httprequest.WithIdempotencyKey(key))
+		}
+		err := client.CallWithOptions(context.Background(), req, &resp, opts...)
+		return resp, err
+	}
+	resp, err := call("k1", "a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{1, "a"})
+
+	// A repeated key returns the original response.
+	resp, err = call("k1", "b")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{1, "a"})
+	c.Assert(n, qt.Equals, 1)
+
+	// A different key is handled as usual.
+	resp, err = call("k2", "c")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{2, "c"})
+
+	// So are requests without a key.
+	resp, err = call("", "d")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{3, "d"})
+	resp, err = call("", "d")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{4, "d"})
+
+	// Server errors are not recorded.
+	fail = true
+	_, err = call("k3", "e")
+	c.Assert(err, qt.ErrorMatches, `.*: failure`)
+	fail = false
+	resp, err = call("k3", "e")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &idempotencyResp{5, "e"})
+}
+
+func TestIdempotencyGuardExpiry(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	clock := func() time.Time {
+		return now
+	}
+	n := 0
+	fail := false
+	srv := newIdempotencyServer(&httprequest.IdempotencyGuard{
+		Store: &httprequest.MemIdempotencyStore{
+			Now: clock,
+		},
+		Expiry: time.Hour,
+		Now:    clock,
+	}, &n, &fail)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		IdempotencyKey: func() string {
+			return "fixed"
+		},
+	}
+	var resp idempotencyResp
+	err := client.Call(context.Background(), &idempotencyReq{}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.N, qt.Equals, 1)
+
+	now = now.Add(59 * time.Minute)
+	err = client.Call(context.Background(), &idempotencyReq{}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.N, qt.Equals, 1)
+
+	now = now.Add(2 * time.Minute)
+	err = client.Call(context.Background(), &idempotencyReq{}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.N, qt.Equals, 2)
+}
+
+func TestIdempotencyKeyInProgress(t *testing.T) {
+	c := qt.New(t)
+
+	store := new(httprequest.MemIdempotencyStore)
+	resp, err := store.Begin(context.Background(), "POST /items k")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.IsNil)
+
+	n := 0
+	fail := false
+	srv := newIdempotencyServer(&httprequest.IdempotencyGuard{
+		Store: store,
+	}, &n, &fail)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err = client.CallWithOptions(context.Background(), &idempotencyReq{}, nil, httprequest.WithIdempotencyKey("k"))
+	c.Assert(err, qt.ErrorMatches, `.*: a request with the same idempotency key is in progress`)
+	c.Assert(errgo.Cause(err).(*httprequest.RemoteError).Code, qt.Equals, httprequest.CodeConflict)
+	c.Assert(n, qt.Equals, 0)
+}
+
+func TestClientIdempotencyKey(t *testing.T) {
+	c := qt.New(t)
+
+	var keys []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		keys = append(keys, req.Header.Get("Idempotency-Key"))
+	}))
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:        srv.URL,
+		IdempotencyKey: httprequest.NewIdempotencyKey,
+	}
+	for _, method := range []string{"GET", "POST", "PUT", "DELETE"} {
+		req, err := http.NewRequest(method, "/", nil)
+		c.Assert(err, qt.Equals, nil)
+		err = client.Do(context.Background(), req, nil)
+		c.Assert(err, qt.Equals, nil)
+	}
+	c.Assert(keys, qt.HasLen, 4)
+	c.Assert(keys[0], qt.Equals, "")
+	c.Assert(keys[1], qt.Matches, uuidPattern)
+	c.Assert(keys[2], qt.Matches, uuidPattern)
+	c.Assert(keys[1], qt.Not(qt.Equals), keys[2])
+	c.Assert(keys[3], qt.Equals, "")
+}
+
+const uuidPattern = `[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}`
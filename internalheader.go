@@ -0,0 +1,102 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrInternalHeader is the cause of the error returned by a Client
+// when a request sets an internal header without permission (see
+// Client.InternalHeaderPrefix).
+var ErrInternalHeader = errgo.New("internal header not allowed")
+
+type internalHeadersKey struct{}
+
+// ContextWithInternalHeaders returns a copy of ctx holding the given
+// internal headers, which are sent by a Client with a matching
+// InternalHeaderPrefix on the calls it makes with the context.
+func ContextWithInternalHeaders(ctx context.Context, h http.Header) context.Context {
+	return context.WithValue(ctx, internalHeadersKey{}, h)
+}
+
+// InternalHeadersFromContext returns the internal headers held in
+// the given context. The context passed to handlers created by a
+// Server with InternalHeaderPrefix set holds the internal headers of
+// a request that came from a trusted proxy, so that a Client with the
+// same prefix propagates them on the calls made by the handler.
+func InternalHeadersFromContext(ctx context.Context) http.Header {
+	h, _ := ctx.Value(internalHeadersKey{}).(http.Header)
+	return h
+}
+
+// hasHeaderPrefix reports whether the header name starts
+// with the given prefix, ignoring case.
+func hasHeaderPrefix(name, prefix string) bool {
+	return len(name) >= len(prefix) && strings.EqualFold(name[:len(prefix)], prefix)
+}
+
+// wrapInternalHeaders returns a handler that removes the headers
+// with the prefix srv.InternalHeaderPrefix from requests that do not
+// come directly from one of srv.TrustedProxies, and records them in
+// the request context otherwise, before calling h.
+func (srv *Server) wrapInternalHeaders(h httprouter.Handle) httprouter.Handle {
+	prefix := srv.InternalHeaderPrefix
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		var internal http.Header
+		for name, vs := range req.Header {
+			if hasHeaderPrefix(name, prefix) {
+				if internal == nil {
+					internal = make(http.Header)
+				}
+				internal[name] = vs
+			}
+		}
+		if internal == nil {
+			h(w, req, p)
+			return
+		}
+		if remoteIP := parseHostIP(req.RemoteAddr); remoteIP != nil && containsIP(srv.TrustedProxies, remoteIP) {
+			h(w, req.WithContext(ContextWithInternalHeaders(req.Context(), internal)), p)
+			return
+		}
+		req1 := *req
+		req1.Header = req.Header.Clone()
+		for name := range internal {
+			delete(req1.Header, name)
+		}
+		h(w, &req1, p)
+	}
+}
+
+// setInternalHeaders checks that req sets no headers with the prefix
+// c.InternalHeaderPrefix unless c.AllowInternalHeaders is set, and
+// adds any such headers held in ctx.
+func (c *Client) setInternalHeaders(ctx context.Context, req *http.Request) error {
+	prefix := c.InternalHeaderPrefix
+	if !c.AllowInternalHeaders {
+		for name := range req.Header {
+			if hasHeaderPrefix(name, prefix) {
+				return errgo.WithCausef(nil, ErrInternalHeader, "request sets internal header %q", name)
+			}
+		}
+	}
+	for name, vs := range InternalHeadersFromContext(ctx) {
+		if !hasHeaderPrefix(name, prefix) {
+			continue
+		}
+		if req.Header == nil {
+			req.Header = make(http.Header)
+		}
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = vs
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type internalHeaderReq struct {
+	httprequest.Route `httprequest:"GET /whoami"`
+	User              string `httprequest:"X-Internal-User,header,omitempty"`
+}
+
+func TestServerInternalHeaderPrefix(t *testing.T) {
+	c := qt.New(t)
+
+	trusted, err := httprequest.ParseCIDRs("10.0.0.1/32")
+	c.Assert(err, qt.IsNil)
+	srv := &httprequest.Server{
+		TrustedProxies:       trusted,
+		InternalHeaderPrefix: "x-internal-",
+	}
+	var ctxHeader http.Header
+	h := srv.Handle(func(p httprequest.Params, req *internalHeaderReq) (string, error) {
+		ctxHeader = httprequest.InternalHeadersFromContext(p.Context)
+		return req.User, nil
+	})
+	router := newHandlerRouter([]httprequest.Handler{h})
+
+	// A request from the gateway keeps its internal headers.
+	req := httptest.NewRequest("GET", "/whoami", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Internal-User", "bob")
+	req.Header.Set("X-Other", "x")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Body.String(), qt.Equals, `"bob"`)
+	c.Assert(ctxHeader, qt.DeepEquals, http.Header{
+		"X-Internal-User": {"bob"},
+	})
+
+	// Others cannot spoof them.
+	ctxHeader = nil
+	req = httptest.NewRequest("GET", "/whoami", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("X-Internal-User", "bob")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Body.String(), qt.Equals, `""`)
+	c.Assert(ctxHeader, qt.IsNil)
+	c.Assert(req.Header.Get("X-Internal-User"), qt.Equals, "bob")
+}
+
+func TestClientInternalHeaderPrefix(t *testing.T) {
+	c := qt.New(t)
+
+	var got http.Header
+	hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = req.Header
+	}))
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:              hsrv.URL,
+		InternalHeaderPrefix: "X-Internal-",
+	}
+	err := client.Call(context.Background(), &internalHeaderReq{User: "alice"}, nil)
+	c.Assert(err, qt.ErrorMatches, `request sets internal header "X-Internal-User"`)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrInternalHeader)
+
+	ctx := httprequest.ContextWithInternalHeaders(context.Background(), http.Header{
+		"X-Internal-User": {"bob"},
+		"X-Unrelated":     {"x"},
+	})
+	err = client.Call(ctx, &internalHeaderReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Get("X-Internal-User"), qt.Equals, "bob")
+	c.Assert(got.Get("X-Unrelated"), qt.Equals, "")
+
+	client.AllowInternalHeaders = true
+	err = client.Call(ctx, &internalHeaderReq{User: "alice"}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got.Get("X-Internal-User"), qt.Equals, "alice")
+}
@@ -0,0 +1,221 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+)
+
+// JSONArrayStream can be returned as the result of a handler (see
+// Server.Handle) to stream a JSON array response an element at a
+// time, so that a large result need not be held in memory. Each
+// element is marshaled as for other JSON results and flushed to the
+// client as soon as it has been written, which makes the server use
+// chunked transfer encoding. See JSONArrayReader for reading such a
+// response.
+//
+// The response status and headers are sent when the first element is
+// written. If Elements returns an error before that, the error is
+// written as an ordinary error response; if it returns an error
+// afterwards, the response is aborted (see http.ErrAbortHandler) so
+// that the client sees that the array is incomplete.
+type JSONArrayStream struct {
+	// Elements writes the elements of the array by calling
+	// write with each of them in turn. It should return any
+	// error returned by write, which happens when an element
+	// cannot be marshaled or sent.
+	Elements func(write func(elem interface{}) error) error
+}
+
+// StreamChannel returns a JSONArrayStream that streams the values
+// received from ch, which must be a channel that can be received from,
+// until it is closed. It panics if ch is not such a channel.
+//
+// If the client goes away, streaming stops and the channel is no
+// longer received from, so the sender should also stop when the
+// request's context is done.
+func StreamChannel(ch interface{}) *JSONArrayStream {
+	return &JSONArrayStream{
+		Elements: channelElements("StreamChannel", ch),
+	}
+}
+
+// channelElements returns a function suitable for use as
+// JSONArrayStream.Elements that writes the values received from ch. The
+// name argument holds the name of the calling function, used in the
+// panic message if ch is not a channel that can be received from.
+func channelElements(name string, ch interface{}) func(write func(interface{}) error) error {
+	chv := reflect.ValueOf(ch)
+	if chv.Kind() != reflect.Chan || chv.Type().ChanDir()&reflect.RecvDir == 0 {
+		panic(errgo.Newf("%s called with %T, not a receivable channel", name, ch))
+	}
+	return func(write func(interface{}) error) error {
+		for {
+			v, ok := chv.Recv()
+			if !ok {
+				return nil
+			}
+			if err := write(v.Interface()); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// jsonArrayFormat holds the format of a JSONArrayStream response.
+var jsonArrayFormat = streamFormat{
+	contentType: "application/json",
+	open:        "[",
+	sep:         ",",
+	close:       "]",
+}
+
+// write writes s as a response with the given status code.
+func (s *JSONArrayStream) write(srv *Server, w http.ResponseWriter, code int) error {
+	return writeStream(srv, w, code, s.Elements, jsonArrayFormat)
+}
+
+// streamFormat describes how the elements of a streamed response are
+// delimited.
+type streamFormat struct {
+	// contentType holds the content type of the response.
+	contentType string
+
+	// open and close hold the text written before the first
+	// element and after the last.
+	open, close string
+
+	// sep holds the text written between elements.
+	sep string
+
+	// term holds the text written after each element.
+	term string
+}
+
+// writeStream writes a response with the given status code holding
+// the elements written by the elements function, marshaled as JSON and
+// delimited as specified by f. Each element is flushed to the client
+// as soon as it has been written. See JSONArrayStream for how errors
+// are handled.
+func writeStream(srv *Server, w http.ResponseWriter, code int, elements func(func(interface{}) error) error, f streamFormat) error {
+	bw := &thresholdWriter{
+		w:    w,
+		code: code,
+		setHeader: func() {
+			w.Header().Set("Content-Type", f.contentType)
+		},
+	}
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	write := func(elem interface{}) error {
+		data, err := srv.TimeFormat.marshal(elem, srv.JSON)
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal element %d", n)
+		}
+		if n == 0 {
+			bw.Write([]byte(f.open))
+		} else {
+			bw.Write([]byte(f.sep))
+		}
+		if _, err := bw.Write(data); err != nil {
+			return errgo.Mask(err)
+		}
+		bw.Write([]byte(f.term))
+		n++
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	}
+	if elements != nil {
+		if err := elements(write); err != nil {
+			if !bw.committed {
+				return errgo.Mask(err, errgo.Any)
+			}
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if n == 0 {
+		bw.Write([]byte(f.open))
+	}
+	bw.Write([]byte(f.close))
+	bw.finish()
+	return nil
+}
+
+// JSONArrayReader reads the elements of a JSON array one at a time,
+// as streamed by a JSONArrayStream, without reading the whole array
+// into memory. For example:
+//
+//	var resp *http.Response
+//	if err := client.Call(ctx, req, &resp); err != nil {
+//		return err
+//	}
+//	defer resp.Body.Close()
+//	r := httprequest.NewJSONArrayReader(resp.Body)
+//	var item Item
+//	for r.Next(&item) {
+//		...
+//	}
+//	if err := r.Err(); err != nil {
+//		return err
+//	}
+type JSONArrayReader struct {
+	dec     *json.Decoder
+	started bool
+	done    bool
+	err     error
+}
+
+// NewJSONArrayReader returns a JSONArrayReader that reads a JSON
+// array from r.
+func NewJSONArrayReader(r io.Reader) *JSONArrayReader {
+	return &JSONArrayReader{
+		dec: json.NewDecoder(r),
+	}
+}
+
+// Next unmarshals the next element of the array into v and reports
+// whether there was one. It returns false at the end of the array or
+// when there is an error, which is returned by Err.
+func (r *JSONArrayReader) Next(v interface{}) bool {
+	if r.done || r.err != nil {
+		return false
+	}
+	if !r.started {
+		r.started = true
+		tok, err := r.dec.Token()
+		if err != nil {
+			r.err = errgo.Notef(err, "cannot read JSON array")
+			return false
+		}
+		if tok != json.Delim('[') {
+			r.err = errgo.Newf("unexpected %v at start of JSON array", tok)
+			return false
+		}
+	}
+	if !r.dec.More() {
+		if _, err := r.dec.Token(); err != nil {
+			r.err = errgo.Notef(err, "cannot read end of JSON array")
+			return false
+		}
+		r.done = true
+		return false
+	}
+	if err := r.dec.Decode(v); err != nil {
+		r.err = errgo.Notef(err, "cannot unmarshal JSON array element")
+		return false
+	}
+	return true
+}
+
+// Err returns the error, if any, that caused Next to return false.
+func (r *JSONArrayReader) Err() error {
+	return r.err
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type streamReq struct {
+	httprequest.Route `httprequest:"GET /items/:kind"`
+	Kind              string `httprequest:"kind,path"`
+}
+
+type streamItem struct {
+	N int `json:"n"`
+}
+
+func newStreamServer() *httptest.Server {
+	var srv httprequest.Server
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *streamReq) (*httprequest.JSONArrayStream, error) {
+			switch req.Kind {
+			case "channel":
+				ch := make(chan streamItem)
+				go func() {
+					defer close(ch)
+					for i := 0; i < 3; i++ {
+						select {
+						case ch <- streamItem{N: i}:
+						case <-p.Context.Done():
+							return
+						}
+					}
+				}()
+				return httprequest.StreamChannel(ch), nil
+			case "empty":
+				return &httprequest.JSONArrayStream{}, nil
+			case "early-error":
+				return &httprequest.JSONArrayStream{
+					Elements: func(write func(interface{}) error) error {
+						return httprequest.Errorf(httprequest.CodeNotFound, "no items")
+					},
+				}, nil
+			case "late-error":
+				return &httprequest.JSONArrayStream{
+					Elements: func(write func(interface{}) error) error {
+						if err := write(streamItem{N: 1}); err != nil {
+							return err
+						}
+						return errgo.New("failed half way")
+					},
+				}, nil
+			}
+			return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unknown kind")
+		}),
+	})
+}
+
+func TestJSONArrayStream(t *testing.T) {
+	c := qt.New(t)
+	srv := newStreamServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/items/channel")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(resp.TransferEncoding, qt.DeepEquals, []string{"chunked"})
+	body, err := readResponseBody(resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `[{"n":0},{"n":1},{"n":2}]`)
+}
+
+func TestJSONArrayStreamEmpty(t *testing.T) {
+	c := qt.New(t)
+	srv := newStreamServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/items/empty")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	body, err := readResponseBody(resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `[]`)
+}
+
+func TestJSONArrayStreamEarlyError(t *testing.T) {
+	c := qt.New(t)
+	srv := newStreamServer()
+	defer srv.Close()
+
+	// An error before any element has been written is returned as
+	// an ordinary error response.
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp *http.Response
+	err := client.Call(context.Background(), &streamReq{Kind: "early-error"}, &resp)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Message: "no items",
+		Code:    httprequest.CodeNotFound,
+	})
+}
+
+func TestJSONArrayReader(t *testing.T) {
+	c := qt.New(t)
+	srv := newStreamServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp *http.Response
+	err := client.Call(context.Background(), &streamReq{Kind: "channel"}, &resp)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	r := httprequest.NewJSONArrayReader(resp.Body)
+	var items []streamItem
+	var item streamItem
+	for r.Next(&item) {
+		items = append(items, item)
+	}
+	c.Assert(r.Err(), qt.IsNil)
+	c.Assert(items, qt.DeepEquals, []streamItem{{0}, {1}, {2}})
+
+	// Next continues to return false at the end of the array.
+	c.Assert(r.Next(&item), qt.Equals, false)
+	c.Assert(r.Err(), qt.IsNil)
+}
+
+func TestJSONArrayReaderTruncated(t *testing.T) {
+	c := qt.New(t)
+	srv := newStreamServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp *http.Response
+	err := client.Call(context.Background(), &streamReq{Kind: "late-error"}, &resp)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	r := httprequest.NewJSONArrayReader(resp.Body)
+	var item streamItem
+	c.Assert(r.Next(&item), qt.Equals, true)
+	c.Assert(item, qt.Equals, streamItem{1})
+	c.Assert(r.Next(&item), qt.Equals, false)
+	c.Assert(r.Err(), qt.ErrorMatches, `cannot .*: unexpected EOF`)
+}
+
+var jsonArrayReaderErrorTests = []struct {
+	about       string
+	body        string
+	expectError string
+}{{
+	about:       "not an array",
+	body:        `{"n":1}`,
+	expectError: `unexpected \{ at start of JSON array`,
+}, {
+	about:       "empty body",
+	body:        ``,
+	expectError: `cannot read JSON array: EOF`,
+}, {
+	about:       "bad element",
+	body:        `[{"n":"x"}]`,
+	expectError: `cannot unmarshal JSON array element: json: cannot unmarshal string into Go .* of type int`,
+}}
+
+func TestJSONArrayReaderError(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range jsonArrayReaderErrorTests {
+		c.Run(test.about, func(c *qt.C) {
+			r := httprequest.NewJSONArrayReader(strings.NewReader(test.body))
+			var item streamItem
+			c.Assert(r.Next(&item), qt.Equals, false)
+			c.Assert(r.Err(), qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+func TestStreamChannelBadChannel(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(func() {
+		httprequest.StreamChannel(make(chan<- int))
+	}, qt.PanicMatches, `StreamChannel called with chan<- int, not a receivable channel`)
+	c.Assert(func() {
+		httprequest.StreamChannel(1)
+	}, qt.PanicMatches, `StreamChannel called with int, not a receivable channel`)
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/httprequest.v1/tags"
+)
+
+const (
+	deprecationHeader = "Deprecation"
+	sunsetHeader      = "Sunset"
+)
+
+// Lifecycle describes the deprecation of an endpoint as conveyed by
+// the Deprecation, Sunset (RFC 8594) and Link headers of its
+// responses.
+//
+// A route can be given a lifecycle with the deprecated, sunset and
+// deprecationlink tags on its Route field, for example:
+//
+//	httprequest.Route `httprequest:"GET /v1/things" deprecated:"2024-01-01" sunset:"2025-01-01" deprecationlink:"https://example.com/v2"`
+//
+// The dates are in the form "2006-01-02" or in RFC 3339 format. The
+// deprecated tag may also be "true" when the date of deprecation is
+// not known. All responses from such a route include the
+// corresponding headers.
+type Lifecycle struct {
+	// Deprecated holds whether the endpoint is deprecated.
+	Deprecated bool
+
+	// DeprecatedAt holds when the endpoint was or will be
+	// deprecated, if known.
+	DeprecatedAt time.Time
+
+	// Sunset holds when the endpoint is expected to stop
+	// responding, if known.
+	Sunset time.Time
+
+	// Link holds the URL of a document describing the
+	// deprecation, if any. It is sent as a Link header with
+	// rel="deprecation".
+	Link string
+}
+
+// IsZero reports whether l holds no lifecycle information.
+func (l Lifecycle) IsZero() bool {
+	return !l.Deprecated && l.Sunset.IsZero() && l.Link == ""
+}
+
+// SetLifecycleHeader sets the headers in h that describe l. It may
+// be used by handlers and middleware to mark responses that are not
+// covered by route tags, for example:
+//
+//	httprequest.SetLifecycleHeader(p.Response.Header(), httprequest.Lifecycle{
+//		Deprecated: true,
+//	})
+func SetLifecycleHeader(h http.Header, l Lifecycle) {
+	if l.Deprecated {
+		if l.DeprecatedAt.IsZero() {
+			h.Set(deprecationHeader, "true")
+		} else {
+			h.Set(deprecationHeader, "@"+strconv.FormatInt(l.DeprecatedAt.Unix(), 10))
+		}
+	}
+	if !l.Sunset.IsZero() {
+		h.Set(sunsetHeader, l.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if l.Link != "" {
+		h.Add("Link", "<"+l.Link+`>; rel="deprecation"`)
+	}
+}
+
+// ParseLifecycleHeader returns the lifecycle information in the
+// given response headers. It reports false if there is none.
+// Unparsable dates are ignored.
+func ParseLifecycleHeader(h http.Header) (Lifecycle, bool) {
+	var l Lifecycle
+	if v := strings.TrimSpace(h.Get(deprecationHeader)); v != "" && v != "false" {
+		l.Deprecated = true
+		if strings.HasPrefix(v, "@") {
+			if secs, err := strconv.ParseInt(v[1:], 10, 64); err == nil {
+				l.DeprecatedAt = time.Unix(secs, 0).UTC()
+			}
+		} else if t, err := http.ParseTime(v); err == nil {
+			l.DeprecatedAt = t
+		}
+	}
+	if v := h.Get(sunsetHeader); v != "" {
+		if t, err := http.ParseTime(v); err == nil {
+			l.Sunset = t
+		}
+	}
+	for _, v := range h.Values("Link") {
+		if link := linkWithRel(v, "deprecation"); link != "" {
+			l.Link = link
+			break
+		}
+	}
+	return l, !l.IsZero()
+}
+
+// linkWithRel returns the target of the first link in the given
+// Link header value that has the given relation type, or the empty
+// string if there is none.
+func linkWithRel(v, rel string) string {
+	for _, link := range strings.Split(v, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "rel=") {
+				continue
+			}
+			for _, r := range strings.Fields(strings.Trim(param[len("rel="):], `"`)) {
+				if strings.EqualFold(r, rel) {
+					return target[1 : len(target)-1]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// routeLifecycle returns the lifecycle specified by the deprecated,
+// sunset and deprecationlink tags of a route, or nil if there are none.
+func routeLifecycle(r tags.Route) *Lifecycle {
+	l := Lifecycle{
+		Deprecated:   r.Deprecated,
+		DeprecatedAt: r.DeprecatedAt,
+		Sunset:       r.Sunset,
+		Link:         r.DeprecationLink,
+	}
+	if l.IsZero() {
+		return nil
+	}
+	return &l
+}
+
+// wrapLifecycle returns a handler that sets the headers describing
+// l on every response before calling h.
+func wrapLifecycle(l Lifecycle, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		SetLifecycleHeader(w.Header(), l)
+		h(w, req, p)
+	}
+}
+
+// notifyLifecycle calls c.OnDeprecation if resp carries any
+// lifecycle headers.
+func (c *Client) notifyLifecycle(req *http.Request, resp *http.Response) {
+	if c.OnDeprecation == nil {
+		return
+	}
+	if l, ok := ParseLifecycleHeader(resp.Header); ok {
+		c.OnDeprecation(req, l)
+	}
+}
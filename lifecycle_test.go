@@ -0,0 +1,156 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type deprecatedReq struct {
+	httprequest.Route `httprequest:"GET /v1/things" deprecated:"2024-01-01" sunset:"2025-06-30T12:00:00Z" deprecationlink:"https://example.com/v2"`
+}
+
+type currentReq struct {
+	httprequest.Route `httprequest:"GET /v2/things"`
+}
+
+func TestLifecycleRouteTags(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	hsrv := newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *deprecatedReq) (string, error) {
+			return "old", nil
+		}),
+		srv.Handle(func(p httprequest.Params, req *currentReq) (string, error) {
+			return "new", nil
+		}),
+	})
+	defer hsrv.Close()
+
+	var got []httprequest.Lifecycle
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+		OnDeprecation: func(req *http.Request, l httprequest.Lifecycle) {
+			c.Check(req.URL.Path, qt.Equals, "/v1/things")
+			got = append(got, l)
+		},
+	}
+	var s string
+	err := client.Call(context.Background(), &deprecatedReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "old")
+	c.Assert(got, qt.DeepEquals, []httprequest.Lifecycle{{
+		Deprecated:   true,
+		DeprecatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:       time.Date(2025, 6, 30, 12, 0, 0, 0, time.UTC),
+		Link:         "https://example.com/v2",
+	}})
+
+	err = client.Call(context.Background(), &currentReq{}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "new")
+	c.Assert(got, qt.HasLen, 1)
+}
+
+func TestLifecycleHeaderFromHandler(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, req *currentReq) error {
+		httprequest.SetLifecycleHeader(p.Response.Header(), httprequest.Lifecycle{
+			Deprecated: true,
+		})
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/v2/things", nil), nil)
+	c.Assert(rec.Header().Get("Deprecation"), qt.Equals, "true")
+	l, ok := httprequest.ParseLifecycleHeader(rec.Header())
+	c.Assert(ok, qt.IsTrue)
+	c.Assert(l, qt.DeepEquals, httprequest.Lifecycle{Deprecated: true})
+}
+
+func TestServerEndpointLifecycle(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	e := srv.Endpoint(func(p httprequest.Params, req *deprecatedReq) error {
+		return nil
+	})
+	c.Assert(e.Lifecycle, qt.Not(qt.IsNil))
+	c.Assert(e.Lifecycle.Deprecated, qt.IsTrue)
+}
+
+var parseLifecycleHeaderTests = []struct {
+	about  string
+	header http.Header
+	expect httprequest.Lifecycle
+	ok     bool
+}{{
+	about:  "no headers",
+	header: http.Header{},
+}, {
+	about: "structured date",
+	header: http.Header{
+		"Deprecation": {"@1688169599"},
+	},
+	expect: httprequest.Lifecycle{
+		Deprecated:   true,
+		DeprecatedAt: time.Unix(1688169599, 0).UTC(),
+	},
+	ok: true,
+}, {
+	about: "http date and sunset",
+	header: http.Header{
+		"Deprecation": {"Sun, 11 Nov 2018 23:59:59 GMT"},
+		"Sunset":      {"Wed, 11 Nov 2020 23:59:59 GMT"},
+	},
+	expect: httprequest.Lifecycle{
+		Deprecated:   true,
+		DeprecatedAt: time.Date(2018, 11, 11, 23, 59, 59, 0, time.UTC),
+		Sunset:       time.Date(2020, 11, 11, 23, 59, 59, 0, time.UTC),
+	},
+	ok: true,
+}, {
+	about: "link among others",
+	header: http.Header{
+		"Link": {`<https://example.com/next>; rel="next", <https://example.com/dep>; rel="deprecation"`},
+	},
+	expect: httprequest.Lifecycle{
+		Link: "https://example.com/dep",
+	},
+	ok: true,
+}}
+
+func TestParseLifecycleHeader(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseLifecycleHeaderTests {
+		c.Run(test.about, func(c *qt.C) {
+			l, ok := httprequest.ParseLifecycleHeader(test.header)
+			c.Assert(ok, qt.Equals, test.ok)
+			c.Assert(l, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestLifecycleBadTag(t *testing.T) {
+	c := qt.New(t)
+	srv := &httprequest.Server{}
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, req *struct {
+			httprequest.Route `httprequest:"GET /x" sunset:"soon"`
+		}) error {
+			return nil
+		})
+	}, qt.PanicMatches, `bad handler function: last argument cannot be used for Unmarshal: bad route tag .*: bad sunset tag "soon"`)
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// Logger is used by a Server to log the requests made to the handlers
+// it creates.
+type Logger interface {
+	// LogRequest is called when a request has been handled.
+	// The context is that of the request.
+	LogRequest(ctx context.Context, entry *RequestLogEntry)
+}
+
+// LoggerFunc implements Logger by calling the function.
+type LoggerFunc func(ctx context.Context, entry *RequestLogEntry)
+
+// LogRequest implements Logger.LogRequest by calling f.
+func (f LoggerFunc) LogRequest(ctx context.Context, entry *RequestLogEntry) {
+	f(ctx, entry)
+}
+
+// RequestLogEntry holds information about a request
+// that has been handled.
+type RequestLogEntry struct {
+	// Method holds the HTTP method of the request.
+	Method string
+
+	// PathPattern holds the path pattern of the route
+	// that handled the request (for example "/items/:id").
+	PathPattern string
+
+	// Status holds the HTTP status code of the response.
+	Status int
+
+	// Latency holds the time taken to handle the request.
+	Latency time.Duration
+
+	// RequestID holds the ID of the request (see Server.RequestID)
+	// or, if it has none, the value of the X-Request-ID header
+	// of the request, if any.
+	RequestID string
+
+	// TraceID and SpanID hold the trace and span IDs of the
+	// request (see Server.TraceContext), if known.
+	TraceID string
+	SpanID  string
+
+	// Error holds the error that was written as the response,
+	// if any. The Status field holds the status it was mapped to.
+	Error error
+}
+
+// requestLogKey is the context key for the log entry
+// of the current request.
+type requestLogKey struct{}
+
+// wrapLogger returns a handler that logs requests to h
+// with srv.Logger.
+func (srv *Server) wrapLogger(method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		start := time.Now()
+		entry := &RequestLogEntry{
+			Method:      method,
+			PathPattern: pathPattern,
+			RequestID:   RequestIDFromContext(req.Context()),
+		}
+		if entry.RequestID == "" {
+			entry.RequestID = req.Header.Get(requestIDHeader)
+		}
+		if tc, ok := TraceContextFromContext(req.Context()); ok {
+			entry.TraceID, entry.SpanID = tc.TraceID, tc.SpanID
+		}
+		w1 := &statusResponseWriter{
+			ResponseWriter: w,
+		}
+		ctx := context.WithValue(req.Context(), requestLogKey{}, entry)
+		req = req.WithContext(ctx)
+		defer func() {
+			entry.Status = w1.status
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			entry.Latency = time.Since(start)
+			srv.Logger.LogRequest(ctx, entry)
+		}()
+		h(w1, req, p)
+	}
+}
+
+// logError records err in the log entry held in ctx, if any.
+func logError(ctx context.Context, err error) {
+	if entry, _ := ctx.Value(requestLogKey{}).(*RequestLogEntry); entry != nil && entry.Error == nil {
+		entry.Error = err
+	}
+}
+
+// statusResponseWriter wraps an http.ResponseWriter and records
+// the status of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && !isInformational(code) {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher.Flush.
+func (w *statusResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestServerLogger(t *testing.T) {
+	c := qt.New(t)
+
+	var entries []*httprequest.RequestLogEntry
+	srv := httprequest.Server{
+		Logger: httprequest.LoggerFunc(func(ctx context.Context, entry *httprequest.RequestLogEntry) {
+			entries = append(entries, entry)
+		}),
+	}
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"GET /items/:id"`
+		}) (string, error) {
+			return "ok", nil
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"DELETE /items/:id"`
+		}) error {
+			return httprequest.Errorf(httprequest.CodeNotFound, "item not found")
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"POST /items"`
+		}) error {
+			p.Response.WriteHeader(http.StatusAccepted)
+			return nil
+		}),
+	})
+
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/items/2", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/items", nil))
+
+	c.Assert(entries, qt.HasLen, 3)
+	for _, e := range entries {
+		c.Assert(e.Latency > 0, qt.Equals, true)
+		e.Latency = 0
+	}
+	c.Assert(entries[0], qt.DeepEquals, &httprequest.RequestLogEntry{
+		Method:      "GET",
+		PathPattern: "/items/:id",
+		Status:      http.StatusOK,
+		RequestID:   "req-1",
+	})
+	c.Assert(entries[1].Error, qt.ErrorMatches, "item not found")
+	c.Assert(errgo.Cause(entries[1].Error).(*httprequest.RemoteError).Code, qt.Equals, httprequest.CodeNotFound)
+	entries[1].Error = nil
+	c.Assert(entries[1], qt.DeepEquals, &httprequest.RequestLogEntry{
+		Method:      "DELETE",
+		PathPattern: "/items/:id",
+		Status:      http.StatusNotFound,
+	})
+	c.Assert(entries[2], qt.DeepEquals, &httprequest.RequestLogEntry{
+		Method:      "POST",
+		PathPattern: "/items",
+		Status:      http.StatusAccepted,
+	})
+}
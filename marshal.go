@@ -42,8 +42,22 @@ import (
 // be used directly; otherwise if implements encoding.TextMarshaler, that
 // will be used to marshal the field, otherwise fmt.Sprint will be used.
 //
-// An "omitempty" attribute on a form or header field specifies that
-// if the form or header value is zero, the form or header entry
+// A time.Time field with a "format" tag (see Unmarshal) will be
+// marshaled using the layout specified by the tag.
+//
+// A cookie field is marshaled as a cookie in the Cookie header of
+// the request.
+//
+// A form, header or cookie field whose marshaled value is equal to the
+// value of its "default" tag (see Unmarshal) is omitted, as the server
+// will use that value anyway.
+//
+// A "priority" tag on the Route field, for example `priority:"u=1, i"`,
+// specifies the value of the Priority header of the request (see
+// Priority).
+//
+// An "omitempty" attribute on a form, header or cookie field specifies
+// that if the value is zero, the form, header or cookie entry
 // will be omitted. If the field is a nil pointer, it will be omitted;
 // otherwise if the field type implements IsZeroer, that method
 // will be used to determine whether the value is zero, otherwise
@@ -51,6 +65,15 @@ import (
 // value for its type, otherwise the value will never be omitted.
 // One notable implementation of IsZeroer is time.Time.
 //
+// A "map" attribute on a form field specifies that the entries of the
+// field (a map as described in Unmarshal) will be marshaled as
+// individual form values. Entries with the same name as another form
+// field in x are ignored.
+//
+// A field with the "apikey" attribute is marshaled as an ordinary
+// string field; Client.Call fills it in from Client.APIKey when it
+// is empty.
+//
 // An "inbody" attribute on a form field specifies that the field will
 // be marshaled as part of an application/x-www-form-urlencoded body.
 // Note that the field may still be unmarshaled from either a URL query
@@ -83,9 +106,22 @@ import (
 // http://example.com/users/bob/details?context=1234 and a JSON-encoded
 // body holding `{"Age":36}`.
 //
+// Query parameters are added to any query already present in baseURL
+// and are ordered by name. Use CanonicalQuery (or set
+// Client.CanonicalQuery) when an exact canonical form is required.
+//
 // It is an error if there is a field specified in the URL that is not
 // found in x.
 func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
+	req, _, err := marshalRequest(baseURL, method, x, nil)
+	return req, err
+}
+
+// marshalRequest is the internal version of Marshal. If pool is
+// non-nil, a JSON body is marshaled into a buffer from it, and the
+// returned pooledBody, which must be released when the request has
+// been made, holds the body. Otherwise it is nil.
+func marshalRequest(baseURL, method string, x interface{}, pool *BufferPool) (*http.Request, *pooledBody, error) {
 	var xv reflect.Value
 	if ch, ok := x.(*CustomHeader); ok {
 		xv = reflect.ValueOf(ch.Body)
@@ -94,11 +130,11 @@ func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
 	}
 	pt, err := getRequestType(xv.Type())
 	if err != nil {
-		return nil, errgo.WithCausef(err, ErrBadUnmarshalType, "bad type %s", xv.Type())
+		return nil, nil, errgo.WithCausef(err, ErrBadUnmarshalType, "bad type %s", xv.Type())
 	}
 	req, err := http.NewRequest(method, baseURL, BytesReaderCloser{bytes.NewReader(nil)})
 	if err != nil {
-		return nil, errgo.Mask(err)
+		return nil, nil, errgo.Mask(err)
 	}
 	req.GetBody = func() (io.ReadCloser, error) { return BytesReaderCloser{bytes.NewReader(nil)}, nil }
 	req.Form = url.Values{}
@@ -110,10 +146,14 @@ func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
 		req.PostForm = url.Values{}
 	}
 	p := &Params{
-		Request: req,
+		Request:    req,
+		bufferPool: pool,
 	}
 	if err := marshal(p, xv, pt); err != nil {
-		return nil, errgo.Mask(err, errgo.Is(ErrUnmarshal))
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrUnmarshal))
+	}
+	if pt.priority != "" {
+		p.Request.Header.Set(priorityHeader, pt.priority)
 	}
 	if pt.formBody {
 		data := []byte(req.PostForm.Encode())
@@ -126,13 +166,14 @@ func Marshal(baseURL, method string, x interface{}) (*http.Request, error) {
 	if headerSetter, ok := x.(HeaderSetter); ok {
 		headerSetter.SetHeader(p.Request.Header)
 	}
-	return p.Request, nil
+	return p.Request, p.body, nil
 }
 
 // marshal is the internal version of Marshal.
 func marshal(p *Params, xv reflect.Value, pt *requestType) error {
 	xv = xv.Elem()
-	for _, f := range pt.fields {
+	for i := range pt.fields {
+		f := &pt.fields[i]
 		fv := xv.FieldByIndex(f.index)
 		if f.isPointer {
 			if fv.IsNil() {
@@ -146,7 +187,11 @@ func marshal(p *Params, xv reflect.Value, pt *requestType) error {
 			return errgo.WithCausef(err, ErrUnmarshal, "cannot marshal field")
 		}
 	}
-	path, err := buildPath(p.Request.URL.Path, p.PathVar)
+	path := p.Request.URL.Path
+	if pt.optionalParam != "" && p.PathVar.ByName(pt.optionalParam) == "" {
+		path = trimOptionalParam(path, pt.optionalParam)
+	}
+	path, err := buildPath(path, p.PathVar)
 	if err != nil {
 		return errgo.Mask(err)
 	}
@@ -216,14 +261,25 @@ func nextPathSegment(s string) (string, string) {
 // a field with the given tag into an HTTP request.
 func getMarshaler(tag tag, t reflect.Type) (marshaler, error) {
 	switch {
-	case tag.source == sourceNone:
+	case tag.source == sourceNone, tag.source == sourceClientIP:
 		return marshalNop, nil
+	case tag.source == sourceBody && tag.mergePatch:
+		return marshalMergePatch, nil
+	case tag.source == sourceBody && t == ndjsonReaderType:
+		return marshalNDJSONBody, nil
 	case tag.source == sourceBody:
 		return marshalBody, nil
+	case tag.relPath:
+		return marshalRelPath(tag), nil
+	case tag.timeFormat != "":
+		if t != timeType {
+			return nil, errgo.Newf("format tag specified on non-time type %s", t)
+		}
+		return marshalTimeWithFormat(tag), nil
 	case t == reflect.TypeOf([]string(nil)):
 		switch tag.source {
 		default:
-			return nil, errgo.New("invalid target type []string for path parameter")
+			return nil, errgo.Newf("invalid target type []string for %s parameter", tag.source)
 		case sourceForm:
 			return marshalAllForm(tag.name), nil
 		case sourceFormBody:
@@ -247,18 +303,46 @@ func marshalNop(v reflect.Value, p *Params) error {
 
 // marshalBody marshals the specified value into the body of the http request.
 func marshalBody(v reflect.Value, p *Params) error {
+	if p.bufferPool != nil {
+		return marshalPooledBody(v, p)
+	}
 	// TODO allow body types that aren't necessarily JSON.
 	data, err := json.Marshal(v.Addr().Interface())
 	if err != nil {
 		return errgo.Notef(err, "cannot marshal request body")
 	}
-	p.Request.Body = BytesReaderCloser{bytes.NewReader(data)}
-	p.Request.GetBody = func() (io.ReadCloser, error) { return BytesReaderCloser{bytes.NewReader(data)}, nil }
-	p.Request.ContentLength = int64(len(data))
+	setJSONBody(p.Request, data)
+	return nil
+}
+
+// marshalPooledBody is like marshalBody except that the value is
+// marshaled into a buffer from p.bufferPool.
+func marshalPooledBody(v reflect.Value, p *Params) error {
+	buf := p.bufferPool.get()
+	if err := (*TimeFormat)(nil).marshalTo(buf, v.Addr().Interface(), nil); err != nil {
+		p.bufferPool.put(buf)
+		return errgo.Notef(err, "cannot marshal request body")
+	}
+	p.body = newPooledBody(p.bufferPool, buf)
+	body, err := p.body.reader()
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	p.Request.Body = body
+	p.Request.GetBody = p.body.reader
+	p.Request.ContentLength = int64(buf.Len())
 	p.Request.Header.Set("Content-Type", "application/json")
 	return nil
 }
 
+// setJSONBody sets the body of req to the given JSON data.
+func setJSONBody(req *http.Request, data []byte) {
+	req.Body = BytesReaderCloser{bytes.NewReader(data)}
+	req.GetBody = func() (io.ReadCloser, error) { return BytesReaderCloser{bytes.NewReader(data)}, nil }
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+}
+
 // marshalAllForm marshals a []string slice into form fields.
 func marshalAllForm(name string) marshaler {
 	return func(v reflect.Value, p *Params) error {
@@ -289,6 +373,35 @@ func marshalAllHeader(name string) marshaler {
 	}
 }
 
+// marshalFormMap marshals a form map field into form fields,
+// ignoring any keys that are bound to other fields.
+func marshalFormMap(source tagSource, bound map[string]bool) marshaler {
+	return func(v reflect.Value, p *Params) error {
+		iter := v.MapRange()
+		for iter.Next() {
+			name := iter.Key().String()
+			if bound[name] {
+				continue
+			}
+			var vals []string
+			if val := iter.Value(); val.Kind() == reflect.String {
+				vals = []string{val.String()}
+			} else {
+				vals = val.Convert(reflect.TypeOf([]string(nil))).Interface().([]string)
+			}
+			if len(vals) == 0 {
+				continue
+			}
+			if source == sourceFormBody {
+				p.Request.PostForm[name] = vals
+			} else {
+				p.Request.Form[name] = vals
+			}
+		}
+		return nil
+	}
+}
+
 // marshalString marshals s string field.
 func marshalString(tag tag) marshaler {
 	formSet := formSetter(tag)
@@ -395,13 +508,18 @@ func formSetter(t tag) func(name, value string, p *Params) {
 	if formSet == nil {
 		panic("unexpected source")
 	}
-	if !t.omitempty {
+	if !t.omitempty && t.defaultValue == "" {
 		return formSet
 	}
 	return func(name, value string, p *Params) {
-		if value != "" {
-			formSet(name, value, p)
+		if t.omitempty && value == "" {
+			return
 		}
+		if t.defaultValue != "" && value == t.defaultValue {
+			// The server will use the default value anyway.
+			return
+		}
+		formSet(name, value, p)
 	}
 }
 
@@ -421,6 +539,12 @@ var formSetters = []func(string, string, *Params){
 	sourceHeader: func(name, value string, p *Params) {
 		p.Request.Header.Set(name, value)
 	},
+	sourceCookie: func(name, value string, p *Params) {
+		p.Request.AddCookie(&http.Cookie{
+			Name:  name,
+			Value: value,
+		})
+	},
 }
 
 // BytesReaderCloser is a bytes.Reader which
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"testing"
 	"time"
 
@@ -216,14 +217,14 @@ var marshalTests = []struct {
 	val: &struct {
 		Body string `httprequest:",body,omitempty"`
 	}{},
-	expectError: `bad type \*struct { Body string "httprequest:\\",body,omitempty\\"" }: bad tag "httprequest:\\",body,omitempty\\"" in field Body: can only use omitempty with form or header fields`,
+	expectError: `bad type \*struct { Body string "httprequest:\\",body,omitempty\\"" }: bad tag "httprequest:\\",body,omitempty\\"" in field Body: can only use omitempty with form, header or cookie fields`,
 }, {
 	about:     "omitempty on path",
 	urlString: "http://localhost:8081/:Users",
 	val: &struct {
 		Users string `httprequest:",path,omitempty"`
 	}{},
-	expectError: `bad type \*struct { Users string "httprequest:\\",path,omitempty\\"" }: bad tag "httprequest:\\",path,omitempty\\"" in field Users: can only use omitempty with form or header fields`,
+	expectError: `bad type \*struct { Users string "httprequest:\\",path,omitempty\\"" }: bad tag "httprequest:\\",path,omitempty\\"" in field Users: can only use omitempty with form, header or cookie fields`,
 }, {
 	about:     "more than one field with body tag",
 	urlString: "http://localhost:8081/user",
@@ -450,6 +451,59 @@ var marshalTests = []struct {
 	},
 	expectURLString: "http://localhost:8081/99?F2=some+text",
 	expectHeader:    http.Header{"F3": []string{"A", "B", "C"}},
+}, {
+	about:     "struct with form map",
+	urlString: "http://localhost:8081/",
+	val: &struct {
+		A string            `httprequest:"a,form"`
+		B map[string]string `httprequest:",form,map"`
+	}{
+		A: "a1",
+		B: map[string]string{
+			"a": "ignored",
+			"b": "b1",
+			"c": "c1",
+		},
+	},
+	expectURLString: "http://localhost:8081/?a=a1&b=b1&c=c1",
+}, {
+	about:     "struct with form map in body",
+	urlString: "http://localhost:8081/",
+	method:    "POST",
+	val: &struct {
+		A url.Values `httprequest:",form,inbody,map"`
+	}{
+		A: url.Values{
+			"a": {"a1", "a2"},
+		},
+	},
+	expectURLString: "http://localhost:8081/",
+	expectBody:      newString("a=a1&a=a2"),
+	expectHeader: http.Header{
+		"Content-Type": {"application/x-www-form-urlencoded"},
+	},
+}, {
+	about:     "time fields with format",
+	urlString: "http://localhost:8081/:C",
+	val: &struct {
+		A time.Time  `httprequest:"a,form" format:"date"`
+		B time.Time  `httprequest:"b,header" format:"unix"`
+		C *time.Time `httprequest:"C,path" format:"unixmilli"`
+		D time.Time  `httprequest:"d,form,omitempty" format:"date"`
+		E time.Time  `httprequest:"e,form" format:"http"`
+	}{
+		A: time.Date(2020, 5, 6, 1, 2, 3, 0, time.UTC),
+		B: time.Date(2001, 9, 9, 1, 46, 40, 0, time.UTC),
+		C: func() *time.Time {
+			t := time.Date(2001, 9, 9, 1, 46, 40, 123e6, time.UTC)
+			return &t
+		}(),
+		E: time.Date(2020, 5, 6, 1, 2, 3, 0, time.UTC),
+	},
+	expectURLString: "http://localhost:8081/1000000000123?a=2020-05-06&e=Wed%2C+06+May+2020+01%3A02%3A03+GMT",
+	expectHeader: http.Header{
+		"B": {"1000000000"},
+	},
 }, {
 	about:     "SetHeader called after marshaling",
 	urlString: "http://localhost:8081/",
@@ -0,0 +1,118 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// mergePatchContentType holds the media type of JSON merge
+// patches (see RFC 7396).
+const mergePatchContentType = "application/merge-patch+json"
+
+// PatchFields holds the set of fields present in a JSON merge patch
+// (see RFC 7396), keyed by Go field name. A struct used as the body
+// of a request with the "mergepatch" attribute (see Unmarshal) must
+// have a field of this type, which should have the `json:"-"` tag.
+type PatchFields map[string]bool
+
+// Has reports whether the field with the given Go name was present
+// in the patch. A field that was present with a null value, meaning
+// that the value should be removed, is reported as present even
+// though its pointer is left nil.
+func (f PatchFields) Has(name string) bool {
+	return f[name]
+}
+
+var patchFieldsType = reflect.TypeOf(PatchFields(nil))
+
+// unmarshalMergePatch returns an unmarshaler that unmarshals a JSON
+// merge patch into a struct of type t, recording the fields present
+// in the patch in its PatchFields field.
+func unmarshalMergePatch(t reflect.Type) (unmarshaler, error) {
+	if t.Kind() != reflect.Struct {
+		return nil, errgo.Newf("invalid target type %s for merge patch body; must be struct", t)
+	}
+	presentIndex := -1
+	jsonNames := make(map[string]string)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Type == patchFieldsType {
+			presentIndex = i
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if jsonTag := f.Tag.Get("json"); jsonTag != "" {
+			if jsonTag == "-" {
+				continue
+			}
+			if n := strings.Split(jsonTag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		jsonNames[name] = f.Name
+	}
+	if presentIndex == -1 {
+		return nil, errgo.Newf("merge patch body type %s has no PatchFields field", t)
+	}
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		if !isJSONMediaType(p.Request.Header) {
+			fancyErr := newFancyDecodeError(p.Request.Header, p.Request.Body)
+			return newDecodeRequestError(p.Request, fancyErr.body, fancyErr)
+		}
+		data, err := ioutil.ReadAll(p.Request.Body)
+		if err != nil {
+			return errgo.Notef(err, "cannot read request body")
+		}
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(data, &members); err != nil || members == nil {
+			return errgo.Newf("merge patch is not a JSON object")
+		}
+		result := makeResult(v)
+		if err := jsonUnmarshal(p.json, data, result.Addr().Interface()); err != nil {
+			return errgo.Notef(err, "cannot unmarshal request body")
+		}
+		present := make(PatchFields)
+		for member := range members {
+			if name, ok := lookupJSONName(jsonNames, member); ok {
+				present[name] = true
+			}
+		}
+		result.Field(presentIndex).Set(reflect.ValueOf(present))
+		return nil
+	}, nil
+}
+
+// lookupJSONName returns the Go name of the field with the given JSON
+// name, matching case-insensitively as encoding/json does when there
+// is no exact match.
+func lookupJSONName(jsonNames map[string]string, member string) (string, bool) {
+	if name, ok := jsonNames[member]; ok {
+		return name, true
+	}
+	for jsonName, name := range jsonNames {
+		if strings.EqualFold(jsonName, member) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// marshalMergePatch marshals the specified value into the body of the
+// http request as a JSON merge patch.
+func marshalMergePatch(v reflect.Value, p *Params) error {
+	if err := marshalBody(v, p); err != nil {
+		return errgo.Mask(err)
+	}
+	p.Request.Header.Set("Content-Type", mergePatchContentType)
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type userPatch struct {
+	Name    *string `json:"name,omitempty"`
+	Email   *string `json:"email,omitempty"`
+	Age     *int
+	Present httprequest.PatchFields `json:"-"`
+}
+
+type patchUserReq struct {
+	httprequest.Route `httprequest:"PATCH /users/:id"`
+	ID                string    `httprequest:"id,path"`
+	Patch             userPatch `httprequest:",body,mergepatch"`
+}
+
+func TestUnmarshalMergePatch(t *testing.T) {
+	c := qt.New(t)
+
+	var got userPatch
+	srv := httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, req *patchUserReq) error {
+		got = req.Patch
+		return nil
+	})
+	req := httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`{"name": "bob", "email": null, "AGE": 3, "other": 1}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK, qt.Commentf("body: %s", rec.Body))
+	c.Assert(*got.Name, qt.Equals, "bob")
+	c.Assert(got.Email, qt.IsNil)
+	c.Assert(*got.Age, qt.Equals, 3)
+	c.Assert(got.Present, qt.DeepEquals, httprequest.PatchFields{
+		"Name":  true,
+		"Email": true,
+		"Age":   true,
+	})
+	c.Assert(got.Present.Has("Email"), qt.IsTrue)
+
+	// A patch that is not an object is rejected.
+	req = httptest.NewRequest("PATCH", "/users/1", strings.NewReader(`[1]`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	rec = httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	c.Assert(rec.Body.String(), qt.Contains, "merge patch is not a JSON object")
+}
+
+func TestMarshalMergePatch(t *testing.T) {
+	c := qt.New(t)
+
+	name := "alice"
+	req, err := httprequest.Marshal("http://example.com/users/1", "PATCH", &patchUserReq{
+		ID: "1",
+		Patch: userPatch{
+			Name: &name,
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.Header.Get("Content-Type"), qt.Equals, "application/merge-patch+json")
+	data := make([]byte, 100)
+	n, _ := req.Body.Read(data)
+	c.Assert(string(data[:n]), qt.Equals, `{"name":"alice","Age":null}`)
+}
+
+func TestMergePatchWithoutPatchFields(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := httprequest.Marshal("http://example.com", "PATCH", &struct {
+		Patch struct {
+			Name *string
+		} `httprequest:",body,mergepatch"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `bad type .*: merge patch body type struct { Name \*string } has no PatchFields field`)
+}
@@ -0,0 +1,26 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"github.com/julienschmidt/httprouter"
+)
+
+// Middleware is implemented by values that wrap the handlers created
+// by a Server with additional behaviour (see Server.Middleware).
+type Middleware interface {
+	// Wrap returns a handler that wraps h, the handler for the
+	// route with the given method and path pattern. Errors should
+	// usually be written with srv.WriteError.
+	Wrap(srv *Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle
+}
+
+// wrapMiddleware wraps h with srv.Middleware so that the first
+// middleware is outermost.
+func (srv *Server) wrapMiddleware(method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	for i := len(srv.Middleware) - 1; i >= 0; i-- {
+		h = srv.Middleware[i].Wrap(srv, method, pathPattern, h)
+	}
+	return h
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type recordMiddleware struct {
+	name  string
+	calls *[]string
+}
+
+func (m recordMiddleware) Wrap(srv *httprequest.Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		*m.calls = append(*m.calls, m.name+" "+method+" "+pathPattern)
+		h(w, req, p)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	c := qt.New(t)
+
+	var calls []string
+	srv := httprequest.Server{
+		Middleware: []httprequest.Middleware{
+			recordMiddleware{"a", &calls},
+			recordMiddleware{"b", &calls},
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /m/:x"`
+	}) {
+		calls = append(calls, "handler")
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/m/1", nil), nil)
+	c.Assert(calls, qt.DeepEquals, []string{"a GET /m/:x", "b GET /m/:x", "handler"})
+}
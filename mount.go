@@ -0,0 +1,114 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strings"
+)
+
+// HandlerFuncWithParams returns a handler for e that finds the values
+// of path parameters by calling param with the name of each
+// parameter, for use with routers that do not store the parameters
+// as httprouter does. As with httprouter, the value of a catch-all
+// parameter is made to start with a slash.
+//
+// See also MountChi and GorillaHandler, and MountServeMux when
+// built with Go 1.22 or later.
+func (e Endpoint) HandlerFuncWithParams(param func(req *http.Request, name string) string) http.HandlerFunc {
+	catchAll := catchAllParam(e.Path)
+	return func(w http.ResponseWriter, req *http.Request) {
+		params := make(map[string]string, len(e.PathParams))
+		for _, name := range e.PathParams {
+			v := param(req, name)
+			if name == catchAll && !strings.HasPrefix(v, "/") {
+				v = "/" + v
+			}
+			params[name] = v
+		}
+		e.HandlerFunc(w, WithPathParams(req, params))
+	}
+}
+
+// ChiRouter is the subset of the chi.Router interface used by
+// MountChi.
+type ChiRouter interface {
+	Method(method, pattern string, h http.Handler)
+}
+
+// MountChi registers the given endpoints with a chi router. The
+// urlParam argument should be chi.URLParam. Path patterns are
+// translated with ChiPattern.
+func MountChi(r ChiRouter, es []Endpoint, urlParam func(req *http.Request, name string) string) {
+	for _, e := range es {
+		catchAll := catchAllParam(e.Path)
+		r.Method(e.Method, ChiPattern(e.Path), e.HandlerFuncWithParams(func(req *http.Request, name string) string {
+			if name == catchAll {
+				// chi always names the catch-all
+				// parameter "*".
+				name = "*"
+			}
+			return urlParam(req, name)
+		}))
+	}
+}
+
+// ChiPattern returns the chi equivalent of the given httprouter path
+// pattern, for example "/items/{id}/*" for "/items/:id/*rest".
+func ChiPattern(path string) string {
+	return translatePattern(path, func(name string, catchAll bool) string {
+		if catchAll {
+			return "*"
+		}
+		return "{" + name + "}"
+	})
+}
+
+// GorillaPattern returns the gorilla/mux equivalent of the given
+// httprouter path pattern, for example "/items/{id}/{rest:.*}" for
+// "/items/:id/*rest".
+func GorillaPattern(path string) string {
+	return translatePattern(path, func(name string, catchAll bool) string {
+		if catchAll {
+			return "{" + name + ":.*}"
+		}
+		return "{" + name + "}"
+	})
+}
+
+// GorillaHandler returns a handler for e that can be registered with
+// a gorilla/mux router. The vars argument should be mux.Vars. For
+// example:
+//
+//	r.Handle(httprequest.GorillaPattern(e.Path), httprequest.GorillaHandler(e, mux.Vars)).Methods(e.Method)
+func GorillaHandler(e Endpoint, vars func(req *http.Request) map[string]string) http.Handler {
+	return e.HandlerFuncWithParams(func(req *http.Request, name string) string {
+		return vars(req)[name]
+	})
+}
+
+// translatePattern translates the parameters in the given httprouter
+// path pattern with the given function.
+func translatePattern(path string, param func(name string, catchAll bool) string) string {
+	segs := strings.Split(path, "/")
+	for i, seg := range segs {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			segs[i] = param(seg[1:], false)
+		case strings.HasPrefix(seg, "*"):
+			segs[i] = param(seg[1:], true)
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// catchAllParam returns the name of the catch-all parameter in the
+// given httprouter path pattern, or the empty string if there is none.
+func catchAllParam(path string) string {
+	i := strings.LastIndex(path, "/*")
+	if i == -1 {
+		return ""
+	}
+	return path[i+2:]
+}
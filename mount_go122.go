@@ -0,0 +1,32 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build go1.22
+// +build go1.22
+
+package httprequest
+
+import "net/http"
+
+// MountServeMux registers the given endpoints with mux using the
+// pattern syntax of Go 1.22 (see ServeMuxPattern). The patterns are
+// recognized only when the enhanced ServeMux patterns are enabled,
+// which is the default for programs whose main module declares Go
+// 1.22 or later (see the httpmuxgo121 GODEBUG setting).
+func MountServeMux(mux *http.ServeMux, es []Endpoint) {
+	for _, e := range es {
+		mux.Handle(ServeMuxPattern(e), e.HandlerFuncWithParams((*http.Request).PathValue))
+	}
+}
+
+// ServeMuxPattern returns the http.ServeMux pattern for e, for
+// example "GET /items/{id}/{rest...}" for the path pattern
+// "/items/:id/*rest".
+func ServeMuxPattern(e Endpoint) string {
+	return e.Method + " " + translatePattern(e.Path, func(name string, catchAll bool) string {
+		if catchAll {
+			return "{" + name + "...}"
+		}
+		return "{" + name + "}"
+	})
+}
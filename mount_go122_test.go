@@ -0,0 +1,48 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+//go:build go1.22
+// +build go1.22
+
+// This module declares an earlier Go version, so the enhanced
+// ServeMux patterns must be turned on explicitly.
+//go:debug httpmuxgo121=0
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestMountServeMux(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	es := srv.Endpoints(func(p httprequest.Params) (endpointHandlers, context.Context, error) {
+		return endpointHandlers{}, p.Context, nil
+	})
+	c.Assert(httprequest.ServeMuxPattern(es[1]), qt.Equals, "GET /items/{id}/{rest...}")
+
+	mux := http.NewServeMux()
+	httprequest.MountServeMux(mux, es)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/items/42/x/y", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"42/x/y"`)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("DELETE", "/items/42", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("PUT", "/items/42", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestChiPattern(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(httprequest.ChiPattern("/items/:id/*rest"), qt.Equals, "/items/{id}/*")
+	c.Assert(httprequest.ChiPattern("/items"), qt.Equals, "/items")
+}
+
+func TestGorillaPattern(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(httprequest.GorillaPattern("/items/:id/*rest"), qt.Equals, "/items/{id}/{rest:.*}")
+	c.Assert(httprequest.GorillaPattern("/a/:b/c"), qt.Equals, "/a/{b}/c")
+}
+
+// fakeChiRouter records the routes registered with it and serves
+// requests with parameters set by the test.
+type fakeChiRouter struct {
+	routes map[string]http.Handler
+}
+
+func (r *fakeChiRouter) Method(method, pattern string, h http.Handler) {
+	if r.routes == nil {
+		r.routes = make(map[string]http.Handler)
+	}
+	r.routes[method+" "+pattern] = h
+}
+
+type chiParamsKey struct{}
+
+func fakeChiURLParam(req *http.Request, name string) string {
+	params, _ := req.Context().Value(chiParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func TestMountChi(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	es := srv.Endpoints(func(p httprequest.Params) (endpointHandlers, context.Context, error) {
+		return endpointHandlers{}, p.Context, nil
+	})
+	var r fakeChiRouter
+	httprequest.MountChi(&r, es, fakeChiURLParam)
+	c.Assert(r.routes, qt.HasLen, 2)
+	h := r.routes["GET /items/{id}/*"]
+	c.Assert(h, qt.Not(qt.IsNil))
+
+	req := httptest.NewRequest("GET", "/items/42/x/y", nil)
+	req = req.WithContext(context.WithValue(req.Context(), chiParamsKey{}, map[string]string{
+		"id": "42",
+		"*":  "x/y",
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"42/x/y"`)
+}
+
+func TestGorillaHandler(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	e := srv.Endpoint(func(p httprequest.Params, req *endpointItemReq) (string, error) {
+		return req.ID + req.Rest, nil
+	})
+	h := httprequest.GorillaHandler(e, func(req *http.Request) map[string]string {
+		return map[string]string{
+			"id":   "7",
+			"rest": "a/b",
+		}
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/items/7/a/b", nil))
+	c.Assert(rec.Body.String(), qt.Equals, `"7/a/b"`)
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ndjsonContentType holds the content type of newline-delimited JSON
+// (see http://ndjson.org).
+const ndjsonContentType = "application/x-ndjson"
+
+// NDJSONStream can be returned as the result of a handler (see
+// Server.Handle) to stream a response holding newline-delimited JSON
+// (NDJSON), with content type application/x-ndjson. Each value is
+// written on its own line and flushed to the client as soon as it has
+// been written. Errors are handled as for JSONArrayStream.
+// Client.CallNDJSON can be used to read such a response.
+type NDJSONStream struct {
+	// Elements writes the values in the stream by calling write
+	// with each of them in turn. It should return any error
+	// returned by write.
+	Elements func(write func(elem interface{}) error) error
+}
+
+// StreamNDJSONChannel returns an NDJSONStream that streams the values
+// received from ch, which must be a channel that can be received from,
+// until it is closed. It panics if ch is not such a channel. See
+// StreamChannel.
+func StreamNDJSONChannel(ch interface{}) *NDJSONStream {
+	return &NDJSONStream{
+		Elements: channelElements("StreamNDJSONChannel", ch),
+	}
+}
+
+// ndjsonFormat holds the format of an NDJSONStream response.
+var ndjsonFormat = streamFormat{
+	contentType: ndjsonContentType,
+	term:        "\n",
+}
+
+// write writes s as a response with the given status code.
+func (s *NDJSONStream) write(srv *Server, w http.ResponseWriter, code int) error {
+	return writeStream(srv, w, code, s.Elements, ndjsonFormat)
+}
+
+// NDJSONReader reads newline-delimited JSON values one at a time.
+//
+// When a field with the "body" attribute has type *NDJSONReader, the
+// request body must have content type application/x-ndjson and the
+// field is set to a reader of it rather than the body being read
+// up front, so that a handler can process a large body one line at a
+// time. With HTTP/1.x, the body should be read before the handler
+// starts writing its response. When such a request is marshaled, the
+// body is read from the NDJSONReader, which will usually have been
+// created by EncodeNDJSON.
+type NDJSONReader struct {
+	src  io.Reader
+	br   *bufio.Reader
+	json Codec
+	line int
+	done bool
+	err  error
+}
+
+var ndjsonReaderType = reflect.TypeOf(NDJSONReader{})
+
+// NewNDJSONReader returns an NDJSONReader that reads
+// newline-delimited JSON values from r.
+func NewNDJSONReader(r io.Reader) *NDJSONReader {
+	return &NDJSONReader{
+		src: r,
+	}
+}
+
+// EncodeNDJSON returns an NDJSONReader that reads the values written
+// by the elements function, encoded as newline-delimited JSON. It can
+// be used as the body of a request so that the values are streamed to
+// the server as they are produced rather than being held in memory.
+// The elements function is called when the body is first read; if it
+// returns an error, sending the request fails with that error.
+func EncodeNDJSON(elements func(write func(elem interface{}) error) error) *NDJSONReader {
+	return NewNDJSONReader(&ndjsonEncoder{
+		elements: elements,
+	})
+}
+
+// Next unmarshals the next value into v and reports whether there was
+// one. Blank lines are ignored. It returns false at the end of the
+// input or when there is an error, which is returned by Err.
+func (r *NDJSONReader) Next(v interface{}) bool {
+	if r.done || r.err != nil {
+		return false
+	}
+	if r.br == nil {
+		r.br = bufio.NewReader(r.src)
+	}
+	for {
+		data, err := r.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			r.err = errgo.Notef(err, "cannot read NDJSON")
+			return false
+		}
+		if len(data) > 0 {
+			r.line++
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			if err := jsonUnmarshal(r.json, data, v); err != nil {
+				r.err = errgo.Notef(err, "cannot unmarshal NDJSON line %d", r.line)
+				return false
+			}
+			return true
+		}
+		if err == io.EOF {
+			r.done = true
+			return false
+		}
+	}
+}
+
+// Err returns the error, if any, that caused Next to return false.
+func (r *NDJSONReader) Err() error {
+	return r.err
+}
+
+// body returns a reader of the data not yet read by Next.
+func (r *NDJSONReader) body() io.ReadCloser {
+	if r.br != nil {
+		return ioutil.NopCloser(io.MultiReader(r.br, r.src))
+	}
+	if rc, ok := r.src.(io.ReadCloser); ok {
+		return rc
+	}
+	return ioutil.NopCloser(r.src)
+}
+
+// ndjsonEncoder is the reader used by EncodeNDJSON. The values are
+// encoded in a separate goroutine when the reader is first read.
+type ndjsonEncoder struct {
+	elements func(write func(elem interface{}) error) error
+	once     sync.Once
+	pr       *io.PipeReader
+}
+
+// Read implements io.Reader.Read.
+func (e *ndjsonEncoder) Read(buf []byte) (int, error) {
+	e.once.Do(e.start)
+	if e.pr == nil {
+		return 0, io.ErrClosedPipe
+	}
+	return e.pr.Read(buf)
+}
+
+// Close implements io.Closer.Close. If the values are being encoded,
+// the next attempt to write one fails.
+func (e *ndjsonEncoder) Close() error {
+	e.once.Do(func() {})
+	if e.pr != nil {
+		e.pr.Close()
+	}
+	return nil
+}
+
+func (e *ndjsonEncoder) start() {
+	pr, pw := io.Pipe()
+	e.pr = pr
+	go func() {
+		// Encode writes each value followed by a newline.
+		enc := json.NewEncoder(pw)
+		var err error
+		if e.elements != nil {
+			err = e.elements(enc.Encode)
+		}
+		pw.CloseWithError(err)
+	}()
+}
+
+// unmarshalNDJSONBody sets the NDJSONReader value v to a reader of the
+// request body.
+func unmarshalNDJSONBody(v reflect.Value, p Params, makeResult resultMaker) error {
+	if !isNDJSONMediaType(p.Request.Header) {
+		err := errgo.Newf("unexpected content type %s; want %s", p.Request.Header.Get("Content-Type"), ndjsonContentType)
+		return newDecodeRequestError(p.Request, nil, err)
+	}
+	r := NewNDJSONReader(p.Request.Body)
+	r.json = p.json
+	makeResult(v).Set(reflect.ValueOf(r).Elem())
+	return nil
+}
+
+// marshalNDJSONBody sets the body of the http request to the data read
+// from the NDJSONReader value v.
+func marshalNDJSONBody(v reflect.Value, p *Params) error {
+	// The length of the body is unknown, so it is sent with
+	// chunked transfer encoding.
+	p.Request.Body = v.Addr().Interface().(*NDJSONReader).body()
+	p.Request.ContentLength = 0
+	p.Request.Header.Set("Content-Type", ndjsonContentType)
+	return nil
+}
+
+// isNDJSONMediaType reports whether the content type of the given
+// header is application/x-ndjson.
+func isNDJSONMediaType(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == ndjsonContentType
+}
+
+// CallNDJSON is like Call except that the response is expected to hold
+// newline-delimited JSON, as written by a handler returning an
+// NDJSONStream. Rather than being unmarshaled, the response body is
+// passed to fn as an NDJSONReader, so that its values can be processed
+// as they arrive. The body is closed when fn returns. Any error
+// returned by fn is returned from CallNDJSON with its cause intact.
+//
+// If the response does not have content type application/x-ndjson, a
+// *DecodeResponseError is returned.
+func (c *Client) CallNDJSON(ctx context.Context, params interface{}, fn func(r *NDJSONReader) error) error {
+	var resp *http.Response
+	if err := c.Call(ctx, params, &resp); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	defer resp.Body.Close()
+	if !isNDJSONMediaType(resp.Header) {
+		err := newDecodeResponseError(resp, nil, errgo.Newf("unexpected content type %s; want %s", resp.Header.Get("Content-Type"), ndjsonContentType))
+		return errgo.Mask(urlError(err, resp.Request), isDecodeResponseError)
+	}
+	r := NewNDJSONReader(resp.Body)
+	r.json = c.JSON
+	if err := fn(r); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type ndjsonIngestReq struct {
+	httprequest.Route `httprequest:"POST /events"`
+	Body              *httprequest.NDJSONReader `httprequest:",body"`
+}
+
+type ndjsonIngestResp struct {
+	Count int
+	Total int
+}
+
+type ndjsonTailReq struct {
+	httprequest.Route `httprequest:"GET /events/:kind"`
+	Kind              string `httprequest:"kind,path"`
+}
+
+type ndjsonEvent struct {
+	N int `json:"n"`
+}
+
+func newNDJSONServer() *httptest.Server {
+	var srv httprequest.Server
+	return newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *ndjsonIngestReq) (*ndjsonIngestResp, error) {
+			var resp ndjsonIngestResp
+			var ev ndjsonEvent
+			for req.Body.Next(&ev) {
+				resp.Count++
+				resp.Total += ev.N
+			}
+			if err := req.Body.Err(); err != nil {
+				return nil, httprequest.Errorf(httprequest.CodeBadRequest, "%v", err)
+			}
+			return &resp, nil
+		}),
+		srv.Handle(func(p httprequest.Params, req *ndjsonTailReq) (*httprequest.NDJSONStream, error) {
+			if req.Kind == "bad" {
+				return nil, httprequest.Errorf(httprequest.CodeNotFound, "no such events")
+			}
+			ch := make(chan ndjsonEvent)
+			go func() {
+				defer close(ch)
+				for i := 1; i <= 3; i++ {
+					select {
+					case ch <- ndjsonEvent{N: i}:
+					case <-p.Context.Done():
+						return
+					}
+				}
+			}()
+			return httprequest.StreamNDJSONChannel(ch), nil
+		}),
+	})
+}
+
+func TestNDJSONStream(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/events/all")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/x-ndjson")
+	c.Assert(resp.TransferEncoding, qt.DeepEquals, []string{"chunked"})
+	body, err := readResponseBody(resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n")
+}
+
+func TestCallNDJSON(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var events []ndjsonEvent
+	err := client.CallNDJSON(context.Background(), &ndjsonTailReq{Kind: "all"}, func(r *httprequest.NDJSONReader) error {
+		var ev ndjsonEvent
+		for r.Next(&ev) {
+			events = append(events, ev)
+		}
+		return r.Err()
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(events, qt.DeepEquals, []ndjsonEvent{{1}, {2}, {3}})
+}
+
+func TestCallNDJSONCallbackError(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	errStop := errgo.New("stop")
+	err := client.CallNDJSON(context.Background(), &ndjsonTailReq{Kind: "all"}, func(r *httprequest.NDJSONReader) error {
+		return errStop
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, errStop)
+}
+
+func TestCallNDJSONErrorResponse(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	called := false
+	err := client.CallNDJSON(context.Background(), &ndjsonTailReq{Kind: "bad"}, func(r *httprequest.NDJSONReader) error {
+		called = true
+		return nil
+	})
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Message: "no such events",
+		Code:    httprequest.CodeNotFound,
+	})
+	c.Assert(called, qt.Equals, false)
+}
+
+func TestCallNDJSONBadContentType(t *testing.T) {
+	c := qt.New(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusOK, []int{1})
+	}))
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.CallNDJSON(context.Background(), &ndjsonTailReq{Kind: "all"}, func(r *httprequest.NDJSONReader) error {
+		return nil
+	})
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/events/all"?: unexpected content type application/json; want application/x-ndjson`)
+	derr, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.Equals, true)
+	body, err := readResponseBody(derr.Response)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `[1]`)
+}
+
+func TestNDJSONRequestBody(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp ndjsonIngestResp
+	err := client.Call(context.Background(), &ndjsonIngestReq{
+		Body: httprequest.EncodeNDJSON(func(write func(interface{}) error) error {
+			for i := 1; i <= 100; i++ {
+				if err := write(ndjsonEvent{N: i}); err != nil {
+					return err
+				}
+			}
+			return nil
+		}),
+	}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, ndjsonIngestResp{
+		Count: 100,
+		Total: 5050,
+	})
+
+	// A reader of already encoded data can also be sent.
+	resp = ndjsonIngestResp{}
+	err = client.Call(context.Background(), &ndjsonIngestReq{
+		Body: httprequest.NewNDJSONReader(strings.NewReader("{\"n\":3}\n\n{\"n\":4}")),
+	}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, ndjsonIngestResp{
+		Count: 2,
+		Total: 7,
+	})
+}
+
+func TestNDJSONRequestBodyEncodeError(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.Call(context.Background(), &ndjsonIngestReq{
+		Body: httprequest.EncodeNDJSON(func(write func(interface{}) error) error {
+			return errgo.New("cannot produce events")
+		}),
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post "?http://.*/events"?: cannot produce events`)
+}
+
+func TestNDJSONRequestBodyBadContentType(t *testing.T) {
+	c := qt.New(t)
+	srv := newNDJSONServer()
+	defer srv.Close()
+
+	req, err := http.NewRequest("POST", srv.URL+"/events", strings.NewReader(`{"n":1}`))
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	body, err := readResponseBody(resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body, qt.Equals, `{"Message":"cannot unmarshal parameters: cannot unmarshal into field Body: unexpected content type application/json; want application/x-ndjson"}`)
+}
+
+var ndjsonReaderTests = []struct {
+	about        string
+	body         string
+	expectEvents []ndjsonEvent
+	expectError  string
+}{{
+	about:        "empty",
+	body:         "",
+	expectEvents: nil,
+}, {
+	about:        "blank lines and no final newline",
+	body:         "\n{\"n\":1}\r\n  \n{\"n\":2}",
+	expectEvents: []ndjsonEvent{{1}, {2}},
+}, {
+	about:        "bad line",
+	body:         "{\"n\":1}\n\n{\"n\":\"x\"}\n{\"n\":3}\n",
+	expectEvents: []ndjsonEvent{{1}},
+	expectError:  `cannot unmarshal NDJSON line 3: json: cannot unmarshal string into Go .* of type int`,
+}, {
+	about:        "partial line",
+	body:         "{\"n\":1}\n{\"n\":",
+	expectEvents: []ndjsonEvent{{1}},
+	expectError:  `cannot unmarshal NDJSON line 2: unexpected end of JSON input`,
+}}
+
+func TestNDJSONReader(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range ndjsonReaderTests {
+		c.Run(test.about, func(c *qt.C) {
+			r := httprequest.NewNDJSONReader(strings.NewReader(test.body))
+			var events []ndjsonEvent
+			var ev ndjsonEvent
+			for r.Next(&ev) {
+				events = append(events, ev)
+			}
+			c.Assert(events, qt.DeepEquals, test.expectEvents)
+			if test.expectError != "" {
+				c.Assert(r.Err(), qt.ErrorMatches, test.expectError)
+			} else {
+				c.Assert(r.Err(), qt.IsNil)
+			}
+			c.Assert(r.Next(&ev), qt.Equals, false)
+		})
+	}
+}
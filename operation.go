@@ -0,0 +1,259 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// OperationStatus holds the status of a long-running operation.
+type OperationStatus string
+
+// These are the possible values of OperationStatus.
+const (
+	OperationRunning   OperationStatus = "running"
+	OperationSucceeded OperationStatus = "succeeded"
+	OperationFailed    OperationStatus = "failed"
+)
+
+// Operation describes the state of a long-running operation started
+// with Operations.Start.
+type Operation struct {
+	// ID holds the ID of the operation.
+	ID string
+
+	// Status holds the status of the operation.
+	Status OperationStatus
+
+	// Location holds the URL of the route that reports the
+	// status of the operation, if known.
+	Location string `json:",omitempty"`
+
+	// Result holds the JSON-encoded result of an operation
+	// that has succeeded, if it has one.
+	Result json.RawMessage `json:",omitempty"`
+
+	// Error holds the error from an operation that has failed.
+	Error *RemoteError `json:",omitempty"`
+}
+
+// Done reports whether the operation has finished.
+func (op *Operation) Done() bool {
+	return op.Status == OperationSucceeded || op.Status == OperationFailed
+}
+
+// Operations runs long-running operations on behalf of handlers and
+// records their state so that clients can poll for it. A handler
+// starts an operation with Start and returns its result, which is
+// written as a 202 Accepted response holding the Operation; another
+// handler, registered for the status route, returns the result of
+// Get. For example:
+//
+//	func (h *handler) Export(p httprequest.Params, req *exportReq) (*httprequest.StatusResponse, error) {
+//		return h.ops.Start(func(ctx context.Context) (interface{}, error) {
+//			return h.export(ctx, req.Dataset)
+//		})
+//	}
+//
+//	func (h *handler) Operation(p httprequest.Params, req *operationReq) (*httprequest.Operation, error) {
+//		return h.ops.Get(req.ID)
+//	}
+//
+// Clients can wait for an operation to finish with
+// Client.WaitOperation.
+//
+// Operations are held in memory, so they are lost when the server
+// restarts.
+type Operations struct {
+	// Location returns the URL of the status route for the
+	// operation with the given ID. It is sent in the Location
+	// header of the 202 response and held in the Location field
+	// of each Operation. If it is nil, no location is reported.
+	Location func(id string) string
+
+	// Expiry holds how long the state of an operation is kept
+	// after it has finished. If it is zero, one hour is used.
+	Expiry time.Duration
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu  sync.Mutex
+	ops map[string]*operationEntry
+}
+
+// operationEntry holds an operation known to Operations.
+type operationEntry struct {
+	op      Operation
+	expires time.Time
+}
+
+// acceptedOperation is the value written in
+// response to a request that starts an operation.
+type acceptedOperation struct {
+	*Operation
+	LocationHeader string `httprequest:"Location,header,omitempty" json:"-"`
+}
+
+// Start starts an operation that calls f in a new goroutine and
+// returns a result suitable for a handler to return: a 202 Accepted
+// response holding the new Operation. The result of f, if not nil, is
+// marshaled as JSON into the Result field of the operation when it
+// succeeds; an error is converted as by DefaultErrorMapper into the
+// Error field. If f panics, the operation fails with a *PanicError
+// holding the panic value.
+//
+// As f runs after the handler has returned, the context passed to
+// it is not that of the request and is never canceled.
+func (o *Operations) Start(f func(ctx context.Context) (interface{}, error)) (*StatusResponse, error) {
+	op := Operation{
+		ID:     newUUID(),
+		Status: OperationRunning,
+	}
+	if o.Location != nil {
+		op.Location = o.Location(op.ID)
+	}
+	o.mu.Lock()
+	o.expire()
+	if o.ops == nil {
+		o.ops = make(map[string]*operationEntry)
+	}
+	o.ops[op.ID] = &operationEntry{
+		op: op,
+	}
+	o.mu.Unlock()
+	go o.run(op.ID, f)
+	return &StatusResponse{
+		Code: http.StatusAccepted,
+		Value: &acceptedOperation{
+			Operation:      &op,
+			LocationHeader: op.Location,
+		},
+	}, nil
+}
+
+// run runs f and records its outcome as the outcome
+// of the operation with the given ID.
+func (o *Operations) run(id string, f func(ctx context.Context) (interface{}, error)) {
+	result, err := callOperation(f)
+	var data json.RawMessage
+	if err == nil && result != nil {
+		data, err = json.Marshal(result)
+		if err != nil {
+			err = errgo.Notef(err, "cannot marshal operation result")
+		}
+	}
+	expiry := o.Expiry
+	if expiry == 0 {
+		expiry = time.Hour
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	e := o.ops[id]
+	if err != nil {
+		e.op.Status = OperationFailed
+		e.op.Error = errorResponseBody(err)
+	} else {
+		e.op.Status = OperationSucceeded
+		e.op.Result = data
+	}
+	e.expires = nowFunc(o.Now)().Add(expiry)
+}
+
+// callOperation calls f, returning a *PanicError
+// if it panics.
+func callOperation(f func(ctx context.Context) (interface{}, error)) (result interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			result, err = nil, &PanicError{
+				Value: v,
+			}
+		}
+	}()
+	return f(context.Background())
+}
+
+// Get returns the state of the operation with the given ID. If there
+// is no such operation, it returns a *RemoteError with the code
+// CodeNotFound.
+func (o *Operations) Get(id string) (*Operation, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.expire()
+	e, ok := o.ops[id]
+	if !ok {
+		return nil, Errorf(CodeNotFound, "operation %q not found", id)
+	}
+	op := e.op
+	return &op, nil
+}
+
+// expire removes the operations that have expired.
+// It must be called with o.mu held.
+func (o *Operations) expire() {
+	now := nowFunc(o.Now)()
+	for id, e := range o.ops {
+		if !e.expires.IsZero() && !now.Before(e.expires) {
+			delete(o.ops, id)
+		}
+	}
+}
+
+// WaitOperation waits for the given operation to finish by polling
+// the URL in its Location field, relative to c.BaseURL unless it is
+// absolute, at the given interval (one second if it is not
+// positive). When the operation succeeds, its result is unmarshaled
+// into the value pointed to by resp, unless resp is nil; when it
+// fails, its Error is returned. On return, op holds the last state
+// of the operation.
+//
+// If ctx is done before the operation finishes, WaitOperation
+// returns an error with the context error as its cause.
+func (c *Client) WaitOperation(ctx context.Context, op *Operation, interval time.Duration, resp interface{}) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	for !op.Done() {
+		if op.Location == "" {
+			return errgo.Newf("operation %q has no location to poll", op.ID)
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return errgo.NoteMask(ctx.Err(), "cannot wait for operation", errgo.Any)
+		}
+		var op1 Operation
+		if err := c.Get(ctx, op.Location, &op1); err != nil {
+			if ctx.Err() != nil {
+				return errgo.NoteMask(ctx.Err(), "cannot wait for operation", errgo.Any)
+			}
+			return errgo.NoteMask(err, "cannot get operation status", errgo.Any)
+		}
+		if op1.Location == "" {
+			op1.Location = op.Location
+		}
+		*op = op1
+	}
+	if op.Status == OperationFailed {
+		if op.Error == nil {
+			return errgo.Newf("operation %q failed", op.ID)
+		}
+		return op.Error
+	}
+	if resp == nil || len(op.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(op.Result, resp); err != nil {
+		return errgo.Notef(err, "cannot unmarshal operation result")
+	}
+	return nil
+}
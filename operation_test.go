@@ -0,0 +1,203 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type operationHandlers struct {
+	ops     *httprequest.Operations
+	release chan struct{}
+}
+
+type startOperationReq struct {
+	httprequest.Route `httprequest:"POST /exports"`
+	Fail              bool `httprequest:"fail,form"`
+}
+
+type getOperationReq struct {
+	httprequest.Route `httprequest:"GET /operations/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func (h operationHandlers) Start(req *startOperationReq) (*httprequest.StatusResponse, error) {
+	return h.ops.Start(func(ctx context.Context) (interface{}, error) {
+		<-h.release
+		if req.Fail {
+			return nil, httprequest.Errorf(httprequest.CodeBadRequest, "export failed")
+		}
+		return []string{"a", "b"}, nil
+	})
+}
+
+func (h operationHandlers) Operation(req *getOperationReq) (*httprequest.Operation, error) {
+	return h.ops.Get(req.ID)
+}
+
+func newOperationServer(c *qt.C) (*httptest.Server, chan struct{}) {
+	h := operationHandlers{
+		ops: &httprequest.Operations{
+			Location: func(id string) string {
+				return "/operations/" + id
+			},
+		},
+		release: make(chan struct{}),
+	}
+	srv := &httprequest.Server{}
+	return newHandlerServer(srv.Handlers(func(p httprequest.Params) (operationHandlers, context.Context, error) {
+		return h, p.Context, nil
+	})), h.release
+}
+
+func TestOperations(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv, release := newOperationServer(c)
+	defer hsrv.Close()
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var httpResp *http.Response
+	err := client.Call(context.Background(), &startOperationReq{}, &httpResp)
+	c.Assert(err, qt.IsNil)
+	httpResp.Body.Close()
+	c.Assert(httpResp.StatusCode, qt.Equals, http.StatusAccepted)
+	c.Assert(httpResp.Header.Get("Location"), qt.Matches, "/operations/.+")
+
+	var op httprequest.Operation
+	err = client.Call(context.Background(), &startOperationReq{}, &op)
+	c.Assert(err, qt.IsNil)
+	c.Assert(op.Status, qt.Equals, httprequest.OperationRunning)
+	c.Assert(op.Location, qt.Equals, "/operations/"+op.ID)
+
+	var op1 httprequest.Operation
+	err = client.Call(context.Background(), &getOperationReq{ID: op.ID}, &op1)
+	c.Assert(err, qt.IsNil)
+	c.Assert(op1, qt.DeepEquals, op)
+
+	close(release)
+	var result []string
+	err = client.WaitOperation(context.Background(), &op, time.Millisecond, &result)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result, qt.DeepEquals, []string{"a", "b"})
+	c.Assert(op.Status, qt.Equals, httprequest.OperationSucceeded)
+
+	err = client.Call(context.Background(), &getOperationReq{ID: "unknown"}, &op1)
+	c.Assert(err, qt.ErrorMatches, `Get .*/operations/unknown: operation "unknown" not found`)
+}
+
+func TestOperationFailure(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv, release := newOperationServer(c)
+	defer hsrv.Close()
+	close(release)
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var op httprequest.Operation
+	err := client.Call(context.Background(), &startOperationReq{Fail: true}, &op)
+	c.Assert(err, qt.IsNil)
+	err = client.WaitOperation(context.Background(), &op, time.Millisecond, nil)
+	c.Assert(err, qt.ErrorMatches, `export failed`)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "export failed",
+	})
+}
+
+func TestWaitOperationContextDone(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv, release := newOperationServer(c)
+	defer hsrv.Close()
+	defer close(release)
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var op httprequest.Operation
+	err := client.Call(context.Background(), &startOperationReq{}, &op)
+	c.Assert(err, qt.IsNil)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = client.WaitOperation(ctx, &op, time.Millisecond, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, context.DeadlineExceeded)
+	c.Assert(op.Status, qt.Equals, httprequest.OperationRunning)
+}
+
+func TestOperationsExpiry(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	ops := &httprequest.Operations{
+		Expiry: time.Minute,
+		Now: func() time.Time {
+			return now
+		},
+	}
+	done := make(chan struct{})
+	resp, err := ops.Start(func(context.Context) (interface{}, error) {
+		defer close(done)
+		return nil, nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Code, qt.Equals, http.StatusAccepted)
+	<-done
+	var op *httprequest.Operation
+	for i := 0; i < 100; i++ {
+		op, err = ops.Get(opID(resp))
+		c.Assert(err, qt.IsNil)
+		if op.Done() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(op.Status, qt.Equals, httprequest.OperationSucceeded)
+	now = now.Add(time.Minute)
+	_, err = ops.Get(op.ID)
+	c.Assert(errgo.Cause(err).(*httprequest.RemoteError).Code, qt.Equals, httprequest.CodeNotFound)
+}
+
+func TestOperationPanic(t *testing.T) {
+	c := qt.New(t)
+
+	ops := &httprequest.Operations{}
+	resp, err := ops.Start(func(context.Context) (interface{}, error) {
+		panic("oops")
+	})
+	c.Assert(err, qt.IsNil)
+	var op *httprequest.Operation
+	for i := 0; i < 100; i++ {
+		op, err = ops.Get(opID(resp))
+		c.Assert(err, qt.IsNil)
+		if op.Done() {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	c.Assert(op.Status, qt.Equals, httprequest.OperationFailed)
+	c.Assert(op.Error, qt.DeepEquals, &httprequest.RemoteError{
+		Message: "internal server error",
+	})
+}
+
+// opID returns the ID of the operation started
+// with the given response.
+func opID(resp *httprequest.StatusResponse) string {
+	rec := httptest.NewRecorder()
+	httprequest.WriteJSON(rec, resp.Code, resp.Value)
+	var op httprequest.Operation
+	httprequest.UnmarshalJSONResponse(rec.Result(), &op)
+	return op.ID
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type optionalThingsReq struct {
+	httprequest.Route `httprequest:"GET /things/:id(int)?"`
+	ID                string `httprequest:"id,path"`
+}
+
+type optionalPathHandlers struct{}
+
+func (optionalPathHandlers) Things(p httprequest.Params, req *optionalThingsReq) ([]string, error) {
+	if req.ID == "" {
+		return []string{"all", p.PathPattern}, nil
+	}
+	return []string{req.ID, p.PathPattern}, nil
+}
+
+func TestOptionalPathParam(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	hs := srv.Handlers(func(p httprequest.Params) (optionalPathHandlers, context.Context, error) {
+		return optionalPathHandlers{}, p.Context, nil
+	})
+	c.Assert(hs, qt.HasLen, 2)
+	c.Assert(hs[0].Path, qt.Equals, "/things/:id")
+	c.Assert(hs[1].Path, qt.Equals, "/things")
+
+	hsrv := newHandlerServer(hs)
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var resp []string
+	err := client.Call(context.Background(), &optionalThingsReq{}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, []string{"all", "/things"})
+
+	err = client.Call(context.Background(), &optionalThingsReq{ID: "42"}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.DeepEquals, []string{"42", "/things/:id"})
+
+	// The constraint still applies when the parameter is present.
+	err = client.Call(context.Background(), &optionalThingsReq{ID: "x"}, &resp)
+	c.Assert(err, qt.ErrorMatches, `.*path parameter id: "x" is not a valid int`)
+}
+
+type optionalRootReq struct {
+	httprequest.Route `httprequest:"GET /:id?"`
+	ID                string `httprequest:"id,path"`
+}
+
+type optionalRootHandlers struct{}
+
+func (optionalRootHandlers) Root(p httprequest.Params, req *optionalRootReq) (string, error) {
+	return req.ID + " " + p.PathPattern, nil
+}
+
+func TestOptionalPathParamAtRoot(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	hs := srv.Handlers(func(p httprequest.Params) (optionalRootHandlers, context.Context, error) {
+		return optionalRootHandlers{}, p.Context, nil
+	})
+	c.Assert(hs, qt.HasLen, 2)
+	c.Assert(hs[0].Path, qt.Equals, "/:id")
+	c.Assert(hs[1].Path, qt.Equals, "/")
+
+	hsrv := newHandlerServer(hs)
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var resp string
+	err := client.Call(context.Background(), &optionalRootReq{}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.Equals, " /")
+
+	err = client.Call(context.Background(), &optionalRootReq{ID: "42"}, &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.Equals, "42 /:id")
+}
+
+func TestMarshalOptionalPathParam(t *testing.T) {
+	c := qt.New(t)
+
+	req, err := httprequest.Marshal("http://example.com/things/:id", "GET", &optionalThingsReq{})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.URL.String(), qt.Equals, "http://example.com/things")
+
+	req, err = httprequest.Marshal("http://example.com/things/:id", "GET", &optionalThingsReq{ID: "7"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(req.URL.String(), qt.Equals, "http://example.com/things/7")
+}
+
+func TestHandleWithOptionalPathParam(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, req *optionalThingsReq) {})
+	}, qt.PanicMatches, `bad handler function: route "/things/:id" has an optional path parameter; use Handlers instead`)
+}
+
+func TestDeriveHandlersWithOptionalPathParam(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	hs := srv.DeriveHandlers(srv.Handlers(func(p httprequest.Params) (optionalPathHandlers, context.Context, error) {
+		return optionalPathHandlers{}, p.Context, nil
+	}))
+	router := newHandlerRouter(hs)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("OPTIONS", "/things", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusNoContent)
+	c.Assert(rec.Header().Get("Allow"), qt.Equals, "GET, HEAD, OPTIONS")
+}
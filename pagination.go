@@ -0,0 +1,114 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"gopkg.in/errgo.v1"
+)
+
+// PageRequest holds the parameters that select a page of results. It
+// is intended to be embedded as an anonymous field in a request
+// parameter struct, so that a paginated endpoint accepts the "limit",
+// "offset" and "cursor" query parameters.
+//
+// Either Offset or Cursor may be used to identify the start of the
+// page, depending on the pagination scheme used by the endpoint.
+type PageRequest struct {
+	// Limit holds the maximum number of items to return.
+	Limit int `httprequest:"limit,form,omitempty"`
+
+	// Offset holds the number of items to skip.
+	Offset int `httprequest:"offset,form,omitempty"`
+
+	// Cursor holds an opaque token, returned from a previous
+	// request, that identifies the start of the page.
+	Cursor string `httprequest:"cursor,form,omitempty"`
+}
+
+// setQuery sets the query parameters for p in q, removing any that
+// are zero.
+func (p PageRequest) setQuery(q url.Values) {
+	setOrDelete := func(key, val string, ok bool) {
+		if ok {
+			q.Set(key, val)
+		} else {
+			q.Del(key)
+		}
+	}
+	setOrDelete("limit", strconv.Itoa(p.Limit), p.Limit != 0)
+	setOrDelete("offset", strconv.Itoa(p.Offset), p.Offset != 0)
+	setOrDelete("cursor", p.Cursor, p.Cursor != "")
+}
+
+// Page is a response value holding a page of results. When written
+// with WriteJSON (or returned from a handler), the body is
+// the JSON-marshaled Items value and RFC 5988 Link headers are added
+// for the next and previous pages. When it is returned from a
+// handler, Items is written in the same way as any other result, so
+// the Server's JSON, TimeFormat and Codecs settings apply to it.
+type Page struct {
+	// Items holds the JSON-marshaled body of the response.
+	Items interface{}
+
+	// URL holds the URL of the current request. The links to the
+	// next and previous pages are derived from it by replacing the
+	// pagination parameters. It is usually set to
+	// ExternalURL(Params.Request) so that the links are
+	// correct when the server is behind a proxy.
+	URL *url.URL
+
+	// Next holds the parameters for the next page of results.
+	// If it is nil, no "next" link is added.
+	Next *PageRequest
+
+	// Prev holds the parameters for the previous page of results.
+	// If it is nil, no "prev" link is added.
+	Prev *PageRequest
+}
+
+// MarshalJSON implements json.Marshaler by marshaling
+// p.Items.
+func (p Page) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Items)
+}
+
+// write writes p as a response to req with the given status code,
+// writing p.Items as the result.
+func (p *Page) write(srv *Server, w http.ResponseWriter, req *http.Request, code int, bufferSize int) error {
+	p.SetHeader(w.Header())
+	return errgo.Mask(srv.writeResultBody(w, req, code, p.Items, bufferSize), errgo.Any)
+}
+
+// SetHeader implements HeaderSetter by calling SetPageLinks.
+func (p Page) SetHeader(h http.Header) {
+	SetPageLinks(h, p.URL, p.Next, p.Prev)
+}
+
+// SetPageLinks adds Link headers to h referring to the next and
+// previous pages of results, as specified by RFC 5988. The links are
+// formed by replacing the pagination parameters in u with those from
+// next and prev. A link is omitted if its page is nil.
+func SetPageLinks(h http.Header, u *url.URL, next, prev *PageRequest) {
+	if u == nil {
+		return
+	}
+	addLink := func(rel string, p *PageRequest) {
+		if p == nil {
+			return
+		}
+		u1 := *u
+		q := u1.Query()
+		p.setQuery(q)
+		u1.RawQuery = q.Encode()
+		h.Add("Link", fmt.Sprintf("<%s>; rel=%q", u1.String(), rel))
+	}
+	addLink("next", next)
+	addLink("prev", prev)
+}
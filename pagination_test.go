@@ -0,0 +1,122 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type pageTestRequest struct {
+	httprequest.Route `httprequest:"GET /things"`
+	httprequest.PageRequest
+	Kind string `httprequest:"kind,form,omitempty"`
+}
+
+func TestPageRequestUnmarshal(t *testing.T) {
+	c := qt.New(t)
+
+	var p pageTestRequest
+	err := httprequest.Unmarshal(httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"limit":  {"10"},
+				"offset": {"20"},
+				"kind":   {"x"},
+			},
+		},
+	}, &p)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(p.PageRequest, qt.DeepEquals, httprequest.PageRequest{
+		Limit:  10,
+		Offset: 20,
+	})
+	c.Assert(p.Kind, qt.Equals, "x")
+}
+
+func TestPageRequestMarshal(t *testing.T) {
+	c := qt.New(t)
+
+	req, err := httprequest.Marshal("http://example.com/things", "GET", &pageTestRequest{
+		PageRequest: httprequest.PageRequest{
+			Cursor: "abc",
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(req.URL.String(), qt.Equals, "http://example.com/things?cursor=abc")
+}
+
+func TestPageLinks(t *testing.T) {
+	c := qt.New(t)
+
+	u, err := url.Parse("/things?kind=x&limit=10&offset=20")
+	c.Assert(err, qt.Equals, nil)
+	rec := httptest.NewRecorder()
+	err = httprequest.WriteJSON(rec, http.StatusOK, httprequest.Page{
+		Items: []string{"a", "b"},
+		URL:   u,
+		Next: &httprequest.PageRequest{
+			Limit:  10,
+			Offset: 30,
+		},
+		Prev: &httprequest.PageRequest{
+			Limit:  10,
+			Offset: 10,
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rec.Body.String(), qt.Equals, `["a","b"]`)
+	c.Assert(rec.Header()["Link"], qt.DeepEquals, []string{
+		`</things?kind=x&limit=10&offset=30>; rel="next"`,
+		`</things?kind=x&limit=10&offset=10>; rel="prev"`,
+	})
+}
+
+func TestPageTimeFormat(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		TimeFormat: &httprequest.TimeFormat{
+			Layout: "unix",
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *pageTestRequest) (*httprequest.Page, error) {
+		return &httprequest.Page{
+			Items: []time.Time{time.Unix(1000, 0)},
+			URL:   p.Request.URL,
+			Next: &httprequest.PageRequest{
+				Limit:  1,
+				Offset: 1,
+			},
+		}, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/things?limit=1", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `[1000]`)
+	c.Assert(rec.Header()["Link"], qt.DeepEquals, []string{
+		`</things?limit=1&offset=1>; rel="next"`,
+	})
+}
+
+func TestPageLinksFirstPage(t *testing.T) {
+	c := qt.New(t)
+
+	u, err := url.Parse("/things?limit=10&offset=10")
+	c.Assert(err, qt.Equals, nil)
+	h := make(http.Header)
+	httprequest.SetPageLinks(h, u, nil, &httprequest.PageRequest{
+		Limit: 10,
+	})
+	c.Assert(h["Link"], qt.DeepEquals, []string{
+		`</things?limit=10>; rel="prev"`,
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+
+	"gopkg.in/errgo.v1"
+)
+
+// pathConstraints holds the constraints that may be given for path
+// parameters in a route pattern (see Server.Handle), keyed by name.
+var pathConstraints = map[string]func(string) bool{
+	"int": func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"uint": func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	},
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"alpha": regexp.MustCompile(`^[a-zA-Z]+$`).MatchString,
+	"alnum": regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString,
+}
+
+// pathConstraint holds a constraint on a path parameter.
+type pathConstraint struct {
+	name       string
+	constraint string
+	check      func(string) bool
+}
+
+// getPathConstraints returns the path constraints for the given
+// constraint names, keyed by parameter name, in parameter name order.
+func getPathConstraints(constraints map[string]string) ([]pathConstraint, error) {
+	pcs := make([]pathConstraint, 0, len(constraints))
+	for name, constraint := range constraints {
+		check := pathConstraints[constraint]
+		if check == nil {
+			return nil, errgo.Newf("unknown constraint %q on path parameter %q", constraint, name)
+		}
+		pcs = append(pcs, pathConstraint{
+			name:       name,
+			constraint: constraint,
+			check:      check,
+		})
+	}
+	sort.Slice(pcs, func(i, j int) bool {
+		return pcs[i].name < pcs[j].name
+	})
+	return pcs, nil
+}
+
+// checkPathConstraints checks the path parameters in p against the
+// given constraints.
+func checkPathConstraints(p Params, pcs []pathConstraint) error {
+	for _, pc := range pcs {
+		for _, pv := range p.PathVar {
+			if pv.Key == pc.name && !pc.check(pv.Value) {
+				return errgo.WithCausef(nil, ErrUnmarshal, "path parameter %s: %q is not a valid %s", pc.name, pv.Value, pc.constraint)
+			}
+		}
+	}
+	return nil
+}
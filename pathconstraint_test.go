@@ -0,0 +1,99 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type constrainedPostsRequest struct {
+	httprequest.Route `httprequest:"GET /users/:id(int)/posts/:post(uuid)"`
+	ID                string `httprequest:"id,path"`
+}
+
+var pathConstraintTests = []struct {
+	about        string
+	path         string
+	expectStatus int
+	expectBody   interface{}
+}{{
+	about:        "valid",
+	path:         "/users/123/posts/5f0c5f2e-4a1b-4c3d-8e9f-0a1b2c3d4e5f",
+	expectStatus: http.StatusOK,
+	expectBody:   "123",
+}, {
+	about:        "invalid int",
+	path:         "/users/abc/posts/5f0c5f2e-4a1b-4c3d-8e9f-0a1b2c3d4e5f",
+	expectStatus: http.StatusInternalServerError,
+	expectBody: &httprequest.RemoteError{
+		Message: `cannot unmarshal parameters: path parameter id: "abc" is not a valid int`,
+	},
+}, {
+	about:        "invalid uuid",
+	path:         "/users/1/posts/nope",
+	expectStatus: http.StatusInternalServerError,
+	expectBody: &httprequest.RemoteError{
+		Message: `cannot unmarshal parameters: path parameter post: "nope" is not a valid uuid`,
+	},
+}}
+
+func TestPathConstraints(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	h := srv.Handle(func(req *constrainedPostsRequest) (string, error) {
+		return req.ID, nil
+	})
+	c.Assert(h.Path, qt.Equals, "/users/:id/posts/:post")
+
+	router := newHandlerRouter([]httprequest.Handler{h})
+	for _, test := range pathConstraintTests {
+		c.Run(test.about, func(c *qt.C) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest("GET", test.path, nil))
+			qthttptest.AssertJSONResponse(c, rec, test.expectStatus, test.expectBody)
+		})
+	}
+}
+
+func TestPathConstraintsMarshal(t *testing.T) {
+	c := qt.New(t)
+
+	// The client builds the URL from the path without constraints.
+	var gotURL string
+	client := httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			gotURL = req.URL.String()
+			return nil, errgo.New("no network")
+		}),
+	}
+	err := client.Call(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET /users/:id(int)"`
+		ID                int `httprequest:"id,path"`
+	}{ID: 42}, nil)
+	c.Assert(err, qt.ErrorMatches, `.*no network`)
+	c.Assert(gotURL, qt.Equals, "http://example.com/users/42")
+}
+
+func TestUnknownPathConstraint(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	c.Assert(func() {
+		srv.Handle(func(*struct {
+			httprequest.Route `httprequest:"GET /users/:id(hex)"`
+		}) {
+		})
+	}, qt.PanicMatches, `bad handler function: .*bad route tag .*: unknown constraint "hex" on path parameter "id"`)
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/tags"
+)
+
+// priorityHeader holds the name of the header used to hold
+// request priorities.
+const priorityHeader = "Priority"
+
+// Priority holds the priority of a request as sent in the
+// Priority header (see RFC 9218).
+type Priority struct {
+	// Urgency holds the urgency of the request, from 0 (most
+	// urgent) to 7 (least urgent).
+	Urgency int
+
+	// Incremental holds whether the client can make use of
+	// the response incrementally as it arrives.
+	Incremental bool
+}
+
+// DefaultPriority holds the priority of requests that do not
+// specify one.
+var DefaultPriority = Priority{
+	Urgency: 3,
+}
+
+// String returns the priority in the form used in the Priority header,
+// for example "u=1, i".
+func (pri Priority) String() string {
+	s := "u=" + strconv.Itoa(pri.Urgency)
+	if pri.Incremental {
+		s += ", i"
+	}
+	return s
+}
+
+// ParsePriority parses the value of a Priority header. Parameters
+// that are not mentioned take their values from DefaultPriority and
+// unknown parameters are ignored.
+func ParsePriority(s string) (Priority, error) {
+	pri, err := tags.ParsePriority(s)
+	if err != nil {
+		return Priority{}, errgo.Mask(err)
+	}
+	return Priority(pri), nil
+}
+
+type priorityKey struct{}
+
+// PriorityFromContext returns the priority stored in the given
+// context, and reports whether there was one. The context passed to
+// handlers created by a Server with Prioritize set always holds the
+// priority of the request.
+func PriorityFromContext(ctx context.Context) (Priority, bool) {
+	pri, ok := ctx.Value(priorityKey{}).(Priority)
+	return pri, ok
+}
+
+// ContextWithPriority returns a context that holds the given priority.
+// A Client uses the priority held in the context of a call in
+// preference to any other.
+func ContextWithPriority(ctx context.Context, pri Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, pri)
+}
+
+// wrapPriority returns a handler that calls srv.Prioritize
+// with the priority of each request before calling h.
+func (srv *Server) wrapPriority(method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		pri, err := ParsePriority(req.Header.Get(priorityHeader))
+		if err != nil {
+			pri = DefaultPriority
+		}
+		ctx := ContextWithPriority(req.Context(), pri)
+		ctx, err = srv.Prioritize(ctx, method, pathPattern, pri)
+		if err != nil {
+			srv.WriteError(req.Context(), w, err)
+			return
+		}
+		h(w, req.WithContext(ctx), p)
+	}
+}
+
+// setPriorityHeader sets the Priority header of req for a call
+// made by c with the given context.
+func (c *Client) setPriorityHeader(ctx context.Context, req *http.Request) {
+	pri, ok := PriorityFromContext(ctx)
+	if !ok {
+		if c.Priority == nil || req.Header.Get(priorityHeader) != "" {
+			return
+		}
+		pri = *c.Priority
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set(priorityHeader, pri.String())
+}
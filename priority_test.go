@@ -0,0 +1,163 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var parsePriorityTests = []struct {
+	s           string
+	expect      httprequest.Priority
+	expectError string
+}{{
+	s:      "",
+	expect: httprequest.DefaultPriority,
+}, {
+	s:      "u=1",
+	expect: httprequest.Priority{Urgency: 1},
+}, {
+	s:      "u=7, i",
+	expect: httprequest.Priority{Urgency: 7, Incremental: true},
+}, {
+	s:      "i=?1,u=0,x=y",
+	expect: httprequest.Priority{Urgency: 0, Incremental: true},
+}, {
+	s:      "i=?0",
+	expect: httprequest.DefaultPriority,
+}, {
+	s:           "u=8",
+	expectError: `invalid urgency "8"`,
+}, {
+	s:           "i=1",
+	expectError: `invalid incremental value "1"`,
+}}
+
+func TestParsePriority(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parsePriorityTests {
+		c.Run(test.s, func(c *qt.C) {
+			pri, err := httprequest.ParsePriority(test.s)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(pri, qt.Equals, test.expect)
+		})
+	}
+	c.Assert(httprequest.Priority{Urgency: 1, Incremental: true}.String(), qt.Equals, "u=1, i")
+}
+
+type priorityRouteReq struct {
+	httprequest.Route `httprequest:"GET /p" priority:"u=1"`
+}
+
+type plainRouteReq struct {
+	httprequest.Route `httprequest:"GET /p"`
+}
+
+func TestClientPriority(t *testing.T) {
+	c := qt.New(t)
+
+	var got string
+	client := &httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.Header.Get("Priority")
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+	ctx := context.Background()
+
+	// No priority is sent by default.
+	err := client.Call(ctx, &plainRouteReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "")
+
+	// The route tag specifies a priority.
+	err = client.Call(ctx, &priorityRouteReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "u=1")
+
+	// The client default is used by routes without a tag.
+	client.Priority = &httprequest.Priority{Urgency: 6}
+	err = client.Call(ctx, &plainRouteReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "u=6")
+	err = client.Call(ctx, &priorityRouteReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "u=1")
+
+	// The context overrides everything.
+	ctx = httprequest.ContextWithPriority(ctx, httprequest.Priority{Urgency: 0, Incremental: true})
+	err = client.Call(ctx, &priorityRouteReq{}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "u=0, i")
+}
+
+func TestBadPriorityTag(t *testing.T) {
+	c := qt.New(t)
+	_, err := httprequest.Marshal("http://example.com", "GET", &struct {
+		httprequest.Route `httprequest:"GET /p" priority:"u=x"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `bad type \*struct .*: bad route tag .*: bad priority tag "u=x": invalid urgency "x"`)
+}
+
+func TestServerPrioritize(t *testing.T) {
+	c := qt.New(t)
+
+	var gotRoute string
+	var gotPriority httprequest.Priority
+	srv := httprequest.Server{
+		Prioritize: func(ctx context.Context, method, pathPattern string, pri httprequest.Priority) (context.Context, error) {
+			gotRoute = method + " " + pathPattern
+			gotPriority = pri
+			if pri.Urgency == 7 {
+				return nil, errgo.New("too busy for background work")
+			}
+			return ctx, nil
+		},
+	}
+	var ctxPriority httprequest.Priority
+	h := srv.Handle(func(p httprequest.Params, req *plainRouteReq) {
+		ctxPriority, _ = httprequest.PriorityFromContext(p.Context)
+	})
+	router := newHandlerRouter([]httprequest.Handler{h})
+
+	req := httptest.NewRequest("GET", "/p", nil)
+	req.Header.Set("Priority", "u=2, i")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(gotRoute, qt.Equals, "GET /p")
+	c.Assert(gotPriority, qt.Equals, httprequest.Priority{Urgency: 2, Incremental: true})
+	c.Assert(ctxPriority, qt.Equals, gotPriority)
+
+	// An invalid header results in the default priority.
+	req = httptest.NewRequest("GET", "/p", nil)
+	req.Header.Set("Priority", "u=99")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(gotPriority, qt.Equals, httprequest.DefaultPriority)
+
+	req = httptest.NewRequest("GET", "/p", nil)
+	req.Header.Set("Priority", "u=7")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), qt.Contains, "too busy for background work")
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ProblemMediaType holds the media type of the RFC 7807 problem
+// details format.
+const ProblemMediaType = "application/problem+json"
+
+// problemDetails holds the JSON form of an RFC 7807 problem details
+// object. The fields of a RemoteError that have no standard
+// equivalent are held in extension members.
+type problemDetails struct {
+	Type      string           `json:"type,omitempty"`
+	Title     string           `json:"title,omitempty"`
+	Status    int              `json:"status,omitempty"`
+	Detail    string           `json:"detail,omitempty"`
+	Instance  string           `json:"instance,omitempty"`
+	Code      string           `json:"code,omitempty"`
+	Info      *json.RawMessage `json:"info,omitempty"`
+	RequestID string           `json:"requestId,omitempty"`
+}
+
+// ProblemErrorWriter returns a function, suitable for use as
+// Server.ErrorWriter, that writes error responses in the RFC 7807
+// problem details format instead of as RemoteError JSON objects.
+//
+// The status code and error body are determined by the given mapper,
+// or by DefaultErrorMapper if it is nil. When the body is a
+// *RemoteError, it is written as a problem details object with the
+// message as its detail and with the code, info and request ID held
+// in the "code", "info" and "requestId" extension members. Other
+// bodies are written unchanged as JSON.
+//
+// The error unmarshaler used by Client by default understands both
+// formats.
+func ProblemErrorWriter(mapper func(ctx context.Context, err error) (int, interface{})) func(ctx context.Context, w http.ResponseWriter, err error) {
+	if mapper == nil {
+		mapper = DefaultErrorMapper
+	}
+	return func(ctx context.Context, w http.ResponseWriter, err error) {
+		status, body := mapper(ctx, err)
+		rerr, ok := body.(*RemoteError)
+		if !ok {
+			if err := WriteJSON(w, status, body); err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			return
+		}
+		data, err1 := json.Marshal(&problemDetails{
+			Type:      "about:blank",
+			Title:     http.StatusText(status),
+			Status:    status,
+			Detail:    rerr.Message,
+			Code:      rerr.Code,
+			Info:      rerr.Info,
+			RequestID: rerr.RequestID,
+		})
+		if err1 != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", ProblemMediaType)
+		w.WriteHeader(status)
+		w.Write(data)
+	}
+}
+
+var remoteErrorType = reflect.TypeOf(RemoteError{})
+
+// isProblemResponse reports whether resp holds a problem details
+// object.
+func isProblemResponse(resp *http.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return mediaType == ProblemMediaType
+}
+
+// unmarshalProblem unmarshals the problem details object in the body
+// of resp into a *RemoteError. The message is taken from the detail
+// member, or the title if there is none. When the problem has no
+// code member, the code is derived from the status as the inverse of
+// DefaultErrorMapper.
+func unmarshalProblem(resp *http.Response) error {
+	var p problemDetails
+	if err := UnmarshalJSONResponse(resp, &p); err != nil {
+		return errgo.NoteMask(err, "cannot unmarshal error response (status "+resp.Status+")", isDecodeResponseError)
+	}
+	rerr := &RemoteError{
+		Message:   p.Detail,
+		Code:      p.Code,
+		Info:      p.Info,
+		RequestID: p.RequestID,
+	}
+	if rerr.Message == "" {
+		rerr.Message = p.Title
+	}
+	if rerr.Code == "" {
+		status := p.Status
+		if status == 0 {
+			status = resp.StatusCode
+		}
+		rerr.Code = StatusErrorCode(status)
+	}
+	return rerr
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package problem implements RFC 7807 problem details for services
+// built with httprequest. Handlers return *Problem errors, a Server
+// writes them in the problem details format with ErrorWriter, and a
+// Client decodes them again with ErrorUnmarshaler:
+//
+//	srv := &httprequest.Server{
+//		ErrorWriter: problem.ErrorWriter,
+//	}
+//	client := &httprequest.Client{
+//		UnmarshalError: problem.ErrorUnmarshaler,
+//	}
+//
+// A handler can then return errors such as:
+//
+//	return nil, problem.NotFound("no user %q", req.Name)
+//
+// Because *Problem implements httprequest.ErrorCoder and
+// httprequest.StatusCoder, problems are also mapped to the right
+// status by httprequest.DefaultErrorMapper.
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// Problem holds an RFC 7807 problem details object. It implements
+// the error interface so that it can be returned by handlers.
+type Problem struct {
+	// Type holds a URI reference that identifies the problem
+	// type. If it is empty, "about:blank" is implied and Title
+	// should be the status text.
+	Type string
+
+	// Title holds a short summary of the problem type.
+	Title string
+
+	// Status holds the HTTP status code of the response. If it is
+	// zero, http.StatusInternalServerError is used.
+	Status int
+
+	// Detail holds an explanation specific to this occurrence of
+	// the problem.
+	Detail string
+
+	// Instance holds a URI reference that identifies this
+	// occurrence of the problem.
+	Instance string
+
+	// Code holds the httprequest error code of the problem (see
+	// httprequest.ErrorCoder), sent in the "code" member.
+	Code string
+
+	// InvalidParams holds the parameters that failed validation,
+	// sent in the "invalid-params" member.
+	InvalidParams []InvalidParam
+
+	// RequestID holds the ID of the request that failed, if
+	// known, sent in the "requestId" member.
+	RequestID string
+
+	// Extensions holds any other members of the problem.
+	Extensions map[string]interface{}
+}
+
+// InvalidParam describes a request parameter that failed validation.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+var (
+	_ httprequest.ErrorCoder  = (*Problem)(nil)
+	_ httprequest.StatusCoder = (*Problem)(nil)
+)
+
+// New returns a problem with the given status whose detail is
+// formatted with fmt.Sprintf(f, a...). Its title is the status text
+// and its code is the one that httprequest.DefaultErrorMapper maps to
+// the status, if any.
+func New(status int, f string, a ...interface{}) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: fmt.Sprintf(f, a...),
+		Code:   httprequest.StatusErrorCode(status),
+	}
+}
+
+// NotFound returns a 404 Not Found problem.
+func NotFound(f string, a ...interface{}) *Problem {
+	return New(http.StatusNotFound, f, a...)
+}
+
+// Conflict returns a 409 Conflict problem.
+func Conflict(f string, a ...interface{}) *Problem {
+	return New(http.StatusConflict, f, a...)
+}
+
+// Validation returns a 400 Bad Request problem describing the given
+// invalid parameters.
+func Validation(detail string, params ...InvalidParam) *Problem {
+	p := New(http.StatusBadRequest, "%s", detail)
+	p.InvalidParams = params
+	return p
+}
+
+// Error implements the error interface by returning the detail of
+// the problem or, if there is none, its title.
+func (p *Problem) Error() string {
+	switch {
+	case p.Detail != "":
+		return p.Detail
+	case p.Title != "":
+		return p.Title
+	}
+	return http.StatusText(p.StatusCode())
+}
+
+// ErrorCode implements httprequest.ErrorCoder by returning p.Code.
+func (p *Problem) ErrorCode() string {
+	return p.Code
+}
+
+// StatusCode implements httprequest.StatusCoder by returning
+// p.Status, or http.StatusInternalServerError if it is zero.
+func (p *Problem) StatusCode() int {
+	if p.Status == 0 {
+		return http.StatusInternalServerError
+	}
+	return p.Status
+}
+
+// standardMembers holds the members of a problem that have fields in
+// Problem.
+type standardMembers struct {
+	Type          string         `json:"type,omitempty"`
+	Title         string         `json:"title,omitempty"`
+	Status        int            `json:"status,omitempty"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code,omitempty"`
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+	RequestID     string         `json:"requestId,omitempty"`
+}
+
+var standardNames = []string{"type", "title", "status", "detail", "instance", "code", "invalid-params", "requestId"}
+
+// MarshalJSON implements json.Marshaler by marshaling the problem
+// as a single object holding both its fields and its extensions.
+func (p *Problem) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(standardMembers{
+		Type:          p.Type,
+		Title:         p.Title,
+		Status:        p.Status,
+		Detail:        p.Detail,
+		Instance:      p.Instance,
+		Code:          p.Code,
+		InvalidParams: p.InvalidParams,
+		RequestID:     p.RequestID,
+	})
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for name, v := range p.Extensions {
+		if _, ok := m[name]; !ok {
+			m[name] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. Members that have no
+// field in Problem are stored in p.Extensions.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	var std standardMembers
+	if err := json.Unmarshal(data, &std); err != nil {
+		return err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	for _, name := range standardNames {
+		delete(m, name)
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*p = Problem{
+		Type:          std.Type,
+		Title:         std.Title,
+		Status:        std.Status,
+		Detail:        std.Detail,
+		Instance:      std.Instance,
+		Code:          std.Code,
+		InvalidParams: std.InvalidParams,
+		RequestID:     std.RequestID,
+		Extensions:    m,
+	}
+	return nil
+}
+
+// ErrorWriter writes err as a problem details response. It is
+// suitable for use as httprequest.Server.ErrorWriter. When the cause
+// of err is a *Problem, it is written as is, with the ID of the
+// request added if it has none; other errors are written as by
+// httprequest.ProblemErrorWriter.
+func ErrorWriter(ctx context.Context, w http.ResponseWriter, err error) {
+	p, ok := errgo.Cause(err).(*Problem)
+	if !ok {
+		httprequest.ProblemErrorWriter(nil)(ctx, w, err)
+		return
+	}
+	p1 := *p
+	if p1.RequestID == "" {
+		p1.RequestID = httprequest.RequestIDFromContext(ctx)
+	}
+	data, err := json.Marshal(&p1)
+	if err != nil {
+		httprequest.ProblemErrorWriter(nil)(ctx, w, errgo.Notef(err, "cannot marshal problem %q", p.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", httprequest.ProblemMediaType)
+	w.WriteHeader(p.StatusCode())
+	w.Write(data)
+}
+
+// ErrorUnmarshaler unmarshals an error response into a *Problem if it
+// is in the problem details format, and with
+// httprequest.DefaultErrorUnmarshaler otherwise. It is suitable for
+// use as httprequest.Client.UnmarshalError.
+//
+// When the problem has no code member, Code is set to the error code
+// that corresponds to its status, if any, so that errors can be
+// classified in the same way as an *httprequest.RemoteError.
+func ErrorUnmarshaler(resp *http.Response) error {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != httprequest.ProblemMediaType {
+		return httprequest.DefaultErrorUnmarshaler(resp)
+	}
+	var p Problem
+	if err := httprequest.UnmarshalJSONResponse(resp, &p); err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot unmarshal error response (status %s)", resp.Status), errgo.Any)
+	}
+	if p.Status == 0 {
+		p.Status = resp.StatusCode
+	}
+	if p.Code == "" {
+		p.Code = httprequest.StatusErrorCode(p.Status)
+	}
+	return &p
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package problem_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/httprequest.v1/problem"
+)
+
+type getReq struct {
+	httprequest.Route `httprequest:"GET /things/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+type putReq struct {
+	httprequest.Route `httprequest:"PUT /things/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+type handlers struct{}
+
+func (handlers) Get(p httprequest.Params, req *getReq) (string, error) {
+	switch req.Name {
+	case "missing":
+		return "", errgo.Mask(problem.NotFound("no thing %q", req.Name), errgo.Any)
+	case "plain":
+		return "", errgo.New("plain error")
+	}
+	return req.Name, nil
+}
+
+func (handlers) Put(p httprequest.Params, req *putReq) error {
+	if req.Name == "exists" {
+		return problem.Conflict("thing %q already exists", req.Name)
+	}
+	return problem.Validation("invalid thing", problem.InvalidParam{
+		Name:   "name",
+		Reason: "must be lower case",
+	})
+}
+
+func newServer(c *qt.C) *httptest.Server {
+	srv := &httprequest.Server{
+		ErrorWriter: problem.ErrorWriter,
+		RequestID:   true,
+	}
+	hsrv := httptest.NewServer(srv.HTTPHandler(srv.Handlers(func(p httprequest.Params) (handlers, context.Context, error) {
+		return handlers{}, p.Context, nil
+	})))
+	c.Defer(hsrv.Close)
+	return hsrv
+}
+
+func TestErrorWriter(t *testing.T) {
+	c := qt.New(t)
+	hsrv := newServer(c)
+	defer c.Done()
+
+	resp, err := http.Get(hsrv.URL + "/things/missing")
+	c.Assert(err, qt.IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotFound)
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/problem+json")
+	var body map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	c.Assert(err, qt.IsNil)
+	c.Assert(body["requestId"], qt.Not(qt.Equals), "")
+	delete(body, "requestId")
+	c.Assert(body, qt.DeepEquals, map[string]interface{}{
+		"title":  "Not Found",
+		"status": 404.0,
+		"detail": `no thing "missing"`,
+		"code":   "not found",
+	})
+}
+
+func TestClientRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	hsrv := newServer(c)
+	defer c.Done()
+
+	client := &httprequest.Client{
+		BaseURL:        hsrv.URL,
+		UnmarshalError: problem.ErrorUnmarshaler,
+	}
+	ctx := context.Background()
+
+	var s string
+	err := client.Call(ctx, &getReq{Name: "missing"}, &s)
+	c.Assert(err, qt.ErrorMatches, `Get "?http://.*/things/missing"?: no thing "missing"`)
+	p, ok := errgo.Cause(err).(*problem.Problem)
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(p.Status, qt.Equals, http.StatusNotFound)
+	c.Assert(p.Code, qt.Equals, httprequest.CodeNotFound)
+	c.Assert(p.RequestID, qt.Not(qt.Equals), "")
+
+	err = client.Call(ctx, &putReq{Name: "exists"}, nil)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &problem.Problem{
+		Title:     "Conflict",
+		Status:    http.StatusConflict,
+		Detail:    `thing "exists" already exists`,
+		Code:      httprequest.CodeConflict,
+		RequestID: errgo.Cause(err).(*problem.Problem).RequestID,
+	})
+
+	err = client.Call(ctx, &putReq{Name: "Bad"}, nil)
+	p = errgo.Cause(err).(*problem.Problem)
+	c.Assert(p.Status, qt.Equals, http.StatusBadRequest)
+	c.Assert(p.Code, qt.Equals, httprequest.CodeBadRequest)
+	c.Assert(p.InvalidParams, qt.DeepEquals, []problem.InvalidParam{{
+		Name:   "name",
+		Reason: "must be lower case",
+	}})
+
+	// Errors that are not problems are still written as
+	// problem details.
+	err = client.Call(ctx, &getReq{Name: "plain"}, &s)
+	p = errgo.Cause(err).(*problem.Problem)
+	c.Assert(p.Status, qt.Equals, http.StatusInternalServerError)
+	c.Assert(p.Detail, qt.Equals, "plain error")
+}
+
+func TestErrorUnmarshalerRemoteError(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		httprequest.WriteJSON(w, http.StatusForbidden, &httprequest.RemoteError{
+			Message: "go away",
+			Code:    httprequest.CodeForbidden,
+		})
+	}))
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL:        srv.URL,
+		UnmarshalError: problem.ErrorUnmarshaler,
+	}
+	err := client.Get(context.Background(), "/", nil)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.RemoteError{
+		Message: "go away",
+		Code:    httprequest.CodeForbidden,
+	})
+}
+
+func TestErrorUnmarshalerStatusFromResponse(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit.","balance":30}`))
+	}))
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL:        srv.URL,
+		UnmarshalError: problem.ErrorUnmarshaler,
+	}
+	err := client.Get(context.Background(), "/", nil)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &problem.Problem{
+		Type:   "https://example.com/probs/out-of-credit",
+		Title:  "You do not have enough credit.",
+		Status: http.StatusForbidden,
+		Code:   httprequest.CodeForbidden,
+		Extensions: map[string]interface{}{
+			"balance": 30.0,
+		},
+	})
+}
+
+func TestDefaultErrorMapper(t *testing.T) {
+	c := qt.New(t)
+
+	status, body := httprequest.DefaultErrorMapper(context.Background(), errgo.Mask(problem.New(http.StatusGone, "gone away"), errgo.Any))
+	c.Assert(status, qt.Equals, http.StatusGone)
+	c.Assert(body, qt.DeepEquals, &httprequest.RemoteError{
+		Message: "gone away",
+	})
+
+	status, body = httprequest.DefaultErrorMapper(context.Background(), problem.NotFound("nothing"))
+	c.Assert(status, qt.Equals, http.StatusNotFound)
+	c.Assert(body, qt.DeepEquals, &httprequest.RemoteError{
+		Message: "nothing",
+		Code:    httprequest.CodeNotFound,
+	})
+}
+
+func TestMarshalJSON(t *testing.T) {
+	c := qt.New(t)
+
+	p := &problem.Problem{
+		Type:   "https://example.com/probs/out-of-credit",
+		Title:  "You do not have enough credit.",
+		Status: http.StatusForbidden,
+		Extensions: map[string]interface{}{
+			"balance": 30,
+			"title":   "ignored",
+		},
+	}
+	data, err := json.Marshal(p)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.JSONEquals, map[string]interface{}{
+		"type":    "https://example.com/probs/out-of-credit",
+		"title":   "You do not have enough credit.",
+		"status":  403,
+		"balance": 30,
+	})
+}
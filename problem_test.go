@@ -0,0 +1,144 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type problemReq struct {
+	httprequest.Route `httprequest:"GET /problem/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+func TestProblemErrorWriter(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		ErrorWriter: httprequest.ProblemErrorWriter(nil),
+	}
+	h := srv.Handle(func(p httprequest.Params, req *problemReq) error {
+		return httprequest.Errorf(httprequest.CodeNotFound, "thing %q not found", req.ID)
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/problem/x", nil), httprouter.Params{{Key: "id", Value: "x"}})
+	c.Assert(rec.Code, qt.Equals, http.StatusNotFound)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, httprequest.ProblemMediaType)
+	c.Assert(rec.Body.String(), qt.JSONEquals, map[string]interface{}{
+		"type":   "about:blank",
+		"title":  "Not Found",
+		"status": 404,
+		"detail": `thing "x" not found`,
+		"code":   "not found",
+	})
+}
+
+func TestProblemErrorWriterNonRemoteError(t *testing.T) {
+	c := qt.New(t)
+
+	w := httprequest.ProblemErrorWriter(func(ctx context.Context, err error) (int, interface{}) {
+		return http.StatusTeapot, map[string]string{"oops": err.Error()}
+	})
+	rec := httptest.NewRecorder()
+	w(context.Background(), rec, errgo.New("boiling"))
+	c.Assert(rec.Code, qt.Equals, http.StatusTeapot)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+	c.Assert(rec.Body.String(), qt.Equals, `{"oops":"boiling"}`)
+}
+
+var problemUnmarshalTests = []struct {
+	about       string
+	status      int
+	contentType string
+	body        string
+	expectError string
+	expectCode  string
+	expectInfo  string
+}{{
+	about:       "problem with code",
+	status:      http.StatusConflict,
+	contentType: "application/problem+json",
+	body:        `{"type":"about:blank","title":"Conflict","status":409,"detail":"already exists","code":"conflict","info":{"a":1}}`,
+	expectError: `Get http://.*/x: already exists`,
+	expectCode:  httprequest.CodeConflict,
+	expectInfo:  `{"a":1}`,
+}, {
+	about:       "problem without code or detail",
+	status:      http.StatusForbidden,
+	contentType: "application/problem+json; charset=utf-8",
+	body:        `{"type":"https://example.com/probs/out-of-credit","title":"You do not have enough credit."}`,
+	expectError: `Get http://.*/x: You do not have enough credit.`,
+	expectCode:  httprequest.CodeForbidden,
+}, {
+	about:       "problem with unmapped status",
+	status:      http.StatusTeapot,
+	contentType: "application/problem+json",
+	body:        `{"title":"I'm a teapot","status":418}`,
+	expectError: `Get http://.*/x: I'm a teapot`,
+}, {
+	about:       "plain remote error",
+	status:      http.StatusBadRequest,
+	contentType: "application/json",
+	body:        `{"Message":"bad","Code":"bad request"}`,
+	expectError: `Get http://.*/x: bad`,
+	expectCode:  httprequest.CodeBadRequest,
+}}
+
+func TestClientUnmarshalsProblem(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range problemUnmarshalTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.WriteHeader(test.status)
+				w.Write([]byte(test.body))
+			}))
+			defer srv.Close()
+			client := &httprequest.Client{
+				BaseURL: srv.URL,
+			}
+			err := client.Get(context.Background(), "/x", nil)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+			c.Assert(ok, qt.Equals, true, qt.Commentf("%#v", errgo.Cause(err)))
+			c.Assert(rerr.Code, qt.Equals, test.expectCode)
+			if test.expectInfo != "" {
+				c.Assert(rerr.Info, qt.Not(qt.IsNil))
+				c.Assert(string(*rerr.Info), qt.Equals, test.expectInfo)
+			}
+		})
+	}
+}
+
+func TestProblemRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		ErrorWriter: httprequest.ProblemErrorWriter(nil),
+		RequestID:   true,
+	}
+	hsrv := httptest.NewServer(srv.HTTPHandler([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, req *problemReq) error {
+			return errgo.WithCausef(nil, httprequest.Errorf(httprequest.CodeBadRequest, ""), "invalid id %q", req.ID)
+		}),
+	}))
+	defer hsrv.Close()
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	err := client.Get(context.Background(), "/problem/x", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/problem/x: invalid id "x"`)
+	rerr := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(rerr.Code, qt.Equals, httprequest.CodeBadRequest)
+	c.Assert(rerr.RequestID, qt.Not(qt.Equals), "")
+}
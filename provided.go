@@ -0,0 +1,59 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"reflect"
+)
+
+// providedFields records the fields of an argument struct
+// that were provided in a request.
+type providedFields struct {
+	names map[string]bool
+}
+
+// add records that f was provided.
+func (pf *providedFields) add(f field) {
+	if pf.names == nil {
+		pf.names = make(map[string]bool)
+	}
+	pf.names[f.name] = true
+	pf.names[f.tag.name] = true
+}
+
+// Provided reports whether the request supplied a value for the field
+// with the given name in the argument struct of the handler, so that
+// a field that was absent can be told apart from one that was
+// explicitly set to its zero value. The name may be either the Go name
+// of the field or the name of the parameter given in its tag.
+//
+// A path, form, header or cookie field is provided if the request has
+// a value with the field's name, a form map field is provided if it
+// collected any values, and a body field is provided if the request
+// has a non-empty body. Other fields are never provided.
+//
+// Provided only has this information for the Params passed to
+// handlers created by a Server; otherwise it always returns false.
+func (p Params) Provided(name string) bool {
+	return p.provided != nil && p.provided.names[name]
+}
+
+// fieldProvided reports whether the request in p supplied a value
+// for the field f, which has been unmarshaled into fv.
+func fieldProvided(f field, fv reflect.Value, p Params) bool {
+	switch {
+	case f.tag.isMap:
+		if fv.Kind() == reflect.Ptr {
+			fv = fv.Elem()
+		}
+		return fv.IsValid() && fv.Len() > 0
+	case f.source == sourceBody:
+		return p.Request.Body != nil && p.Request.Body != http.NoBody && p.Request.ContentLength != 0
+	case int(f.source) < len(formGetters) && formGetters[f.source] != nil:
+		_, ok := formGetters[f.source](f.tag.name, p)
+		return ok
+	}
+	return false
+}
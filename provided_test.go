@@ -0,0 +1,113 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type providedReq struct {
+	httprequest.Route `httprequest:"POST /provided/:id"`
+	ID                string            `httprequest:"id,path"`
+	Count             int               `httprequest:"count,form"`
+	Limit             int               `httprequest:"limit,form"`
+	Token             string            `httprequest:"X-Token,header"`
+	Session           string            `httprequest:"session,cookie"`
+	Body              *struct{}         `httprequest:",body"`
+	Rest              map[string]string `httprequest:",form,map"`
+	Ignored           int
+}
+
+var providedNames = []string{"ID", "id", "Count", "count", "Limit", "limit", "Token", "X-Token", "Session", "Body", "Rest", "Ignored"}
+
+func TestParamsProvided(t *testing.T) {
+	c := qt.New(t)
+
+	got := make(map[string]bool)
+	srv := httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, req *providedReq) {
+		for _, name := range providedNames {
+			got[name] = p.Provided(name)
+		}
+	})
+	router := newHandlerRouter([]httprequest.Handler{h})
+
+	req := httptest.NewRequest("POST", "/provided/1?count=0", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Token", "")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK, qt.Commentf("%s", rec.Body))
+	c.Assert(got, qt.DeepEquals, map[string]bool{
+		"ID":      true,
+		"id":      true,
+		"Count":   true,
+		"count":   true,
+		"Limit":   false,
+		"limit":   false,
+		"Token":   true,
+		"X-Token": true,
+		"Session": false,
+		"Body":    true,
+		"Rest":    false,
+		"Ignored": false,
+	})
+
+	req = httptest.NewRequest("POST", "/provided/1?limit=5&other=x", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	req.AddCookie(&http.Cookie{Name: "session", Value: "s"})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK, qt.Commentf("%s", rec.Body))
+	c.Assert(got["Count"], qt.IsFalse)
+	c.Assert(got["Limit"], qt.IsTrue)
+	c.Assert(got["Session"], qt.IsTrue)
+	c.Assert(got["Rest"], qt.IsTrue)
+}
+
+type providedHandlers struct{}
+
+func (providedHandlers) Get(p httprequest.Params, req *struct {
+	httprequest.Route `httprequest:"GET /provided"`
+	Count             int `httprequest:"count,form"`
+}) (bool, error) {
+	return p.Provided("count"), nil
+}
+
+func TestParamsProvidedHandlers(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	hs := srv.Handlers(func(p httprequest.Params) (providedHandlers, context.Context, error) {
+		return providedHandlers{}, p.Context, nil
+	})
+	rec := httptest.NewRecorder()
+	hs[0].Handle(rec, httptest.NewRequest("GET", "/provided?count=0", nil), nil)
+	c.Assert(rec.Body.String(), qt.Equals, "true")
+}
+
+func TestParamsProvidedOutsideHandler(t *testing.T) {
+	c := qt.New(t)
+
+	req := httptest.NewRequest("GET", "/provided?count=1", nil)
+	req.ParseForm()
+	p := httprequest.Params{
+		Request: req,
+	}
+	var x struct {
+		Count int `httprequest:"count,form"`
+	}
+	err := httprequest.Unmarshal(p, &x)
+	c.Assert(err, qt.IsNil)
+	c.Assert(x.Count, qt.Equals, 1)
+	c.Assert(p.Provided("count"), qt.IsFalse)
+}
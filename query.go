@@ -0,0 +1,64 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// CanonicalQuery returns the given query parameters encoded in a
+// canonical form suitable for computing signatures over or for use
+// in cache keys. The parameters are ordered by name; values with the
+// same name retain their relative order. All bytes other than the
+// RFC 3986 unreserved characters (letters, digits, '-', '.', '_' and
+// '~') are percent-encoded with upper case hex digits, so, for
+// example, a space is encoded as %20 rather than +.
+//
+// See also Client.CanonicalQuery.
+func CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf strings.Builder
+	for _, k := range keys {
+		for _, v := range q[k] {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(escapeRFC3986(k))
+			buf.WriteByte('=')
+			buf.WriteString(escapeRFC3986(v))
+		}
+	}
+	return buf.String()
+}
+
+// escapeRFC3986 percent-encodes all the bytes in s
+// other than unreserved characters.
+func escapeRFC3986(s string) string {
+	const hex = "0123456789ABCDEF"
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isUnreserved(c) {
+			buf.WriteByte(c)
+			continue
+		}
+		buf.WriteByte('%')
+		buf.WriteByte(hex[c>>4])
+		buf.WriteByte(hex[c&0xf])
+	}
+	return buf.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
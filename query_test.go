@@ -0,0 +1,82 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var canonicalQueryTests = []struct {
+	about  string
+	q      url.Values
+	expect string
+}{{
+	about:  "empty",
+	expect: "",
+}, {
+	about: "sorted by name",
+	q: url.Values{
+		"b": {"2"},
+		"a": {"1"},
+		"c": {"3"},
+	},
+	expect: "a=1&b=2&c=3",
+}, {
+	about: "values keep their order",
+	q: url.Values{
+		"x": {"z", "a", "m"},
+	},
+	expect: "x=z&x=a&x=m",
+}, {
+	about: "escaping",
+	q: url.Values{
+		"a b": {"c d+e/f~g_h.i-j*"},
+		"é":   {""},
+	},
+	expect: "a%20b=c%20d%2Be%2Ff~g_h.i-j%2A&%C3%A9=",
+}}
+
+func TestCanonicalQuery(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range canonicalQueryTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(httprequest.CanonicalQuery(test.q), qt.Equals, test.expect)
+		})
+	}
+}
+
+func TestClientCanonicalQuery(t *testing.T) {
+	c := qt.New(t)
+
+	var rawQuery string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rawQuery = req.URL.RawQuery
+	}))
+	defer srv.Close()
+
+	type params struct {
+		httprequest.Route `httprequest:"GET /x"`
+		B                 string `httprequest:"b,form"`
+		A                 string `httprequest:"a,form"`
+	}
+	client := httprequest.Client{
+		BaseURL: srv.URL + "?z=1",
+	}
+	err := client.Call(context.Background(), &params{B: "x y", A: "1"}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rawQuery, qt.Equals, "z=1&a=1&b=x+y")
+
+	client.CanonicalQuery = true
+	err = client.Call(context.Background(), &params{B: "x y", A: "1"}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rawQuery, qt.Equals, "a=1&b=x%20y&z=1")
+}
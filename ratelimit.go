@@ -0,0 +1,73 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// RateLimiter is used by a Server to limit the rate of requests.
+// See the ratelimit package for an implementation.
+type RateLimiter interface {
+	// Allow is called before a request to the given route is
+	// handled. The route is in the form "METHOD /path/pattern" as
+	// found in Handler, and key identifies the caller (see
+	// Server.RateLimitKey). If the request should be rejected,
+	// Allow returns an error; a *RateLimitError causes a response
+	// with a 429 Too Many Requests status and a Retry-After header.
+	Allow(ctx context.Context, route, key string) error
+}
+
+// RateLimitError is the error returned by a RateLimiter when
+// a request exceeds the allowed rate.
+type RateLimitError struct {
+	// RetryAfter holds how long the caller should wait
+	// before trying again.
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit exceeded; retry after %v", e.RetryAfter)
+}
+
+// ErrorCode implements ErrorCoder by returning CodeTooManyRequests.
+func (e *RateLimitError) ErrorCode() string {
+	return CodeTooManyRequests
+}
+
+// wrapRateLimit returns a handler that checks requests to the route
+// with the given method and path pattern with srv.RateLimiter before
+// calling h.
+func (srv *Server) wrapRateLimit(method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	route := method + " " + pathPattern
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		var key string
+		if srv.RateLimitKey != nil {
+			key = srv.RateLimitKey(req)
+		} else if ip, ok := ClientIPFromContext(req.Context()); ok {
+			key = ip.String()
+		}
+		if err := srv.RateLimiter.Allow(req.Context(), route, key); err != nil {
+			if rerr, ok := errgo.Cause(err).(*RateLimitError); ok {
+				secs := int64(math.Ceil(rerr.RetryAfter.Seconds()))
+				if secs < 1 {
+					secs = 1
+				}
+				w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+			}
+			srv.WriteError(req.Context(), w, err)
+			return
+		}
+		h(w, req, p)
+	}
+}
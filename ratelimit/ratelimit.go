@@ -0,0 +1,126 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package ratelimit provides an in-memory implementation of
+// httprequest.RateLimiter.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// TokenBucket is an in-memory rate limiter that uses a token bucket
+// for each route and caller. Each bucket holds up to Burst tokens and
+// is refilled at Rate tokens per second; each request takes a token,
+// and requests that find their bucket empty are rejected.
+//
+// A TokenBucket must not be copied after first use.
+type TokenBucket struct {
+	// Rate holds the number of requests per second allowed
+	// in the long term for each route and caller.
+	Rate float64
+
+	// Burst holds the maximum number of requests that may be
+	// made at once. If it is less than one, one is used.
+	Burst int
+
+	// Routes holds the rate and burst to use for particular
+	// routes, overriding Rate and Burst. The keys are routes in
+	// the form "METHOD /path/pattern". Only the Rate and Burst
+	// fields of the values are used.
+	Routes map[string]*TokenBucket
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	nextPurge time.Time
+}
+
+var _ httprequest.RateLimiter = (*TokenBucket)(nil)
+
+type bucketKey struct {
+	route string
+	key   string
+}
+
+type bucket struct {
+	tokens float64
+	// updated holds when tokens was last brought up to date.
+	updated time.Time
+	// full holds when the bucket will be full.
+	full time.Time
+}
+
+// Allow implements httprequest.RateLimiter.Allow.
+func (tb *TokenBucket) Allow(ctx context.Context, route, key string) error {
+	rate, burst := tb.Rate, tb.Burst
+	if r := tb.Routes[route]; r != nil {
+		rate, burst = r.Rate, r.Burst
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	now := time.Now()
+	if tb.Now != nil {
+		now = tb.Now()
+	}
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	if tb.buckets == nil {
+		tb.buckets = make(map[bucketKey]*bucket)
+	}
+	if now.After(tb.nextPurge) {
+		// Full buckets are equivalent to new ones,
+		// so there is no need to keep them.
+		for k, b := range tb.buckets {
+			if !now.Before(b.full) {
+				delete(tb.buckets, k)
+			}
+		}
+		tb.nextPurge = now.Add(time.Minute)
+	}
+	bk := bucketKey{route, key}
+	b := tb.buckets[bk]
+	if b == nil {
+		b = &bucket{
+			tokens:  float64(burst),
+			updated: now,
+		}
+		tb.buckets[bk] = b
+	}
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.updated = now
+	}
+	if b.tokens < 1 {
+		if rate <= 0 {
+			return &httprequest.RateLimitError{
+				RetryAfter: time.Hour,
+			}
+		}
+		return &httprequest.RateLimitError{
+			RetryAfter: secondsToDuration((1 - b.tokens) / rate),
+		}
+	}
+	b.tokens--
+	if rate > 0 {
+		b.full = now.Add(secondsToDuration((float64(burst) - b.tokens) / rate))
+	} else {
+		// The bucket will never refill, so keep it forever.
+		b.full = now.Add(100 * 365 * 24 * time.Hour)
+	}
+	return nil
+}
+
+func secondsToDuration(secs float64) time.Duration {
+	return time.Duration(secs * float64(time.Second))
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/httprequest.v1/ratelimit"
+)
+
+func TestTokenBucket(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tb := &ratelimit.TokenBucket{
+		Rate:  2,
+		Burst: 3,
+		Routes: map[string]*ratelimit.TokenBucket{
+			"POST /slow": {
+				Rate: 0.1,
+			},
+		},
+		Now: func() time.Time {
+			return now
+		},
+	}
+	ctx := context.Background()
+
+	// The burst is allowed.
+	for i := 0; i < 3; i++ {
+		c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Equals, nil)
+	}
+	err := tb.Allow(ctx, "GET /x", "a")
+	c.Assert(err, qt.DeepEquals, &httprequest.RateLimitError{
+		RetryAfter: 500 * time.Millisecond,
+	})
+
+	// Other callers and routes have their own buckets.
+	c.Assert(tb.Allow(ctx, "GET /x", "b"), qt.Equals, nil)
+	c.Assert(tb.Allow(ctx, "GET /y", "a"), qt.Equals, nil)
+
+	// Tokens are refilled over time.
+	now = now.Add(time.Second)
+	c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Equals, nil)
+	c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Equals, nil)
+	c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Not(qt.IsNil))
+
+	// Routes can have their own limits.
+	c.Assert(tb.Allow(ctx, "POST /slow", "a"), qt.Equals, nil)
+	err = tb.Allow(ctx, "POST /slow", "a")
+	c.Assert(err, qt.DeepEquals, &httprequest.RateLimitError{
+		RetryAfter: 10 * time.Second,
+	})
+
+	// After a long time, the buckets are full again.
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Equals, nil)
+	}
+	c.Assert(tb.Allow(ctx, "GET /x", "a"), qt.Not(qt.IsNil))
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type rateLimiterFunc func(ctx context.Context, route, key string) error
+
+func (f rateLimiterFunc) Allow(ctx context.Context, route, key string) error {
+	return f(ctx, route, key)
+}
+
+func TestServerRateLimiter(t *testing.T) {
+	c := qt.New(t)
+
+	var gotRoute, gotKey string
+	var limitErr error
+	srv := httprequest.Server{
+		RateLimiter: rateLimiterFunc(func(ctx context.Context, route, key string) error {
+			gotRoute, gotKey = route, key
+			return limitErr
+		}),
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /things/:id"`
+	}) (string, error) {
+		return "ok", nil
+	})
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/things/x", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		h.Handle(rec, req, nil)
+		return rec
+	}
+
+	rec := do()
+	qthttptest.AssertJSONResponse(c, rec, http.StatusOK, "ok")
+	c.Assert(gotRoute, qt.Equals, "GET /things/:id")
+	c.Assert(gotKey, qt.Equals, "192.0.2.1")
+
+	limitErr = &httprequest.RateLimitError{
+		RetryAfter: 1500 * time.Millisecond,
+	}
+	rec = do()
+	qthttptest.AssertJSONResponse(c, rec, http.StatusTooManyRequests, &httprequest.RemoteError{
+		Code:    httprequest.CodeTooManyRequests,
+		Message: "rate limit exceeded; retry after 1.5s",
+	})
+	c.Assert(rec.Header().Get("Retry-After"), qt.Equals, "2")
+
+	// Other errors are passed through the error mapper.
+	limitErr = errgo.New("limiter failure")
+	rec = do()
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Header().Get("Retry-After"), qt.Equals, "")
+
+	// The key can be customized.
+	limitErr = nil
+	srv.RateLimitKey = func(req *http.Request) string {
+		return "custom"
+	}
+	h = srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /things/:id"`
+	}) (string, error) {
+		return "ok", nil
+	})
+	do()
+	c.Assert(gotKey, qt.Equals, "custom")
+}
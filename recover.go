@@ -0,0 +1,99 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// PanicError is the error passed to the error mapper when a handler
+// panics and Server.RecoverPanics is set.
+type PanicError struct {
+	// Value holds the value passed to panic.
+	Value interface{}
+
+	// Stack holds the stack trace of the panicking goroutine
+	// if Server.OnPanic is set.
+	Stack []byte
+}
+
+// Error implements the error interface. The message does not include
+// the panic value so that internal details are not sent to clients
+// by DefaultErrorMapper.
+func (e *PanicError) Error() string {
+	return "internal server error"
+}
+
+// String returns a description of the panic including its value.
+func (e *PanicError) String() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// wrapRecover returns a handler that recovers from panics in h and
+// writes them as errors.
+func (srv *Server) wrapRecover(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w1 := &recoverResponseWriter{
+			ResponseWriter: w,
+		}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				// This is used deliberately to abort the
+				// response, so let it through.
+				panic(v)
+			}
+			perr := &PanicError{
+				Value: v,
+			}
+			if srv.OnPanic != nil {
+				perr.Stack = debug.Stack()
+				srv.OnPanic(req, perr)
+			}
+			if w1.written {
+				// The response has already been started, so
+				// the best we can do is to abort it so
+				// that the client sees that it is incomplete.
+				panic(http.ErrAbortHandler)
+			}
+			srv.WriteError(req.Context(), w, perr)
+		}()
+		h(w1, req, p)
+	}
+}
+
+// recoverResponseWriter records whether a response has been
+// started.
+type recoverResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *recoverResponseWriter) WriteHeader(code int) {
+	if !isInformational(code) {
+		w.written = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *recoverResponseWriter) Write(data []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher by flushing the underlying
+// ResponseWriter if it supports it.
+func (w *recoverResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.written = true
+		f.Flush()
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type panicReq struct {
+	httprequest.Route `httprequest:"GET /panic"`
+}
+
+func TestServerRecoverPanics(t *testing.T) {
+	c := qt.New(t)
+
+	var panicked *httprequest.PanicError
+	srv := &httprequest.Server{
+		RecoverPanics: true,
+		OnPanic: func(req *http.Request, err *httprequest.PanicError) {
+			c.Check(req.URL.Path, qt.Equals, "/panic")
+			panicked = err
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, req *panicReq) (string, error) {
+		panic("something bad")
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/panic", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), qt.Equals, `{"Message":"internal server error"}`)
+	c.Assert(panicked, qt.Not(qt.IsNil))
+	c.Assert(panicked.Value, qt.Equals, "something bad")
+	c.Assert(panicked.String(), qt.Equals, "panic: something bad")
+	c.Assert(string(panicked.Stack), qt.Contains, "recover_test.go")
+}
+
+func TestServerRecoverPanicsErrorMapper(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		RecoverPanics: true,
+		ErrorMapper: func(ctx context.Context, err error) (int, interface{}) {
+			if perr, ok := err.(*httprequest.PanicError); ok {
+				c.Check(perr.Stack, qt.IsNil)
+				return http.StatusServiceUnavailable, &httprequest.RemoteError{
+					Message: perr.String(),
+				}
+			}
+			return http.StatusInternalServerError, nil
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, req *panicReq) error {
+		var m map[string]int
+		m["x"] = 1
+		return nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/panic", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusServiceUnavailable)
+	c.Assert(rec.Body.String(), qt.Equals, `{"Message":"panic: assignment to entry in nil map"}`)
+}
+
+func TestServerRecoverPanicsAfterWrite(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		RecoverPanics: true,
+	}
+	h := srv.Handle(func(p httprequest.Params, req *panicReq) {
+		p.Response.WriteHeader(http.StatusOK)
+		p.Response.Write([]byte("partial"))
+		panic("too late")
+	})
+	rec := httptest.NewRecorder()
+	c.Assert(func() {
+		h.Handle(rec, httptest.NewRequest("GET", "/panic", nil), nil)
+	}, qt.PanicMatches, http.ErrAbortHandler.Error())
+	c.Assert(rec.Body.String(), qt.Equals, "partial")
+}
+
+func TestServerPanicsNotRecovered(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, req *panicReq) {
+		panic("not recovered")
+	})
+	c.Assert(func() {
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/panic", nil), nil)
+	}, qt.PanicMatches, "not recovered")
+}
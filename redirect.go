@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// Redirect describes a route that redirects requests to another path,
+// for example after the path of an API has changed.
+type Redirect struct {
+	// Method holds the HTTP method of the route.
+	// If it is empty, GET is used.
+	Method string
+
+	// From holds the path pattern of the route in httprouter
+	// syntax, for example "/v1/users/:id".
+	From string
+
+	// To holds the path to redirect to. Parameters in From are
+	// substituted where they appear as whole path segments with
+	// the same names, for example "/v2/people/:id". Every
+	// parameter in To must also be in From.
+	To string
+
+	// Code holds the status code of the redirect response, which
+	// must be http.StatusMovedPermanently,
+	// http.StatusFound, http.StatusSeeOther,
+	// http.StatusTemporaryRedirect or
+	// http.StatusPermanentRedirect. If it is zero,
+	// http.StatusPermanentRedirect is used, which
+	// preserves the method and body of the request.
+	Code int
+}
+
+// Redirect returns a handler that redirects requests as described by
+// r. The query of the request is preserved, and the Location header
+// holds the external URL of the target (see ExternalURL).
+//
+// Redirect will panic if r is invalid.
+func (srv *Server) Redirect(r Redirect) Handler {
+	if r.Method == "" {
+		r.Method = "GET"
+	}
+	if r.Code == 0 {
+		r.Code = http.StatusPermanentRedirect
+	}
+	if err := r.validate(); err != nil {
+		panic(errgo.Notef(err, "bad redirect from %q", r.From))
+	}
+	to := strings.Split(r.To, "/")
+	h := func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		segs := make([]string, len(to))
+		for i, seg := range to {
+			if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+				seg = strings.TrimPrefix(p.ByName(seg[1:]), "/")
+			}
+			segs[i] = seg
+		}
+		target := *req
+		u := *req.URL
+		u.Path = strings.Join(segs, "/")
+		u.RawPath = ""
+		target.URL = &u
+		w.Header().Set("Location", ExternalURL(&target).String())
+		w.WriteHeader(r.Code)
+	}
+	return Handler{
+		Method: r.Method,
+		Path:   r.From,
+		Handle: srv.wrapShutdown(srv.wrapClientIP(h)),
+	}
+}
+
+// validate checks that r is valid.
+func (r Redirect) validate() error {
+	switch r.Code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return errgo.Newf("invalid redirect status %d", r.Code)
+	}
+	if !strings.HasPrefix(r.From, "/") || !strings.HasPrefix(r.To, "/") {
+		return errgo.Newf("paths must start with /")
+	}
+	from := make(map[string]bool)
+	for _, name := range pathParamNames(r.From) {
+		from[name] = true
+	}
+	for _, name := range pathParamNames(r.To) {
+		if !from[name] {
+			return errgo.Newf("parameter %q in %q not found in %q", name, r.To, r.From)
+		}
+	}
+	return nil
+}
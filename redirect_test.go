@@ -0,0 +1,71 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestRedirect(t *testing.T) {
+	c := qt.New(t)
+
+	_, proxies, err := net.ParseCIDR("10.0.0.0/8")
+	c.Assert(err, qt.IsNil)
+	srv := httprequest.Server{
+		TrustedProxies: []*net.IPNet{proxies},
+	}
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Redirect(httprequest.Redirect{
+			From: "/v1/users/:id/files/*path",
+			To:   "/v2/people/:id/*path",
+		}),
+		srv.Redirect(httprequest.Redirect{
+			Method: "POST",
+			From:   "/v1/users",
+			To:     "/v2/people",
+			Code:   http.StatusMovedPermanently,
+		}),
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/v1/users/42/files/a/b%20c?x=1", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusPermanentRedirect)
+	c.Assert(rec.Header().Get("Location"), qt.Equals, "http://example.com/v2/people/42/a/b%20c?x=1")
+
+	req := httptest.NewRequest("POST", "http://internal/v1/users", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "api.example.com")
+	req.Header.Set("X-Forwarded-Prefix", "/api")
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusMovedPermanently)
+	c.Assert(rec.Header().Get("Location"), qt.Equals, "https://api.example.com/api/v2/people")
+}
+
+func TestRedirectPanics(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	c.Assert(func() {
+		srv.Redirect(httprequest.Redirect{
+			From: "/a/:id",
+			To:   "/b/:name",
+		})
+	}, qt.PanicMatches, `bad redirect from "/a/:id": parameter "name" in "/b/:name" not found in "/a/:id"`)
+	c.Assert(func() {
+		srv.Redirect(httprequest.Redirect{
+			From: "/a",
+			To:   "/b",
+			Code: http.StatusOK,
+		})
+	}, qt.PanicMatches, `bad redirect from "/a": invalid redirect status 200`)
+}
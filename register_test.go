@@ -0,0 +1,114 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type registerReq struct {
+	ID   string `httprequest:"id,path"`
+	Body struct {
+		Name string
+	} `httprequest:",body"`
+}
+
+func TestServerRegister(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	var hs []httprequest.Handler
+	plugins := map[string]string{
+		"alpha": "a",
+		"beta":  "b",
+	}
+	for name, prefix := range plugins {
+		prefix := prefix
+		h := srv.Register("PUT", "/plugins/"+name+"/:id", func(p httprequest.Params, req *registerReq) (string, error) {
+			if req.Body.Name == "" {
+				return "", httprequest.Errorf(httprequest.CodeBadRequest, "no name")
+			}
+			return prefix + req.ID + req.Body.Name, nil
+		})
+		c.Assert(h.Method, qt.Equals, "PUT")
+		c.Assert(h.Path, qt.Equals, "/plugins/"+name+"/:id")
+		hs = append(hs, h)
+	}
+	router := newHandlerRouter(hs)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/plugins/beta/42", strings.NewReader(`{"Name":"x"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"b42x"`)
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest("PUT", "/plugins/alpha/1", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusBadRequest)
+	c.Assert(rec.Body.String(), qt.Equals, `{"Message":"no name","Code":"bad request"}`)
+}
+
+var registerPanicTests = []struct {
+	about       string
+	method      string
+	path        string
+	f           interface{}
+	expectPanic string
+}{{
+	about:  "bad method",
+	method: "FETCH",
+	path:   "/x",
+	f: func(p httprequest.Params, req *registerReq) error {
+		return nil
+	},
+	expectPanic: `bad route "FETCH /x": invalid method`,
+}, {
+	about:  "relative path",
+	method: "GET",
+	path:   "x",
+	f: func(p httprequest.Params, req *registerReq) error {
+		return nil
+	},
+	expectPanic: `bad path "x"`,
+}, {
+	about:  "argument with route",
+	method: "GET",
+	path:   "/x",
+	f: func(p httprequest.Params, req *struct {
+		httprequest.Route `httprequest:"GET /y"`
+	}) error {
+		return nil
+	},
+	expectPanic: `bad handler function: route "GET /x" specified for argument that has its own route`,
+}, {
+	about:  "path constraint",
+	method: "GET",
+	path:   "/x/:id(int)",
+	f: func(p httprequest.Params, req *registerReq) error {
+		return nil
+	},
+	expectPanic: `bad route "GET /x/:id\(int\)": path constraints and optional parameters must be specified in a Route field`,
+}}
+
+func TestServerRegisterPanics(t *testing.T) {
+	c := qt.New(t)
+	srv := &httprequest.Server{}
+	for _, test := range registerPanicTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(func() {
+				srv.Register(test.method, test.path, test.f)
+			}, qt.PanicMatches, test.expectPanic)
+		})
+	}
+}
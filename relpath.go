@@ -0,0 +1,57 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"reflect"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// unmarshalRelPath returns an unmarshaler that unmarshals the value
+// of a catch-all path parameter as a relative path.
+func unmarshalRelPath(tag tag) unmarshaler {
+	getVal := formGetter(tag)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := getVal(tag.name, p)
+		if !ok {
+			return nil
+		}
+		val = strings.TrimPrefix(val, "/")
+		if err := checkRelPath(val); err != nil {
+			return errgo.Mask(err)
+		}
+		makeResult(v).SetString(val)
+		return nil
+	}
+}
+
+// marshalRelPath returns a marshaler that marshals a relative path
+// as the value of a catch-all path parameter.
+func marshalRelPath(tag tag) marshaler {
+	formSet := formSetter(tag)
+	return func(v reflect.Value, p *Params) error {
+		val := v.String()
+		if strings.HasPrefix(val, "/") {
+			return errgo.Newf("relative path %q starts with /", val)
+		}
+		if err := checkRelPath(val); err != nil {
+			return errgo.Mask(err)
+		}
+		formSet(tag.name, "/"+val, p)
+		return nil
+	}
+}
+
+// checkRelPath checks that the given relative path cannot refer to
+// anything outside the directory it is relative to.
+func checkRelPath(relPath string) error {
+	for _, seg := range strings.Split(relPath, "/") {
+		if seg == "." || seg == ".." || strings.Contains(seg, "\\") {
+			return errgo.Newf("invalid relative path %q", relPath)
+		}
+	}
+	return nil
+}
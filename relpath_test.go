@@ -0,0 +1,85 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type fileReq struct {
+	httprequest.Route `httprequest:"GET /files/:bucket/*path"`
+	Bucket            string `httprequest:"bucket,path"`
+	Path              string `httprequest:"path,relpath"`
+}
+
+func TestUnmarshalRelPath(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, req *fileReq) (string, error) {
+		return req.Bucket + ":" + req.Path, nil
+	})
+	router := newHandlerRouter([]httprequest.Handler{h})
+
+	for path, expect := range map[string]string{
+		"/files/b/a/b/c.txt": `"b:a/b/c.txt"`,
+		"/files/b/":          `"b:"`,
+	} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		c.Assert(rec.Code, qt.Equals, http.StatusOK, qt.Commentf("%s", path))
+		c.Assert(rec.Body.String(), qt.Equals, expect)
+	}
+
+	// httprouter does not clean paths itself, so the
+	// value is checked.
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/files/b/x", nil), httprouter.Params{
+		{Key: "bucket", Value: "b"},
+		{Key: "path", Value: "/a/../../etc/passwd"},
+	})
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), qt.Contains, `invalid relative path \"a/../../etc/passwd\"`)
+}
+
+func TestMarshalRelPath(t *testing.T) {
+	c := qt.New(t)
+
+	var got string
+	client := &httprequest.Client{
+		BaseURL: "http://example.com",
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.URL.String()
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+			}, nil
+		}),
+	}
+	err := client.Call(context.Background(), &fileReq{Bucket: "b", Path: "a/b c.txt"}, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, "http://example.com/files/b/a/b%20c.txt")
+
+	err = client.Call(context.Background(), &fileReq{Bucket: "b", Path: "/abs"}, nil)
+	c.Assert(err, qt.ErrorMatches, `.*relative path "/abs" starts with /`)
+}
+
+func TestRelPathNotCatchAll(t *testing.T) {
+	c := qt.New(t)
+
+	_, err := httprequest.Marshal("http://example.com", "GET", &struct {
+		httprequest.Route `httprequest:"GET /files/:path"`
+		Path              string `httprequest:"path,relpath"`
+	}{})
+	c.Assert(err, qt.ErrorMatches, `bad type .*: relpath field Path does not match a catch-all parameter at the end of "/files/:path"`)
+}
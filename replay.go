@@ -0,0 +1,144 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// NonceStore is used by ReplayGuard to remember the nonces
+// it has seen.
+type NonceStore interface {
+	// Add records that the given nonce has been seen. The nonce
+	// need not be remembered after the given expiry time. Add
+	// reports whether the nonce was newly added; it returns false
+	// if the nonce has already been seen.
+	Add(ctx context.Context, nonce string, expires time.Time) (bool, error)
+}
+
+// ReplayGuard protects against replayed requests by checking that
+// each request holds a recent timestamp and a nonce that has not been
+// seen before. It is intended to be used with signed requests where
+// the signature covers both values.
+//
+// A ReplayGuard is enabled for all the handlers created by a Server by
+// setting the Server.ReplayGuard field.
+type ReplayGuard struct {
+	// Store holds the store used to remember nonces.
+	Store NonceStore
+
+	// NonceHeader holds the name of the header holding the nonce.
+	// If it is empty, "X-Nonce" is used.
+	NonceHeader string
+
+	// TimestampHeader holds the name of the header holding the
+	// time of the request in seconds since the Unix epoch.
+	// If it is empty, "X-Timestamp" is used.
+	TimestampHeader string
+
+	// MaxClockSkew holds the maximum difference allowed between
+	// the request timestamp and the current time. If it is zero,
+	// five minutes is used.
+	MaxClockSkew time.Duration
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+}
+
+// Check checks that the given request is not a replay.
+// If it fails, the returned error will be a *RemoteError
+// with a CodeUnauthorized code, unless the nonce store
+// fails.
+func (g *ReplayGuard) Check(ctx context.Context, req *http.Request) error {
+	nonceHeader := g.NonceHeader
+	if nonceHeader == "" {
+		nonceHeader = "X-Nonce"
+	}
+	timestampHeader := g.TimestampHeader
+	if timestampHeader == "" {
+		timestampHeader = "X-Timestamp"
+	}
+	skew := g.MaxClockSkew
+	if skew == 0 {
+		skew = 5 * time.Minute
+	}
+	now := nowFunc(g.Now)
+	nonce := req.Header.Get(nonceHeader)
+	if nonce == "" {
+		return Errorf(CodeUnauthorized, "missing %s header", nonceHeader)
+	}
+	tsStr := req.Header.Get(timestampHeader)
+	if tsStr == "" {
+		return Errorf(CodeUnauthorized, "missing %s header", timestampHeader)
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return Errorf(CodeUnauthorized, "invalid %s header %q", timestampHeader, tsStr)
+	}
+	t := time.Unix(ts, 0)
+	if d := now().Sub(t); d > skew || d < -skew {
+		return Errorf(CodeUnauthorized, "request timestamp outside allowed window")
+	}
+	added, err := g.Store.Add(ctx, nonce, t.Add(skew))
+	if err != nil {
+		return errgo.Notef(err, "cannot record nonce")
+	}
+	if !added {
+		return Errorf(CodeUnauthorized, "nonce has already been used")
+	}
+	return nil
+}
+
+// wrap returns a handler that checks requests with g before
+// calling h.
+func (g *ReplayGuard) wrap(srv *Server, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if err := g.Check(req.Context(), req); err != nil {
+			srv.WriteError(req.Context(), w, err)
+			return
+		}
+		h(w, req, p)
+	}
+}
+
+// MemNonceStore is an in-memory implementation of NonceStore.
+// The zero value is ready to use.
+type MemNonceStore struct {
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	nextPurge time.Time
+}
+
+// Add implements NonceStore.Add.
+func (s *MemNonceStore) Add(ctx context.Context, nonce string, expires time.Time) (bool, error) {
+	now := nowFunc(s.Now)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nonces == nil {
+		s.nonces = make(map[string]time.Time)
+	}
+	if now.After(s.nextPurge) {
+		for n, t := range s.nonces {
+			if now.After(t) {
+				delete(s.nonces, n)
+			}
+		}
+		s.nextPurge = now.Add(time.Minute)
+	}
+	if t, ok := s.nonces[nonce]; ok && !now.After(t) {
+		return false, nil
+	}
+	s.nonces[nonce] = expires
+	return true, nil
+}
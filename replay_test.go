@@ -0,0 +1,140 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var replayGuardTests = []struct {
+	about        string
+	header       http.Header
+	expectStatus int
+	expectError  string
+}{{
+	about: "valid request",
+	header: http.Header{
+		"X-Nonce":     {"n1"},
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Unix(), 10)},
+	},
+	expectStatus: http.StatusOK,
+}, {
+	about: "replayed request",
+	header: http.Header{
+		"X-Nonce":     {"n1"},
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Unix(), 10)},
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectError:  "nonce has already been used",
+}, {
+	about: "timestamp within skew",
+	header: http.Header{
+		"X-Nonce":     {"n2"},
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Add(-time.Minute).Unix(), 10)},
+	},
+	expectStatus: http.StatusOK,
+}, {
+	about: "timestamp too old",
+	header: http.Header{
+		"X-Nonce":     {"n3"},
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Add(-time.Hour).Unix(), 10)},
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectError:  "request timestamp outside allowed window",
+}, {
+	about: "timestamp in the future",
+	header: http.Header{
+		"X-Nonce":     {"n4"},
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Add(time.Hour).Unix(), 10)},
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectError:  "request timestamp outside allowed window",
+}, {
+	about: "missing nonce",
+	header: http.Header{
+		"X-Timestamp": {strconv.FormatInt(replayTestTime.Unix(), 10)},
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectError:  "missing X-Nonce header",
+}, {
+	about: "bad timestamp",
+	header: http.Header{
+		"X-Nonce":     {"n5"},
+		"X-Timestamp": {"yesterday"},
+	},
+	expectStatus: http.StatusUnauthorized,
+	expectError:  `invalid X-Timestamp header "yesterday"`,
+}}
+
+var replayTestTime = time.Date(2020, 5, 6, 12, 0, 0, 0, time.UTC)
+
+func TestReplayGuard(t *testing.T) {
+	c := qt.New(t)
+
+	now := func() time.Time {
+		return replayTestTime
+	}
+	srv := httprequest.Server{
+		ReplayGuard: &httprequest.ReplayGuard{
+			Store: &httprequest.MemNonceStore{
+				Now: now,
+			},
+			Now: now,
+		},
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"POST /signed"`
+	}) (string, error) {
+		return "ok", nil
+	})
+	for _, test := range replayGuardTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("POST", "/signed", nil)
+			req.Header = test.header
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			if test.expectError == "" {
+				qthttptest.AssertJSONResponse(c, rec, test.expectStatus, "ok")
+				return
+			}
+			qthttptest.AssertJSONResponse(c, rec, test.expectStatus, &httprequest.RemoteError{
+				Code:    httprequest.CodeUnauthorized,
+				Message: test.expectError,
+			})
+		})
+	}
+}
+
+func TestMemNonceStoreExpiry(t *testing.T) {
+	c := qt.New(t)
+
+	now := replayTestTime
+	store := &httprequest.MemNonceStore{
+		Now: func() time.Time {
+			return now
+		},
+	}
+	ctx := context.Background()
+	added, err := store.Add(ctx, "n", now.Add(time.Minute))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, true)
+	added, err = store.Add(ctx, "n", now.Add(time.Minute))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, false)
+
+	now = now.Add(2 * time.Minute)
+	added, err = store.Add(ctx, "n", now.Add(time.Minute))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, true)
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// requestIDHeader holds the name of the header
+// used to hold request IDs.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLen holds the maximum length of an inbound
+// request ID that will be used by a Server.
+const maxRequestIDLen = 128
+
+type requestIDKey struct{}
+
+// RequestIDFromContext returns the request ID stored in the given
+// context, or the empty string if there is none. The context passed
+// to handlers created by a Server with RequestID set always holds the
+// ID of the request.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ContextWithRequestID returns a context that holds the given request
+// ID. This can be used to set the ID forwarded by a Client with
+// ForwardRequestID set when the request is not made on behalf of a
+// Server handler.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// wrapRequestID returns a handler that assigns
+// an ID to each request before calling h.
+func (srv *Server) wrapRequestID(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newUUID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		h(w, req.WithContext(ContextWithRequestID(req.Context(), id)), p)
+	}
+}
+
+// validRequestID reports whether the given inbound
+// request ID is acceptable for use.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
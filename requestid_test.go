@@ -0,0 +1,143 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var requestIDTests = []struct {
+	about    string
+	inbound  string
+	expectID string
+}{{
+	about:    "inbound id used",
+	inbound:  "abc-123",
+	expectID: "abc-123",
+}, {
+	about:    "generated id",
+	expectID: uuidPattern,
+}, {
+	about:    "inbound id too long",
+	inbound:  strings.Repeat("x", 200),
+	expectID: uuidPattern,
+}, {
+	about:    "inbound id with invalid characters",
+	inbound:  "a b",
+	expectID: uuidPattern,
+}}
+
+func TestServerRequestID(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		RequestID: true,
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /"`
+	}) (string, error) {
+		return httprequest.RequestIDFromContext(p.Context), nil
+	})
+	for _, test := range requestIDTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if test.inbound != "" {
+				req.Header.Set("X-Request-ID", test.inbound)
+			}
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, nil)
+			c.Assert(rec.Code, qt.Equals, http.StatusOK)
+			id := rec.Header().Get("X-Request-ID")
+			c.Assert(id, qt.Matches, test.expectID)
+			c.Assert(rec.Body.String(), qt.Equals, `"`+id+`"`)
+		})
+	}
+}
+
+func TestServerRequestIDInError(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		RequestID: true,
+	}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /"`
+	}) error {
+		return httprequest.Errorf(httprequest.CodeNotFound, "not here")
+	})
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Request-ID", "req-1")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, nil)
+	qthttptest.AssertJSONResponse(c, rec, http.StatusNotFound, &httprequest.RemoteError{
+		Code:      httprequest.CodeNotFound,
+		Message:   "not here",
+		RequestID: "req-1",
+	})
+}
+
+func TestClientForwardRequestID(t *testing.T) {
+	c := qt.New(t)
+
+	// The backend records the request ID it sees.
+	var backendID string
+	backendSrv := httprequest.Server{
+		RequestID: true,
+	}
+	backend := newHandlerServer([]httprequest.Handler{
+		backendSrv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"GET /backend"`
+		}) error {
+			backendID = httprequest.RequestIDFromContext(p.Context)
+			return httprequest.Errorf(httprequest.CodeBadRequest, "backend failure")
+		}),
+	})
+	defer backend.Close()
+
+	// The frontend calls the backend while handling a request.
+	client := httprequest.Client{
+		BaseURL:          backend.URL,
+		ForwardRequestID: true,
+	}
+	var callErr error
+	frontendSrv := httprequest.Server{
+		RequestID: true,
+	}
+	h := frontendSrv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /frontend"`
+	}) {
+		callErr = client.Get(p.Context, "/backend", nil)
+	})
+	req := httptest.NewRequest("GET", "/frontend", nil)
+	req.Header.Set("X-Request-ID", "req-2")
+	h.Handle(httptest.NewRecorder(), req, nil)
+
+	c.Assert(backendID, qt.Equals, "req-2")
+	c.Assert(errgo.Cause(callErr), qt.DeepEquals, &httprequest.RemoteError{
+		Code:      httprequest.CodeBadRequest,
+		Message:   "backend failure",
+		RequestID: "req-2",
+	})
+
+	// Without a request ID in the context, none is sent.
+	err := client.Get(context.Background(), "/backend", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http.*: backend failure`)
+	c.Assert(backendID, qt.Matches, uuidPattern)
+	c.Assert(backendID, qt.Not(qt.Equals), "req-2")
+
+	ctx := httprequest.ContextWithRequestID(context.Background(), "req-3")
+	err = client.Get(ctx, "/backend", nil)
+	c.Assert(err, qt.ErrorMatches, `Get http.*: backend failure`)
+	c.Assert(backendID, qt.Equals, "req-3")
+}
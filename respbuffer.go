@@ -0,0 +1,194 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+)
+
+// writeResult writes the result of a handler as a response to req with
+// the given status code, encoded with the codec negotiated from
+// srv.Codecs, or as JSON if there are none. When the result is JSON
+// and srv.ResponseBufferSize is positive, a result that is a slice or
+// array is marshaled an element at a time and sent as soon as more
+// than that many bytes have been marshaled. A *CustomResponse result
+// writes its own body, a *JSONArrayStream or *NDJSONStream result
+// streams its elements, a *Created result is written with a 201
+// status and a Location header, a *StatusResponse result is
+// written with its own status, and the items of a *Page result are
+// written as if they were the result, with its Link headers. Fields of the result with the "header"
+// attribute are written as response headers. When
+// srv.ResponseDigests is non-empty, the whole response is buffered so
+// that its length and checksums can be sent in its header.
+func (srv *Server) writeResult(w http.ResponseWriter, req *http.Request, code int, val interface{}) error {
+	if len(srv.ResponseDigests) == 0 {
+		return srv.writeResultBody(w, req, code, val, srv.ResponseBufferSize)
+	}
+	dw := &digestWriter{
+		ResponseWriter: w,
+		algs:           srv.ResponseDigests,
+	}
+	if err := srv.writeResultBody(dw, req, code, val, 0); err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	return errgo.Mask(dw.finish())
+}
+
+// writeResultBody is the internal version of writeResult. The
+// bufferSize argument is used in place of srv.ResponseBufferSize.
+func (srv *Server) writeResultBody(w http.ResponseWriter, req *http.Request, code int, val interface{}, bufferSize int) error {
+	switch r := val.(type) {
+	case *CustomResponse:
+		if r != nil {
+			return r.write(w, code)
+		}
+	case CustomResponse:
+		return r.write(w, code)
+	case *JSONArrayStream:
+		if r != nil {
+			return r.write(srv, w, code)
+		}
+	case JSONArrayStream:
+		return r.write(srv, w, code)
+	case *NDJSONStream:
+		if r != nil {
+			return r.write(srv, w, code)
+		}
+	case NDJSONStream:
+		return r.write(srv, w, code)
+	case *Created:
+		if r != nil {
+			return r.write(srv, w, req, bufferSize)
+		}
+	case Created:
+		return r.write(srv, w, req, bufferSize)
+	case *StatusResponse:
+		if r != nil {
+			return r.write(srv, w, req, code, bufferSize)
+		}
+	case StatusResponse:
+		return r.write(srv, w, req, code, bufferSize)
+	case *Page:
+		if r != nil {
+			return r.write(srv, w, req, code, bufferSize)
+		}
+	case Page:
+		return r.write(srv, w, req, code, bufferSize)
+	}
+	if err := setResultHeader(w.Header(), val); err != nil {
+		return errgo.Mask(err)
+	}
+	if code == http.StatusNoContent {
+		// Only the headers of the result can be sent.
+		w.WriteHeader(code)
+		return nil
+	}
+	if len(srv.Codecs) > 0 {
+		if c := negotiateCodec(req.Header.Get("Accept"), srv.Codecs); c != JSONCodec {
+			return writeCodec(w, code, val, c)
+		}
+	}
+	if bufferSize <= 0 {
+		return writeJSON(w, code, val, srv.TimeFormat, srv.JSON, srv.BufferPool)
+	}
+	bw := &thresholdWriter{
+		w:     w,
+		code:  code,
+		limit: bufferSize,
+		setHeader: func() {
+			w.Header().Set("content-type", "application/json")
+			if headerSetter, ok := val.(HeaderSetter); ok {
+				headerSetter.SetHeader(w.Header())
+			}
+		},
+	}
+	if err := encodeJSON(bw, val, srv.TimeFormat, srv.JSON); err != nil {
+		if !bw.committed {
+			return errgo.Mask(err)
+		}
+		// The response status has already been sent, so the best
+		// we can do is to abort the response so that the client
+		// sees that it is incomplete.
+		panic(http.ErrAbortHandler)
+	}
+	bw.finish()
+	return nil
+}
+
+// encodeJSON writes val to w as JSON using jc, formatting time.Time
+// values as specified by tf. Slices and arrays are written an element at a time.
+func encodeJSON(w *thresholdWriter, val interface{}, tf *TimeFormat, jc Codec) error {
+	v := reflect.ValueOf(val)
+	if ch, ok := val.(*CustomHeader); ok {
+		v = reflect.ValueOf(ch.Body)
+	}
+	for v.IsValid() && v.Kind() == reflect.Ptr && !v.IsNil() && !v.Type().Implements(jsonMarshalerType) {
+		v = v.Elem()
+	}
+	if !v.IsValid() || (v.Kind() != reflect.Slice && v.Kind() != reflect.Array) ||
+		v.Kind() == reflect.Slice && (v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8) ||
+		v.Type().Implements(jsonMarshalerType) || v.Type().Implements(textMarshalerType) {
+		data, err := tf.marshal(val, jc)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		w.Write(data)
+		return nil
+	}
+	w.Write([]byte("["))
+	for i := 0; i < v.Len(); i++ {
+		if i > 0 {
+			w.Write([]byte(","))
+		}
+		data, err := tf.marshal(v.Index(i).Interface(), jc)
+		if err != nil {
+			return errgo.Notef(err, "cannot marshal element %d", i)
+		}
+		w.Write(data)
+	}
+	w.Write([]byte("]"))
+	return nil
+}
+
+// thresholdWriter buffers data written to it until more than limit
+// bytes have been written, at which point the response is committed:
+// the header and buffered data are written to w, followed by any
+// further data.
+type thresholdWriter struct {
+	w         http.ResponseWriter
+	code      int
+	limit     int
+	setHeader func()
+	buf       bytes.Buffer
+	committed bool
+}
+
+func (w *thresholdWriter) Write(data []byte) (int, error) {
+	if w.committed {
+		return w.w.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() > w.limit {
+		w.setHeader()
+		w.w.WriteHeader(w.code)
+		w.committed = true
+		w.w.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	return len(data), nil
+}
+
+// finish writes the response if it has not been committed.
+func (w *thresholdWriter) finish() {
+	if w.committed {
+		return
+	}
+	w.setHeader()
+	w.w.WriteHeader(w.code)
+	w.w.Write(w.buf.Bytes())
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// failingMarshaler fails to marshal as JSON.
+type failingMarshaler struct{}
+
+func (failingMarshaler) MarshalJSON() ([]byte, error) {
+	return nil, errgo.New("marshal failure")
+}
+
+// newBufferTestHandler returns a handler that responds with
+// n elements followed by a value that cannot be marshaled
+// if fail is true.
+func newBufferTestHandler(srv *httprequest.Server, n int, fail bool) httprequest.Handler {
+	return srv.Handle(func(_ *struct {
+		httprequest.Route `httprequest:"GET /items"`
+	}) ([]interface{}, error) {
+		var items []interface{}
+		for i := 0; i < n; i++ {
+			items = append(items, strings.Repeat("x", 10))
+		}
+		if fail {
+			items = append(items, failingMarshaler{})
+		}
+		return items, nil
+	})
+}
+
+func TestResponseBufferSizeSuccess(t *testing.T) {
+	c := qt.New(t)
+
+	for _, size := range []int{0, 5, 1000} {
+		srv := &httprequest.Server{
+			ResponseBufferSize: size,
+		}
+		h := newBufferTestHandler(srv, 20, false)
+		rec := httptest.NewRecorder()
+		h.Handle(rec, httptest.NewRequest("GET", "/items", nil), nil)
+		c.Assert(rec.Code, qt.Equals, http.StatusOK, qt.Commentf("size %d", size))
+		c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/json")
+		var items []string
+		err := json.Unmarshal(rec.Body.Bytes(), &items)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(items, qt.HasLen, 20)
+	}
+}
+
+func TestResponseBufferSizeErrorBeforeCommit(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		ResponseBufferSize: 1000,
+	}
+	h := newBufferTestHandler(srv, 3, true)
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/items", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	var errResp httprequest.RemoteError
+	err := json.Unmarshal(rec.Body.Bytes(), &errResp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(errResp.Message, qt.Matches, `cannot marshal element 3: json: error calling MarshalJSON for type .*failingMarshaler: marshal failure`)
+}
+
+func TestResponseBufferSizeErrorAfterCommit(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		ResponseBufferSize: 50,
+	}
+	h := newBufferTestHandler(srv, 20, true)
+	rec := httptest.NewRecorder()
+	c.Assert(func() {
+		h.Handle(rec, httptest.NewRequest("GET", "/items", nil), nil)
+	}, qt.PanicMatches, "net/http: abort Handler")
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+
+	// A real client sees the response fail.
+	server := httptest.NewServer(httprequest.ToHTTP(h.Handle))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/items")
+	if err == nil {
+		var items []string
+		err = json.NewDecoder(resp.Body).Decode(&items)
+		resp.Body.Close()
+	}
+	c.Assert(err, qt.Not(qt.IsNil))
+}
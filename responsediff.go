@@ -0,0 +1,213 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// ResponseDiffer can be used to validate a new implementation of a
+// handler against an existing one. For a sample of requests, it calls
+// both handlers and reports any difference between their responses.
+// The response from the primary handler is always the one that is
+// served.
+//
+// Note that both handlers are called for sampled requests, so a
+// candidate handler should not have side effects that would conflict
+// with those of the primary.
+type ResponseDiffer struct {
+	// Rate holds the fraction of requests, between 0 and 1,
+	// for which the candidate handler is called.
+	Rate float64
+
+	// CompareHeaders holds the names of response headers
+	// that are compared in addition to the status code and body.
+	CompareHeaders []string
+
+	// Report is called with the details of each sampled request
+	// for which the responses differ.
+	Report func(*ResponseDiff)
+
+	// Rand returns a random number in [0, 1) that is used to
+	// choose which requests are sampled. If it is nil,
+	// math/rand.Float64 is used.
+	Rand func() float64
+}
+
+// ResponseDiff describes the differing responses of the primary
+// and candidate handlers to a request.
+type ResponseDiff struct {
+	// Method and Path hold the method and path pattern
+	// of the route.
+	Method string
+	Path   string
+
+	// Request holds the request. Its body has already
+	// been consumed; the body sent is in RequestBody.
+	Request     *http.Request
+	RequestBody []byte
+
+	// Primary and Candidate hold the responses from the
+	// primary and candidate handlers.
+	Primary   RecordedResponse
+	Candidate RecordedResponse
+}
+
+// RecordedResponse holds a response recorded by a ResponseDiffer.
+type RecordedResponse struct {
+	Status int
+	Header http.Header
+
+	// Body holds the response body, truncated to 1MiB.
+	Body []byte
+
+	// Panic holds the value the handler panicked with, if any.
+	// The primary handler's panics are not recovered.
+	Panic interface{}
+}
+
+// Handler returns a handler that serves requests with primary,
+// also calling candidate for a sample of requests as configured
+// by d. Both handlers must have the same method and path.
+func (d *ResponseDiffer) Handler(primary, candidate Handler) Handler {
+	if primary.Method != candidate.Method || primary.Path != candidate.Path {
+		panic(errgo.Newf("mismatched routes for response diff: %s %s vs %s %s", primary.Method, primary.Path, candidate.Method, candidate.Path))
+	}
+	return Handler{
+		Method: primary.Method,
+		Path:   primary.Path,
+		Handle: d.wrap(primary, candidate.Handle),
+	}
+}
+
+func (d *ResponseDiffer) wrap(primary Handler, candidate httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		if !sampled(d.Rate, d.Rand) {
+			primary.Handle(w, req, p)
+			return
+		}
+		var reqBody []byte
+		if req.Body != nil {
+			data, err := ioutil.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				// We can't replay the request, so don't
+				// bother with the candidate.
+				req.Body = ioutil.NopCloser(bytes.NewReader(data))
+				primary.Handle(w, req, p)
+				return
+			}
+			reqBody = data
+		}
+		candidateReq := req.Clone(req.Context())
+		if req.Body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+			candidateReq.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+		}
+		// Run the candidate concurrently so that the request
+		// latency is not increased more than necessary.
+		var candidateResp RecordedResponse
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			candidateResp = recordCandidate(candidate, candidateReq, p)
+		}()
+		w1 := &harResponseWriter{
+			ResponseWriter: w,
+			status:         http.StatusOK,
+		}
+		primary.Handle(w1, req, p)
+		wg.Wait()
+		primaryResp := RecordedResponse{
+			Status: w1.status,
+			Header: w.Header(),
+			Body:   w1.body.Bytes(),
+		}
+		if d.Report == nil || d.sameResponse(&primaryResp, &candidateResp) {
+			return
+		}
+		d.Report(&ResponseDiff{
+			Method:      primary.Method,
+			Path:        primary.Path,
+			Request:     req,
+			RequestBody: reqBody,
+			Primary:     primaryResp,
+			Candidate:   candidateResp,
+		})
+	}
+}
+
+// recordCandidate calls h and returns the response it writes.
+func recordCandidate(h httprouter.Handle, req *http.Request, p httprouter.Params) (resp RecordedResponse) {
+	w := &harResponseWriter{
+		ResponseWriter: discardResponseWriter{make(http.Header)},
+		status:         http.StatusOK,
+	}
+	defer func() {
+		resp = RecordedResponse{
+			Status: w.status,
+			Header: w.Header(),
+			Body:   w.body.Bytes(),
+		}
+		if r := recover(); r != nil {
+			resp.Status = 0
+			resp.Panic = r
+		}
+	}()
+	h(w, req, p)
+	return
+}
+
+// sameResponse reports whether the two responses
+// should be considered to be the same.
+func (d *ResponseDiffer) sameResponse(r0, r1 *RecordedResponse) bool {
+	if r0.Status != r1.Status || r1.Panic != nil {
+		return false
+	}
+	for _, h := range d.CompareHeaders {
+		if !reflect.DeepEqual(r0.Header[http.CanonicalHeaderKey(h)], r1.Header[http.CanonicalHeaderKey(h)]) {
+			return false
+		}
+	}
+	if isJSONResponse(r0.Header) && isJSONResponse(r1.Header) {
+		// Compare the JSON values so that differences
+		// in formatting and field order are ignored.
+		var v0, v1 interface{}
+		if json.Unmarshal(r0.Body, &v0) == nil && json.Unmarshal(r1.Body, &v1) == nil {
+			return reflect.DeepEqual(v0, v1)
+		}
+	}
+	return bytes.Equal(r0.Body, r1.Body)
+}
+
+func isJSONResponse(h http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// discardResponseWriter is an http.ResponseWriter
+// that discards everything written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (w discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (discardResponseWriter) Write(data []byte) (int, error) {
+	return len(data), nil
+}
+
+func (discardResponseWriter) WriteHeader(int) {}
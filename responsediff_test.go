@@ -0,0 +1,148 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type diffRequest struct {
+	httprequest.Route `httprequest:"POST /diff/:id"`
+	ID                string `httprequest:"id,path"`
+	Body              struct {
+		N int
+	} `httprequest:",body"`
+}
+
+type diffResponse struct {
+	ID string
+	N  int
+}
+
+var responseDifferTests = []struct {
+	about      string
+	candidate  func(*diffRequest) (*diffResponse, error)
+	expectDiff bool
+}{{
+	about: "same response",
+	candidate: func(p *diffRequest) (*diffResponse, error) {
+		return &diffResponse{
+			ID: p.ID,
+			N:  p.Body.N,
+		}, nil
+	},
+}, {
+	about: "different body",
+	candidate: func(p *diffRequest) (*diffResponse, error) {
+		return &diffResponse{
+			ID: p.ID,
+			N:  p.Body.N + 1,
+		}, nil
+	},
+	expectDiff: true,
+}, {
+	about: "different status",
+	candidate: func(p *diffRequest) (*diffResponse, error) {
+		return nil, errgo.New("failed")
+	},
+	expectDiff: true,
+}, {
+	about: "candidate panics",
+	candidate: func(p *diffRequest) (*diffResponse, error) {
+		panic("oops")
+	},
+	expectDiff: true,
+}}
+
+func TestResponseDiffer(t *testing.T) {
+	c := qt.New(t)
+
+	primary := testServer.Handle(func(p *diffRequest) (*diffResponse, error) {
+		return &diffResponse{
+			ID: p.ID,
+			N:  p.Body.N,
+		}, nil
+	})
+	for _, test := range responseDifferTests {
+		c.Run(test.about, func(c *qt.C) {
+			var diffs []*httprequest.ResponseDiff
+			differ := &httprequest.ResponseDiffer{
+				Rate: 1,
+				Report: func(d *httprequest.ResponseDiff) {
+					diffs = append(diffs, d)
+				},
+			}
+			h := differ.Handler(primary, testServer.Handle(test.candidate))
+			c.Assert(h.Method, qt.Equals, "POST")
+			c.Assert(h.Path, qt.Equals, "/diff/:id")
+
+			req := httptest.NewRequest("POST", "/diff/x", strings.NewReader(`{"N": 99}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.Handle(rec, req, httprouter.Params{{Key: "id", Value: "x"}})
+
+			// The primary response is always served.
+			qthttptest.AssertJSONResponse(c, rec, http.StatusOK, diffResponse{
+				ID: "x",
+				N:  99,
+			})
+			if !test.expectDiff {
+				c.Assert(diffs, qt.HasLen, 0)
+				return
+			}
+			c.Assert(diffs, qt.HasLen, 1)
+			c.Assert(diffs[0].Path, qt.Equals, "/diff/:id")
+			c.Assert(string(diffs[0].RequestBody), qt.Equals, `{"N": 99}`)
+			c.Assert(diffs[0].Primary.Status, qt.Equals, http.StatusOK)
+			c.Assert(string(diffs[0].Primary.Body), qt.Equals, `{"ID":"x","N":99}`)
+		})
+	}
+}
+
+func TestResponseDifferNotSampled(t *testing.T) {
+	c := qt.New(t)
+
+	called := false
+	differ := &httprequest.ResponseDiffer{}
+	h := differ.Handler(
+		testServer.Handle(func(p *diffRequest) (*diffResponse, error) {
+			return &diffResponse{}, nil
+		}),
+		testServer.Handle(func(p *diffRequest) (*diffResponse, error) {
+			called = true
+			return nil, nil
+		}),
+	)
+	req := httptest.NewRequest("POST", "/diff/x", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req, httprouter.Params{{Key: "id", Value: "x"}})
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(called, qt.Equals, false)
+}
+
+func TestResponseDifferMismatchedRoutes(t *testing.T) {
+	c := qt.New(t)
+
+	differ := &httprequest.ResponseDiffer{}
+	c.Assert(func() {
+		differ.Handler(
+			testServer.Handle(func(p *diffRequest) {}),
+			testServer.Handle(func(p *struct {
+				httprequest.Route `httprequest:"GET /diff/:id"`
+			}) {
+			}),
+		)
+	}, qt.PanicMatches, `mismatched routes for response diff: POST /diff/:id vs GET /diff/:id`)
+}
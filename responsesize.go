@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ResponseTooLargeError is the cause of the error returned by a Client
+// when a response body is larger than the maximum allowed size. See
+// Client.MaxResponseSize and WithMaxResponseSize.
+type ResponseTooLargeError struct {
+	// Limit holds the maximum size of the response body
+	// in bytes.
+	Limit int64
+}
+
+// Error implements the error interface.
+func (e *ResponseTooLargeError) Error() string {
+	return fmt.Sprintf("response body too large (limit %d bytes)", e.Limit)
+}
+
+// limitResponse limits the size of the body of the given response to
+// limit bytes. If the response declares a larger content length, its
+// body is closed and a *ResponseTooLargeError is returned; otherwise
+// the body is replaced by one that returns a *ResponseTooLargeError
+// when more than limit bytes are read.
+func limitResponse(resp *http.Response, limit int64) error {
+	if resp.ContentLength > limit {
+		resp.Body.Close()
+		return &ResponseTooLargeError{
+			Limit: limit,
+		}
+	}
+	resp.Body = &limitedBody{
+		ReadCloser: resp.Body,
+		limit:      limit,
+		n:          limit,
+	}
+	return nil
+}
+
+// limitedBody is a response body that returns a *ResponseTooLargeError
+// if more than limit bytes are read from it.
+type limitedBody struct {
+	io.ReadCloser
+	limit int64
+
+	// n holds the number of bytes remaining.
+	n int64
+}
+
+func (b *limitedBody) Read(buf []byte) (int, error) {
+	if b.n <= 0 {
+		// Check whether there is any more data.
+		var extra [1]byte
+		n, err := b.ReadCloser.Read(extra[:])
+		if n > 0 {
+			return 0, &ResponseTooLargeError{
+				Limit: b.limit,
+			}
+		}
+		return 0, err
+	}
+	if int64(len(buf)) > b.n {
+		buf = buf[:b.n]
+	}
+	n, err := b.ReadCloser.Read(buf)
+	b.n -= int64(n)
+	return n, err
+}
+
+// responseTooLarge returns the *ResponseTooLargeError that caused a
+// response to fail to decode, or nil if there is none.
+func responseTooLarge(err error) *ResponseTooLargeError {
+	switch err := err.(type) {
+	case *ResponseTooLargeError:
+		return err
+	case *DecodeResponseError:
+		// The error may have been annotated without
+		// preserving its cause, so look through all
+		// the underlying errors.
+		for e := err.DecodeError; e != nil; {
+			if err, ok := e.(*ResponseTooLargeError); ok {
+				return err
+			}
+			w, ok := e.(errgo.Wrapper)
+			if !ok {
+				break
+			}
+			e = w.Underlying()
+		}
+	}
+	return nil
+}
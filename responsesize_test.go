@@ -0,0 +1,133 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// newSizeServer returns a server that responds to /ok with a JSON
+// string of n bytes, to /error with an error with a message of n
+// bytes, and to /chunked with a JSON string of n bytes and no
+// Content-Length header.
+func newSizeServer(n int) *httptest.Server {
+	s := strings.Repeat("x", n-2)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/ok":
+			httprequest.WriteJSON(w, http.StatusOK, s)
+		case "/error":
+			httprequest.WriteJSON(w, http.StatusBadRequest, &httprequest.RemoteError{
+				Message: s,
+			})
+		case "/chunked":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`"`))
+			w.(http.Flusher).Flush()
+			w.Write([]byte(s + `"`))
+		}
+	}))
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newSizeServer(1000)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:         srv.URL,
+		MaxResponseSize: 100,
+	}
+	for _, path := range []string{"/ok", "/error", "/chunked"} {
+		c.Run(path, func(c *qt.C) {
+			var resp string
+			err := client.Get(context.Background(), path, &resp)
+			c.Assert(err, qt.ErrorMatches, `Get http://.*`+path+`: (error reading response body: )?response body too large \(limit 100 bytes\)`)
+			c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.ResponseTooLargeError{
+				Limit: 100,
+			})
+		})
+	}
+}
+
+func TestMaxResponseSizeNotExceeded(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newSizeServer(100)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:         srv.URL,
+		MaxResponseSize: 100,
+	}
+	var resp string
+	err := client.Get(context.Background(), "/chunked", &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.HasLen, 98)
+}
+
+func TestMaxResponseSizeWithHTTPResponse(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newSizeServer(1000)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:         srv.URL,
+		MaxResponseSize: 100,
+	}
+	var resp *http.Response
+	err := client.Get(context.Background(), "/chunked", &resp)
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(data, qt.HasLen, 100)
+	c.Assert(err, qt.ErrorMatches, `response body too large \(limit 100 bytes\)`)
+}
+
+func TestWithMaxResponseSize(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newSizeServer(1000)
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:         srv.URL,
+		MaxResponseSize: 100,
+	}
+	req, err := http.NewRequest("GET", srv.URL+"/ok", nil)
+	c.Assert(err, qt.Equals, nil)
+	var resp string
+	err = client.Do(context.Background(), req, &resp)
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.ResponseTooLargeError{
+		Limit: 100,
+	})
+
+	// The limit can be lifted for a single call.
+	err = client.CallWithOptions(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET /ok"`
+	}{}, &resp, httprequest.WithMaxResponseSize(-1))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.HasLen, 998)
+
+	// Or lowered.
+	client.MaxResponseSize = 0
+	err = client.CallWithOptions(context.Background(), &struct {
+		httprequest.Route `httprequest:"GET /ok"`
+	}{}, &resp, httprequest.WithMaxResponseSize(10))
+	c.Assert(errgo.Cause(err), qt.DeepEquals, &httprequest.ResponseTooLargeError{
+		Limit: 10,
+	})
+}
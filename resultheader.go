@@ -0,0 +1,151 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+)
+
+// resultHeaderFields returns the fields of the given result type that
+// have the "header" or "cookie" attribute, or nil if t is not a struct
+// or pointer to struct type.
+func resultHeaderFields(t reflect.Type) ([]field, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == customHeaderType || t == timeType {
+		return nil, nil
+	}
+	rt, err := getRequestType(reflect.PtrTo(t))
+	if err != nil {
+		return nil, errgo.Notef(err, "bad result type %s", t)
+	}
+	var fields []field
+	for _, f := range rt.fields {
+		if f.source == sourceHeader || f.source == sourceCookie {
+			fields = append(fields, f)
+		}
+	}
+	return fields, nil
+}
+
+// setResultHeader sets the headers in h from the header fields of
+// val, which is the result of a handler. Cookie fields are written
+// as Set-Cookie headers.
+func setResultHeader(h http.Header, val interface{}) error {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return nil
+	}
+	fields, err := resultHeaderFields(v.Type())
+	if err != nil || len(fields) == 0 {
+		return errgo.Mask(err)
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	// The marshalers require addressable values.
+	xv := reflect.New(v.Type()).Elem()
+	xv.Set(v)
+	p := &Params{
+		Request: &http.Request{
+			Header: h,
+		},
+	}
+	for _, f := range fields {
+		fv, ok := fieldByIndex(xv, f.index)
+		if !ok {
+			continue
+		}
+		if f.isPointer {
+			if fv.IsNil() {
+				continue
+			}
+			fv = fv.Elem()
+		}
+		if f.source == sourceCookie {
+			if err := setResultCookie(h, f, fv); err != nil {
+				return errgo.Mask(err)
+			}
+			continue
+		}
+		if err := f.marshal(fv, p); err != nil {
+			return errgo.Notef(err, "cannot marshal field %s into response header", f.name)
+		}
+	}
+	return nil
+}
+
+// setResultCookie adds a Set-Cookie header to h for the
+// cookie field f with the value fv.
+func setResultCookie(h http.Header, f field, fv reflect.Value) error {
+	p := &Params{
+		Request: &http.Request{
+			Header: make(http.Header),
+		},
+	}
+	if err := f.marshal(fv, p); err != nil {
+		return errgo.Notef(err, "cannot marshal field %s into response cookie", f.name)
+	}
+	for _, c := range p.Request.Cookies() {
+		http.SetCookie(headerOnlyResponseWriter{h: h}, &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     "/",
+			Secure:   f.tag.secure,
+			HttpOnly: f.tag.httpOnly,
+		})
+	}
+	return nil
+}
+
+// unmarshalResultHeader sets the header fields of the value pointed to
+// by x from the headers of resp.
+func unmarshalResultHeader(resp *http.Response, x interface{}) error {
+	xv := reflect.ValueOf(x)
+	if xv.Kind() != reflect.Ptr || xv.IsNil() {
+		return nil
+	}
+	fields, err := resultHeaderFields(xv.Type().Elem())
+	if err != nil || len(fields) == 0 {
+		return errgo.Mask(err)
+	}
+	xv = xv.Elem()
+	if xv.Kind() == reflect.Ptr {
+		if xv.IsNil() {
+			xv.Set(reflect.New(xv.Type().Elem()))
+		}
+		xv = xv.Elem()
+	}
+	h := resp.Header
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		// Make the cookies set by the response available
+		// to the cookie fields.
+		h = h.Clone()
+		for _, c := range cookies {
+			h.Add("Cookie", (&http.Cookie{Name: c.Name, Value: c.Value}).String())
+		}
+	}
+	p := Params{
+		Request: &http.Request{
+			Header: h,
+		},
+	}
+	for _, f := range fields {
+		fv, ok := fieldByIndex(xv, f.index)
+		if !ok {
+			continue
+		}
+		if err := f.unmarshal(fv, p, f.makeResult); err != nil {
+			return errgo.Notef(err, "cannot unmarshal response header into field %s", f.name)
+		}
+	}
+	return nil
+}
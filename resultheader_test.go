@@ -0,0 +1,105 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type quotaResponse struct {
+	Remaining int       `httprequest:"X-Rate-Limit-Remaining,header" json:"-"`
+	Reset     time.Time `httprequest:"X-Rate-Limit-Reset,header" format:"unix" json:"-"`
+	Tags      []string  `httprequest:"X-Tag,header" json:"-"`
+	Note      *string   `httprequest:"X-Note,header" json:"-"`
+	Name      string    `json:"name"`
+}
+
+func TestResultHeaderFields(t *testing.T) {
+	c := qt.New(t)
+
+	reset := time.Unix(1600000000, 0)
+	srv := httprequest.Server{}
+	h := srv.Handle(func(p httprequest.Params, _ *struct {
+		httprequest.Route `httprequest:"GET /quota"`
+	}) (*quotaResponse, error) {
+		return &quotaResponse{
+			Remaining: 42,
+			Reset:     reset,
+			Tags:      []string{"a", "b"},
+			Name:      "q",
+		}, nil
+	})
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest("GET", "/quota", nil), nil)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Header().Get("X-Rate-Limit-Remaining"), qt.Equals, "42")
+	c.Assert(rec.Header().Get("X-Rate-Limit-Reset"), qt.Equals, "1600000000")
+	c.Assert(rec.Header()["X-Tag"], qt.DeepEquals, []string{"a", "b"})
+	_, ok := rec.Header()["X-Note"]
+	c.Assert(ok, qt.IsFalse)
+	c.Assert(rec.Body.String(), qt.Equals, `{"name":"q"}`)
+
+	hsrv := newHandlerServer([]httprequest.Handler{h})
+	defer hsrv.Close()
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var resp quotaResponse
+	err := client.Get(context.Background(), "/quota", &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Remaining, qt.Equals, 42)
+	c.Assert(resp.Reset.Equal(reset), qt.IsTrue)
+	c.Assert(resp.Tags, qt.DeepEquals, []string{"a", "b"})
+	c.Assert(resp.Note, qt.IsNil)
+	c.Assert(resp.Name, qt.Equals, "q")
+}
+
+func TestResultHeaderFieldsEmptyBody(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Rate-Limit-Remaining", "3")
+		w.Header().Set("X-Note", "hello")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hsrv.Close()
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var resp quotaResponse
+	err := client.Get(context.Background(), "/", &resp)
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp.Remaining, qt.Equals, 3)
+	c.Assert(*resp.Note, qt.Equals, "hello")
+}
+
+func TestResultHeaderFieldsBadHeader(t *testing.T) {
+	c := qt.New(t)
+
+	hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("X-Rate-Limit-Remaining", "lots")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer hsrv.Close()
+
+	client := httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var resp quotaResponse
+	err := client.Get(context.Background(), "/", &resp)
+	c.Assert(err, qt.ErrorMatches, `Get "?http.*"?: cannot unmarshal response header into field Remaining: cannot parse "lots" into int: .*`)
+	_, ok := errgo.Cause(err).(*httprequest.DecodeResponseError)
+	c.Assert(ok, qt.IsTrue)
+}
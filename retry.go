@@ -0,0 +1,134 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// RetryPolicy specifies how a call is retried when it fails with a
+// transient error: an error from the Doer other than the context
+// being done, or a response with one of the statuses 429 Too Many
+// Requests, 502 Bad Gateway, 503 Service Unavailable or 504 Gateway
+// Timeout. The request is sent again unchanged, so a policy should
+// only be used for calls that are safe to repeat.
+//
+// See WithRetry.
+type RetryPolicy struct {
+	// MaxAttempts holds the maximum number of times the
+	// request is sent, including the first. If it is less
+	// than 2, the request is not retried.
+	MaxAttempts int
+
+	// Backoff holds how long to wait before the first retry.
+	// The wait doubles for each subsequent retry. If it is
+	// zero, 100 milliseconds is used. A longer wait requested
+	// by the Retry-After header of a response is respected.
+	Backoff time.Duration
+
+	// MaxBackoff holds the maximum time to wait between
+	// attempts. If it is zero, 10 seconds is used.
+	MaxBackoff time.Duration
+}
+
+// WithRetry returns a CallOption that retries the call according
+// to the given policy.
+func WithRetry(p RetryPolicy) CallOption {
+	return func(o *callOptions) {
+		o.retry = &p
+	}
+}
+
+// sendRetrying sends req as sendAuthorized does, retrying according
+// to c.retry if it is set.
+func (c *Client) sendRetrying(ctx context.Context, doer Doer, req *http.Request) (*http.Response, error) {
+	p := c.retry
+	if p == nil || p.MaxAttempts < 2 {
+		return c.sendAuthorized(ctx, doer, req)
+	}
+	// Make sure that the body can be sent again.
+	if err := setGetBody(req); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	backoff, maxBackoff := p.Backoff, p.MaxBackoff
+	if backoff <= 0 {
+		backoff = 100 * time.Millisecond
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = 10 * time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		httpResp, err := c.sendAuthorized(ctx, doer, req)
+		if attempt >= p.MaxAttempts || !shouldRetry(ctx, httpResp, err) {
+			return httpResp, errgo.Mask(err, errgo.Any)
+		}
+		wait := backoff
+		if httpResp != nil {
+			if d := retryAfter(httpResp, nowFunc(c.Now)()); d > wait {
+				wait = d
+			}
+			io.Copy(ioutil.Discard, io.LimitReader(httpResp.Body, 8*1024))
+			httpResp.Body.Close()
+		}
+		if wait > maxBackoff {
+			wait = maxBackoff
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, errgo.Mask(urlError(ctx.Err(), req), errgo.Any)
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+		req = req.Clone(ctx)
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+		}
+	}
+}
+
+// shouldRetry reports whether a request that resulted in the given
+// response or error should be retried.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter returns the delay requested by the Retry-After
+// header of resp at the given time, or zero if there is none.
+func retryAfter(resp *http.Response, now time.Time) time.Duration {
+	v := resp.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
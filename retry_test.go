@@ -0,0 +1,146 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type retryReq struct {
+	httprequest.Route `httprequest:"PUT /items/:id"`
+	ID                string `httprequest:"id,path"`
+	Body              string `httprequest:",body"`
+}
+
+func newRetryServer(c *qt.C, statuses ...int) (*httptest.Server, *int32) {
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		i := int(atomic.AddInt32(&n, 1)) - 1
+		data, err := ioutil.ReadAll(req.Body)
+		c.Check(err, qt.IsNil)
+		c.Check(string(data), qt.Equals, `"hello"`)
+		if i < len(statuses) {
+			w.Header().Set("Retry-After", "0")
+			httprequest.WriteJSON(w, statuses[i], &httprequest.RemoteError{
+				Message: http.StatusText(statuses[i]),
+			})
+			return
+		}
+		httprequest.WriteJSON(w, http.StatusOK, "ok")
+	}))
+	return srv, &n
+}
+
+func TestWithRetry(t *testing.T) {
+	c := qt.New(t)
+
+	srv, n := newRetryServer(c, http.StatusServiceUnavailable, http.StatusBadGateway)
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	var resp string
+	err := client.CallWithOptions(context.Background(), &retryReq{ID: "1", Body: "hello"}, &resp, httprequest.WithRetry(httprequest.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+	}))
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.Equals, "ok")
+	c.Assert(atomic.LoadInt32(n), qt.Equals, int32(3))
+}
+
+func TestWithRetryGivesUp(t *testing.T) {
+	c := qt.New(t)
+
+	srv, n := newRetryServer(c, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests)
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.CallWithOptions(context.Background(), &retryReq{ID: "1", Body: "hello"}, nil, httprequest.WithRetry(httprequest.RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+	}))
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/items/1: Too Many Requests`)
+	c.Assert(atomic.LoadInt32(n), qt.Equals, int32(2))
+}
+
+func TestWithRetryDoesNotRetryClientErrors(t *testing.T) {
+	c := qt.New(t)
+
+	srv, n := newRetryServer(c, http.StatusBadRequest)
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.CallWithOptions(context.Background(), &retryReq{ID: "1", Body: "hello"}, nil, httprequest.WithRetry(httprequest.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Millisecond,
+	}))
+	c.Assert(err, qt.ErrorMatches, `Put http://.*/items/1: Bad Request`)
+	c.Assert(atomic.LoadInt32(n), qt.Equals, int32(1))
+}
+
+func TestWithRetryContextDone(t *testing.T) {
+	c := qt.New(t)
+
+	srv, _ := newRetryServer(c, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := client.CallWithOptions(ctx, &retryReq{ID: "1", Body: "hello"}, nil, httprequest.WithRetry(httprequest.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Hour,
+	}))
+	c.Assert(errgo.Cause(err), qt.Equals, context.DeadlineExceeded)
+}
+
+func TestWithRetryAfterDate(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var n int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&n, 1) == 1 {
+			w.Header().Set("Retry-After", now.Add(time.Hour).Format(http.TimeFormat))
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		httprequest.WriteJSON(w, http.StatusOK, "ok")
+	}))
+	defer srv.Close()
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+		Now: func() time.Time {
+			return now
+		},
+	}
+	// The delay is found using the client's clock, so the
+	// requested hour is limited to MaxBackoff.
+	start := time.Now()
+	var resp string
+	err := client.CallWithOptions(context.Background(), &retryReq{ID: "1", Body: "hello"}, &resp, httprequest.WithRetry(httprequest.RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  50 * time.Millisecond,
+	}))
+	c.Assert(err, qt.IsNil)
+	c.Assert(resp, qt.Equals, "ok")
+	c.Assert(time.Since(start) >= 50*time.Millisecond, qt.IsTrue)
+	c.Assert(atomic.LoadInt32(&n), qt.Equals, int32(2))
+}
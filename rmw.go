@@ -0,0 +1,107 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrPreconditionFailed is the cause of the error returned by
+// Client.ReadModifyWrite when the resource was modified concurrently
+// on every attempt to update it.
+var ErrPreconditionFailed = errgo.New("precondition failed")
+
+// defaultReadModifyWriteAttempts holds the default value of
+// Client.MaxReadModifyWriteAttempts.
+const defaultReadModifyWriteAttempts = 3
+
+// ReadModifyWrite updates a resource using optimistic concurrency so
+// that concurrent updates are not lost. It reads the resource by
+// calling the route specified by get, unmarshaling the result into
+// val, which must be a non-nil pointer, and noting the ETag header of
+// the response. It then calls modify, which should change *val as
+// required and return the parameters of the request that writes the
+// resource, usually a PUT; that request is sent with an If-Match
+// header holding the ETag. If the resource has been changed in the
+// meantime and the server responds with 412 Precondition Failed,
+// the whole process is repeated, up to c.MaxReadModifyWriteAttempts
+// times.
+//
+// If modify returns an error, ReadModifyWrite returns it without
+// writing the resource. If every attempt fails with 412
+// Precondition Failed, the error has ErrPreconditionFailed as its
+// cause. The options apply to both calls.
+func (c *Client) ReadModifyWrite(ctx context.Context, get, val interface{}, modify func() (interface{}, error), opts ...CallOption) error {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errgo.Newf("value of type %T is not a non-nil pointer", val)
+	}
+	unmarshalError := newCallOptions(opts).unmarshalError
+	if unmarshalError == nil {
+		unmarshalError = c.UnmarshalError
+	}
+	if unmarshalError == nil {
+		unmarshalError = DefaultErrorUnmarshaler
+	}
+	attempts := c.MaxReadModifyWriteAttempts
+	if attempts <= 0 {
+		attempts = defaultReadModifyWriteAttempts
+	}
+	for i := 0; ; i++ {
+		etag, err := c.readETag(ctx, get, v, opts)
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		put, err := modify()
+		if err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+		conflict := false
+		putOpts := append(opts[:len(opts):len(opts)],
+			WithHeader("If-Match", etag),
+			WithUnmarshalError(func(resp *http.Response) error {
+				conflict = resp.StatusCode == http.StatusPreconditionFailed
+				return unmarshalError(resp)
+			}),
+		)
+		err = c.CallWithOptions(ctx, put, nil, putOpts...)
+		if err == nil {
+			return nil
+		}
+		if !conflict {
+			return errgo.Mask(err, errgo.Any)
+		}
+		if i+1 >= attempts {
+			return errgo.WithCausef(err, ErrPreconditionFailed, "resource modified concurrently after %d attempts", attempts)
+		}
+		if err := ctx.Err(); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	}
+}
+
+// readETag reads the resource specified by get into the value
+// pointed to by v and returns its ETag.
+func (c *Client) readETag(ctx context.Context, get interface{}, v reflect.Value, opts []CallOption) (string, error) {
+	var httpResp *http.Response
+	if err := c.CallWithOptions(ctx, get, &httpResp, opts...); err != nil {
+		return "", errgo.Mask(err, errgo.Any)
+	}
+	etag := httpResp.Header.Get("ETag")
+	if etag == "" {
+		httpResp.Body.Close()
+		return "", errgo.Mask(urlError(errgo.New("no ETag in response"), httpResp.Request))
+	}
+	// Start afresh so that nothing is left over from
+	// an earlier attempt.
+	v.Elem().Set(reflect.Zero(v.Type().Elem()))
+	if err := c.unmarshalResponse(httpResp, v.Interface()); err != nil {
+		return "", errgo.Mask(err, errgo.Any)
+	}
+	return etag, nil
+}
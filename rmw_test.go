@@ -0,0 +1,169 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type rmwDoc struct {
+	ETag  string `httprequest:"ETag,header" json:"-"`
+	Count int
+	Tags  map[string]bool `json:",omitempty"`
+}
+
+type rmwGetReq struct {
+	httprequest.Route `httprequest:"GET /doc"`
+}
+
+type rmwPutReq struct {
+	httprequest.Route `httprequest:"PUT /doc"`
+	IfMatch           string `httprequest:"If-Match,header"`
+	Doc               rmwDoc `httprequest:",body"`
+}
+
+// rmwStore holds a document that is versioned by its ETag.
+type rmwStore struct {
+	mu      sync.Mutex
+	version int
+	doc     rmwDoc
+	puts    int
+}
+
+func (s *rmwStore) etag() string {
+	return fmt.Sprintf(`"%d"`, s.version)
+}
+
+func (s *rmwStore) Get(req *rmwGetReq) (*rmwDoc, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	doc := s.doc
+	doc.ETag = s.etag()
+	return &doc, nil
+}
+
+func (s *rmwStore) Put(req *rmwPutReq) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.puts++
+	if req.IfMatch != s.etag() {
+		return httprequest.Errorf(httprequest.CodePreconditionFailed, "document has changed")
+	}
+	s.doc = req.Doc
+	s.version++
+	return nil
+}
+
+// modifyConcurrently simulates another client updating the document.
+func (s *rmwStore) modifyConcurrently() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.doc.Count += 100
+	s.doc.Tags = nil
+	s.version++
+}
+
+func newRMWServer(store *rmwStore) *httptest.Server {
+	srv := &httprequest.Server{}
+	return newHandlerServer(srv.Handlers(func(p httprequest.Params) (*rmwStore, context.Context, error) {
+		return store, p.Context, nil
+	}))
+}
+
+func TestReadModifyWrite(t *testing.T) {
+	c := qt.New(t)
+
+	store := &rmwStore{
+		doc: rmwDoc{
+			Tags: map[string]bool{"a": true},
+		},
+	}
+	hsrv := newRMWServer(store)
+	defer hsrv.Close()
+	client := &httprequest.Client{BaseURL: hsrv.URL}
+
+	var doc rmwDoc
+	calls := 0
+	err := client.ReadModifyWrite(context.Background(), &rmwGetReq{}, &doc, func() (interface{}, error) {
+		calls++
+		if calls == 1 {
+			c.Assert(doc.Tags, qt.DeepEquals, map[string]bool{"a": true})
+			store.modifyConcurrently()
+		} else {
+			// The value has been read afresh.
+			c.Assert(doc.Tags, qt.IsNil)
+		}
+		doc.Count++
+		return &rmwPutReq{IfMatch: doc.ETag, Doc: doc}, nil
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(calls, qt.Equals, 2)
+	c.Assert(store.puts, qt.Equals, 2)
+	c.Assert(store.doc.Count, qt.Equals, 101)
+}
+
+func TestReadModifyWriteTooManyAttempts(t *testing.T) {
+	c := qt.New(t)
+
+	store := &rmwStore{}
+	hsrv := newRMWServer(store)
+	defer hsrv.Close()
+	client := &httprequest.Client{
+		BaseURL:                    hsrv.URL,
+		MaxReadModifyWriteAttempts: 2,
+	}
+	var doc rmwDoc
+	err := client.ReadModifyWrite(context.Background(), &rmwGetReq{}, &doc, func() (interface{}, error) {
+		store.modifyConcurrently()
+		return &rmwPutReq{IfMatch: doc.ETag, Doc: doc}, nil
+	})
+	c.Assert(err, qt.ErrorMatches, `resource modified concurrently after 2 attempts: Put http://.*/doc: document has changed`)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrPreconditionFailed)
+	c.Assert(store.puts, qt.Equals, 2)
+}
+
+func TestReadModifyWriteModifyError(t *testing.T) {
+	c := qt.New(t)
+
+	store := &rmwStore{}
+	hsrv := newRMWServer(store)
+	defer hsrv.Close()
+	client := &httprequest.Client{BaseURL: hsrv.URL}
+
+	var doc rmwDoc
+	err := client.ReadModifyWrite(context.Background(), &rmwGetReq{}, &doc, func() (interface{}, error) {
+		return nil, errgo.New("cannot modify")
+	})
+	c.Assert(err, qt.ErrorMatches, `cannot modify`)
+	c.Assert(store.puts, qt.Equals, 0)
+}
+
+func TestReadModifyWriteNoETag(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	hsrv := newHandlerServer([]httprequest.Handler{
+		srv.Handle(func(req *rmwGetReq) (int, error) {
+			return 1, nil
+		}),
+	})
+	defer hsrv.Close()
+	client := &httprequest.Client{BaseURL: hsrv.URL}
+
+	var n int
+	err := client.ReadModifyWrite(context.Background(), &rmwGetReq{}, &n, func() (interface{}, error) {
+		c.Errorf("modify called unexpectedly")
+		return nil, nil
+	})
+	c.Assert(err, qt.ErrorMatches, `Get http://.*/doc: no ETag in response`)
+}
@@ -0,0 +1,253 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// Registrar is implemented by routers that handlers can be added to,
+// such as *httprouter.Router and *Router.
+type Registrar interface {
+	Handle(method, path string, handle httprouter.Handle)
+}
+
+// HTTPHandler returns an http.Handler that serves the given handlers,
+// as returned by Handlers, Handle and similar methods, using
+// httprouter.Router or, if srv.BuiltinRouter is set, Router.
+func (srv *Server) HTTPHandler(hs []Handler) http.Handler {
+	if srv.BuiltinRouter {
+		var r Router
+		AddHandlers(&r, hs)
+		return &r
+	}
+	r := httprouter.New()
+	AddHandlers(r, hs)
+	return r
+}
+
+// Router is a request router that can be used instead of
+// httprouter.Router. It accepts the same path patterns, with named
+// parameters such as ":id" and a final catch-all parameter such as
+// "*rest", and makes path parameters available in the same way (see
+// httprouter.ParamsFromContext), so handlers created by Server work
+// unchanged with it.
+//
+// Unlike httprouter, Router allows routes whose patterns overlap: a
+// static path segment takes precedence over a named parameter, which
+// takes precedence over a catch-all parameter, so that, for example,
+// "/things/new" and "/things/:id" can both be registered. Router does
+// not redirect requests with or without trailing slashes.
+//
+// The zero Router is ready to use.
+type Router struct {
+	// NotFound, if non-nil, is used to respond to requests that
+	// match no route. If it is nil, http.NotFound is used.
+	NotFound http.Handler
+
+	// MethodNotAllowed, if non-nil, is used to respond to
+	// requests whose path matches a route but whose method does
+	// not. The Allow header is set before it is called. If it is
+	// nil, a 405 Method Not Allowed status is written.
+	MethodNotAllowed http.Handler
+
+	root routerNode
+}
+
+// routerNode holds a node in the tree of path segments of a Router.
+type routerNode struct {
+	// static holds the children for static path segments.
+	static map[string]*routerNode
+
+	// param holds the child for a named parameter, and
+	// paramName holds its name.
+	param     *routerNode
+	paramName string
+
+	// catchAll holds the handlers for a catch-all parameter, and
+	// catchAllName holds its name.
+	catchAll     map[string]httprouter.Handle
+	catchAllName string
+
+	// handlers holds the handlers for the path ending at this
+	// node, keyed by method.
+	handlers map[string]httprouter.Handle
+}
+
+// Handle registers handle for requests with the given method and path
+// pattern. It panics if the pattern is invalid or the route has
+// already been registered.
+func (r *Router) Handle(method, path string, handle httprouter.Handle) {
+	if err := r.handle(method, path, handle); err != nil {
+		panic(errgo.Notef(err, "cannot add route %s %s", method, path))
+	}
+}
+
+func (r *Router) handle(method, path string, handle httprouter.Handle) error {
+	if !strings.HasPrefix(path, "/") {
+		return errgo.New("path must begin with /")
+	}
+	n := &r.root
+	segs := strings.Split(path[1:], "/")
+	for i, seg := range segs {
+		switch {
+		case strings.HasPrefix(seg, ":"):
+			name := seg[1:]
+			if name == "" {
+				return errgo.New("empty parameter name")
+			}
+			if n.param == nil {
+				n.param, n.paramName = new(routerNode), name
+			} else if n.paramName != name {
+				return errgo.Newf("parameter %q conflicts with existing parameter %q", name, n.paramName)
+			}
+			n = n.param
+		case strings.HasPrefix(seg, "*"):
+			name := seg[1:]
+			if name == "" {
+				return errgo.New("empty parameter name")
+			}
+			if i != len(segs)-1 {
+				return errgo.New("catch-all parameter must be at the end of the path")
+			}
+			if n.catchAll == nil {
+				n.catchAll, n.catchAllName = make(map[string]httprouter.Handle), name
+			} else if n.catchAllName != name {
+				return errgo.Newf("parameter %q conflicts with existing parameter %q", name, n.catchAllName)
+			}
+			return addRouteHandler(n.catchAll, method, handle)
+		default:
+			child := n.static[seg]
+			if child == nil {
+				if n.static == nil {
+					n.static = make(map[string]*routerNode)
+				}
+				child = new(routerNode)
+				n.static[seg] = child
+			}
+			n = child
+		}
+	}
+	if n.handlers == nil {
+		n.handlers = make(map[string]httprouter.Handle)
+	}
+	return addRouteHandler(n.handlers, method, handle)
+}
+
+func addRouteHandler(handlers map[string]httprouter.Handle, method string, handle httprouter.Handle) error {
+	if _, ok := handlers[method]; ok {
+		return errgo.New("route already registered")
+	}
+	handlers[method] = handle
+	return nil
+}
+
+// ServeHTTP implements http.Handler by calling the handler for the
+// most specific route that matches req.
+func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	handlers, ps := r.lookup(req.URL.Path, req.Method)
+	if handlers == nil {
+		// Find out whether the path matches a route
+		// for any other method.
+		handlers, _ = r.lookup(req.URL.Path, "")
+	}
+	if handlers == nil {
+		if r.NotFound != nil {
+			r.NotFound.ServeHTTP(w, req)
+		} else {
+			http.NotFound(w, req)
+		}
+		return
+	}
+	h, ok := handlers[req.Method]
+	if !ok {
+		methods := make([]string, 0, len(handlers))
+		for m := range handlers {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		if r.MethodNotAllowed != nil {
+			r.MethodNotAllowed.ServeHTTP(w, req)
+		} else {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if len(ps) > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), httprouter.ParamsKey, ps))
+	}
+	h(w, req, ps)
+}
+
+// lookup returns the handlers for the most specific route that
+// matches the given path and has a handler for the given method, and
+// the values of its parameters. If method is empty, routes for any
+// method match.
+func (r *Router) lookup(path, method string) (map[string]httprouter.Handle, httprouter.Params) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, nil
+	}
+	return r.root.lookup(path[1:], method, nil)
+}
+
+// lookup implements Router.lookup for the part of the request path
+// after the segments that led to n. Static segments are tried before
+// named parameters, and those before catch-all parameters.
+func (n *routerNode) lookup(path, method string, ps httprouter.Params) (map[string]httprouter.Handle, httprouter.Params) {
+	seg, rest, more := path, "", false
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		seg, rest, more = path[:i], path[i+1:], true
+	}
+	if child := n.static[seg]; child != nil {
+		if hs, ps1 := child.match(rest, more, method, ps); hs != nil {
+			return hs, ps1
+		}
+	}
+	if n.param != nil && seg != "" {
+		ps1 := append(ps[:len(ps):len(ps)], httprouter.Param{
+			Key:   n.paramName,
+			Value: seg,
+		})
+		if hs, ps1 := n.param.match(rest, more, method, ps1); hs != nil {
+			return hs, ps1
+		}
+	}
+	if hasRouteHandler(n.catchAll, method) {
+		return n.catchAll, append(ps[:len(ps):len(ps)], httprouter.Param{
+			Key:   n.catchAllName,
+			Value: "/" + path,
+		})
+	}
+	return nil, nil
+}
+
+// match returns the handlers for the route that matches the rest of
+// the path after the segment that led to n. The more argument reports
+// whether there are more segments.
+func (n *routerNode) match(rest string, more bool, method string, ps httprouter.Params) (map[string]httprouter.Handle, httprouter.Params) {
+	if !more {
+		if !hasRouteHandler(n.handlers, method) {
+			return nil, nil
+		}
+		return n.handlers, ps
+	}
+	return n.lookup(rest, method, ps)
+}
+
+// hasRouteHandler reports whether handlers has a handler for the
+// given method or, if method is empty, any handler.
+func hasRouteHandler(handlers map[string]httprouter.Handle, method string) bool {
+	if method == "" {
+		return len(handlers) > 0
+	}
+	_, ok := handlers[method]
+	return ok
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func routerTestHandle(name string) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		fmt.Fprintf(w, "%s %v", name, p)
+	}
+}
+
+var routerTests = []struct {
+	about       string
+	method      string
+	path        string
+	expectCode  int
+	expectBody  string
+	expectAllow string
+}{{
+	about:      "root",
+	method:     "GET",
+	path:       "/",
+	expectCode: http.StatusOK,
+	expectBody: "root []",
+}, {
+	about:      "static takes precedence over parameter",
+	method:     "GET",
+	path:       "/things/new",
+	expectCode: http.StatusOK,
+	expectBody: "new []",
+}, {
+	about:      "parameter",
+	method:     "GET",
+	path:       "/things/42",
+	expectCode: http.StatusOK,
+	expectBody: "thing [{id 42}]",
+}, {
+	about:      "parameter used when static route has no handler for method",
+	method:     "DELETE",
+	path:       "/things/new",
+	expectCode: http.StatusOK,
+	expectBody: "delete [{id new}]",
+}, {
+	about:      "nested parameters",
+	method:     "GET",
+	path:       "/things/42/parts/7",
+	expectCode: http.StatusOK,
+	expectBody: "part [{id 42} {part 7}]",
+}, {
+	about:      "catch-all",
+	method:     "GET",
+	path:       "/things/42/files/a/b",
+	expectCode: http.StatusOK,
+	expectBody: "files [{id 42} {path /a/b}]",
+}, {
+	about:      "empty catch-all",
+	method:     "GET",
+	path:       "/things/42/files/",
+	expectCode: http.StatusOK,
+	expectBody: "files [{id 42} {path /}]",
+}, {
+	about:      "catch-all after failed parameter match",
+	method:     "GET",
+	path:       "/static/x/y",
+	expectCode: http.StatusOK,
+	expectBody: "static [{rest /x/y}]",
+}, {
+	about:       "method not allowed",
+	method:      "PUT",
+	path:        "/things/42",
+	expectCode:  http.StatusMethodNotAllowed,
+	expectAllow: "DELETE, GET",
+}, {
+	about:      "not found",
+	method:     "GET",
+	path:       "/other",
+	expectCode: http.StatusNotFound,
+}, {
+	about:      "empty parameter does not match",
+	method:     "GET",
+	path:       "/things//parts/7",
+	expectCode: http.StatusNotFound,
+}}
+
+func TestRouter(t *testing.T) {
+	c := qt.New(t)
+
+	var r httprequest.Router
+	r.Handle("GET", "/", routerTestHandle("root"))
+	r.Handle("GET", "/things/new", routerTestHandle("new"))
+	r.Handle("GET", "/things/:id", routerTestHandle("thing"))
+	r.Handle("DELETE", "/things/:id", routerTestHandle("delete"))
+	r.Handle("GET", "/things/:id/parts/:part", routerTestHandle("part"))
+	r.Handle("GET", "/things/:id/files/*path", routerTestHandle("files"))
+	r.Handle("GET", "/static/:x", routerTestHandle("x"))
+	r.Handle("GET", "/static/*rest", routerTestHandle("static"))
+
+	for _, test := range routerTests {
+		c.Run(test.about, func(c *qt.C) {
+			rec := httptest.NewRecorder()
+			r.ServeHTTP(rec, httptest.NewRequest(test.method, test.path, nil))
+			c.Assert(rec.Code, qt.Equals, test.expectCode)
+			if test.expectBody != "" {
+				c.Assert(rec.Body.String(), qt.Equals, test.expectBody)
+			}
+			c.Assert(rec.Header().Get("Allow"), qt.Equals, test.expectAllow)
+		})
+	}
+}
+
+var routerPanicTests = []struct {
+	about       string
+	path        string
+	expectPanic string
+}{{
+	about:       "duplicate route",
+	path:        "/a/:id",
+	expectPanic: `cannot add route GET /a/:id: route already registered`,
+}, {
+	about:       "conflicting parameter name",
+	path:        "/a/:name/b",
+	expectPanic: `cannot add route GET /a/:name/b: parameter "name" conflicts with existing parameter "id"`,
+}, {
+	about:       "catch-all not at end",
+	path:        "/a/*rest/b",
+	expectPanic: `cannot add route GET /a/\*rest/b: catch-all parameter must be at the end of the path`,
+}, {
+	about:       "relative path",
+	path:        "a",
+	expectPanic: `cannot add route GET a: path must begin with /`,
+}}
+
+func TestRouterPanics(t *testing.T) {
+	c := qt.New(t)
+
+	var r httprequest.Router
+	r.Handle("GET", "/a/:id", routerTestHandle("a"))
+	for _, test := range routerPanicTests {
+		c.Run(test.about, func(c *qt.C) {
+			c.Assert(func() {
+				r.Handle("GET", test.path, routerTestHandle("x"))
+			}, qt.PanicMatches, test.expectPanic)
+		})
+	}
+}
+
+func TestServerHTTPHandlerBuiltinRouter(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		BuiltinRouter: true,
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (endpointHandlers, context.Context, error) {
+		return endpointHandlers{}, p.Context, nil
+	})
+	h := srv.HTTPHandler(hs)
+	_, ok := h.(*httprequest.Router)
+	c.Assert(ok, qt.IsTrue)
+
+	hsrv := httptest.NewServer(h)
+	defer hsrv.Close()
+	client := &httprequest.Client{
+		BaseURL: hsrv.URL,
+	}
+	var s string
+	err := client.Call(context.Background(), &endpointItemReq{ID: "1", Rest: "/x"}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "1/x")
+
+	srv.BuiltinRouter = false
+	_, ok = srv.HTTPHandler(hs).(*httprouter.Router)
+	c.Assert(ok, qt.IsTrue)
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SagaStep holds a step of a Saga.
+type SagaStep struct {
+	// Name holds the name of the step, used in errors.
+	Name string
+
+	// Do performs the step, typically by making one or more
+	// calls with a Client.
+	Do func(ctx context.Context) error
+
+	// Compensate, if non-nil, undoes the effects of a successful
+	// call to Do. It is called when a later step fails.
+	Compensate func(ctx context.Context) error
+}
+
+// Saga orchestrates a workflow that spans several calls, such as
+// calls to different services, that cannot be made atomic. Steps are
+// run in order; when a step fails, the steps that have already
+// succeeded are compensated in reverse order, so that, for example,
+// a resource created by one call is deleted when a later call fails.
+//
+// Values returned by earlier steps can be used by later steps and
+// compensations by capturing variables in their functions, for
+// example:
+//
+//	var s httprequest.Saga
+//	var order *Order
+//	err := s.Run(ctx, httprequest.SagaStep{
+//		Name: "create order",
+//		Do: func(ctx context.Context) (err error) {
+//			order, err = orders.Create(ctx, &CreateOrderRequest{...})
+//			return err
+//		},
+//		Compensate: func(ctx context.Context) error {
+//			return orders.Delete(ctx, &DeleteOrderRequest{ID: order.ID})
+//		},
+//	}, httprequest.SagaStep{
+//		Name: "charge payment",
+//		Do: func(ctx context.Context) error {
+//			return payments.Charge(ctx, &ChargeRequest{OrderID: order.ID})
+//		},
+//	})
+//
+// The zero Saga is ready to use. A Saga may be used by only one
+// goroutine at a time.
+type Saga struct {
+	// CompensationTimeout holds the maximum time that each
+	// compensation may take. If it is zero, there is no limit.
+	CompensationTimeout time.Duration
+
+	done []SagaStep
+}
+
+// Run runs the given steps in order after any steps run by earlier
+// calls to Run. If a step fails or the context is done before it
+// starts, all the steps that have succeeded so far are compensated
+// and Run returns a *SagaError.
+//
+// Compensations are run with a context that holds the values of ctx
+// but is not canceled when ctx is, so that the workflow can be
+// undone even when it fails because ctx was canceled.
+func (s *Saga) Run(ctx context.Context, steps ...SagaStep) error {
+	for _, step := range steps {
+		err := ctx.Err()
+		if err == nil {
+			err = step.Do(ctx)
+		}
+		if err != nil {
+			serr := &SagaError{
+				Step: step.Name,
+				Err:  err,
+			}
+			serr.CompensationErrors = s.compensate(ctx)
+			return serr
+		}
+		s.done = append(s.done, step)
+	}
+	return nil
+}
+
+// Compensate compensates all the steps that have succeeded so far in
+// reverse order, for example when the workflow must be abandoned for
+// a reason other than the failure of a step. It returns a *SagaError
+// if any compensation fails.
+func (s *Saga) Compensate(ctx context.Context) error {
+	if errs := s.compensate(ctx); len(errs) > 0 {
+		return &SagaError{
+			CompensationErrors: errs,
+		}
+	}
+	return nil
+}
+
+// compensate compensates the completed steps of s and returns any
+// errors keyed by step name.
+func (s *Saga) compensate(ctx context.Context) map[string]error {
+	ctx = detachedContext{ctx}
+	var errs map[string]error
+	for i := len(s.done) - 1; i >= 0; i-- {
+		step := s.done[i]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := s.compensateStep(ctx, step); err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[step.Name] = err
+		}
+	}
+	s.done = nil
+	return errs
+}
+
+func (s *Saga) compensateStep(ctx context.Context, step SagaStep) error {
+	if s.CompensationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.CompensationTimeout)
+		defer cancel()
+	}
+	return step.Compensate(ctx)
+}
+
+// SagaError is the error returned by Saga.Run when a step fails, and
+// by Saga.Compensate when a compensation fails. Its cause is the
+// error returned by the failed step.
+type SagaError struct {
+	// Step holds the name of the step that failed, or the empty
+	// string if the error was returned by Saga.Compensate.
+	Step string
+
+	// Err holds the error returned by the step, or the context
+	// error if the context was done before the step started.
+	Err error
+
+	// CompensationErrors holds the errors returned by any
+	// compensations that failed, keyed by step name. When a
+	// compensation fails, the effects of its step may remain.
+	CompensationErrors map[string]error
+}
+
+// Error implements the error interface.
+func (e *SagaError) Error() string {
+	var buf strings.Builder
+	if e.Err != nil {
+		fmt.Fprintf(&buf, "saga step %q failed: %v", e.Step, e.Err)
+	} else {
+		buf.WriteString("saga compensation failed")
+	}
+	if len(e.CompensationErrors) > 0 {
+		names := make([]string, 0, len(e.CompensationErrors))
+		for name := range e.CompensationErrors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&buf, "; cannot compensate step %q: %v", name, e.CompensationErrors[name])
+		}
+	}
+	return buf.String()
+}
+
+// Cause implements errgo.Causer by returning the cause of the error
+// returned by the failed step, so that, for example, a *RemoteError
+// returned by a call can be inspected.
+func (e *SagaError) Cause() error {
+	if e.Err == nil {
+		return nil
+	}
+	return errgo.Cause(e.Err)
+}
+
+// detachedContext is a context that holds the values of its parent
+// but is never canceled and has no deadline.
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type sagaCreateReq struct {
+	httprequest.Route `httprequest:"PUT /items/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+type sagaDeleteReq struct {
+	httprequest.Route `httprequest:"DELETE /items/:name"`
+	Name              string `httprequest:"name,path"`
+}
+
+type sagaHandlers struct {
+	items map[string]bool
+}
+
+func (h sagaHandlers) Create(req *sagaCreateReq) error {
+	if req.Name == "bad" {
+		return httprequest.Errorf(httprequest.CodeConflict, "cannot create %q", req.Name)
+	}
+	h.items[req.Name] = true
+	return nil
+}
+
+func (h sagaHandlers) Delete(req *sagaDeleteReq) error {
+	delete(h.items, req.Name)
+	return nil
+}
+
+func newSagaServer(items map[string]bool) *httptest.Server {
+	srv := &httprequest.Server{}
+	return newHandlerServer(srv.Handlers(func(p httprequest.Params) (sagaHandlers, context.Context, error) {
+		return sagaHandlers{items}, p.Context, nil
+	}))
+}
+
+func sagaCreateStep(client *httprequest.Client, name string) httprequest.SagaStep {
+	return httprequest.SagaStep{
+		Name: "create " + name,
+		Do: func(ctx context.Context) error {
+			return client.Call(ctx, &sagaCreateReq{Name: name}, nil)
+		},
+		Compensate: func(ctx context.Context) error {
+			return client.Call(ctx, &sagaDeleteReq{Name: name}, nil)
+		},
+	}
+}
+
+func TestSagaSuccess(t *testing.T) {
+	c := qt.New(t)
+
+	items := make(map[string]bool)
+	hsrv := newSagaServer(items)
+	defer hsrv.Close()
+	client := &httprequest.Client{BaseURL: hsrv.URL}
+
+	var s httprequest.Saga
+	err := s.Run(context.Background(), sagaCreateStep(client, "a"), sagaCreateStep(client, "b"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(items, qt.DeepEquals, map[string]bool{"a": true, "b": true})
+}
+
+func TestSagaCompensatesOnFailure(t *testing.T) {
+	c := qt.New(t)
+
+	items := make(map[string]bool)
+	hsrv := newSagaServer(items)
+	defer hsrv.Close()
+	client := &httprequest.Client{BaseURL: hsrv.URL}
+
+	var s httprequest.Saga
+	ctx := context.Background()
+	err := s.Run(ctx, sagaCreateStep(client, "a"))
+	c.Assert(err, qt.IsNil)
+	err = s.Run(ctx, sagaCreateStep(client, "b"), sagaCreateStep(client, "bad"), sagaCreateStep(client, "c"))
+	c.Assert(err, qt.ErrorMatches, `saga step "create bad" failed: Put http://.*/items/bad: cannot create "bad"`)
+	serr := err.(*httprequest.SagaError)
+	c.Assert(serr.Step, qt.Equals, "create bad")
+	c.Assert(serr.CompensationErrors, qt.HasLen, 0)
+	c.Assert(errgo.Cause(err).(*httprequest.RemoteError).Code, qt.Equals, httprequest.CodeConflict)
+	c.Assert(items, qt.DeepEquals, map[string]bool{})
+}
+
+func TestSagaCompensationFailure(t *testing.T) {
+	c := qt.New(t)
+
+	var compensated []string
+	step := func(name string, doErr, compErr error) httprequest.SagaStep {
+		return httprequest.SagaStep{
+			Name: name,
+			Do: func(ctx context.Context) error {
+				return doErr
+			},
+			Compensate: func(ctx context.Context) error {
+				compensated = append(compensated, name)
+				return compErr
+			},
+		}
+	}
+	var s httprequest.Saga
+	err := s.Run(context.Background(),
+		step("one", nil, nil),
+		step("two", nil, errgo.New("undo failed")),
+		step("three", nil, nil),
+		step("four", errgo.New("oops"), nil),
+	)
+	c.Assert(err, qt.ErrorMatches, `saga step "four" failed: oops; cannot compensate step "two": undo failed`)
+	c.Assert(compensated, qt.DeepEquals, []string{"three", "two", "one"})
+
+	// Nothing remains to be compensated.
+	c.Assert(s.Compensate(context.Background()), qt.IsNil)
+	c.Assert(compensated, qt.HasLen, 3)
+}
+
+func TestSagaCanceledContext(t *testing.T) {
+	c := qt.New(t)
+
+	type key struct{}
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "v"))
+	var compCtxErr error
+	var compValue interface{}
+	s := httprequest.Saga{
+		CompensationTimeout: time.Minute,
+	}
+	err := s.Run(ctx, httprequest.SagaStep{
+		Name: "first",
+		Do: func(ctx context.Context) error {
+			cancel()
+			return nil
+		},
+		Compensate: func(ctx context.Context) error {
+			compCtxErr = ctx.Err()
+			compValue = ctx.Value(key{})
+			_, hasDeadline := ctx.Deadline()
+			c.Check(hasDeadline, qt.IsTrue)
+			return nil
+		},
+	}, httprequest.SagaStep{
+		Name: "second",
+		Do: func(ctx context.Context) error {
+			c.Errorf("second step run after cancellation")
+			return nil
+		},
+	})
+	c.Assert(err, qt.ErrorMatches, `saga step "second" failed: context canceled`)
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+	c.Assert(compCtxErr, qt.IsNil)
+	c.Assert(compValue, qt.Equals, "v")
+}
+
+func TestSagaCompensate(t *testing.T) {
+	c := qt.New(t)
+
+	var s httprequest.Saga
+	undone := false
+	err := s.Run(context.Background(), httprequest.SagaStep{
+		Name: "x",
+		Do: func(ctx context.Context) error {
+			return nil
+		},
+		Compensate: func(ctx context.Context) error {
+			undone = true
+			return errgo.New("no")
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	err = s.Compensate(context.Background())
+	c.Assert(err, qt.ErrorMatches, `saga compensation failed; cannot compensate step "x": no`)
+	c.Assert(undone, qt.IsTrue)
+}
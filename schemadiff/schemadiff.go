@@ -0,0 +1,514 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package schemadiff compares schema snapshots, as written by
+// httprequest-generate-client with the -snapshot flag, and reports
+// the differences between them, classified as breaking or
+// non-breaking, so that they can be used for changelogs and to gate
+// releases:
+//
+//	oldSnap, err := schemadiff.ReadFile("api-v1.json")
+//	...
+//	newSnap, err := schemadiff.ReadFile("api.json")
+//	...
+//	report := schemadiff.Diff(oldSnap, newSnap)
+//	if report.Breaking() {
+//		...
+//	}
+package schemadiff
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/tags"
+)
+
+// SnapshotVersion holds the version of the snapshot format that is
+// understood by this package.
+const SnapshotVersion = 1
+
+// Snapshot holds a schema snapshot.
+type Snapshot struct {
+	Version int      `json:"version"`
+	Imports []string `json:"imports,omitempty"`
+	Types   []Type   `json:"types"`
+	Methods []Method `json:"methods"`
+}
+
+// Type holds a type declared in a snapshot. Type holds its type
+// expression in Go syntax.
+type Type struct {
+	Name  string `json:"name"`
+	Doc   string `json:"doc,omitempty"`
+	Type  string `json:"type"`
+	Alias bool   `json:"alias,omitempty"`
+}
+
+// Method holds a server method in a snapshot.
+type Method struct {
+	Name       string `json:"name"`
+	Doc        string `json:"doc,omitempty"`
+	ParamType  string `json:"param-type"`
+	RespType   string `json:"resp-type,omitempty"`
+	HTTPMethod string `json:"http-method"`
+	Path       string `json:"path"`
+}
+
+// Read reads a snapshot from r.
+func Read(r io.Reader) (*Snapshot, error) {
+	var snap Snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, errgo.Notef(err, "cannot parse snapshot")
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, errgo.Newf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+// ReadFile reads a snapshot from the named file.
+func ReadFile(filename string) (*Snapshot, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	defer f.Close()
+	snap, err := Read(f)
+	if err != nil {
+		return nil, errgo.Notef(err, "%s", filename)
+	}
+	return snap, nil
+}
+
+// ChangeKind describes the kind of a Change.
+type ChangeKind string
+
+// These are the kinds of change.
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+	Changed ChangeKind = "changed"
+)
+
+// Part identifies the part of a route that a Change applies to.
+type Part string
+
+// These are the parts of a route.
+const (
+	Request  Part = "request"
+	Response Part = "response"
+)
+
+// Change describes a difference between two snapshots.
+type Change struct {
+	// Kind holds the kind of the change.
+	Kind ChangeKind `json:"kind"`
+
+	// Route holds the route that changed, in the form
+	// "METHOD /path/pattern".
+	Route string `json:"route"`
+
+	// Part and Field identify the field that changed, if the
+	// change is not to the route as a whole. Request fields are
+	// named by their source and name, for example "form limit"
+	// or "header X-Foo", and the body is named "body"; the JSON
+	// fields within the body and the response are named by
+	// their paths, for example "body.name" or "items[].id".
+	// The response as a whole has an empty field name.
+	Part  Part   `json:"part,omitempty"`
+	Field string `json:"field,omitempty"`
+
+	// Old and New hold the old and new types of the field,
+	// where relevant.
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+
+	// Breaking holds whether existing clients may be broken by
+	// the change.
+	Breaking bool `json:"breaking"`
+}
+
+// String returns a description of the change.
+func (c Change) String() string {
+	var buf strings.Builder
+	if c.Breaking {
+		buf.WriteString("breaking: ")
+	}
+	buf.WriteString(c.Route)
+	if c.Part != "" {
+		buf.WriteString(" ")
+		buf.WriteString(string(c.Part))
+		if c.Field != "" {
+			buf.WriteString(" ")
+			buf.WriteString(c.Field)
+		}
+	}
+	buf.WriteString(" ")
+	buf.WriteString(string(c.Kind))
+	switch c.Kind {
+	case Changed:
+		fmt.Fprintf(&buf, " from %s to %s", c.Old, c.New)
+	case Added:
+		if c.New != "" {
+			fmt.Fprintf(&buf, " (%s)", c.New)
+		}
+	case Removed:
+		if c.Old != "" {
+			fmt.Fprintf(&buf, " (%s)", c.Old)
+		}
+	}
+	return buf.String()
+}
+
+// Report holds the differences between two snapshots.
+type Report struct {
+	Changes []Change `json:"changes"`
+}
+
+// Breaking reports whether any of the changes is breaking.
+func (r *Report) Breaking() bool {
+	for _, c := range r.Changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the differences between the old and new snapshots.
+// Routes are matched by method and path pattern, so a route whose
+// path changes is reported as removed and added.
+//
+// Removing a route, or a request or response field, and changing the
+// type of a field are breaking changes; adding a route or field is
+// not.
+func Diff(old, new *Snapshot) *Report {
+	oldRoutes, newRoutes := routes(old), routes(new)
+	var names []string
+	for name := range oldRoutes {
+		names = append(names, name)
+	}
+	for name := range newRoutes {
+		if _, ok := oldRoutes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	r := &Report{
+		Changes: []Change{},
+	}
+	for _, name := range names {
+		m0, ok0 := oldRoutes[name]
+		m1, ok1 := newRoutes[name]
+		switch {
+		case !ok1:
+			r.Changes = append(r.Changes, Change{
+				Kind:     Removed,
+				Route:    name,
+				Breaking: true,
+			})
+		case !ok0:
+			r.Changes = append(r.Changes, Change{
+				Kind:  Added,
+				Route: name,
+			})
+		default:
+			r.Changes = append(r.Changes, diffFields(name, Request, requestFields(old, m0), requestFields(new, m1))...)
+			resp0, resp1 := responseFields(old, m0), responseFields(new, m1)
+			if m0.RespType == "" || m1.RespType == "" {
+				// The response was added or removed as a whole,
+				// so there is no point in listing its fields.
+				resp0, resp1 = topLevel(resp0), topLevel(resp1)
+			}
+			r.Changes = append(r.Changes, diffFields(name, Response, resp0, resp1)...)
+		}
+	}
+	return r
+}
+
+// routes returns the methods of snap keyed by route.
+func routes(snap *Snapshot) map[string]Method {
+	m := make(map[string]Method)
+	for _, meth := range snap.Methods {
+		m[meth.HTTPMethod+" "+meth.Path] = meth
+	}
+	return m
+}
+
+// topLevel returns the entry for the top level type in fields, if
+// any, without those for its fields.
+func topLevel(fields map[string]string) map[string]string {
+	t, ok := fields[""]
+	if !ok {
+		return nil
+	}
+	return map[string]string{"": t}
+}
+
+// diffFields returns the changes between the old and new fields of a
+// part of the given route.
+func diffFields(route string, part Part, old, new map[string]string) []Change {
+	var names []string
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range new {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var changes []Change
+	for _, name := range names {
+		t0, ok0 := old[name]
+		t1, ok1 := new[name]
+		c := Change{
+			Route: route,
+			Part:  part,
+			Field: name,
+			Old:   t0,
+			New:   t1,
+		}
+		switch {
+		case !ok1:
+			c.Kind, c.Breaking = Removed, true
+		case !ok0:
+			c.Kind = Added
+		case t0 != t1:
+			c.Kind, c.Breaking = Changed, true
+		default:
+			continue
+		}
+		changes = append(changes, c)
+	}
+	return changes
+}
+
+// maxDepth holds the maximum depth to which nested types are
+// compared, which also guards against recursive types.
+const maxDepth = 8
+
+// requestFields returns the request fields of m, keyed by source and
+// name, with their types.
+func requestFields(snap *Snapshot, m Method) map[string]string {
+	fields := make(map[string]string)
+	st := structType(snap, parseType(m.ParamType))
+	if st == nil {
+		return fields
+	}
+	forEachField(snap, st, 0, func(name string, f *ast.Field, tag reflect.StructTag) bool {
+		t, err := tags.Parse(tag, name)
+		if err != nil || t.Source == tags.SourceNone {
+			// Recurse into untagged embedded structs.
+			return true
+		}
+		switch t.Source {
+		case tags.SourceBody:
+			fields["body"] = typeString(f.Type)
+			addJSONFields(snap, fields, "body", f.Type, 1)
+		case tags.SourceFormBody:
+			fields["form "+t.Name] = typeString(f.Type)
+		default:
+			fields[t.Source.String()+" "+t.Name] = typeString(f.Type)
+		}
+		return false
+	})
+	return fields
+}
+
+// responseFields returns the JSON fields of the response of m, keyed
+// by path, with their types. The response type itself has the empty
+// key.
+func responseFields(snap *Snapshot, m Method) map[string]string {
+	fields := make(map[string]string)
+	if m.RespType == "" {
+		return fields
+	}
+	expr := parseType(m.RespType)
+	fields[""] = typeString(expr)
+	addJSONFields(snap, fields, "", expr, 0)
+	return fields
+}
+
+// addJSONFields adds the JSON fields of the type expr to fields, with
+// the given path prefix.
+func addJSONFields(snap *Snapshot, fields map[string]string, prefix string, expr ast.Expr, depth int) {
+	if depth > maxDepth {
+		return
+	}
+	expr = resolve(snap, expr)
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		addJSONFields(snap, fields, prefix, e.X, depth)
+		return
+	case *ast.ArrayType:
+		addJSONFields(snap, fields, prefix+"[]", e.Elt, depth+1)
+		return
+	case *ast.MapType:
+		addJSONFields(snap, fields, prefix+"[*]", e.Value, depth+1)
+		return
+	}
+	st, ok := expr.(*ast.StructType)
+	if !ok {
+		return
+	}
+	forEachField(snap, st, depth, func(name string, f *ast.Field, tag reflect.StructTag) bool {
+		jsonName, ok := tag.Lookup("json")
+		if jsonName == "-" {
+			return false
+		}
+		if i := strings.Index(jsonName, ","); i >= 0 {
+			jsonName = jsonName[:i]
+		}
+		if len(f.Names) == 0 && !ok {
+			// Embedded fields without a JSON name are
+			// flattened.
+			return true
+		}
+		if !ast.IsExported(name) {
+			return false
+		}
+		if jsonName == "" {
+			jsonName = name
+		}
+		path := jsonName
+		if prefix != "" {
+			path = prefix + "." + jsonName
+		}
+		fields[path] = typeString(f.Type)
+		addJSONFields(snap, fields, path, f.Type, depth+1)
+		return false
+	})
+}
+
+// forEachField calls fn for each field of st with its name and tag.
+// When fn returns true for an embedded struct field, fn is called for
+// the fields of the embedded struct too.
+func forEachField(snap *Snapshot, st *ast.StructType, depth int, fn func(name string, f *ast.Field, tag reflect.StructTag) bool) {
+	if depth > maxDepth || st.Fields == nil {
+		return
+	}
+	for _, f := range st.Fields.List {
+		var tag reflect.StructTag
+		if f.Tag != nil {
+			if s, err := strconv.Unquote(f.Tag.Value); err == nil {
+				tag = reflect.StructTag(s)
+			}
+		}
+		if len(f.Names) == 0 {
+			if fn(embeddedName(f.Type), f, tag) {
+				if st1 := structType(snap, f.Type); st1 != nil {
+					forEachField(snap, st1, depth+1, fn)
+				}
+			}
+			continue
+		}
+		for _, name := range f.Names {
+			fn(name.Name, f, tag)
+		}
+	}
+}
+
+// embeddedName returns the field name of an embedded field of the
+// given type.
+func embeddedName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedName(e.X)
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
+
+// structType returns the struct type that expr is or points to,
+// resolving names declared in snap, or nil if there is none.
+func structType(snap *Snapshot, expr ast.Expr) *ast.StructType {
+	expr = resolve(snap, expr)
+	if e, ok := expr.(*ast.StarExpr); ok {
+		expr = resolve(snap, e.X)
+	}
+	st, _ := expr.(*ast.StructType)
+	return st
+}
+
+// resolve returns the type expression declared in snap for expr if it
+// is the name of a declared type, or expr otherwise.
+func resolve(snap *Snapshot, expr ast.Expr) ast.Expr {
+	for i := 0; i < maxDepth; i++ {
+		id, ok := expr.(*ast.Ident)
+		if !ok {
+			return expr
+		}
+		decl := lookupType(snap, id.Name)
+		if decl == nil {
+			return expr
+		}
+		expr = parseType(decl.Type)
+	}
+	return expr
+}
+
+func lookupType(snap *Snapshot, name string) *Type {
+	for i := range snap.Types {
+		if snap.Types[i].Name == name {
+			return &snap.Types[i]
+		}
+	}
+	return nil
+}
+
+// parseType parses the type expression s. If s is not valid, it
+// returns an identifier holding s, so that it can still be compared.
+func parseType(s string) ast.Expr {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return ast.NewIdent(s)
+	}
+	return expr
+}
+
+// typeString returns the canonical form of the type expression expr,
+// with struct types abbreviated so that changes to their fields are
+// reported only for the fields themselves.
+func typeString(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + typeString(e.X)
+	case *ast.ArrayType:
+		if e.Len == nil {
+			return "[]" + typeString(e.Elt)
+		}
+		return "[" + exprString(e.Len) + "]" + typeString(e.Elt)
+	case *ast.MapType:
+		return "map[" + typeString(e.Key) + "]" + typeString(e.Value)
+	case *ast.StructType:
+		return "struct{...}"
+	}
+	return exprString(expr)
+}
+
+// exprString returns the canonical form of the expression expr.
+func exprString(expr ast.Expr) string {
+	var buf bytes.Buffer
+	if err := printer.Fprint(&buf, token.NewFileSet(), expr); err != nil {
+		return fmt.Sprintf("%#v", expr)
+	}
+	return buf.String()
+}
@@ -0,0 +1,165 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package schemadiff_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1/schemadiff"
+)
+
+const oldSnapshot = `{
+	"version": 1,
+	"imports": ["gopkg.in/httprequest.v1", "time"],
+	"types": [{
+		"name": "GetThingParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"GET /things/:id\\\"`" + `\nID string ` + "`httprequest:\\\"id,path\\\"`" + `\nLimit int ` + "`httprequest:\\\"limit,form\\\"`" + `\nFilter string ` + "`httprequest:\\\"filter,form\\\"`" + `\n}"
+	}, {
+		"name": "Thing",
+		"type": "struct {\nName string ` + "`json:\\\"name\\\"`" + `\nCount int ` + "`json:\\\"count\\\"`" + `\nTags []Tag ` + "`json:\\\"tags\\\"`" + `\nsecret string\n}"
+	}, {
+		"name": "Tag",
+		"type": "struct {\nKey string\n}"
+	}, {
+		"name": "PutThingParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"PUT /things/:id\\\"`" + `\nID string ` + "`httprequest:\\\"id,path\\\"`" + `\nBody Thing ` + "`httprequest:\\\",body\\\"`" + `\n}"
+	}, {
+		"name": "DeleteThingParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"DELETE /things/:id\\\"`" + `\nID string ` + "`httprequest:\\\"id,path\\\"`" + `\n}"
+	}],
+	"methods": [{
+		"name": "GetThing",
+		"param-type": "GetThingParams",
+		"resp-type": "*Thing",
+		"http-method": "GET",
+		"path": "/things/:id"
+	}, {
+		"name": "PutThing",
+		"param-type": "PutThingParams",
+		"http-method": "PUT",
+		"path": "/things/:id"
+	}, {
+		"name": "DeleteThing",
+		"param-type": "DeleteThingParams",
+		"http-method": "DELETE",
+		"path": "/things/:id"
+	}]
+}`
+
+const newSnapshot = `{
+	"version": 1,
+	"imports": ["gopkg.in/httprequest.v1", "time"],
+	"types": [{
+		"name": "GetThingParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"GET /things/:id\\\"`" + `\nID string ` + "`httprequest:\\\"id,path\\\"`" + `\nLimit int64 ` + "`httprequest:\\\"limit,form\\\"`" + `\nSince time.Time ` + "`httprequest:\\\"since,form\\\"`" + `\n}"
+	}, {
+		"name": "Thing",
+		"type": "struct {\nName string ` + "`json:\\\"name\\\"`" + `\nTags []Tag ` + "`json:\\\"tags\\\"`" + `\nCreated time.Time ` + "`json:\\\"created\\\"`" + `\n}"
+	}, {
+		"name": "Tag",
+		"type": "struct {\nKey string\nValue string\n}"
+	}, {
+		"name": "PutThingParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"PUT /things/:id\\\"`" + `\nID string ` + "`httprequest:\\\"id,path\\\"`" + `\nBody Thing ` + "`httprequest:\\\",body\\\"`" + `\n}"
+	}, {
+		"name": "ListThingsParams",
+		"type": "struct {\nhttprequest.Route ` + "`httprequest:\\\"GET /things\\\"`" + `\n}"
+	}],
+	"methods": [{
+		"name": "GetThing",
+		"param-type": "GetThingParams",
+		"resp-type": "*Thing",
+		"http-method": "GET",
+		"path": "/things/:id"
+	}, {
+		"name": "PutThing",
+		"param-type": "PutThingParams",
+		"resp-type": "*Thing",
+		"http-method": "PUT",
+		"path": "/things/:id"
+	}, {
+		"name": "ListThings",
+		"param-type": "ListThingsParams",
+		"resp-type": "[]Thing",
+		"http-method": "GET",
+		"path": "/things"
+	}]
+}`
+
+func readSnapshot(c *qt.C, s string) *schemadiff.Snapshot {
+	snap, err := schemadiff.Read(strings.NewReader(s))
+	c.Assert(err, qt.IsNil)
+	return snap
+}
+
+func TestDiff(t *testing.T) {
+	c := qt.New(t)
+	report := schemadiff.Diff(readSnapshot(c, oldSnapshot), readSnapshot(c, newSnapshot))
+	var changes []string
+	for _, change := range report.Changes {
+		changes = append(changes, change.String())
+	}
+	c.Assert(changes, qt.DeepEquals, []string{
+		"breaking: DELETE /things/:id removed",
+		"GET /things added",
+		"breaking: GET /things/:id request form filter removed (string)",
+		"breaking: GET /things/:id request form limit changed from int to int64",
+		"GET /things/:id request form since added (time.Time)",
+		"breaking: GET /things/:id response count removed (int)",
+		"GET /things/:id response created added (time.Time)",
+		"GET /things/:id response tags[].Value added (string)",
+		"breaking: PUT /things/:id request body.count removed (int)",
+		"PUT /things/:id request body.created added (time.Time)",
+		"PUT /things/:id request body.tags[].Value added (string)",
+		"PUT /things/:id response added (*Thing)",
+	})
+	c.Assert(report.Breaking(), qt.Equals, true)
+}
+
+func TestDiffIdentical(t *testing.T) {
+	c := qt.New(t)
+	report := schemadiff.Diff(readSnapshot(c, oldSnapshot), readSnapshot(c, oldSnapshot))
+	c.Assert(report.Changes, qt.HasLen, 0)
+	c.Assert(report.Breaking(), qt.Equals, false)
+	data, err := json.Marshal(report)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.Equals, `{"changes":[]}`)
+}
+
+func TestDiffJSON(t *testing.T) {
+	c := qt.New(t)
+	report := schemadiff.Diff(readSnapshot(c, oldSnapshot), readSnapshot(c, newSnapshot))
+	data, err := json.Marshal(report.Changes[:3])
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(data), qt.JSONEquals, []interface{}{
+		map[string]interface{}{
+			"kind":     "removed",
+			"route":    "DELETE /things/:id",
+			"breaking": true,
+		},
+		map[string]interface{}{
+			"kind":     "added",
+			"route":    "GET /things",
+			"breaking": false,
+		},
+		map[string]interface{}{
+			"kind":     "removed",
+			"route":    "GET /things/:id",
+			"part":     "request",
+			"field":    "form filter",
+			"old":      "string",
+			"breaking": true,
+		},
+	})
+}
+
+func TestReadBadVersion(t *testing.T) {
+	c := qt.New(t)
+	_, err := schemadiff.Read(strings.NewReader(`{"version": 2}`))
+	c.Assert(err, qt.ErrorMatches, `unsupported snapshot version 2`)
+}
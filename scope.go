@@ -0,0 +1,40 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// wrapScopes returns a handler that checks that requests have been
+// granted all of the given scopes before calling h.
+func (srv *Server) wrapScopes(scopes []string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ctx := req.Context()
+		granted, err := srv.Scopes(ctx, req)
+		if err != nil {
+			srv.WriteError(ctx, w, errgo.Mask(err, errgo.Any))
+			return
+		}
+		for _, scope := range scopes {
+			if !containsString(granted, scope) {
+				srv.WriteError(ctx, w, Errorf(CodeForbidden, "missing required scope %q", scope))
+				return
+			}
+		}
+		h(w, req, p)
+	}
+}
+
+func containsString(ss []string, s string) bool {
+	for _, s1 := range ss {
+		if s1 == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type scopedReq struct {
+	httprequest.Route `httprequest:"PUT /things/:id" scope:"things:read, things:write"`
+}
+
+var scopeTests = []struct {
+	about        string
+	token        string
+	expectStatus int
+	expectError  *httprequest.RemoteError
+}{{
+	about:        "all scopes granted",
+	token:        "things:read things:write other",
+	expectStatus: http.StatusOK,
+}, {
+	about:        "scope missing",
+	token:        "things:read",
+	expectStatus: http.StatusForbidden,
+	expectError: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: `missing required scope "things:write"`,
+	},
+}, {
+	about:        "no token",
+	expectStatus: http.StatusUnauthorized,
+	expectError: &httprequest.RemoteError{
+		Code:    httprequest.CodeUnauthorized,
+		Message: "no token",
+	},
+}}
+
+func TestServerScopes(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httprequest.Server{
+		Scopes: func(ctx context.Context, req *http.Request) ([]string, error) {
+			token := req.Header.Get("Authorization")
+			if token == "" {
+				return nil, httprequest.Errorf(httprequest.CodeUnauthorized, "no token")
+			}
+			return strings.Fields(token), nil
+		},
+	}
+	router := newHandlerRouter([]httprequest.Handler{
+		srv.Handle(func(p httprequest.Params, _ *scopedReq) (string, error) {
+			return "ok", nil
+		}),
+		srv.Handle(func(p httprequest.Params, _ *struct {
+			httprequest.Route `httprequest:"GET /things/:id"`
+		}) (string, error) {
+			return "unscoped", nil
+		}),
+	})
+	for _, test := range scopeTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("PUT", "/things/1", nil)
+			if test.token != "" {
+				req.Header.Set("Authorization", test.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if test.expectError != nil {
+				qthttptest.AssertJSONResponse(c, rec, test.expectStatus, test.expectError)
+				return
+			}
+			qthttptest.AssertJSONResponse(c, rec, test.expectStatus, "ok")
+		})
+	}
+
+	// Routes without a scope tag are not checked.
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/1", nil))
+	qthttptest.AssertJSONResponse(c, rec, http.StatusOK, "unscoped")
+}
+
+func TestServerScopesNotSet(t *testing.T) {
+	c := qt.New(t)
+
+	var srv httprequest.Server
+	c.Assert(func() {
+		srv.Handle(func(p httprequest.Params, _ *scopedReq) {})
+	}, qt.PanicMatches, `bad handler function: route requires scopes but Server.Scopes is nil`)
+}
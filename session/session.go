@@ -0,0 +1,277 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package session provides session management for handlers created
+// by an httprequest.Server. A Manager loads the session identified by
+// a request cookie or header from a Store and makes it available to
+// handlers through the request context:
+//
+//	mgr := &session.Manager{
+//		Store: session.NewMemoryStore(),
+//	}
+//	srv := &httprequest.Server{
+//		Middleware: []httprequest.Middleware{mgr},
+//	}
+//
+// A handler can then use the session as follows:
+//
+//	s := session.FromContext(p.Context)
+//	s.Set("user", user)
+//	if err := s.Save(p.Context); err != nil {
+//		return errgo.Mask(err)
+//	}
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// ErrNotFound is used as the cause of the error returned by
+// Store.Load when there is no session with the given ID.
+var ErrNotFound = errgo.New("session not found")
+
+// Store is implemented by session storage back ends.
+type Store interface {
+	// Load returns the values stored for the session with the
+	// given ID. It returns an error with an ErrNotFound cause
+	// if there is no such session or it has expired.
+	Load(ctx context.Context, id string) (map[string]string, error)
+
+	// Save stores the values for the session with the given ID,
+	// replacing any that are already stored. The session expires
+	// after the given duration.
+	Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error
+
+	// Delete removes the session with the given ID. It is not an
+	// error if there is no such session.
+	Delete(ctx context.Context, id string) error
+}
+
+const (
+	defaultCookieName = "session"
+	defaultTTL        = 24 * time.Hour
+)
+
+// Manager loads sessions into the context of requests to the handlers
+// it wraps. It implements httprequest.Middleware.
+type Manager struct {
+	// Store holds the store used to load and save sessions.
+	Store Store
+
+	// CookieName holds the name of the cookie that holds the
+	// session ID. If it is empty, "session" is used.
+	CookieName string
+
+	// Header holds the name of a request header that holds the
+	// session ID. If it is not empty, the session ID is taken from
+	// this header instead of a cookie, and the ID of a new session
+	// is returned in the response header of the same name.
+	Header string
+
+	// TTL holds how long sessions last after they are last saved.
+	// If it is zero, 24 hours is used.
+	TTL time.Duration
+
+	// Secure holds whether the session cookie is only to be sent
+	// over HTTPS.
+	Secure bool
+
+	// NewID returns the ID for a new session. If it is nil, a
+	// random 128-bit ID is used.
+	NewID func() (string, error)
+}
+
+var _ httprequest.Middleware = (*Manager)(nil)
+
+// Wrap implements httprequest.Middleware.Wrap by returning a handler
+// that loads the session for the request before calling h. If the
+// request has no session, or its session has expired, the handler is
+// given a new empty session which is stored only when it is saved.
+func (m *Manager) Wrap(srv *httprequest.Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		s, err := m.load(req)
+		if err != nil {
+			srv.WriteError(req.Context(), w, errgo.Notef(err, "cannot load session"))
+			return
+		}
+		s.header = w.Header()
+		h(w, req.WithContext(ContextWithSession(req.Context(), s)), p)
+	}
+}
+
+// load returns the session for the given request.
+func (m *Manager) load(req *http.Request) (*Session, error) {
+	s := &Session{
+		mgr: m,
+	}
+	id := m.requestID(req)
+	if id == "" {
+		return s, nil
+	}
+	values, err := m.Store.Load(req.Context(), id)
+	if errgo.Cause(err) == ErrNotFound {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	s.id = id
+	s.values = values
+	return s, nil
+}
+
+// requestID returns the session ID sent with the request,
+// or the empty string if there is none.
+func (m *Manager) requestID(req *http.Request) string {
+	if m.Header != "" {
+		return req.Header.Get(m.Header)
+	}
+	c, err := req.Cookie(m.cookieName())
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// setID sends the given session ID in the response header h. If
+// maxAge is negative, the client is told to discard the session.
+func (m *Manager) setID(h http.Header, id string, maxAge int) {
+	if m.Header != "" {
+		if maxAge < 0 {
+			h.Del(m.Header)
+		} else {
+			h.Set(m.Header, id)
+		}
+		return
+	}
+	h.Add("Set-Cookie", (&http.Cookie{
+		Name:     m.cookieName(),
+		Value:    id,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   m.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}).String())
+}
+
+func (m *Manager) cookieName() string {
+	if m.CookieName != "" {
+		return m.CookieName
+	}
+	return defaultCookieName
+}
+
+func (m *Manager) ttl() time.Duration {
+	if m.TTL > 0 {
+		return m.TTL
+	}
+	return defaultTTL
+}
+
+func (m *Manager) newID() (string, error) {
+	if m.NewID != nil {
+		return m.NewID()
+	}
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", errgo.Notef(err, "cannot generate session ID")
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+// Session holds the session for a request. A Session is not safe
+// for concurrent use.
+type Session struct {
+	mgr    *Manager
+	header http.Header
+	id     string
+	values map[string]string
+}
+
+// ID returns the ID of the session, or the empty string if
+// the session is new and has not yet been saved.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Get returns the value stored in the session under the given key,
+// or the empty string if there is none.
+func (s *Session) Get(key string) string {
+	return s.values[key]
+}
+
+// Set sets the value stored in the session under the given key.
+// The change is not stored until Save is called.
+func (s *Session) Set(key, value string) {
+	if s.values == nil {
+		s.values = make(map[string]string)
+	}
+	s.values[key] = value
+}
+
+// Delete removes the value stored in the session under the given key.
+// The change is not stored until Save is called.
+func (s *Session) Delete(key string) {
+	delete(s.values, key)
+}
+
+// Save stores the session and sends its ID in the response. Because
+// the ID is sent in a response header, handlers that write the
+// response themselves must call Save before writing it.
+func (s *Session) Save(ctx context.Context) error {
+	if s.id == "" {
+		id, err := s.mgr.newID()
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		s.id = id
+	}
+	ttl := s.mgr.ttl()
+	if err := s.mgr.Store.Save(ctx, s.id, s.values, ttl); err != nil {
+		return errgo.Notef(err, "cannot save session")
+	}
+	s.mgr.setID(s.header, s.id, int(ttl/time.Second))
+	return nil
+}
+
+// Destroy removes the session from the store and tells the client to
+// discard its ID. The session is empty afterwards; if it is saved
+// again, it is given a new ID.
+func (s *Session) Destroy(ctx context.Context) error {
+	if s.id == "" {
+		s.values = nil
+		return nil
+	}
+	if err := s.mgr.Store.Delete(ctx, s.id); err != nil {
+		return errgo.Notef(err, "cannot delete session")
+	}
+	s.mgr.setID(s.header, s.id, -1)
+	s.id = ""
+	s.values = nil
+	return nil
+}
+
+type sessionKey struct{}
+
+// ContextWithSession returns a context that holds the given session.
+func ContextWithSession(ctx context.Context, s *Session) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+// FromContext returns the session held in the given context, or nil
+// if there is none, for example because the handler was not wrapped
+// by a Manager.
+func FromContext(ctx context.Context) *Session {
+	s, _ := ctx.Value(sessionKey{}).(*Session)
+	return s
+}
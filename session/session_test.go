@@ -0,0 +1,209 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package session_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+	"gopkg.in/httprequest.v1/session"
+)
+
+type countReq struct {
+	httprequest.Route `httprequest:"GET /count"`
+}
+
+type logoutReq struct {
+	httprequest.Route `httprequest:"POST /logout"`
+}
+
+type handlers struct{}
+
+func (handlers) Count(p httprequest.Params, req *countReq) (string, error) {
+	s := session.FromContext(p.Context)
+	s.Set("count", s.Get("count")+"x")
+	if err := s.Save(p.Context); err != nil {
+		return "", errgo.Mask(err)
+	}
+	return s.Get("count"), nil
+}
+
+func (handlers) Logout(p httprequest.Params, req *logoutReq) error {
+	return session.FromContext(p.Context).Destroy(p.Context)
+}
+
+func newRouter(mgr *session.Manager) *httprouter.Router {
+	srv := &httprequest.Server{
+		Middleware: []httprequest.Middleware{mgr},
+	}
+	router := httprouter.New()
+	for _, h := range srv.Handlers(func(p httprequest.Params) (handlers, context.Context, error) {
+		return handlers{}, p.Context, nil
+	}) {
+		router.Handle(h.Method, h.Path, h.Handle)
+	}
+	return router
+}
+
+func TestCookieSession(t *testing.T) {
+	c := qt.New(t)
+
+	store := session.NewMemoryStore()
+	router := newRouter(&session.Manager{
+		Store: store,
+		NewID: func() (string, error) {
+			return "id1", nil
+		},
+	})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/count", nil))
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"x"`)
+	cookies := rec.Result().Cookies()
+	c.Assert(cookies, qt.HasLen, 1)
+	c.Assert(cookies[0].Name, qt.Equals, "session")
+	c.Assert(cookies[0].Value, qt.Equals, "id1")
+	c.Assert(cookies[0].HttpOnly, qt.IsTrue)
+	c.Assert(cookies[0].MaxAge, qt.Equals, 24*60*60)
+
+	// The session is loaded on the next request.
+	req := httptest.NewRequest("GET", "/count", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "id1"})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Body.String(), qt.Equals, `"xx"`)
+
+	// After logging out, the session is gone.
+	req = httptest.NewRequest("POST", "/logout", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "id1"})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	cookies = rec.Result().Cookies()
+	c.Assert(cookies, qt.HasLen, 1)
+	c.Assert(cookies[0].MaxAge, qt.Equals, -1)
+	_, err := store.Load(context.Background(), "id1")
+	c.Assert(errgo.Cause(err), qt.Equals, session.ErrNotFound)
+
+	// An unknown session ID results in a new session.
+	req = httptest.NewRequest("GET", "/count", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "id1"})
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Body.String(), qt.Equals, `"x"`)
+}
+
+func TestHeaderSession(t *testing.T) {
+	c := qt.New(t)
+
+	router := newRouter(&session.Manager{
+		Store:  session.NewMemoryStore(),
+		Header: "X-Session",
+	})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/count", nil))
+	id := rec.Header().Get("X-Session")
+	c.Assert(id, qt.HasLen, 32)
+	c.Assert(rec.Result().Cookies(), qt.HasLen, 0)
+
+	req := httptest.NewRequest("GET", "/count", nil)
+	req.Header.Set("X-Session", id)
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Body.String(), qt.Equals, `"xx"`)
+}
+
+type errorStore struct {
+	session.Store
+}
+
+func (errorStore) Load(ctx context.Context, id string) (map[string]string, error) {
+	return nil, errgo.New("store unavailable")
+}
+
+func TestLoadError(t *testing.T) {
+	c := qt.New(t)
+
+	router := newRouter(&session.Manager{
+		Store: errorStore{},
+	})
+	req := httptest.NewRequest("GET", "/count", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: "id1"})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	c.Assert(rec.Body.String(), qt.Contains, "cannot load session: store unavailable")
+}
+
+func TestMemoryStoreExpiry(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	store := &session.MemoryStore{
+		Now: func() time.Time {
+			return now
+		},
+	}
+	ctx := context.Background()
+	err := store.Save(ctx, "a", map[string]string{"k": "v"}, time.Minute)
+	c.Assert(err, qt.IsNil)
+	values, err := store.Load(ctx, "a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(values, qt.DeepEquals, map[string]string{"k": "v"})
+
+	now = now.Add(time.Minute)
+	_, err = store.Load(ctx, "a")
+	c.Assert(errgo.Cause(err), qt.Equals, session.ErrNotFound)
+}
+
+type fakeRedis map[string]string
+
+func (r fakeRedis) Get(ctx context.Context, key string) (string, error) {
+	v, ok := r[key]
+	if !ok {
+		return "", errgo.WithCausef(nil, session.ErrNotFound, "")
+	}
+	return v, nil
+}
+
+func (r fakeRedis) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	r[key] = value
+	return nil
+}
+
+func (r fakeRedis) Del(ctx context.Context, key string) error {
+	delete(r, key)
+	return nil
+}
+
+func TestRedisStore(t *testing.T) {
+	c := qt.New(t)
+
+	r := fakeRedis{}
+	store := &session.RedisStore{
+		Client: r,
+	}
+	ctx := context.Background()
+	err := store.Save(ctx, "a", map[string]string{"k": "v"}, time.Minute)
+	c.Assert(err, qt.IsNil)
+	c.Assert(r, qt.DeepEquals, fakeRedis{"session:a": `{"k":"v"}`})
+
+	values, err := store.Load(ctx, "a")
+	c.Assert(err, qt.IsNil)
+	c.Assert(values, qt.DeepEquals, map[string]string{"k": "v"})
+
+	err = store.Delete(ctx, "a")
+	c.Assert(err, qt.IsNil)
+	_, err = store.Load(ctx, "a")
+	c.Assert(errgo.Cause(err), qt.Equals, session.ErrNotFound)
+}
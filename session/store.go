@@ -0,0 +1,168 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// MemoryStore is a Store that holds sessions in memory. It is suitable
+// for tests and for servers that run as a single process.
+type MemoryStore struct {
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	sessions  map[string]memorySession
+	nextPurge time.Time
+}
+
+type memorySession struct {
+	values  map[string]string
+	expires time.Time
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns a new empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load implements Store.Load.
+func (s *MemoryStore) Load(ctx context.Context, id string) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ms, ok := s.sessions[id]
+	if !ok {
+		return nil, errgo.WithCausef(nil, ErrNotFound, "")
+	}
+	if !s.now().Before(ms.expires) {
+		delete(s.sessions, id)
+		return nil, errgo.WithCausef(nil, ErrNotFound, "")
+	}
+	return copyValues(ms.values), nil
+}
+
+// Save implements Store.Save.
+func (s *MemoryStore) Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.sessions == nil {
+		s.sessions = make(map[string]memorySession)
+	}
+	// Remove expired sessions so that the store does not grow
+	// without bound, at most once a minute so that saving a
+	// session does not take time proportional to the number of
+	// sessions.
+	if now.After(s.nextPurge) {
+		for id, ms := range s.sessions {
+			if !now.Before(ms.expires) {
+				delete(s.sessions, id)
+			}
+		}
+		s.nextPurge = now.Add(time.Minute)
+	}
+	s.sessions[id] = memorySession{
+		values:  copyValues(values),
+		expires: now.Add(ttl),
+	}
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *MemoryStore) Delete(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+	return nil
+}
+
+func (s *MemoryStore) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func copyValues(values map[string]string) map[string]string {
+	values1 := make(map[string]string, len(values))
+	for k, v := range values {
+		values1[k] = v
+	}
+	return values1
+}
+
+// RedisClient holds the subset of Redis operations used by RedisStore.
+// It is typically implemented by a small adapter around the Redis
+// client library in use.
+type RedisClient interface {
+	// Get returns the value of the given key. It returns an error
+	// with an ErrNotFound cause if the key does not exist.
+	Get(ctx context.Context, key string) (string, error)
+
+	// Set sets the value of the given key with the given
+	// expiry time.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+
+	// Del removes the given key.
+	Del(ctx context.Context, key string) error
+}
+
+// RedisStore is a Store that holds sessions in Redis, so that they can
+// be shared between server processes. The values of each session are
+// stored as a JSON object.
+type RedisStore struct {
+	// Client holds the Redis client to use.
+	Client RedisClient
+
+	// Prefix holds a prefix added to session IDs to make the Redis
+	// keys. If it is empty, "session:" is used.
+	Prefix string
+}
+
+var _ Store = (*RedisStore)(nil)
+
+// Load implements Store.Load.
+func (s *RedisStore) Load(ctx context.Context, id string) (map[string]string, error) {
+	data, err := s.Client.Get(ctx, s.key(id))
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrNotFound))
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(data), &values); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal session")
+	}
+	return values, nil
+}
+
+// Save implements Store.Save.
+func (s *RedisStore) Save(ctx context.Context, id string, values map[string]string, ttl time.Duration) error {
+	if values == nil {
+		values = map[string]string{}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	return errgo.Mask(s.Client.Set(ctx, s.key(id), string(data), ttl))
+}
+
+// Delete implements Store.Delete.
+func (s *RedisStore) Delete(ctx context.Context, id string) error {
+	return errgo.Mask(s.Client.Del(ctx, s.key(id)))
+}
+
+func (s *RedisStore) key(id string) string {
+	if s.Prefix != "" {
+		return s.Prefix + id
+	}
+	return "session:" + id
+}
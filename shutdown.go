@@ -0,0 +1,123 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// Shutdown gracefully shuts down the handlers created by srv. Requests
+// that arrive after Shutdown has been called are rejected with a 503
+// Service Unavailable status, and Shutdown waits for the requests
+// that are already being handled (including handlers that stream
+// their responses) to complete.
+//
+// If ctx is done before the requests complete, the contexts of the
+// remaining requests are canceled and Shutdown returns an error with
+// the context's error as its cause.
+//
+// Shutdown does not close any listeners or connections; it should
+// usually be called before http.Server.Shutdown.
+func (srv *Server) Shutdown(ctx context.Context) error {
+	st := srv.shutdownState()
+	st.mu.Lock()
+	st.closing = true
+	if st.idle == nil {
+		st.idle = make(chan struct{})
+		if len(st.active) == 0 {
+			close(st.idle)
+		}
+	}
+	idle := st.idle
+	st.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for _, cancel := range st.active {
+		cancel()
+	}
+	return errgo.NoteMask(ctx.Err(), "requests still in progress", errgo.Any)
+}
+
+// shutdownMu guards the creation of Server.shutdown. A mutex cannot
+// be held in Server itself because Server values are commonly
+// copied.
+var shutdownMu sync.Mutex
+
+// shutdownState returns the state used to track the requests
+// handled by srv, creating it if necessary.
+func (srv *Server) shutdownState() *shutdownState {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if srv.shutdown == nil {
+		srv.shutdown = new(shutdownState)
+	}
+	return srv.shutdown
+}
+
+// shutdownState tracks the requests in progress for Shutdown.
+type shutdownState struct {
+	mu      sync.Mutex
+	closing bool
+	active  map[uint64]context.CancelFunc
+	nextID  uint64
+
+	// idle is closed when closing is true and there
+	// are no active requests.
+	idle chan struct{}
+}
+
+// wrapShutdown returns a handler that tracks requests to h
+// so that Shutdown can wait for them.
+func (srv *Server) wrapShutdown(h httprouter.Handle) httprouter.Handle {
+	st := srv.shutdownState()
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+		id, ok := st.start(cancel)
+		if !ok {
+			w.Header().Set("Connection", "close")
+			srv.WriteError(ctx, w, Errorf(CodeServiceUnavailable, "server is shutting down"))
+			return
+		}
+		defer st.end(id)
+		h(w, req.WithContext(ctx), p)
+	}
+}
+
+// start records the start of a request with the given cancel
+// function and returns an identifier for it. It reports false if the
+// server is shutting down.
+func (st *shutdownState) start(cancel context.CancelFunc) (uint64, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closing {
+		return 0, false
+	}
+	if st.active == nil {
+		st.active = make(map[uint64]context.CancelFunc)
+	}
+	st.nextID++
+	st.active[st.nextID] = cancel
+	return st.nextID, true
+}
+
+// end records the end of the request with the given identifier.
+func (st *shutdownState) end(id uint64) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.active, id)
+	if st.closing && len(st.active) == 0 {
+		close(st.idle)
+	}
+}
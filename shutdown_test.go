@@ -0,0 +1,119 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/qthttptest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type shutdownReq struct {
+	httprequest.Route `httprequest:"GET /wait"`
+	Block             bool `httprequest:"block,form"`
+}
+
+func TestServerShutdown(t *testing.T) {
+	c := qt.New(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var srv httprequest.Server
+	h := srv.Handle(func(p httprequest.Params, req *shutdownReq) (string, error) {
+		if req.Block {
+			close(started)
+			<-release
+		}
+		return "done", nil
+	})
+
+	// Start a request that blocks until released.
+	rec := httptest.NewRecorder()
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		h.Handle(rec, httptest.NewRequest("GET", "/wait?block=1", nil), nil)
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- srv.Shutdown(context.Background())
+	}()
+
+	// Wait until the shutdown is in progress, when new
+	// requests are rejected.
+	var rec1 *httptest.ResponseRecorder
+	for a := 0; ; a++ {
+		c.Assert(a < 1000, qt.Equals, true, qt.Commentf("server did not start shutting down"))
+		rec1 = httptest.NewRecorder()
+		h.Handle(rec1, httptest.NewRequest("GET", "/wait", nil), nil)
+		if rec1.Code == http.StatusServiceUnavailable {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	qthttptest.AssertJSONResponse(c, rec1, http.StatusServiceUnavailable, &httprequest.RemoteError{
+		Code:    httprequest.CodeServiceUnavailable,
+		Message: "server is shutting down",
+	})
+
+	select {
+	case err := <-shutdownErr:
+		c.Fatalf("shutdown returned early with error %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	c.Assert(<-shutdownErr, qt.Equals, nil)
+	<-handled
+	qthttptest.AssertJSONResponse(c, rec, http.StatusOK, "done")
+}
+
+func TestServerShutdownTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	started := make(chan struct{})
+	var srv httprequest.Server
+	h := srv.Handle(func(p httprequest.Params, _ *shutdownReq) error {
+		close(started)
+		<-p.Context.Done()
+		return p.Context.Err()
+	})
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		h.Handle(httptest.NewRecorder(), httptest.NewRequest("GET", "/wait", nil), nil)
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	c.Assert(err, qt.ErrorMatches, `requests still in progress: context deadline exceeded`)
+	c.Assert(errgo.Cause(err), qt.Equals, context.DeadlineExceeded)
+
+	// The handler's context has been canceled.
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("handler context not canceled")
+	}
+}
+
+func TestServerShutdownIdle(t *testing.T) {
+	c := qt.New(t)
+
+	var srv httprequest.Server
+	c.Assert(srv.Shutdown(context.Background()), qt.Equals, nil)
+	// Shutdown may be called more than once.
+	c.Assert(srv.Shutdown(context.Background()), qt.Equals, nil)
+}
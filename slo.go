@@ -0,0 +1,216 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SLOTracker keeps rolling counts of the successful and failed calls
+// made by a Client to each endpoint, so that the success rate of a
+// dependency can be monitored against a service level objective.
+//
+// An endpoint is identified by the route of the call, in the form
+// "METHOD /path/pattern", when the call is made with Client.Call and
+// friends, or by the method and URL path otherwise.
+//
+// An SLOTracker is enabled by setting the Client.SLOTracker field.
+// The same SLOTracker may be used by several clients.
+type SLOTracker struct {
+	// Windows holds the durations over which success rates are
+	// computed. If it is empty, 5 minutes and 1 hour are used.
+	Windows []time.Duration
+
+	// Resolution holds the granularity of the rolling counts.
+	// Calls are counted in intervals of this length, so the
+	// windows are accurate to within one interval. If it is
+	// zero, 10 seconds is used.
+	Resolution time.Duration
+
+	// IsSuccess reports whether a call was successful. It is
+	// called with the HTTP response (or nil if no response was
+	// received) and any error from sending the request. If it is
+	// nil, calls are counted as successful if a response was
+	// received with a status other than 5xx.
+	IsSuccess func(resp *http.Response, err error) bool
+
+	// Observe, if non-nil, is called after each call is counted.
+	// It can be used to export metrics.
+	Observe func(endpoint string, success bool)
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	endpoints map[string]*sloCounter
+}
+
+// SLOWindow holds the counts of calls to an endpoint over
+// a window of time.
+type SLOWindow struct {
+	// Window holds the duration of the window.
+	Window time.Duration
+
+	// Total holds the total number of calls.
+	Total int64
+
+	// Success holds the number of successful calls.
+	Success int64
+}
+
+// SuccessRate returns the fraction of calls in the window that
+// were successful. It returns 1 if there were no calls.
+func (w SLOWindow) SuccessRate() float64 {
+	if w.Total == 0 {
+		return 1
+	}
+	return float64(w.Success) / float64(w.Total)
+}
+
+// Stats returns the counts for each of the configured windows for
+// the given endpoint, in the order of t.Windows.
+func (t *SLOTracker) Stats(endpoint string) []SLOWindow {
+	windows, _ := t.params()
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := make([]SLOWindow, len(windows))
+	c := t.endpoints[endpoint]
+	for i, w := range windows {
+		stats[i].Window = w
+		if c != nil {
+			stats[i].Total, stats[i].Success = c.count(now, w)
+		}
+	}
+	return stats
+}
+
+// Endpoints returns all the endpoints that calls have been
+// recorded for, in alphabetical order.
+func (t *SLOTracker) Endpoints() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	endpoints := make([]string, 0, len(t.endpoints))
+	for e := range t.endpoints {
+		endpoints = append(endpoints, e)
+	}
+	sort.Strings(endpoints)
+	return endpoints
+}
+
+// record records the outcome of a call to the given endpoint.
+func (t *SLOTracker) record(endpoint string, resp *http.Response, err error) {
+	var success bool
+	if t.IsSuccess != nil {
+		success = t.IsSuccess(resp, err)
+	} else {
+		success = err == nil && resp != nil && resp.StatusCode < 500
+	}
+	windows, resolution := t.params()
+	now := t.now()
+	t.mu.Lock()
+	if t.endpoints == nil {
+		t.endpoints = make(map[string]*sloCounter)
+	}
+	c := t.endpoints[endpoint]
+	if c == nil {
+		c = newSLOCounter(windows, resolution)
+		t.endpoints[endpoint] = c
+	}
+	c.add(now, success)
+	t.mu.Unlock()
+	if t.Observe != nil {
+		t.Observe(endpoint, success)
+	}
+}
+
+func (t *SLOTracker) params() (windows []time.Duration, resolution time.Duration) {
+	windows = t.Windows
+	if len(windows) == 0 {
+		windows = []time.Duration{5 * time.Minute, time.Hour}
+	}
+	resolution = t.Resolution
+	if resolution <= 0 {
+		resolution = 10 * time.Second
+	}
+	return windows, resolution
+}
+
+func (t *SLOTracker) now() time.Time {
+	return nowFunc(t.Now)()
+}
+
+// sloCounter holds rolling counts of calls in a ring of
+// fixed-length intervals.
+type sloCounter struct {
+	resolution time.Duration
+	intervals  []sloInterval
+}
+
+type sloInterval struct {
+	// n holds the number of the interval since the
+	// epoch, used to detect stale entries in the ring.
+	n       int64
+	total   int64
+	success int64
+}
+
+func newSLOCounter(windows []time.Duration, resolution time.Duration) *sloCounter {
+	var max time.Duration
+	for _, w := range windows {
+		if w > max {
+			max = w
+		}
+	}
+	return &sloCounter{
+		resolution: resolution,
+		intervals:  make([]sloInterval, max/resolution+1),
+	}
+}
+
+func (c *sloCounter) add(now time.Time, success bool) {
+	n := now.UnixNano() / int64(c.resolution)
+	iv := &c.intervals[n%int64(len(c.intervals))]
+	if iv.n != n {
+		*iv = sloInterval{n: n}
+	}
+	iv.total++
+	if success {
+		iv.success++
+	}
+}
+
+// count returns the counts for the given window ending now.
+func (c *sloCounter) count(now time.Time, window time.Duration) (total, success int64) {
+	n := now.UnixNano() / int64(c.resolution)
+	first := n - int64(window/c.resolution)
+	for _, iv := range c.intervals {
+		if iv.n > first && iv.n <= n {
+			total += iv.total
+			success += iv.success
+		}
+	}
+	return total, success
+}
+
+// endpointKey is the context key used to pass the
+// route of a call from Client.Call to Client.Do.
+type endpointKey struct{}
+
+func contextWithEndpoint(ctx context.Context, endpoint string) context.Context {
+	return context.WithValue(ctx, endpointKey{}, endpoint)
+}
+
+// requestEndpoint returns the endpoint of the given request
+// as recorded by an SLOTracker.
+func requestEndpoint(ctx context.Context, req *http.Request) string {
+	if endpoint, ok := ctx.Value(endpointKey{}).(string); ok {
+		return endpoint
+	}
+	return req.Method + " " + req.URL.Path
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type sloReq struct {
+	httprequest.Route `httprequest:"GET /status/:code"`
+	Code              int `httprequest:"code,path"`
+}
+
+func TestSLOTracker(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/status/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/status/404":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	type observation struct {
+		Endpoint string
+		Success  bool
+	}
+	var observed []observation
+	tracker := &httprequest.SLOTracker{
+		Windows:    []time.Duration{time.Minute, 10 * time.Minute},
+		Resolution: 10 * time.Second,
+		Observe: func(endpoint string, success bool) {
+			observed = append(observed, observation{endpoint, success})
+		},
+		Now: func() time.Time {
+			return now
+		},
+	}
+	client := httprequest.Client{
+		BaseURL:    srv.URL,
+		SLOTracker: tracker,
+	}
+	call := func(code int) {
+		client.Call(context.Background(), &sloReq{Code: code}, nil)
+	}
+	call(200)
+	call(404)
+	call(500)
+	c.Assert(observed, qt.DeepEquals, []observation{
+		{"GET /status/:code", true},
+		{"GET /status/:code", true},
+		{"GET /status/:code", false},
+	})
+
+	now = now.Add(5 * time.Minute)
+	call(500)
+	call(200)
+
+	// Calls made with Do are identified by their URL path.
+	req, err := http.NewRequest("GET", "/other", nil)
+	c.Assert(err, qt.Equals, nil)
+	client.Do(context.Background(), req, nil)
+
+	c.Assert(tracker.Endpoints(), qt.DeepEquals, []string{"GET /other", "GET /status/:code"})
+	stats := tracker.Stats("GET /status/:code")
+	c.Assert(stats, qt.DeepEquals, []httprequest.SLOWindow{{
+		Window:  time.Minute,
+		Total:   2,
+		Success: 1,
+	}, {
+		Window:  10 * time.Minute,
+		Total:   5,
+		Success: 3,
+	}})
+	c.Assert(stats[1].SuccessRate(), qt.Equals, 0.6)
+
+	// Old calls drop out of the windows.
+	now = now.Add(6 * time.Minute)
+	stats = tracker.Stats("GET /status/:code")
+	c.Assert(stats, qt.DeepEquals, []httprequest.SLOWindow{{
+		Window: time.Minute,
+	}, {
+		Window:  10 * time.Minute,
+		Total:   2,
+		Success: 1,
+	}})
+	c.Assert(stats[0].SuccessRate(), qt.Equals, 1.0)
+}
+
+func TestSLOTrackerTransportError(t *testing.T) {
+	c := qt.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+
+	tracker := &httprequest.SLOTracker{}
+	client := httprequest.Client{
+		BaseURL:    url,
+		SLOTracker: tracker,
+	}
+	err := client.Call(context.Background(), &sloReq{Code: 200}, nil)
+	c.Assert(err, qt.Not(qt.IsNil))
+	stats := tracker.Stats("GET /status/:code")
+	c.Assert(stats, qt.HasLen, 2)
+	c.Assert(stats[0].Total, qt.Equals, int64(1))
+	c.Assert(stats[0].Success, qt.Equals, int64(0))
+}
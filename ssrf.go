@@ -0,0 +1,178 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrDisallowedDestination is the cause of the error returned when
+// an SSRFGuard rejects a request.
+var ErrDisallowedDestination = errgo.New("disallowed destination")
+
+// DefaultSSRFDeny holds the networks denied by an SSRFGuard
+// with a nil Deny field: loopback, private, link-local, shared,
+// multicast and other special-purpose address ranges.
+var DefaultSSRFDeny = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+// SSRFGuard protects against server-side request forgery when a
+// Client makes requests to URLs that may be controlled by an untrusted
+// party, such as webhook endpoints. It restricts the URL schemes that
+// may be used and the IP addresses that may be connected to.
+//
+// The address check is made when each connection is dialed, after
+// DNS resolution, so it cannot be bypassed by a host name that
+// resolves to a different address when the request is made (DNS
+// rebinding) or by redirects.
+//
+// An SSRFGuard is enabled for a client by setting the
+// Client.SSRFGuard field.
+type SSRFGuard struct {
+	// AllowedSchemes holds the URL schemes that may be used.
+	// If it is empty, only "http" and "https" are allowed.
+	AllowedSchemes []string
+
+	// Deny holds the networks that may not be connected to.
+	// If it is nil, DefaultSSRFDeny is used.
+	Deny []*net.IPNet
+
+	// Allow holds networks that may be connected to even
+	// though they are in Deny.
+	Allow []*net.IPNet
+
+	// Dialer holds the dialer used to make connections.
+	// If it is nil, a dialer with a 30 second timeout is used.
+	// Its Control field is overridden.
+	Dialer *net.Dialer
+
+	initOnce sync.Once
+	client   *http.Client
+}
+
+// CheckURL checks that the given URL uses an allowed scheme and, if
+// its host is an IP address, that the address is allowed. Host names
+// are checked when the connection is dialed.
+func (g *SSRFGuard) CheckURL(u *url.URL) error {
+	if !g.schemeAllowed(u.Scheme) {
+		return errgo.WithCausef(nil, ErrDisallowedDestination, "URL scheme %q not allowed", u.Scheme)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := g.checkIP(ip); err != nil {
+			return errgo.Mask(err, errgo.Is(ErrDisallowedDestination))
+		}
+	}
+	return nil
+}
+
+// DialContext dials the given address, failing if the address it
+// connects to is not allowed. It is suitable for use as the
+// DialContext field of an http.Transport.
+func (g *SSRFGuard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout: 30 * time.Second,
+	}
+	if g.Dialer != nil {
+		d = *g.Dialer
+	}
+	d.Control = g.control
+	return d.DialContext(ctx, network, addr)
+}
+
+// Transport returns an HTTP transport that uses g.DialContext to make
+// connections. It can be used to protect a Client that has its own
+// Doer. The transport does not use a proxy because connections to the
+// proxy would bypass the address check.
+func (g *SSRFGuard) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = nil
+	t.DialContext = g.DialContext
+	return t
+}
+
+// httpClient returns the HTTP client used by a Client with g
+// set and no Doer.
+func (g *SSRFGuard) httpClient() *http.Client {
+	g.initOnce.Do(func() {
+		g.client = &http.Client{
+			Transport: g.Transport(),
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) >= 10 {
+					return errgo.New("stopped after 10 redirects")
+				}
+				return g.CheckURL(req.URL)
+			},
+		}
+	})
+	return g.client
+}
+
+// control is used as the Control function of the dialer
+// to check the address actually being connected to.
+func (g *SSRFGuard) control(network, address string, _ syscall.RawConn) error {
+	ip := parseHostIP(address)
+	if ip == nil {
+		return errgo.WithCausef(nil, ErrDisallowedDestination, "cannot determine IP address of %q", address)
+	}
+	return g.checkIP(ip)
+}
+
+func (g *SSRFGuard) checkIP(ip net.IP) error {
+	if containsIP(g.Allow, ip) {
+		return nil
+	}
+	deny := g.Deny
+	if deny == nil {
+		deny = DefaultSSRFDeny
+	}
+	if containsIP(deny, ip) {
+		return errgo.WithCausef(nil, ErrDisallowedDestination, "address %s not allowed", ip)
+	}
+	return nil
+}
+
+func (g *SSRFGuard) schemeAllowed(scheme string) bool {
+	if len(g.AllowedSchemes) == 0 {
+		return scheme == "http" || scheme == "https"
+	}
+	for _, s := range g.AllowedSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDRs(ss ...string) []*net.IPNet {
+	nets, err := ParseCIDRs(ss...)
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
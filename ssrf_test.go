@@ -0,0 +1,96 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+func TestSSRFGuardDeniesLoopback(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newServer()
+	defer srv.Close()
+
+	client := httprequest.Client{
+		BaseURL:   srv.URL,
+		SSRFGuard: &httprequest.SSRFGuard{},
+	}
+	// The server URL holds a literal loopback address, so
+	// the URL check rejects it before dialing.
+	err := client.Call(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrDisallowedDestination)
+	c.Assert(err, qt.ErrorMatches, `address 127.0.0.1 not allowed`)
+
+	// A host name that resolves to a loopback address is
+	// rejected when dialing.
+	client.BaseURL = strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	err = client.Call(context.Background(), &chM1Req{
+		P: "hello",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Get "?http://localhost:[0-9]+/m1/hello"?: .*address (127.0.0.1|::1) not allowed`)
+}
+
+func TestSSRFGuardAllow(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newServer()
+	defer srv.Close()
+
+	allow, err := httprequest.ParseCIDRs("127.0.0.0/8", "::1")
+	c.Assert(err, qt.Equals, nil)
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		SSRFGuard: &httprequest.SSRFGuard{
+			Allow: allow,
+		},
+	}
+	var resp chM1Resp
+	err = client.Call(context.Background(), &chM1Req{
+		P: "hello",
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.P, qt.Equals, "hello")
+}
+
+func TestSSRFGuardScheme(t *testing.T) {
+	c := qt.New(t)
+
+	client := httprequest.Client{
+		SSRFGuard: &httprequest.SSRFGuard{},
+	}
+	err := client.Get(context.Background(), "ftp://example.com/file", nil)
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrDisallowedDestination)
+	c.Assert(err, qt.ErrorMatches, `URL scheme "ftp" not allowed`)
+}
+
+func TestSSRFGuardRedirect(t *testing.T) {
+	c := qt.New(t)
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "http://10.0.0.1/", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	allow, err := httprequest.ParseCIDRs("127.0.0.1")
+	c.Assert(err, qt.Equals, nil)
+	client := httprequest.Client{
+		SSRFGuard: &httprequest.SSRFGuard{
+			Allow: allow,
+		},
+	}
+	err = client.Get(context.Background(), redirector.URL, nil)
+	c.Assert(err, qt.ErrorMatches, `Get .*http://10.0.0.1/.*: address 10.0.0.1 not allowed`)
+}
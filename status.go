@@ -0,0 +1,38 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+
+	"gopkg.in/errgo.v1"
+)
+
+// StatusResponse can be returned as the result of a handler (see
+// Server.Handle) to respond with a status other than the usual one
+// for the route, for example 202 Accepted when a request has been
+// queued rather than completed. Value is written as the body as for
+// any other result. If Value is nil or Code is http.StatusNoContent,
+// the response has no body.
+type StatusResponse struct {
+	// Code holds the HTTP status of the response. If it is
+	// zero, the usual status for the route is used.
+	Code int
+
+	// Value holds the response body.
+	Value interface{}
+}
+
+// write writes r as a response to req. The given code
+// is used if r.Code is zero.
+func (r *StatusResponse) write(srv *Server, w http.ResponseWriter, req *http.Request, code int, bufferSize int) error {
+	if r.Code != 0 {
+		code = r.Code
+	}
+	if r.Value == nil {
+		w.WriteHeader(code)
+		return nil
+	}
+	return errgo.Mask(srv.writeResultBody(w, req, code, r.Value, bufferSize), errgo.Any)
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type statusResult struct {
+	ETag string `httprequest:"ETag,header" json:"-"`
+	Name string
+}
+
+var statusTests = []struct {
+	about        string
+	handler      interface{}
+	expectStatus int
+	expectHeader string
+	expectBody   string
+	expectPanic  string
+}{{
+	about: "status tag with result",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs" status:"202"`
+	}) (statusResult, error) {
+		return statusResult{Name: "job"}, nil
+	},
+	expectStatus: http.StatusAccepted,
+	expectBody:   `{"Name":"job"}`,
+}, {
+	about: "status tag without result",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs" status:"202"`
+	}) error {
+		return nil
+	},
+	expectStatus: http.StatusAccepted,
+}, {
+	about: "no content with result headers",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"PUT /jobs" status:"204"`
+	}) (statusResult, error) {
+		return statusResult{ETag: `"x"`, Name: "ignored"}, nil
+	},
+	expectStatus: http.StatusNoContent,
+	expectHeader: `"x"`,
+}, {
+	about: "status tag with error",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs" status:"202"`
+	}) error {
+		return httprequest.Errorf(httprequest.CodeBadRequest, "no")
+	},
+	expectStatus: http.StatusBadRequest,
+	expectBody:   `{"Message":"no","Code":"bad request"}`,
+}, {
+	about: "status response",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs"`
+	}) (*httprequest.StatusResponse, error) {
+		return &httprequest.StatusResponse{
+			Code:  http.StatusAccepted,
+			Value: statusResult{ETag: `"y"`, Name: "queued"},
+		}, nil
+	},
+	expectStatus: http.StatusAccepted,
+	expectHeader: `"y"`,
+	expectBody:   `{"Name":"queued"}`,
+}, {
+	about: "status response with route status",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs" status:"202"`
+	}) (httprequest.StatusResponse, error) {
+		return httprequest.StatusResponse{}, nil
+	},
+	expectStatus: http.StatusAccepted,
+}, {
+	about: "bad status tag",
+	handler: func(*struct {
+		httprequest.Route `httprequest:"POST /jobs" status:"404"`
+	}) error {
+		return nil
+	},
+	expectPanic: `bad handler function: last argument cannot be used for Unmarshal: bad route tag .*: bad status tag "404"`,
+}}
+
+func TestRouteStatus(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range statusTests {
+		c.Run(test.about, func(c *qt.C) {
+			srv := &httprequest.Server{}
+			if test.expectPanic != "" {
+				c.Assert(func() {
+					srv.Handle(test.handler)
+				}, qt.PanicMatches, test.expectPanic)
+				return
+			}
+			h := srv.Handle(test.handler)
+			router := newHandlerRouter([]httprequest.Handler{h})
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(h.Method, h.Path, nil))
+			c.Assert(rec.Code, qt.Equals, test.expectStatus)
+			c.Assert(rec.Header().Get("ETag"), qt.Equals, test.expectHeader)
+			c.Assert(rec.Body.String(), qt.Equals, test.expectBody)
+		})
+	}
+}
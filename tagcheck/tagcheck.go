@@ -0,0 +1,241 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package tagcheck defines an analyzer that checks httprequest
+// parameter structs for mistakes that would otherwise only be found at
+// run time, when the struct is first used by Unmarshal, Marshal or
+// Server.Handle, or not at all.
+package tagcheck
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+
+	"gopkg.in/httprequest.v1/tags"
+)
+
+const httprequestPath = "gopkg.in/httprequest.v1"
+
+const doc = `check httprequest parameter structs
+
+The httprequesttags analyzer reports struct types that embed
+httprequest.Route or have httprequest tags and that contain:
+
+- tags that httprequest cannot parse;
+- path fields whose names are not in the route path;
+- more than one form field with the same name;
+- a body field in a GET route;
+- unexported fields with httprequest tags, which are ignored;
+- fields whose types cannot be used with their tags.`
+
+// Analyzer checks httprequest parameter structs.
+var Analyzer = &analysis.Analyzer{
+	Name: "httprequesttags",
+	Doc:  doc,
+	Run:  run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, f := range pass.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			if st, ok := n.(*ast.StructType); ok {
+				checkStruct(pass, st)
+			}
+			return true
+		})
+	}
+	return nil, nil
+}
+
+// paramField holds a field of a parameter struct.
+type paramField struct {
+	name     string
+	tag      reflect.StructTag
+	typ      types.Type
+	exported bool
+	embedded bool
+	pos      token.Pos
+}
+
+func checkStruct(pass *analysis.Pass, st *ast.StructType) {
+	t, ok := pass.TypesInfo.Types[st].Type.(*types.Struct)
+	if !ok {
+		return
+	}
+	var route *tags.Route
+	var fields []paramField
+	isParams := false
+	for i := 0; i < t.NumFields(); i++ {
+		v := t.Field(i)
+		tag := reflect.StructTag(t.Tag(i))
+		if v.Embedded() && isRoute(v.Type()) {
+			isParams = true
+			r, err := tags.ParseRoute(tag)
+			if err != nil {
+				pass.Reportf(v.Pos(), "bad route tag %q: %v", tag, err)
+				continue
+			}
+			route = &r
+			continue
+		}
+		if _, ok := tag.Lookup("httprequest"); ok {
+			isParams = true
+		}
+		fields = appendFields(fields, v, tag, v.Pos(), map[types.Type]bool{})
+	}
+	if !isParams {
+		return
+	}
+	var pathParams map[string]bool
+	if route != nil && route.Path != "" {
+		pathParams = make(map[string]bool)
+		for _, seg := range strings.Split(route.Path, "/") {
+			if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+				pathParams[seg[1:]] = true
+			}
+		}
+	}
+	formNames := make(map[string]string)
+	for _, f := range fields {
+		if _, ok := f.tag.Lookup("httprequest"); !ok {
+			continue
+		}
+		if !f.exported && !f.embedded {
+			pass.Reportf(f.pos, "unexported field %s has httprequest tag and will be ignored", f.name)
+			continue
+		}
+		tag, err := tags.Parse(f.tag, f.name)
+		if err != nil {
+			pass.Reportf(f.pos, "bad httprequest tag on field %s: %v", f.name, err)
+			continue
+		}
+		switch tag.Source {
+		case tags.SourcePath:
+			if pathParams != nil && !pathParams[tag.Name] {
+				pass.Reportf(f.pos, "path field %s: parameter %q not found in route path %q", f.name, tag.Name, route.Path)
+			}
+		case tags.SourceForm, tags.SourceFormBody:
+			if tag.Map {
+				break
+			}
+			if other, ok := formNames[tag.Name]; ok {
+				pass.Reportf(f.pos, "form field %s: parameter %q is also used by field %s", f.name, tag.Name, other)
+			} else {
+				formNames[tag.Name] = f.name
+			}
+		case tags.SourceBody:
+			if route != nil && route.Method == "GET" {
+				pass.Reportf(f.pos, "body field %s in GET route", f.name)
+			}
+		}
+		if msg := checkType(tag, f.typ); msg != "" {
+			pass.Reportf(f.pos, "field %s: %s", f.name, msg)
+		}
+	}
+}
+
+// appendFields appends v to fields, along with the fields of any
+// untagged embedded struct, as Unmarshal does. The pos argument holds
+// the position to report for fields found inside embedded structs.
+func appendFields(fields []paramField, v *types.Var, tag reflect.StructTag, pos token.Pos, seen map[types.Type]bool) []paramField {
+	fields = append(fields, paramField{
+		name:     v.Name(),
+		tag:      tag,
+		typ:      v.Type(),
+		exported: v.Exported(),
+		embedded: v.Embedded(),
+		pos:      pos,
+	})
+	if !v.Embedded() || tag.Get("httprequest") != "" {
+		return fields
+	}
+	t := v.Type()
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	st, ok := t.Underlying().(*types.Struct)
+	if !ok || seen[t] {
+		return fields
+	}
+	seen[t] = true
+	for i := 0; i < st.NumFields(); i++ {
+		fields = appendFields(fields, st.Field(i), reflect.StructTag(st.Tag(i)), pos, seen)
+	}
+	return fields
+}
+
+// checkType returns a description of the problem if a field of
+// type t cannot be used with the given tag, or the empty string
+// if it can.
+func checkType(tag tags.Tag, t types.Type) string {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	switch {
+	case tag.Source == tags.SourceNone || tag.Source == tags.SourceBody:
+		return ""
+	case tag.Source == tags.SourceClientIP:
+		if !isBasic(t, types.IsString) && !isNamed(t, "net", "IP") {
+			return "client IP must be of type string or net.IP, not " + t.String()
+		}
+	case tag.APIKey:
+		if !isBasic(t, types.IsString) {
+			return "API key must be of type string, not " + t.String()
+		}
+	case tag.Map:
+		m, ok := t.Underlying().(*types.Map)
+		if !ok || !isBasic(m.Key(), types.IsString) || !(isBasic(m.Elem(), types.IsString) || isStringSlice(m.Elem())) {
+			return "form map must be a map from string to string or []string, not " + t.String()
+		}
+	case tag.Format != "":
+		if !isNamed(t, "time", "Time") {
+			return "format tag used on non-time type " + t.String()
+		}
+	case isStringSlice(t):
+		if tag.Source == tags.SourcePath || tag.Source == tags.SourceCookie {
+			return tag.Source.String() + " parameter cannot be of type " + t.String()
+		}
+	case isBasic(t, types.IsBoolean|types.IsNumeric|types.IsString):
+	case implements(t, "UnmarshalText"), implements(t, "Scan"):
+	default:
+		return "unsupported type " + t.String() + " for " + tag.Source.String() + " parameter"
+	}
+	return ""
+}
+
+// isRoute reports whether t is httprequest.Route.
+func isRoute(t types.Type) bool {
+	return isNamed(t, httprequestPath, "Route")
+}
+
+func isNamed(t types.Type, pkgPath, name string) bool {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == pkgPath && obj.Name() == name
+}
+
+func isBasic(t types.Type, info types.BasicInfo) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&info != 0
+}
+
+func isStringSlice(t types.Type) bool {
+	s, ok := t.Underlying().(*types.Slice)
+	return ok && isBasic(s.Elem(), types.IsString)
+}
+
+// implements reports whether t or a pointer to t
+// has a method with the given name.
+func implements(t types.Type, method string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(t), true, nil, method)
+	_, ok := obj.(*types.Func)
+	return ok
+}
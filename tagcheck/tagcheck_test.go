@@ -0,0 +1,123 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tagcheck_test
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/tools/go/analysis"
+
+	"gopkg.in/httprequest.v1/tagcheck"
+)
+
+// TestAnalyzer runs the analyzer on the package in testdata/src/a and
+// checks that it reports exactly the diagnostics described by the
+// "// want" comments in it, in the style of analysistest.
+func TestAnalyzer(t *testing.T) {
+	c := qt.New(t)
+
+	fset := token.NewFileSet()
+	imp := &testImporter{
+		fset:    fset,
+		std:     importer.Default(),
+		srcRoot: filepath.Join("testdata", "src"),
+	}
+	pkg, files, info, err := imp.check("a")
+	c.Assert(err, qt.IsNil)
+
+	type diag struct {
+		line int
+		msg  string
+	}
+	var got []diag
+	pass := &analysis.Pass{
+		Analyzer:  tagcheck.Analyzer,
+		Fset:      fset,
+		Files:     files,
+		Pkg:       pkg,
+		TypesInfo: info,
+		Report: func(d analysis.Diagnostic) {
+			got = append(got, diag{fset.Position(d.Pos).Line, d.Message})
+		},
+	}
+	_, err = tagcheck.Analyzer.Run(pass)
+	c.Assert(err, qt.IsNil)
+
+	want := make(map[int]*regexp.Regexp)
+	for _, f := range files {
+		for _, cg := range f.Comments {
+			for _, cm := range cg.List {
+				text := strings.TrimPrefix(cm.Text, "//")
+				text = strings.TrimSpace(text)
+				if !strings.HasPrefix(text, "want `") {
+					continue
+				}
+				pattern := strings.TrimSuffix(strings.TrimPrefix(text, "want `"), "`")
+				want[fset.Position(cm.Pos()).Line] = regexp.MustCompile(pattern)
+			}
+		}
+	}
+	for _, d := range got {
+		re, ok := want[d.line]
+		if !ok {
+			c.Errorf("line %d: unexpected diagnostic %q", d.line, d.msg)
+			continue
+		}
+		c.Check(d.msg, qt.Matches, re.String(), qt.Commentf("line %d", d.line))
+		delete(want, d.line)
+	}
+	for line, re := range want {
+		c.Errorf("line %d: no diagnostic matching %q", line, re)
+	}
+}
+
+// testImporter type checks packages from source under srcRoot and
+// imports all other packages with std.
+type testImporter struct {
+	fset    *token.FileSet
+	std     types.Importer
+	srcRoot string
+}
+
+func (imp *testImporter) Import(path string) (*types.Package, error) {
+	if matches, _ := filepath.Glob(filepath.Join(imp.srcRoot, path, "*.go")); len(matches) > 0 {
+		pkg, _, _, err := imp.check(path)
+		return pkg, err
+	}
+	return imp.std.Import(path)
+}
+
+func (imp *testImporter) check(path string) (*types.Package, []*ast.File, *types.Info, error) {
+	matches, err := filepath.Glob(filepath.Join(imp.srcRoot, path, "*.go"))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var files []*ast.File
+	for _, name := range matches {
+		f, err := parser.ParseFile(imp.fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		files = append(files, f)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{
+		Importer: imp,
+	}
+	pkg, err := conf.Check(path, imp.fset, files, info)
+	return pkg, files, info, err
+}
@@ -0,0 +1,71 @@
+package a
+
+import (
+	"net"
+	"time"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type good struct {
+	httprequest.Route `httprequest:"GET /items/:id/*rest"`
+	ID                string            `httprequest:"id,path"`
+	Rest              string            `httprequest:"rest,path"`
+	Limit             *int              `httprequest:"limit,form,omitempty"`
+	Tags              []string          `httprequest:"tag,form"`
+	Since             time.Time         `httprequest:"since,form" format:"unix"`
+	Other             map[string]string `httprequest:",form,map"`
+	IP                net.IP            `httprequest:",clientip"`
+	Ignored           chan int
+	embedded
+}
+
+type embedded struct {
+	Page int `httprequest:"page,form"`
+}
+
+type notParams struct {
+	Name string `json:"name"`
+}
+
+type badRoute struct {
+	httprequest.Route `httprequest:"FETCH /x"` // want `bad route tag .*: invalid method`
+}
+
+type badTag struct {
+	A string `httprequest:"a,form,bogus"` // want `bad httprequest tag on field A: unknown tag flag "bogus"`
+}
+
+type missingPath struct {
+	httprequest.Route `httprequest:"GET /items/:id"`
+	ID                string `httprequest:"ident,path"` // want `path field ID: parameter "ident" not found in route path "/items/:id"`
+}
+
+type duplicateForm struct {
+	A string `httprequest:"x,form"`
+	B string `httprequest:"x,form,inbody"` // want `form field B: parameter "x" is also used by field A`
+}
+
+type duplicateEmbeddedForm struct {
+	Page     int `httprequest:"page,form"`
+	embedded     // want `form field Page: parameter "page" is also used by field Page`
+}
+
+type getBody struct {
+	httprequest.Route `httprequest:"GET /x"`
+	Body              notParams `httprequest:",body"` // want `body field Body in GET route`
+}
+
+type unexported struct {
+	a string `httprequest:"a,form"` // want `unexported field a has httprequest tag and will be ignored`
+}
+
+type badTypes struct {
+	Cookie []string        `httprequest:"c,cookie"`             // want `field Cookie: cookie parameter cannot be of type \[\]string`
+	Path   []string        `httprequest:"p,path"`               // want `field Path: path parameter cannot be of type \[\]string`
+	Form   struct{ X int } `httprequest:"f,form"`               // want `field Form: unsupported type struct{X int} for form parameter`
+	Time   string          `httprequest:"t,form" format:"unix"` // want `field Time: format tag used on non-time type string`
+	Map    map[string]int  `httprequest:",form,map"`            // want `field Map: form map must be a map from string to string or \[\]string, not map\[string\]int`
+	IP     int             `httprequest:",clientip"`            // want `field IP: client IP must be of type string or net.IP, not int`
+	Key    int             `httprequest:"k,header,apikey"`      // want `field Key: API key must be of type string, not int`
+}
@@ -0,0 +1,5 @@
+// Package httprequest is a stand-in for gopkg.in/httprequest.v1
+// holding only what the tests need.
+package httprequest
+
+type Route struct{}
@@ -1,7 +1,7 @@
 // Copyright 2015 Canonical Ltd.
 // Licensed under the LGPLv3, see LICENCE file for details.
 
-package httprequest
+package tags
 
 import (
 	"reflect"
@@ -237,7 +237,7 @@ func TestFields(t *testing.T) {
 		test := test
 		c.Run(test.about, func(c *qt.C) {
 			t := reflect.TypeOf(test.val)
-			got := fields(t)
+			got := Fields(t)
 			c.Assert(got, qt.HasLen, len(test.expect))
 			for j, field := range got {
 				expect := test.expect[j]
@@ -0,0 +1,587 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+// Package tags parses the struct tags used by httprequest to describe
+// request parameters and routes, so that other tools, such as linters,
+// document generators and validators, can interpret parameter structs
+// in exactly the same way as httprequest does at run time.
+//
+// See the documentation for httprequest.Unmarshal for the meaning
+// of the tags.
+package tags
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Source specifies where a field is marshaled to and unmarshaled
+// from.
+type Source uint8
+
+const (
+	// SourceNone is used for fields that have no source.
+	SourceNone Source = iota
+
+	// SourcePath is used for fields with the "path" attribute.
+	SourcePath
+
+	// SourceForm is used for fields with the "form" attribute.
+	SourceForm
+
+	// SourceFormBody is used for fields with both the "form"
+	// and "inbody" attributes.
+	SourceFormBody
+
+	// SourceBody is used for fields with the "body" attribute.
+	SourceBody
+
+	// SourceHeader is used for fields with the "header" attribute.
+	SourceHeader
+
+	// SourceClientIP is used for fields with the "clientip"
+	// attribute.
+	SourceClientIP
+
+	// SourceCookie is used for fields with the "cookie" attribute.
+	SourceCookie
+)
+
+var sourceNames = []string{
+	SourceNone:     "none",
+	SourcePath:     "path",
+	SourceForm:     "form",
+	SourceFormBody: "formbody",
+	SourceBody:     "body",
+	SourceHeader:   "header",
+	SourceClientIP: "clientip",
+	SourceCookie:   "cookie",
+}
+
+// String returns the name of the source.
+func (s Source) String() string {
+	if int(s) < len(sourceNames) {
+		return sourceNames[s]
+	}
+	return fmt.Sprintf("Source(%d)", s)
+}
+
+// Tag holds the information in the tags of a parameter field.
+type Tag struct {
+	// Name holds the name of the parameter. It is the name
+	// given in the httprequest tag or, if none is given,
+	// the name of the field.
+	Name string
+
+	// Source holds where the parameter is found.
+	Source Source
+
+	// OmitEmpty holds whether the "omitempty" attribute
+	// was specified.
+	OmitEmpty bool
+
+	// Map holds whether the "map" attribute was specified.
+	Map bool
+
+	// APIKey holds whether the "apikey" attribute was specified.
+	APIKey bool
+
+	// MergePatch holds whether the "mergepatch" attribute
+	// was specified.
+	MergePatch bool
+
+	// RelPath holds whether the "relpath" attribute was specified.
+	// It implies the path source.
+	RelPath bool
+
+	// Secure and HTTPOnly hold whether the "secure" and
+	// "httponly" attributes were specified.
+	Secure   bool
+	HTTPOnly bool
+
+	// Duplicates holds the "first", "last" or "unique" attribute,
+	// if specified, which determines how a form parameter that is
+	// given more than once is treated.
+	Duplicates string
+
+	// Format holds the value of the format tag, if any. It holds
+	// either a layout name, such as "rfc3339" or "unix", or a
+	// layout as accepted by time.Time.Format.
+	Format string
+
+	// Default holds the value of the default tag, if any.
+	Default string
+}
+
+// Parse parses the tags of the field with the given name.
+func Parse(rtag reflect.StructTag, fieldName string) (Tag, error) {
+	t := Tag{
+		Name: fieldName,
+	}
+	if format, ok := rtag.Lookup("format"); ok {
+		if format == "" {
+			return Tag{}, fmt.Errorf("empty format tag")
+		}
+		t.Format = format
+	}
+	if def, ok := rtag.Lookup("default"); ok {
+		if def == "" {
+			return Tag{}, fmt.Errorf("empty default tag")
+		}
+		t.Default = def
+	}
+	tagStr := rtag.Get("httprequest")
+	if tagStr == "" {
+		// The format and default tags are only significant
+		// when the field has a source.
+		t.Format = ""
+		t.Default = ""
+		return t, nil
+	}
+	fields := strings.Split(tagStr, ",")
+	if fields[0] != "" {
+		t.Name = fields[0]
+	}
+	inBody := false
+	for _, f := range fields[1:] {
+		switch f {
+		case "path":
+			t.Source = SourcePath
+		case "form":
+			t.Source = SourceForm
+		case "inbody":
+			inBody = true
+		case "body":
+			t.Source = SourceBody
+		case "header":
+			t.Source = SourceHeader
+		case "clientip":
+			t.Source = SourceClientIP
+		case "cookie":
+			t.Source = SourceCookie
+		case "secure":
+			t.Secure = true
+		case "httponly":
+			t.HTTPOnly = true
+		case "omitempty":
+			t.OmitEmpty = true
+		case "map":
+			t.Map = true
+		case "apikey":
+			t.APIKey = true
+		case "mergepatch":
+			t.MergePatch = true
+		case "relpath":
+			t.RelPath = true
+		case "first", "last", "unique":
+			if t.Duplicates != "" {
+				return Tag{}, fmt.Errorf("cannot use both %s and %s", t.Duplicates, f)
+			}
+			t.Duplicates = f
+		default:
+			return Tag{}, fmt.Errorf("unknown tag flag %q", f)
+		}
+	}
+	if t.OmitEmpty && t.Source != SourceForm && t.Source != SourceHeader && t.Source != SourceCookie {
+		return Tag{}, fmt.Errorf("can only use omitempty with form, header or cookie fields")
+	}
+	if (t.Secure || t.HTTPOnly) && t.Source != SourceCookie {
+		return Tag{}, fmt.Errorf("can only use secure or httponly with cookie fields")
+	}
+	if t.RelPath {
+		if t.Source == SourceNone {
+			t.Source = SourcePath
+		}
+		if t.Source != SourcePath || t.APIKey || t.Format != "" {
+			return Tag{}, fmt.Errorf("can only use relpath with string path fields")
+		}
+	}
+	if t.MergePatch && t.Source != SourceBody {
+		return Tag{}, fmt.Errorf("can only use mergepatch with body field")
+	}
+	if t.Map && t.Source != SourceForm {
+		return Tag{}, fmt.Errorf("can only use map with form field")
+	}
+	if t.Duplicates != "" && (t.Source != SourceForm || t.Map) {
+		return Tag{}, fmt.Errorf("can only use %s with form field", t.Duplicates)
+	}
+	if t.Format != "" && t.Source != SourceForm && t.Source != SourcePath && t.Source != SourceHeader && t.Source != SourceCookie {
+		return Tag{}, fmt.Errorf("can only use format with path, form, header or cookie fields")
+	}
+	if t.Default != "" && (t.Source != SourceForm && t.Source != SourceHeader && t.Source != SourceCookie || t.Map || t.APIKey) {
+		return Tag{}, fmt.Errorf("can only use default with form, header or cookie fields")
+	}
+	if inBody {
+		if t.Source != SourceForm {
+			return Tag{}, fmt.Errorf("can only use inbody with form field")
+		}
+		t.Source = SourceFormBody
+	}
+	if t.APIKey {
+		switch t.Source {
+		case SourcePath, SourceForm, SourceFormBody, SourceHeader:
+		default:
+			return Tag{}, fmt.Errorf("can only use apikey with path, form or header fields")
+		}
+	}
+	return t, nil
+}
+
+// Route holds the information in the tags of an httprequest.Route
+// field.
+type Route struct {
+	// Method holds the HTTP method of the route.
+	Method string
+
+	// Path holds the path pattern of the route, if specified,
+	// without any constraints.
+	Path string
+
+	// Constraints maps the names of path parameters to the
+	// constraints given for them in the path pattern, if any.
+	// For example, the pattern "/users/:id(int)" has the
+	// path "/users/:id" and the constraint "int" on the
+	// parameter "id".
+	Constraints map[string]string
+
+	// OptionalParam holds the name of the final path parameter
+	// when it is marked as optional with a trailing "?", for
+	// example "id" in the pattern "/things/:id?". The
+	// parameter is included in Path but the "?" is not.
+	OptionalParam string
+
+	// Scopes holds the scopes required by the route, as
+	// specified by the scope tag.
+	Scopes []string
+
+	// CSRFExempt holds whether the route is exempt from
+	// CSRF checks, as specified by the tag csrf:"exempt".
+	CSRFExempt bool
+
+	// Status holds the status code of successful responses, as
+	// specified by the status tag, or zero if there is none.
+	Status int
+
+	// Priority holds the priority of the route, as specified by
+	// the priority tag, or nil if there is none.
+	Priority *Priority
+
+	// Version holds the API version served by the route, as
+	// specified by the version tag, if any.
+	Version string
+
+	// EarlyHints holds the Link header value to send in an early
+	// hints response, as specified by the earlyhints tag, if any.
+	EarlyHints string
+
+	// Deprecated holds whether the route is deprecated, as
+	// specified by the deprecated tag, and DeprecatedAt holds
+	// the date given in that tag, if any.
+	Deprecated   bool
+	DeprecatedAt time.Time
+
+	// Sunset holds when the route is expected to stop responding,
+	// as specified by the sunset tag, if any.
+	Sunset time.Time
+
+	// DeprecationLink holds the URL of a document describing the
+	// deprecation of the route, as specified by the
+	// deprecationlink tag, if any.
+	DeprecationLink string
+}
+
+// Priority holds a priority as specified by a priority tag or sent in
+// a Priority header (see RFC 9218).
+type Priority struct {
+	// Urgency holds the urgency, from 0 (most urgent) to 7
+	// (least urgent).
+	Urgency int
+
+	// Incremental holds whether the response can be used
+	// incrementally as it arrives.
+	Incremental bool
+}
+
+// ParsePriority parses a priority in the form used in the Priority
+// header, for example "u=1, i". Parameters that are not mentioned
+// take their default values (urgency 3, not incremental) and unknown
+// parameters are ignored.
+func ParsePriority(s string) (Priority, error) {
+	pri := Priority{
+		Urgency: 3,
+	}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, val := item, ""
+		if i := strings.Index(item, "="); i >= 0 {
+			key, val = item[:i], item[i+1:]
+		}
+		switch key {
+		case "u":
+			u, err := strconv.Atoi(val)
+			if err != nil || u < 0 || u > 7 {
+				return Priority{}, errgo.Newf("invalid urgency %q", val)
+			}
+			pri.Urgency = u
+		case "i":
+			switch val {
+			case "", "?1":
+				pri.Incremental = true
+			case "?0":
+				pri.Incremental = false
+			default:
+				return Priority{}, errgo.Newf("invalid incremental value %q", val)
+			}
+		}
+	}
+	return pri, nil
+}
+
+// ValidMethod holds the HTTP methods that may be used in a route tag.
+//
+// Note: HEAD and OPTIONS are deliberately omitted from this list.
+// HEAD will be routed through GET handlers and OPTIONS is handled
+// separately.
+var ValidMethod = map[string]bool{
+	"PUT":    true,
+	"POST":   true,
+	"DELETE": true,
+	"GET":    true,
+	"PATCH":  true,
+}
+
+// ParseRoute parses the tags of an httprequest.Route field.
+func ParseRoute(rtag reflect.StructTag) (Route, error) {
+	tagStr := rtag.Get("httprequest")
+	if tagStr == "" {
+		return Route{}, errgo.New("no httprequest tag")
+	}
+	var r Route
+	f := strings.Fields(tagStr)
+	switch len(f) {
+	case 2:
+		pattern := f[1]
+		optional := strings.HasSuffix(pattern, "?")
+		if optional {
+			pattern = strings.TrimSuffix(pattern, "?")
+		}
+		if strings.Contains(pattern, "?") {
+			return Route{}, errgo.New("only the final path parameter may be optional")
+		}
+		path, constraints, err := parsePathConstraints(pattern)
+		if err != nil {
+			return Route{}, errgo.Mask(err)
+		}
+		r.Path, r.Constraints = path, constraints
+		if optional {
+			i := strings.LastIndex(path, "/")
+			if i == -1 || !strings.HasPrefix(path[i+1:], ":") || len(path[i+1:]) == 1 {
+				return Route{}, errgo.New("only the final path parameter may be optional")
+			}
+			r.OptionalParam = path[i+2:]
+		}
+		fallthrough
+	case 1:
+		r.Method = f[0]
+	default:
+		return Route{}, errgo.New("wrong field count")
+	}
+	if !ValidMethod[r.Method] {
+		return Route{}, errgo.Newf("invalid method")
+	}
+	// TODO check that path looks valid
+	if scopes := rtag.Get("scope"); scopes != "" {
+		r.Scopes = strings.FieldsFunc(scopes, func(r rune) bool {
+			return r == ',' || r == ' '
+		})
+	}
+	if csrf, ok := rtag.Lookup("csrf"); ok {
+		if csrf != "exempt" {
+			return Route{}, errgo.Newf("bad csrf tag %q", csrf)
+		}
+		r.CSRFExempt = true
+	}
+	if status, ok := rtag.Lookup("status"); ok {
+		code, err := strconv.Atoi(status)
+		if err != nil || code < 200 || code > 299 {
+			return Route{}, errgo.Newf("bad status tag %q", status)
+		}
+		r.Status = code
+	}
+	if pri, ok := rtag.Lookup("priority"); ok {
+		p, err := ParsePriority(pri)
+		if err != nil {
+			return Route{}, errgo.Notef(err, "bad priority tag %q", pri)
+		}
+		r.Priority = &p
+	}
+	if version, ok := rtag.Lookup("version"); ok {
+		if version == "" || strings.ContainsAny(version, " /+;,") {
+			return Route{}, errgo.Newf("bad version tag %q", version)
+		}
+		r.Version = version
+	}
+	if hints, ok := rtag.Lookup("earlyhints"); ok {
+		if strings.TrimSpace(hints) == "" {
+			return Route{}, errgo.Newf("bad earlyhints tag %q", hints)
+		}
+		r.EarlyHints = hints
+	}
+	if err := parseLifecycle(rtag, &r); err != nil {
+		return Route{}, errgo.Mask(err)
+	}
+	return r, nil
+}
+
+// parseLifecycle sets the fields of r that are specified by the
+// deprecated, sunset and deprecationlink tags.
+func parseLifecycle(rtag reflect.StructTag, r *Route) error {
+	if v, ok := rtag.Lookup("deprecated"); ok {
+		r.Deprecated = true
+		if v != "true" {
+			t, err := parseDate(v)
+			if err != nil {
+				return errgo.Newf("bad deprecated tag %q", v)
+			}
+			r.DeprecatedAt = t
+		}
+	}
+	if v, ok := rtag.Lookup("sunset"); ok {
+		t, err := parseDate(v)
+		if err != nil {
+			return errgo.Newf("bad sunset tag %q", v)
+		}
+		r.Sunset = t
+	}
+	if v, ok := rtag.Lookup("deprecationlink"); ok {
+		if v == "" {
+			return errgo.Newf("empty deprecationlink tag")
+		}
+		r.DeprecationLink = v
+	}
+	return nil
+}
+
+// parseDate parses a date in the form "2006-01-02" or in RFC 3339
+// format.
+func parseDate(s string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parsePathConstraints removes the constraints from the given path
+// pattern and returns them keyed by parameter name.
+func parsePathConstraints(pattern string) (string, map[string]string, error) {
+	if !strings.Contains(pattern, "(") {
+		return pattern, nil, nil
+	}
+	constraints := make(map[string]string)
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		open := strings.Index(seg, "(")
+		if open == -1 {
+			continue
+		}
+		if seg[0] != ':' && seg[0] != '*' {
+			return "", nil, errgo.Newf("constraint on non-parameter path segment %q", seg)
+		}
+		if seg[0] == '*' {
+			return "", nil, errgo.Newf("constraint on catch-all parameter %q", seg)
+		}
+		if !strings.HasSuffix(seg, ")") || open == len(seg)-2 {
+			return "", nil, errgo.Newf("bad constraint in path segment %q", seg)
+		}
+		constraints[seg[1:open]] = seg[open+1 : len(seg)-1]
+		segs[i] = seg[:open]
+	}
+	return strings.Join(segs, "/"), constraints, nil
+}
+
+// Fields returns all the fields in the given struct type
+// including fields inside anonymous struct members.
+// The fields are ordered with top level fields first
+// followed by the members of those fields
+// for anonymous fields.
+func Fields(t reflect.Type) []reflect.StructField {
+	byName := make(map[string]reflect.StructField)
+	addFields(t, byName, nil)
+	fields := make(fieldsByIndex, 0, len(byName))
+	for _, f := range byName {
+		if f.Name != "" {
+			fields = append(fields, f)
+		}
+	}
+	sort.Sort(fields)
+	return fields
+}
+
+func addFields(t reflect.Type, byName map[string]reflect.StructField, index []int) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		index := append(index, i)
+		var add bool
+		old, ok := byName[f.Name]
+		switch {
+		case ok && len(old.Index) == len(index):
+			// Fields with the same name at the same depth
+			// cancel one another out. Set the field name
+			// to empty to signify that has happened.
+			old.Name = ""
+			byName[f.Name] = old
+			add = false
+		case ok:
+			// Fields at less depth win.
+			add = len(index) < len(old.Index)
+		default:
+			// The field did not previously exist.
+			add = true
+		}
+		if add {
+			// copy the index so that it's not overwritten
+			// by the other appends.
+			f.Index = append([]int(nil), index...)
+			byName[f.Name] = f
+		}
+		if f.Anonymous {
+			if f.Type.Kind() == reflect.Ptr {
+				f.Type = f.Type.Elem()
+			}
+			if f.Type.Kind() == reflect.Struct {
+				addFields(f.Type, byName, index)
+			}
+		}
+	}
+}
+
+type fieldsByIndex []reflect.StructField
+
+func (f fieldsByIndex) Len() int {
+	return len(f)
+}
+
+func (f fieldsByIndex) Swap(i, j int) {
+	f[i], f[j] = f[j], f[i]
+}
+
+func (f fieldsByIndex) Less(i, j int) bool {
+	indexi, indexj := f[i].Index, f[j].Index
+	for len(indexi) != 0 && len(indexj) != 0 {
+		ii, ij := indexi[0], indexj[0]
+		if ii != ij {
+			return ii < ij
+		}
+		indexi, indexj = indexi[1:], indexj[1:]
+	}
+	return len(indexi) < len(indexj)
+}
@@ -0,0 +1,305 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package tags_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1/tags"
+)
+
+var parseTests = []struct {
+	about       string
+	tag         reflect.StructTag
+	expect      tags.Tag
+	expectError string
+}{{
+	about:  "no tag",
+	expect: tags.Tag{Name: "Field"},
+}, {
+	about:  "form with name",
+	tag:    `httprequest:"x,form,omitempty"`,
+	expect: tags.Tag{Name: "x", Source: tags.SourceForm, OmitEmpty: true},
+}, {
+	about:  "form in body",
+	tag:    `httprequest:",form,inbody"`,
+	expect: tags.Tag{Name: "Field", Source: tags.SourceFormBody},
+}, {
+	about:  "form map",
+	tag:    `httprequest:",form,map"`,
+	expect: tags.Tag{Name: "Field", Source: tags.SourceForm, Map: true},
+}, {
+	about:  "header with format",
+	tag:    `httprequest:"X-Time,header" format:"unix"`,
+	expect: tags.Tag{Name: "X-Time", Source: tags.SourceHeader, Format: "unix"},
+}, {
+	about:  "format without source is ignored",
+	tag:    `format:"unix"`,
+	expect: tags.Tag{Name: "Field"},
+}, {
+	about:  "apikey",
+	tag:    `httprequest:"key,header,apikey"`,
+	expect: tags.Tag{Name: "key", Source: tags.SourceHeader, APIKey: true},
+}, {
+	about:  "cookie",
+	tag:    `httprequest:"session,cookie,secure,httponly"`,
+	expect: tags.Tag{Name: "session", Source: tags.SourceCookie, Secure: true, HTTPOnly: true},
+}, {
+	about:  "merge patch body",
+	tag:    `httprequest:",body,mergepatch"`,
+	expect: tags.Tag{Name: "Field", Source: tags.SourceBody, MergePatch: true},
+}, {
+	about:  "form with last",
+	tag:    `httprequest:"x,form,last"`,
+	expect: tags.Tag{Name: "x", Source: tags.SourceForm, Duplicates: "last"},
+}, {
+	about:  "form in body with unique",
+	tag:    `httprequest:"x,form,inbody,unique"`,
+	expect: tags.Tag{Name: "x", Source: tags.SourceFormBody, Duplicates: "unique"},
+}, {
+	about:       "first and last",
+	tag:         `httprequest:"x,form,first,last"`,
+	expectError: `cannot use both first and last`,
+}, {
+	about:       "unique on header",
+	tag:         `httprequest:"x,header,unique"`,
+	expectError: `can only use unique with form field`,
+}, {
+	about:       "mergepatch without body",
+	tag:         `httprequest:"x,form,mergepatch"`,
+	expectError: `can only use mergepatch with body field`,
+}, {
+	about:  "form with default",
+	tag:    `httprequest:"limit,form" default:"100"`,
+	expect: tags.Tag{Name: "limit", Source: tags.SourceForm, Default: "100"},
+}, {
+	about:       "default on path",
+	tag:         `httprequest:"id,path" default:"1"`,
+	expectError: `can only use default with form, header or cookie fields`,
+}, {
+	about:       "empty default",
+	tag:         `httprequest:"limit,form" default:""`,
+	expectError: `empty default tag`,
+}, {
+	about:  "relpath",
+	tag:    `httprequest:"path,relpath"`,
+	expect: tags.Tag{Name: "path", Source: tags.SourcePath, RelPath: true},
+}, {
+	about:       "relpath with form",
+	tag:         `httprequest:"path,form,relpath"`,
+	expectError: `can only use relpath with string path fields`,
+}, {
+	about:       "secure without cookie",
+	tag:         `httprequest:"x,header,secure"`,
+	expectError: `can only use secure or httponly with cookie fields`,
+}, {
+	about:       "unknown flag",
+	tag:         `httprequest:",foo"`,
+	expectError: `unknown tag flag "foo"`,
+}, {
+	about:       "omitempty on body",
+	tag:         `httprequest:",body,omitempty"`,
+	expectError: `can only use omitempty with form, header or cookie fields`,
+}, {
+	about:       "inbody without form",
+	tag:         `httprequest:",path,inbody"`,
+	expectError: `can only use inbody with form field`,
+}}
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTests {
+		c.Run(test.about, func(c *qt.C) {
+			tag, err := tags.Parse(test.tag, "Field")
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(tag, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+var parseRouteTests = []struct {
+	about       string
+	tag         reflect.StructTag
+	expect      tags.Route
+	expectError string
+}{{
+	about:  "method and path",
+	tag:    `httprequest:"GET /foo/:id"`,
+	expect: tags.Route{Method: "GET", Path: "/foo/:id"},
+}, {
+	about:  "method only",
+	tag:    `httprequest:"POST"`,
+	expect: tags.Route{Method: "POST"},
+}, {
+	about:  "scopes",
+	tag:    `httprequest:"PUT /x" scope:"a:read, a:write b"`,
+	expect: tags.Route{Method: "PUT", Path: "/x", Scopes: []string{"a:read", "a:write", "b"}},
+}, {
+	about: "path constraints",
+	tag:   `httprequest:"GET /users/:id(int)/posts/:slug(alnum)/*rest"`,
+	expect: tags.Route{
+		Method: "GET",
+		Path:   "/users/:id/posts/:slug/*rest",
+		Constraints: map[string]string{
+			"id":   "int",
+			"slug": "alnum",
+		},
+	},
+}, {
+	about:       "empty constraint",
+	tag:         `httprequest:"GET /users/:id()"`,
+	expectError: `bad constraint in path segment ":id\(\)"`,
+}, {
+	about:       "constraint on catch-all",
+	tag:         `httprequest:"GET /files/*path(alpha)"`,
+	expectError: `constraint on catch-all parameter "\*path\(alpha\)"`,
+}, {
+	about:       "constraint on literal segment",
+	tag:         `httprequest:"GET /files(x)"`,
+	expectError: `constraint on non-parameter path segment "files\(x\)"`,
+}, {
+	about: "optional parameter",
+	tag:   `httprequest:"GET /things/:id(int)?"`,
+	expect: tags.Route{
+		Method: "GET",
+		Path:   "/things/:id",
+		Constraints: map[string]string{
+			"id": "int",
+		},
+		OptionalParam: "id",
+	},
+}, {
+	about:       "optional parameter not at end",
+	tag:         `httprequest:"GET /things/:id?/parts"`,
+	expectError: `only the final path parameter may be optional`,
+}, {
+	about:       "optional catch-all parameter",
+	tag:         `httprequest:"GET /things/*rest?"`,
+	expectError: `only the final path parameter may be optional`,
+}, {
+	about:       "optional literal segment",
+	tag:         `httprequest:"GET /things?"`,
+	expectError: `only the final path parameter may be optional`,
+}, {
+	about: "csrf exempt",
+	tag:   `httprequest:"POST /hooks" csrf:"exempt"`,
+	expect: tags.Route{
+		Method:     "POST",
+		Path:       "/hooks",
+		CSRFExempt: true,
+	},
+}, {
+	about:       "bad csrf tag",
+	tag:         `httprequest:"POST /hooks" csrf:"yes"`,
+	expectError: `bad csrf tag "yes"`,
+}, {
+	about: "status, priority, version and early hints",
+	tag:   `httprequest:"POST /jobs" status:"202" priority:"u=1, i" version:"v2" earlyhints:"</style.css>; rel=preload"`,
+	expect: tags.Route{
+		Method: "POST",
+		Path:   "/jobs",
+		Status: 202,
+		Priority: &tags.Priority{
+			Urgency:     1,
+			Incremental: true,
+		},
+		Version:    "v2",
+		EarlyHints: "</style.css>; rel=preload",
+	},
+}, {
+	about:       "bad status tag",
+	tag:         `httprequest:"POST /jobs" status:"404"`,
+	expectError: `bad status tag "404"`,
+}, {
+	about:       "bad priority tag",
+	tag:         `httprequest:"GET /x" priority:"u=9"`,
+	expectError: `bad priority tag "u=9": invalid urgency "9"`,
+}, {
+	about:       "bad version tag",
+	tag:         `httprequest:"GET /x" version:"v1/x"`,
+	expectError: `bad version tag "v1/x"`,
+}, {
+	about:       "bad earlyhints tag",
+	tag:         `httprequest:"GET /x" earlyhints:" "`,
+	expectError: `bad earlyhints tag " "`,
+}, {
+	about: "lifecycle",
+	tag:   `httprequest:"GET /v1/x" deprecated:"2024-01-01" sunset:"2025-01-01T12:00:00Z" deprecationlink:"https://example.com/v2"`,
+	expect: tags.Route{
+		Method:          "GET",
+		Path:            "/v1/x",
+		Deprecated:      true,
+		DeprecatedAt:    time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:          time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC),
+		DeprecationLink: "https://example.com/v2",
+	},
+}, {
+	about: "deprecated without date",
+	tag:   `httprequest:"GET /v1/x" deprecated:"true"`,
+	expect: tags.Route{
+		Method:     "GET",
+		Path:       "/v1/x",
+		Deprecated: true,
+	},
+}, {
+	about:       "bad sunset tag",
+	tag:         `httprequest:"GET /v1/x" sunset:"soon"`,
+	expectError: `bad sunset tag "soon"`,
+}, {
+	about:       "empty deprecationlink tag",
+	tag:         `httprequest:"GET /v1/x" deprecationlink:""`,
+	expectError: `empty deprecationlink tag`,
+}, {
+	about:       "no tag",
+	expectError: `no httprequest tag`,
+}, {
+	about:       "invalid method",
+	tag:         `httprequest:"HEAD /x"`,
+	expectError: `invalid method`,
+}, {
+	about:       "too many fields",
+	tag:         `httprequest:"GET /x y"`,
+	expectError: `wrong field count`,
+}}
+
+func TestParseRoute(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseRouteTests {
+		c.Run(test.about, func(c *qt.C) {
+			r, err := tags.ParseRoute(test.tag)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(r, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	c := qt.New(t)
+	pri, err := tags.ParsePriority("")
+	c.Assert(err, qt.IsNil)
+	c.Assert(pri, qt.Equals, tags.Priority{Urgency: 3})
+	pri, err = tags.ParsePriority("i, u=0, x=1")
+	c.Assert(err, qt.IsNil)
+	c.Assert(pri, qt.Equals, tags.Priority{Urgency: 0, Incremental: true})
+	_, err = tags.ParsePriority("i=yes")
+	c.Assert(err, qt.ErrorMatches, `invalid incremental value "yes"`)
+}
+
+func TestSourceString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(tags.SourceFormBody.String(), qt.Equals, "formbody")
+	c.Assert(tags.Source(99).String(), qt.Equals, "Source(99)")
+}
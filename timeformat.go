@@ -0,0 +1,100 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	// unixLayout and unixMilliLayout are pseudo-layouts
+	// that specify that a time is formatted as the number
+	// of seconds or milliseconds since the Unix epoch.
+	unixLayout      = "unix"
+	unixMilliLayout = "unixmilli"
+)
+
+// timeLayouts maps from the layout names that may be used in a
+// format tag to the time layouts they stand for. The names are
+// case-insensitive.
+var timeLayouts = map[string]string{
+	"rfc3339":     time.RFC3339,
+	"rfc3339nano": time.RFC3339Nano,
+	"rfc1123":     time.RFC1123,
+	"rfc1123z":    time.RFC1123Z,
+	"http":        http.TimeFormat,
+	"date":        "2006-01-02",
+	"unix":        unixLayout,
+	"unixmilli":   unixMilliLayout,
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// parseTime parses s as a time according to the given layout.
+func parseTime(layout, s string) (time.Time, error) {
+	switch layout {
+	case unixLayout, unixMilliLayout:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return time.Time{}, errgo.Newf("cannot parse %q as Unix time", s)
+		}
+		if layout == unixMilliLayout {
+			return time.Unix(n/1e3, (n%1e3)*1e6).UTC(), nil
+		}
+		return time.Unix(n, 0).UTC(), nil
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return time.Time{}, errgo.Mask(err)
+	}
+	return t, nil
+}
+
+// formatTime formats t according to the given layout.
+func formatTime(layout string, t time.Time) string {
+	switch layout {
+	case unixLayout:
+		return strconv.FormatInt(t.Unix(), 10)
+	case unixMilliLayout:
+		return strconv.FormatInt(t.UnixNano()/1e6, 10)
+	}
+	return t.Format(layout)
+}
+
+// unmarshalTimeWithFormat returns an unmarshaler that
+// unmarshals a time.Time using the layout specified in the tag.
+func unmarshalTimeWithFormat(tag tag) unmarshaler {
+	getVal := formGetter(tag)
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		val, ok := getVal(tag.name, p)
+		if !ok {
+			return nil
+		}
+		t, err := parseTime(tag.timeFormat, val)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		makeResult(v).Set(reflect.ValueOf(t))
+		return nil
+	}
+}
+
+// marshalTimeWithFormat returns a marshaler that
+// marshals a time.Time using the layout specified in the tag.
+func marshalTimeWithFormat(tag tag) marshaler {
+	formSet := formSetter(tag)
+	omit := omitter(timeType, tag)
+	return func(v reflect.Value, p *Params) error {
+		if omit(v) {
+			return nil
+		}
+		formSet(tag.name, formatTime(tag.timeFormat, v.Interface().(time.Time)), p)
+		return nil
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"sync"
+
+	"golang.org/x/oauth2"
+	"gopkg.in/errgo.v1"
+)
+
+// TokenRefresher may be implemented by an oauth2.TokenSource used as
+// Client.TokenSource to allow the client to obtain a new token when
+// the server rejects the current one.
+type TokenRefresher interface {
+	// RefreshToken returns a token to use instead of the given
+	// token, which has been rejected by the server. If a newer
+	// token has already been obtained, it may be returned
+	// without obtaining another one.
+	RefreshToken(rejected *oauth2.Token) (*oauth2.Token, error)
+}
+
+// ReuseTokenSource is like oauth2.ReuseTokenSource: it returns a token
+// source that returns t until it expires and then obtains a new token
+// from src. The returned token source also implements TokenRefresher
+// by discarding the current token and obtaining a new one from src.
+//
+// Note that the token sources returned by oauth2.Config.TokenSource
+// and similar functions already reuse tokens, so they will not
+// return a new token when refreshed. To refresh tokens obtained from
+// such a configuration, src should obtain a new token each time it
+// is called, for example by using oauth2.Config.Exchange or
+// clientcredentials.Config.Token.
+func ReuseTokenSource(t *oauth2.Token, src oauth2.TokenSource) oauth2.TokenSource {
+	return &reuseTokenSource{
+		src: src,
+		t:   t,
+	}
+}
+
+type reuseTokenSource struct {
+	src oauth2.TokenSource
+
+	mu sync.Mutex
+	t  *oauth2.Token
+}
+
+// Token implements oauth2.TokenSource.
+func (s *reuseTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.t.Valid() {
+		return s.t, nil
+	}
+	return s.newToken()
+}
+
+// RefreshToken implements TokenRefresher.
+func (s *reuseTokenSource) RefreshToken(rejected *oauth2.Token) (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.t.Valid() && s.t.AccessToken != rejected.AccessToken {
+		// Another request has already refreshed the token.
+		return s.t, nil
+	}
+	return s.newToken()
+}
+
+// newToken obtains a new token from the source.
+// It must be called with s.mu held.
+func (s *reuseTokenSource) newToken() (*oauth2.Token, error) {
+	s.t = nil
+	t, err := s.src.Token()
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	s.t = t
+	return t, nil
+}
+
+// refreshToken returns a token to replace the given rejected token
+// obtained from src.
+func refreshToken(src oauth2.TokenSource, rejected *oauth2.Token) (*oauth2.Token, error) {
+	if r, ok := src.(TokenRefresher); ok {
+		t, err := r.RefreshToken(rejected)
+		return t, errgo.Mask(err, errgo.Any)
+	}
+	t, err := src.Token()
+	return t, errgo.Mask(err, errgo.Any)
+}
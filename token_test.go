@@ -0,0 +1,166 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"golang.org/x/oauth2"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+// newTokenServer returns a server that accepts only requests
+// authorized with the given access token and that responds
+// with the request body.
+func newTokenServer(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+token {
+			httprequest.WriteJSON(w, http.StatusUnauthorized, &httprequest.RemoteError{
+				Code:    httprequest.CodeUnauthorized,
+				Message: "invalid token",
+			})
+			return
+		}
+		data, _ := ioutil.ReadAll(req.Body)
+		httprequest.WriteJSON(w, http.StatusOK, string(data))
+	}))
+}
+
+// tokenSequence returns a token source that returns
+// tokens tok1, tok2 and so on.
+func tokenSequence(n *int) oauth2.TokenSource {
+	return tokenSourceFunc(func() (*oauth2.Token, error) {
+		*n++
+		return &oauth2.Token{
+			AccessToken: fmt.Sprintf("tok%d", *n),
+		}, nil
+	})
+}
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+func TestTokenSource(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newTokenServer("tok1")
+	defer srv.Close()
+
+	n := 0
+	client := httprequest.Client{
+		BaseURL:     srv.URL,
+		TokenSource: httprequest.ReuseTokenSource(nil, tokenSequence(&n)),
+	}
+	var resp string
+	err := client.Call(context.Background(), &chM2Req{
+		P:    "foo",
+		Body: struct{ I int }{999},
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.Equals, `{"I":999}`)
+
+	// The token is reused.
+	err = client.Call(context.Background(), &chM2Req{
+		P: "foo",
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 1)
+}
+
+func TestTokenSourceRefresh(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newTokenServer("tok2")
+	defer srv.Close()
+
+	n := 0
+	client := httprequest.Client{
+		BaseURL:     srv.URL,
+		TokenSource: httprequest.ReuseTokenSource(nil, tokenSequence(&n)),
+	}
+	// The first token is rejected, so the token is refreshed
+	// and the request, including its body, is sent again.
+	var resp string
+	err := client.Call(context.Background(), &chM2Req{
+		P:    "foo",
+		Body: struct{ I int }{999},
+	}, &resp)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.Equals, `{"I":999}`)
+	c.Assert(n, qt.Equals, 2)
+
+	// The refreshed token is used for subsequent requests.
+	err = client.Call(context.Background(), &chM2Req{
+		P: "foo",
+	}, nil)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 2)
+}
+
+func TestTokenSourceRefreshOnlyOnce(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newTokenServer("tok3")
+	defer srv.Close()
+
+	n := 0
+	client := httprequest.Client{
+		BaseURL:     srv.URL,
+		TokenSource: httprequest.ReuseTokenSource(nil, tokenSequence(&n)),
+	}
+	err := client.Call(context.Background(), &chM2Req{
+		P: "foo",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/m2/foo: invalid token`)
+	c.Assert(n, qt.Equals, 2)
+}
+
+func TestTokenSourceUnchangedToken(t *testing.T) {
+	c := qt.New(t)
+
+	srv := newTokenServer("other")
+	defer srv.Close()
+
+	calls := 0
+	client := httprequest.Client{
+		BaseURL: srv.URL,
+		TokenSource: tokenSourceFunc(func() (*oauth2.Token, error) {
+			calls++
+			return &oauth2.Token{AccessToken: "tok"}, nil
+		}),
+	}
+	// The token source does not return a new token,
+	// so the request is not retried.
+	err := client.Call(context.Background(), &chM2Req{
+		P: "foo",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `Post http://.*/m2/foo: invalid token`)
+	c.Assert(calls, qt.Equals, 2)
+}
+
+func TestTokenSourceError(t *testing.T) {
+	c := qt.New(t)
+
+	client := httprequest.Client{
+		BaseURL: "http://0.1.2.3",
+		TokenSource: tokenSourceFunc(func() (*oauth2.Token, error) {
+			return nil, errgo.New("no token")
+		}),
+	}
+	err := client.Call(context.Background(), &chM2Req{
+		P: "foo",
+	}, nil)
+	c.Assert(err, qt.ErrorMatches, `cannot obtain token: no token`)
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+)
+
+// TraceContext holds the trace context of a request as defined by
+// the W3C Trace Context recommendation (see
+// https://www.w3.org/TR/trace-context/).
+type TraceContext struct {
+	// TraceID holds the ID of the trace as 32 lower case
+	// hexadecimal digits.
+	TraceID string
+
+	// SpanID holds the ID of the current span as 16 lower case
+	// hexadecimal digits. It is sent as the parent ID of calls
+	// made by a Client with ForwardTraceContext set.
+	SpanID string
+
+	// ParentSpanID holds the ID of the span that made the request,
+	// taken from the traceparent header, if any.
+	ParentSpanID string
+
+	// Sampled holds whether the caller may have recorded the trace.
+	Sampled bool
+
+	// State holds the vendor-specific trace state from the
+	// tracestate header, which is propagated unchanged.
+	State string
+}
+
+// Traceparent returns the value of the traceparent header that
+// identifies tc.SpanID as the parent of an outgoing request.
+func (tc TraceContext) Traceparent() string {
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + flags
+}
+
+// ParseTraceparent parses the value of a traceparent header. The
+// returned TraceContext has the caller's span ID in SpanID. It
+// reports false if the value is not valid.
+func ParseTraceparent(s string) (TraceContext, bool) {
+	// The header has the form version-traceid-parentid-flags, for
+	// example 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01.
+	// Later versions may append more fields, so only the length of
+	// version 00 is fixed.
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return TraceContext{}, false
+	}
+	version, traceID, spanID, flags := s[0:2], s[3:35], s[36:52], s[53:55]
+	if !isLowerHex(version) || version == "ff" || !isLowerHex(flags) {
+		return TraceContext{}, false
+	}
+	if len(s) > 55 && (version == "00" || s[55] != '-') {
+		return TraceContext{}, false
+	}
+	if !isTraceID(traceID) || !isTraceID(spanID) {
+		return TraceContext{}, false
+	}
+	f, _ := hex.DecodeString(flags)
+	return TraceContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: f[0]&1 != 0,
+	}, true
+}
+
+// isTraceID reports whether s is a valid trace or span ID: lower case
+// hexadecimal digits that are not all zero.
+func isTraceID(s string) bool {
+	if !isLowerHex(s) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] != '0' {
+			return true
+		}
+	}
+	return false
+}
+
+func isLowerHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// randomHex returns a random ID of n bytes as hexadecimal digits.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(errgo.Notef(err, "cannot generate random ID"))
+	}
+	return hex.EncodeToString(b)
+}
+
+type traceContextKey struct{}
+
+// TraceContextFromContext returns the trace context stored in the
+// given context and reports whether there is one. The context passed
+// to handlers created by a Server with TraceContext set always holds
+// the trace context of the request.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// ContextWithTraceContext returns a context that holds the given
+// trace context. This can be used to set the trace context forwarded
+// by a Client with ForwardTraceContext set when the call is not made
+// on behalf of a Server handler.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// wrapTraceContext returns a handler that determines the trace
+// context of each request before calling h. The request is given a
+// new span in the trace identified by its traceparent header or, if
+// it has none, in a new trace.
+func (srv *Server) wrapTraceContext(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		tc, ok := ParseTraceparent(req.Header.Get(traceparentHeader))
+		if ok {
+			tc.ParentSpanID = tc.SpanID
+			tc.State = req.Header.Get(tracestateHeader)
+		} else {
+			tc.TraceID = randomHex(16)
+		}
+		tc.SpanID = randomHex(8)
+		h(w, req.WithContext(ContextWithTraceContext(req.Context(), tc)), p)
+	}
+}
+
+// setTraceContextHeaders sets the traceparent and tracestate headers
+// of req from the trace context held in ctx, if any, unless req
+// already has a traceparent header.
+func setTraceContextHeaders(ctx context.Context, req *http.Request) {
+	if req.Header.Get(traceparentHeader) != "" {
+		return
+	}
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return
+	}
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	req.Header.Set(traceparentHeader, tc.Traceparent())
+	if tc.State != "" {
+		req.Header.Set(tracestateHeader, tc.State)
+	}
+}
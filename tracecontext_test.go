@@ -0,0 +1,179 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+var parseTraceparentTests = []struct {
+	about  string
+	header string
+	expect httprequest.TraceContext
+	ok     bool
+}{{
+	about:  "sampled",
+	header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	expect: httprequest.TraceContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+		Sampled: true,
+	},
+	ok: true,
+}, {
+	about:  "not sampled",
+	header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+	expect: httprequest.TraceContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+	},
+	ok: true,
+}, {
+	about:  "later version with extra fields",
+	header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	expect: httprequest.TraceContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+		Sampled: true,
+	},
+	ok: true,
+}, {
+	about:  "version 00 with extra fields",
+	header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+}, {
+	about:  "invalid version",
+	header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+}, {
+	about:  "zero trace id",
+	header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+}, {
+	about:  "zero span id",
+	header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+}, {
+	about:  "upper case",
+	header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+}, {
+	about:  "too short",
+	header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+}, {
+	about: "empty",
+}}
+
+func TestParseTraceparent(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTraceparentTests {
+		c.Run(test.about, func(c *qt.C) {
+			tc, ok := httprequest.ParseTraceparent(test.header)
+			c.Assert(ok, qt.Equals, test.ok)
+			c.Assert(tc, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	c := qt.New(t)
+	tc := httprequest.TraceContext{
+		TraceID: "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:  "00f067aa0ba902b7",
+		Sampled: true,
+	}
+	c.Assert(tc.Traceparent(), qt.Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	tc.Sampled = false
+	c.Assert(tc.Traceparent(), qt.Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+}
+
+type traceReq struct {
+	httprequest.Route `httprequest:"GET /trace"`
+}
+
+func TestServerTraceContext(t *testing.T) {
+	c := qt.New(t)
+
+	var entry *httprequest.RequestLogEntry
+	srv := &httprequest.Server{
+		TraceContext: true,
+		Logger: httprequest.LoggerFunc(func(ctx context.Context, e *httprequest.RequestLogEntry) {
+			entry = e
+		}),
+	}
+	var tc httprequest.TraceContext
+	h := srv.Handle(func(p httprequest.Params, req *traceReq) {
+		var ok bool
+		tc, ok = httprequest.TraceContextFromContext(p.Context)
+		c.Check(ok, qt.Equals, true)
+	})
+
+	req := httptest.NewRequest("GET", "/trace", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=value")
+	h.Handle(httptest.NewRecorder(), req, nil)
+	c.Assert(tc.TraceID, qt.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(tc.ParentSpanID, qt.Equals, "00f067aa0ba902b7")
+	c.Assert(tc.SpanID, qt.Matches, "[0-9a-f]{16}")
+	c.Assert(tc.SpanID, qt.Not(qt.Equals), tc.ParentSpanID)
+	c.Assert(tc.Sampled, qt.Equals, true)
+	c.Assert(tc.State, qt.Equals, "vendor=value")
+	c.Assert(entry.TraceID, qt.Equals, tc.TraceID)
+	c.Assert(entry.SpanID, qt.Equals, tc.SpanID)
+
+	// Without a valid traceparent header, a new trace is started.
+	req = httptest.NewRequest("GET", "/trace", nil)
+	req.Header.Set("Traceparent", "invalid")
+	req.Header.Set("Tracestate", "vendor=value")
+	h.Handle(httptest.NewRecorder(), req, nil)
+	c.Assert(tc.TraceID, qt.Matches, "[0-9a-f]{32}")
+	c.Assert(tc.TraceID, qt.Not(qt.Equals), "4bf92f3577b34da6a3ce929d0e0e4736")
+	c.Assert(tc.SpanID, qt.Matches, "[0-9a-f]{16}")
+	c.Assert(tc.ParentSpanID, qt.Equals, "")
+	c.Assert(tc.Sampled, qt.Equals, false)
+	c.Assert(tc.State, qt.Equals, "")
+}
+
+func TestClientForwardTraceContext(t *testing.T) {
+	c := qt.New(t)
+
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header = req.Header
+	}))
+	defer srv.Close()
+
+	tc := httprequest.TraceContext{
+		TraceID:      "4bf92f3577b34da6a3ce929d0e0e4736",
+		SpanID:       "b7ad6b7169203331",
+		ParentSpanID: "00f067aa0ba902b7",
+		Sampled:      true,
+		State:        "vendor=value",
+	}
+	ctx := httprequest.ContextWithTraceContext(context.Background(), tc)
+
+	client := &httprequest.Client{
+		BaseURL: srv.URL,
+	}
+	err := client.Get(ctx, "/", nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Get("Traceparent"), qt.Equals, "")
+
+	client.ForwardTraceContext = true
+	err = client.Get(ctx, "/", nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Get("Traceparent"), qt.Equals, "00-4bf92f3577b34da6a3ce929d0e0e4736-b7ad6b7169203331-01")
+	c.Assert(header.Get("Tracestate"), qt.Equals, "vendor=value")
+
+	// An explicit traceparent header is not overridden.
+	req, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, qt.IsNil)
+	req.Header.Set("Traceparent", "00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-00")
+	err = client.Do(ctx, req, nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(header.Get("Traceparent"), qt.Equals, "00-0af7651916cd43dd8448eb211c80319c-00f067aa0ba902b7-00")
+	c.Assert(header.Get("Tracestate"), qt.Equals, "")
+}
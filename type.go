@@ -15,12 +15,13 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
-	"sort"
 	"strings"
 	"sync"
 
 	"github.com/julienschmidt/httprouter"
 	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1/tags"
 )
 
 // TODO include field name and source in error messages.
@@ -48,6 +49,25 @@ type Params struct {
 	// Context holds a context for the request. In Go 1.7 and later,
 	// this should be used in preference to Request.Context.
 	Context context.Context
+
+	// provided holds the fields provided in the request,
+	// as recorded by unmarshal. It is only set for handlers
+	// created by a Server; see Provided.
+	provided *providedFields
+
+	// duplicateParams holds the policy for form parameters that
+	// are given more than once, as set by Server.DuplicateParams.
+	duplicateParams DuplicateParamPolicy
+
+	// json holds the codec used to unmarshal JSON request
+	// bodies, as set by Server.JSON, or nil for encoding/json.
+	json Codec
+
+	// bufferPool holds the pool used to marshal a JSON request
+	// body, as set by Client.BufferPool, and body holds the body
+	// marshaled with it.
+	bufferPool *BufferPool
+	body       *pooledBody
 }
 
 // resultMaker is provided to the unmarshal functions.
@@ -73,6 +93,58 @@ type requestType struct {
 	path     string
 	formBody bool
 	fields   []field
+
+	// scopes holds the scopes required by the route,
+	// as specified by the scope tag on the Route field.
+	scopes []string
+
+	// pathConstraints holds the constraints on path
+	// parameters given in the route pattern.
+	pathConstraints []pathConstraint
+
+	// apiKey holds the field with the apikey attribute,
+	// or nil if there is none.
+	apiKey *apiKeyField
+
+	// optionalParam holds the name of the final path
+	// parameter if it is optional, or the empty string.
+	optionalParam string
+
+	// priority holds the value of the Priority header
+	// specified by the priority tag on the Route field,
+	// or the empty string if there is none.
+	priority string
+
+	// status holds the success status specified by the
+	// status tag on the Route field, or zero if there is none.
+	status int
+
+	// lifecycle holds the lifecycle specified by the tags
+	// on the Route field, or nil if there is none.
+	lifecycle *Lifecycle
+
+	// version holds the API version specified by the
+	// version tag on the Route field, if any.
+	version string
+
+	// earlyHints holds the Link header value specified by the
+	// earlyhints tag on the Route field, if any.
+	earlyHints string
+
+	// csrfExempt holds whether the route is exempt from CSRF
+	// checks, as specified by the csrf tag on the Route field.
+	csrfExempt bool
+}
+
+// apiKeyField holds information on a field
+// with the apikey attribute.
+type apiKeyField struct {
+	// name and source hold where the API key is found.
+	name   string
+	source tagSource
+
+	// index holds the index slice of the field.
+	index []int
 }
 
 // field holds preprocessed information on an individual field
@@ -99,6 +171,17 @@ type field struct {
 
 	// isPointer is true if the field is pointer to the underlying type.
 	isPointer bool
+
+	// source holds where the field is marshaled to
+	// and unmarshaled from.
+	source tagSource
+
+	// tag holds the parsed tag of the field.
+	tag tag
+
+	// singleFormValue holds whether the field is unmarshaled
+	// from a single value of a form parameter.
+	singleFormValue bool
 }
 
 // getRequestType is like parseRequestType except that
@@ -137,11 +220,16 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 	hasBody := false
 	var pt requestType
 	foundRoute := false
+	// formNames holds the names of all the form fields, and
+	// mapField holds the index in pt.fields of the form map
+	// field, or -1 if there is none.
+	formNames := make(map[string]bool)
+	mapField := -1
 	// taggedFieldIndex holds the index of most recent anonymous
 	// tagged field - we will skip any fields inside that.
 	// It is nil when we're not inside an anonymous tagged field.
 	var taggedFieldIndex []int
-	for _, f := range fields(t.Elem()) {
+	for _, f := range tags.Fields(t.Elem()) {
 		if f.PkgPath != "" && !f.Anonymous {
 			// Ignore non-anonymous unexported fields.
 			continue
@@ -152,11 +240,21 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 		}
 		taggedFieldIndex = nil
 		if !foundRoute && f.Anonymous && f.Type == reflect.TypeOf(Route{}) {
-			var err error
-			pt.method, pt.path, err = parseRouteTag(f.Tag)
+			r, err := tags.ParseRoute(f.Tag)
+			if err != nil {
+				return nil, errgo.Notef(err, "bad route tag %q", f.Tag)
+			}
+			pt.method, pt.path, pt.scopes = r.Method, r.Path, r.Scopes
+			pt.optionalParam, pt.csrfExempt = r.OptionalParam, r.CSRFExempt
+			pt.pathConstraints, err = getPathConstraints(r.Constraints)
 			if err != nil {
 				return nil, errgo.Notef(err, "bad route tag %q", f.Tag)
 			}
+			if r.Priority != nil {
+				pt.priority = Priority(*r.Priority).String()
+			}
+			pt.status, pt.version, pt.earlyHints = r.Status, r.Version, r.EarlyHints
+			pt.lifecycle = routeLifecycle(r)
 			foundRoute = true
 			continue
 		}
@@ -177,8 +275,23 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 			return nil, errgo.New("cannot specify inbody field with a body field")
 		}
 		field := field{
-			index: f.Index,
-			name:  f.Name,
+			index:  f.Index,
+			name:   f.Name,
+			source: tag.source,
+			tag:    tag,
+		}
+		if tag.apiKey {
+			if pt.apiKey != nil {
+				return nil, errgo.New("more than one apikey field specified")
+			}
+			if f.Type.Kind() != reflect.String {
+				return nil, errgo.Newf("invalid target type %s for API key", f.Type)
+			}
+			pt.apiKey = &apiKeyField{
+				name:   tag.name,
+				source: tag.source,
+				index:  f.Index,
+			}
 		}
 		if f.Type.Kind() == reflect.Ptr {
 			// The field is a pointer, so when the value is set,
@@ -192,10 +305,33 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 			field.isPointer = false
 		}
 
+		if tag.isMap {
+			if mapField != -1 {
+				return nil, errgo.New("more than one form map field specified")
+			}
+			if !isFormMapType(f.Type) {
+				return nil, errgo.Newf("invalid target type %s for form map", f.Type)
+			}
+			// The marshaler and unmarshaler are filled in below
+			// when we know the names of all the other form fields.
+			mapField = len(pt.fields)
+			pt.fields = append(pt.fields, field)
+			continue
+		}
+		if tag.source == sourceForm || tag.source == sourceFormBody {
+			formNames[tag.name] = true
+			field.singleFormValue = f.Type != reflect.TypeOf([]string(nil))
+		}
+
 		field.unmarshal, err = getUnmarshaler(tag, f.Type)
 		if err != nil {
 			return nil, errgo.Mask(err)
 		}
+		if tag.defaultValue != "" {
+			if err := checkDefault(field.unmarshal, f.Type); err != nil {
+				return nil, errgo.Notef(err, "bad default tag in field %s", f.Name)
+			}
+		}
 
 		field.marshal, err = getMarshaler(tag, f.Type)
 		if err != nil {
@@ -207,6 +343,18 @@ func parseRequestType(t reflect.Type) (*requestType, error) {
 		}
 		pt.fields = append(pt.fields, field)
 	}
+	if mapField != -1 {
+		f := &pt.fields[mapField]
+		f.unmarshal = unmarshalFormMap(formNames)
+		f.marshal = marshalFormMap(f.source, formNames)
+	}
+	if pt.path != "" {
+		for _, f := range pt.fields {
+			if f.tag.relPath && !strings.HasSuffix(pt.path, "/*"+f.tag.name) {
+				return nil, errgo.Newf("relpath field %s does not match a catch-all parameter at the end of %q", f.name, pt.path)
+			}
+		}
+	}
 	return &pt, nil
 }
 
@@ -227,39 +375,6 @@ func withinIndex(i0, i1 []int) bool {
 	return true
 }
 
-// Note: we deliberately omit HEAD and OPTIONS
-// from this list. HEAD will be routed through GET handlers
-// and OPTIONS is handled separately.
-var validMethod = map[string]bool{
-	"PUT":    true,
-	"POST":   true,
-	"DELETE": true,
-	"GET":    true,
-	"PATCH":  true,
-}
-
-func parseRouteTag(tag reflect.StructTag) (method, path string, err error) {
-	tagStr := tag.Get("httprequest")
-	if tagStr == "" {
-		return "", "", errgo.New("no httprequest tag")
-	}
-	f := strings.Fields(tagStr)
-	switch len(f) {
-	case 2:
-		path = f[1]
-		fallthrough
-	case 1:
-		method = f[0]
-	default:
-		return "", "", errgo.New("wrong field count")
-	}
-	if !validMethod[method] {
-		return "", "", errgo.Newf("invalid method")
-	}
-	// TODO check that path looks valid
-	return method, path, nil
-}
-
 func makePointerResult(v reflect.Value) reflect.Value {
 	if v.IsNil() {
 		v.Set(reflect.New(v.Type().Elem()))
@@ -271,142 +386,78 @@ func makeValueResult(v reflect.Value) reflect.Value {
 	return v
 }
 
-type tagSource uint8
+type tagSource = tags.Source
 
 const (
-	sourceNone = iota
-	sourcePath
-	sourceForm
-	sourceFormBody
-	sourceBody
-	sourceHeader
+	sourceNone     = tags.SourceNone
+	sourcePath     = tags.SourcePath
+	sourceForm     = tags.SourceForm
+	sourceFormBody = tags.SourceFormBody
+	sourceBody     = tags.SourceBody
+	sourceHeader   = tags.SourceHeader
+	sourceClientIP = tags.SourceClientIP
+	sourceCookie   = tags.SourceCookie
 )
 
 type tag struct {
 	name      string
 	source    tagSource
 	omitempty bool
+	isMap     bool
+	apiKey    bool
+
+	// mergePatch holds whether a body field holds
+	// a JSON merge patch.
+	mergePatch bool
+
+	// relPath holds whether a path field holds the
+	// value of a catch-all parameter as a relative path.
+	relPath bool
+
+	// secure and httpOnly hold the attributes of
+	// cookies written in responses.
+	secure   bool
+	httpOnly bool
+
+	// duplicates holds the attribute that specifies how a form
+	// parameter given more than once is treated, if any (see
+	// DuplicateParamPolicy).
+	duplicates string
+
+	// timeFormat holds the time layout specified by the
+	// format tag, if any.
+	timeFormat string
+
+	// defaultValue holds the value specified by the default
+	// tag, if any. It is used when unmarshaling if the
+	// parameter is absent, and the parameter is omitted
+	// when marshaling if it has this value.
+	defaultValue string
 }
 
 // parseTag parses the given struct tag attached to the given
 // field name into a tag structure.
 func parseTag(rtag reflect.StructTag, fieldName string) (tag, error) {
-	t := tag{
-		name: fieldName,
-	}
-	tagStr := rtag.Get("httprequest")
-	if tagStr == "" {
-		return t, nil
-	}
-	fields := strings.Split(tagStr, ",")
-	if fields[0] != "" {
-		t.name = fields[0]
-	}
-	inBody := false
-	for _, f := range fields[1:] {
-		switch f {
-		case "path":
-			t.source = sourcePath
-		case "form":
-			t.source = sourceForm
-		case "inbody":
-			inBody = true
-		case "body":
-			t.source = sourceBody
-		case "header":
-			t.source = sourceHeader
-		case "omitempty":
-			t.omitempty = true
-		default:
-			return tag{}, fmt.Errorf("unknown tag flag %q", f)
-		}
-	}
-	if t.omitempty && t.source != sourceForm && t.source != sourceHeader {
-		return tag{}, fmt.Errorf("can only use omitempty with form or header fields")
-	}
-	if inBody {
-		if t.source != sourceForm {
-			return tag{}, fmt.Errorf("can only use inbody with form field")
-		}
-		t.source = sourceFormBody
-	}
-	return t, nil
-}
-
-// fields returns all the fields in the given struct type
-// including fields inside anonymous struct members.
-// The fields are ordered with top level fields first
-// followed by the members of those fields
-// for anonymous fields.
-func fields(t reflect.Type) []reflect.StructField {
-	byName := make(map[string]reflect.StructField)
-	addFields(t, byName, nil)
-	fields := make(fieldsByIndex, 0, len(byName))
-	for _, f := range byName {
-		if f.Name != "" {
-			fields = append(fields, f)
-		}
-	}
-	sort.Sort(fields)
-	return fields
-}
-
-func addFields(t reflect.Type, byName map[string]reflect.StructField, index []int) {
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		index := append(index, i)
-		var add bool
-		old, ok := byName[f.Name]
-		switch {
-		case ok && len(old.Index) == len(index):
-			// Fields with the same name at the same depth
-			// cancel one another out. Set the field name
-			// to empty to signify that has happened.
-			old.Name = ""
-			byName[f.Name] = old
-			add = false
-		case ok:
-			// Fields at less depth win.
-			add = len(index) < len(old.Index)
-		default:
-			// The field did not previously exist.
-			add = true
-		}
-		if add {
-			// copy the index so that it's not overwritten
-			// by the other appends.
-			f.Index = append([]int(nil), index...)
-			byName[f.Name] = f
-		}
-		if f.Anonymous {
-			if f.Type.Kind() == reflect.Ptr {
-				f.Type = f.Type.Elem()
-			}
-			if f.Type.Kind() == reflect.Struct {
-				addFields(f.Type, byName, index)
-			}
-		}
+	t, err := tags.Parse(rtag, fieldName)
+	if err != nil {
+		return tag{}, err
 	}
-}
-
-type fieldsByIndex []reflect.StructField
-
-func (f fieldsByIndex) Len() int {
-	return len(f)
-}
-
-func (f fieldsByIndex) Swap(i, j int) {
-	f[i], f[j] = f[j], f[i]
-}
-
-func (f fieldsByIndex) Less(i, j int) bool {
-	indexi, indexj := f[i].Index, f[j].Index
-	for len(indexi) != 0 && len(indexj) != 0 {
-		ii, ij := indexi[0], indexj[0]
-		if ii != ij {
-			return ii < ij
-		}
-		indexi, indexj = indexi[1:], indexj[1:]
+	timeFormat := t.Format
+	if layout, ok := timeLayouts[strings.ToLower(timeFormat)]; ok {
+		timeFormat = layout
 	}
-	return len(indexi) < len(indexj)
+	return tag{
+		name:         t.Name,
+		source:       t.Source,
+		omitempty:    t.OmitEmpty,
+		isMap:        t.Map,
+		apiKey:       t.APIKey,
+		mergePatch:   t.MergePatch,
+		relPath:      t.RelPath,
+		defaultValue: t.Default,
+		secure:       t.Secure,
+		httpOnly:     t.HTTPOnly,
+		duplicates:   t.Duplicates,
+		timeFormat:   timeFormat,
+	}, nil
 }
@@ -1,10 +1,12 @@
 package httprequest
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strconv"
 
 	"gopkg.in/errgo.v1"
 )
@@ -34,8 +36,75 @@ var (
 //	"header" - the field is taken from the given name in
 //		p.Request.Header.
 //
+//	"cookie" - the field is taken from the value of the cookie
+//		with the given name in p.Request. When the field is
+//		in the result of a handler (see Server.Handle), it is
+//		written as a Set-Cookie header instead, with the
+//		Secure and HttpOnly attributes set if the field also
+//		has the "secure" or "httponly" attribute.
+//
 //	"body" - the field is filled in by parsing the request body
-//		as JSON.
+//		as JSON. If the field has type *NDJSONReader, it is
+//		instead set to a reader of a newline-delimited JSON
+//		body.
+//
+//	"clientip" - the field is set to the IP address of the client
+//		making the request (see Server.TrustedProxies). The
+//		field must be of type net.IP or string. The field name
+//		is ignored.
+//
+// An "apikey" attribute on a path, form or header field of type
+// string specifies that the field holds an API key. Handlers created
+// by a Server check the key against Server.APIKeyStore before the
+// handler is called, and the field is set to the ID of the key rather
+// than to the key itself (see APIKeyStore). When unmarshaling outside
+// of such a handler, the field is left empty.
+//
+// A "relpath" attribute on a string field specifies that the field
+// holds the value of a catch-all path parameter, such as "*path" in the
+// route "/files/*path", as a relative path: without the leading slash
+// that httprouter includes. The attribute implies the "path" source, so
+// `httprequest:"path,relpath"` is sufficient. A value that contains "."
+// or ".." segments or backslashes is rejected, so that the field can be
+// used safely to look up files in a directory. For example:
+//
+//	type fileReq struct {
+//		httprequest.Route `httprequest:"GET /files/*path"`
+//		Path string `httprequest:"path,relpath"`
+//	}
+//
+// A "mergepatch" attribute on a body field specifies that the body
+// is a JSON merge patch (see RFC 7396), typically sent with a PATCH
+// request. The field must be a struct, usually with pointer fields,
+// that has a field of type PatchFields; after unmarshaling, that field
+// holds the names of the fields that were present in the patch, so
+// that a field that was absent can be told apart from one that was
+// set to null. For example:
+//
+//	type Patch struct {
+//		Name    *string
+//		Email   *string
+//		Present httprequest.PatchFields `json:"-"`
+//	}
+//
+// When such a field is marshaled, the request is sent with the
+// Content-Type "application/merge-patch+json".
+//
+// A "map" attribute on a form field specifies that the field
+// collects all the form values that are not bound to any other
+// field in the struct. The field must be a map with string keys and
+// either string or []string values (for example url.Values); when the
+// values are strings, only the first value for each key is used. At
+// most one such field may be specified.
+//
+// A "first", "last" or "unique" attribute on a form field that holds a
+// single value specifies how a parameter that is given more than once
+// is treated: the first or last value is used, or unmarshaling fails
+// (see DuplicateParamPolicy). Without one of these attributes, the
+// policy is taken from Server.DuplicateParams in handlers created by a
+// Server, and the first value is used otherwise. For example:
+//
+//	UserID string `httprequest:"user,form,unique"`
 //
 // For path and form parameters, the field will be filled out from
 // the field in p.PathVar or p.Form using one of the following
@@ -51,6 +120,30 @@ var (
 //
 // -  otherwise fmt.Sscan will be used to set the value.
 //
+// A time.Time field may have a "format" tag specifying the layout
+// that will be used to parse its value, either as a layout accepted
+// by time.Parse or as one of the following (case-insensitive) names:
+//
+//	rfc3339 - time.RFC3339
+//	rfc3339nano - time.RFC3339Nano
+//	rfc1123 - time.RFC1123
+//	rfc1123z - time.RFC1123Z
+//	http - http.TimeFormat
+//	date - "2006-01-02"
+//	unix - decimal seconds since the Unix epoch
+//	unixmilli - decimal milliseconds since the Unix epoch
+//
+// For example:
+//
+//	Since time.Time `httprequest:"since,form" format:"date"`
+//
+// A form, header or cookie field may have a "default" tag specifying
+// the value to use when the parameter is absent from the request. The
+// default is unmarshaled exactly as a value in the request would be,
+// and the type must not be []string. For example:
+//
+//	Limit int `httprequest:"limit,form" default:"100"`
+//
 // When the unmarshaling fails, Unmarshal returns an error with an
 // ErrUnmarshal cause. If the type of x is inappropriate,
 // it returns an error with an ErrBadUnmarshalType cause.
@@ -68,12 +161,24 @@ func Unmarshal(p Params, x interface{}) error {
 
 // unmarshal is the internal version of Unmarshal.
 func unmarshal(p Params, xv reflect.Value, pt *requestType) error {
+	if err := checkPathConstraints(p, pt.pathConstraints); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrUnmarshal))
+	}
 	xv = xv.Elem()
-	for _, f := range pt.fields {
+	for i := range pt.fields {
+		// Use a pointer into the slice rather than a copy
+		// of each field, so that the loop does not allocate.
+		f := &pt.fields[i]
 		fv := xv.FieldByIndex(f.index)
+		if err := checkDuplicateParam(f, p); err != nil {
+			return errgo.WithCausef(err, ErrUnmarshal, "cannot unmarshal into field %s", f.name)
+		}
 		if err := f.unmarshal(fv, p, f.makeResult); err != nil {
 			return errgo.WithCausef(err, ErrUnmarshal, "cannot unmarshal into field %s", f.name)
 		}
+		if p.provided != nil && fieldProvided(*f, fv, p) {
+			p.provided.add(*f)
+		}
 	}
 	return nil
 }
@@ -85,12 +190,33 @@ func getUnmarshaler(tag tag, t reflect.Type) (unmarshaler, error) {
 	switch {
 	case tag.source == sourceNone:
 		return unmarshalNop, nil
+	case tag.source == sourceBody && tag.mergePatch:
+		return unmarshalMergePatch(t)
+	case tag.source == sourceBody && t == ndjsonReaderType:
+		return unmarshalNDJSONBody, nil
 	case tag.source == sourceBody:
 		return unmarshalBody, nil
+	case tag.source == sourceClientIP:
+		return unmarshalClientIP(t)
+	case tag.apiKey:
+		return unmarshalAPIKeyID, nil
+	case tag.relPath:
+		if t.Kind() != reflect.String {
+			return nil, errgo.Newf("invalid target type %s for relpath field", t)
+		}
+		return unmarshalRelPath(tag), nil
+	case tag.timeFormat != "":
+		if t != timeType {
+			return nil, errgo.Newf("format tag specified on non-time type %s", t)
+		}
+		return unmarshalTimeWithFormat(tag), nil
 	case t == reflect.TypeOf([]string(nil)):
+		if tag.defaultValue != "" {
+			return nil, errgo.Newf("cannot use default tag with []string")
+		}
 		switch tag.source {
 		default:
-			return nil, errgo.New("invalid target type []string for path parameter")
+			return nil, errgo.Newf("invalid target type []string for %s parameter", tag.source)
 		case sourceForm, sourceFormBody:
 			return unmarshalAllForm(tag.name), nil
 		case sourceHeader:
@@ -101,7 +227,7 @@ func getUnmarshaler(tag tag, t reflect.Type) (unmarshaler, error) {
 	case implementsTextUnmarshaler(t):
 		return unmarshalWithUnmarshalText(t, tag), nil
 	default:
-		return unmarshalWithScan(tag), nil
+		return unmarshalWithScan(t, tag), nil
 	}
 }
 
@@ -137,12 +263,45 @@ func unmarshalAllHeader(name string) unmarshaler {
 	}
 }
 
+// isFormMapType reports whether t is suitable for use
+// as a form map field: a map from string to either string or []string
+// (for example url.Values).
+func isFormMapType(t reflect.Type) bool {
+	if t.Kind() != reflect.Map || t.Key().Kind() != reflect.String {
+		return false
+	}
+	elem := t.Elem()
+	return elem.Kind() == reflect.String || elem.ConvertibleTo(reflect.TypeOf([]string(nil)))
+}
+
+// unmarshalFormMap unmarshals all the form fields that
+// are not in the bound set into a map.
+func unmarshalFormMap(bound map[string]bool) unmarshaler {
+	return func(v reflect.Value, p Params, makeResult resultMaker) error {
+		var m reflect.Value
+		for name, vals := range p.Request.Form {
+			if bound[name] || len(vals) == 0 {
+				continue
+			}
+			if !m.IsValid() {
+				m = makeResult(v)
+				m.Set(reflect.MakeMap(m.Type()))
+			}
+			var val reflect.Value
+			if m.Type().Elem().Kind() == reflect.String {
+				val = reflect.ValueOf(vals[0])
+			} else {
+				val = reflect.ValueOf(vals)
+			}
+			m.SetMapIndex(reflect.ValueOf(name).Convert(m.Type().Key()), val.Convert(m.Type().Elem()))
+		}
+		return nil
+	}
+}
+
 // unmarshalString unmarshals into a string field.
 func unmarshalString(tag tag) unmarshaler {
-	getVal := formGetters[tag.source]
-	if getVal == nil {
-		panic("unexpected source")
-	}
+	getVal := formGetter(tag)
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
 		val, ok := getVal(tag.name, p)
 		if ok {
@@ -166,7 +325,7 @@ func unmarshalBody(v reflect.Value, p Params, makeResult resultMaker) error {
 	}
 	// TODO allow body types that aren't necessarily JSON.
 	result := makeResult(v)
-	if err := json.Unmarshal(data, result.Addr().Interface()); err != nil {
+	if err := jsonUnmarshal(p.json, data, result.Addr().Interface()); err != nil {
 		return errgo.Notef(err, "cannot unmarshal request body")
 	}
 	return nil
@@ -194,13 +353,66 @@ var formGetters = []func(name string, p Params) (string, bool){
 		}
 		return vs[0], true
 	},
+	sourceCookie: func(name string, p Params) (string, bool) {
+		c, err := p.Request.Cookie(name)
+		if err != nil {
+			return "", false
+		}
+		return c.Value, true
+	},
+}
+
+// formGetter returns a function that returns the value for a given key
+// from the source specified by t and reports whether the value was
+// found. If t specifies a default value, it is returned when the
+// value is not found.
+func formGetter(t tag) func(name string, p Params) (string, bool) {
+	formGet := formGetters[t.source]
+	if t.source == sourceForm || t.source == sourceFormBody {
+		formGet = func(name string, p Params) (string, bool) {
+			return getFormValue(name, p, duplicateParamPolicy(t, p))
+		}
+	}
+	if formGet == nil {
+		panic("unexpected source")
+	}
+	if t.defaultValue == "" {
+		return formGet
+	}
+	return func(name string, p Params) (string, bool) {
+		if val, ok := formGet(name, p); ok {
+			return val, true
+		}
+		return t.defaultValue, true
+	}
+}
+
+// checkDefault checks that the default value used by the
+// unmarshaler u can be unmarshaled into a value of type t.
+func checkDefault(u unmarshaler, t reflect.Type) error {
+	p := Params{
+		Request: &http.Request{
+			Form:   make(url.Values),
+			Header: make(http.Header),
+		},
+	}
+	return errgo.Mask(u(reflect.New(t).Elem(), p, makeValueResult))
 }
 
 func getFromForm(name string, p Params) (string, bool) {
+	return getFormValue(name, p, FirstParam)
+}
+
+// getFormValue returns the value of the given form parameter,
+// choosing between multiple values according to policy.
+func getFormValue(name string, p Params, policy DuplicateParamPolicy) (string, bool) {
 	vs := p.Request.Form[name]
 	if len(vs) == 0 {
 		return "", false
 	}
+	if policy == LastParam {
+		return vs[len(vs)-1], true
+	}
 	return vs[0], true
 }
 
@@ -225,10 +437,7 @@ func implementsTextUnmarshaler(t reflect.Type) bool {
 // that unmarshals the given type from the given tag
 // using its UnmarshalText method.
 func unmarshalWithUnmarshalText(t reflect.Type, tag tag) unmarshaler {
-	getVal := formGetters[tag.source]
-	if getVal == nil {
-		panic("unexpected source")
-	}
+	getVal := formGetter(tag)
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
 		val, ok := getVal(tag.name, p)
 		if !ok {
@@ -241,11 +450,14 @@ func unmarshalWithUnmarshalText(t reflect.Type, tag tag) unmarshaler {
 }
 
 // unmarshalWithScan returns an unmarshaler
-// that unmarshals the given tag using fmt.Scan.
-func unmarshalWithScan(tag tag) unmarshaler {
-	formGet := formGetters[tag.source]
-	if formGet == nil {
-		panic("unexpected source")
+// that unmarshals the given tag into a value of type t using fmt.Scan.
+// Values of basic kinds are parsed with a kindParser first, falling
+// back to fmt.Scan if that fails.
+func unmarshalWithScan(t reflect.Type, tag tag) unmarshaler {
+	formGet := formGetter(tag)
+	parse := kindParsers[t.Kind()]
+	if reflect.PtrTo(t).Implements(scannerType) {
+		parse = nil
 	}
 	return func(v reflect.Value, p Params, makeResult resultMaker) error {
 		val, ok := formGet(tag.name, p)
@@ -253,10 +465,80 @@ func unmarshalWithScan(tag tag) unmarshaler {
 			// TODO allow specifying that a field is mandatory?
 			return nil
 		}
-		_, err := fmt.Sscan(val, makeResult(v).Addr().Interface())
+		rv := makeResult(v)
+		if parse != nil && parse(rv, val) {
+			return nil
+		}
+		_, err := fmt.Sscan(val, rv.Addr().Interface())
 		if err != nil {
 			return errgo.Notef(err, "cannot parse %q into %s", val, v.Type())
 		}
 		return nil
 	}
 }
+
+var scannerType = reflect.TypeOf((*fmt.Scanner)(nil)).Elem()
+
+// kindParser parses s into v, which is of a basic kind, and reports
+// whether it succeeded. Unlike fmt.Sscan, it does not allocate, so it
+// is used for the common case of well formed numbers and booleans;
+// other values are left to fmt.Sscan so that they are interpreted,
+// and errors reported, in the same way as before.
+//
+// Integers are parsed with base 0, as fmt.Sscan does with the %v
+// verb, so that "010" is octal and "0x10" is hexadecimal.
+type kindParser func(v reflect.Value, s string) bool
+
+// kindParsers holds the kindParser for each basic kind.
+var kindParsers = map[reflect.Kind]kindParser{
+	reflect.Int:     parseIntKind,
+	reflect.Int8:    parseIntKind,
+	reflect.Int16:   parseIntKind,
+	reflect.Int32:   parseIntKind,
+	reflect.Int64:   parseIntKind,
+	reflect.Uint:    parseUintKind,
+	reflect.Uint8:   parseUintKind,
+	reflect.Uint16:  parseUintKind,
+	reflect.Uint32:  parseUintKind,
+	reflect.Uint64:  parseUintKind,
+	reflect.Uintptr: parseUintKind,
+	reflect.Float32: parseFloatKind,
+	reflect.Float64: parseFloatKind,
+	reflect.Bool:    parseBoolKind,
+}
+
+func parseIntKind(v reflect.Value, s string) bool {
+	n, err := strconv.ParseInt(s, 0, v.Type().Bits())
+	if err != nil {
+		return false
+	}
+	v.SetInt(n)
+	return true
+}
+
+func parseUintKind(v reflect.Value, s string) bool {
+	n, err := strconv.ParseUint(s, 0, v.Type().Bits())
+	if err != nil {
+		return false
+	}
+	v.SetUint(n)
+	return true
+}
+
+func parseFloatKind(v reflect.Value, s string) bool {
+	n, err := strconv.ParseFloat(s, v.Type().Bits())
+	if err != nil {
+		return false
+	}
+	v.SetFloat(n)
+	return true
+}
+
+func parseBoolKind(v reflect.Value, s string) bool {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false
+	}
+	v.SetBool(b)
+	return true
+}
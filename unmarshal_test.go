@@ -270,6 +270,65 @@ var unmarshalTests = []struct {
 		},
 	},
 	expectError: `cannot unmarshal into field A: cannot parse "not an int" into int: expected integer`,
+}, {
+	about: "basic kinds",
+	val: struct {
+		I   int     `httprequest:",form"`
+		I8  int8    `httprequest:",form"`
+		Oct int     `httprequest:",form"`
+		Hex int64   `httprequest:",form"`
+		U   uint16  `httprequest:",form"`
+		F   float32 `httprequest:",form"`
+		B   bool    `httprequest:",form"`
+		TB  bool    `httprequest:",form"`
+		Sep int     `httprequest:",form"`
+		Suf int     `httprequest:",form"`
+		P   *int    `httprequest:",form"`
+	}{
+		I:   -12,
+		I8:  127,
+		Oct: 8,
+		Hex: 255,
+		U:   65535,
+		F:   1.5,
+		B:   true,
+		TB:  true,
+		Sep: 1000,
+		Suf: 12,
+		P:   newInt(99),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"I":   {"-12"},
+				"I8":  {"127"},
+				"Oct": {"010"},
+				"Hex": {"0xff"},
+				"U":   {"65535"},
+				"F":   {"1.5"},
+				"B":   {"1"},
+				// Values that strconv does not accept are
+				// still interpreted as fmt.Sscan does.
+				"TB":  {"tRuE"},
+				"Sep": {"1_000"},
+				"Suf": {"12abc"},
+				"P":   {"99"},
+			},
+		},
+	},
+}, {
+	about: "basic kind out of range",
+	val: struct {
+		A int8 `httprequest:",form"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"A": {"300"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: cannot parse "300" into int8: integer overflow on token 300`,
 }, {
 	about: "scan field not present",
 	val: struct {
@@ -334,6 +393,135 @@ var unmarshalTests = []struct {
 		B1 int `httprequest:",xxx"`
 	}{},
 	expectError: `bad type .*: bad tag "httprequest:\\",xxx\\"" in field B1: unknown tag flag "xxx"`,
+}, {
+	about: "form map fields",
+	val: struct {
+		A int               `httprequest:"a,form"`
+		B map[string]string `httprequest:",form,map"`
+	}{
+		A: 1,
+		B: map[string]string{
+			"b": "b1",
+			"c": "c1",
+		},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"1"},
+				"b": {"b1", "b2"},
+				"c": {"c1"},
+			},
+		},
+	},
+}, {
+	about: "form map with url.Values",
+	val: struct {
+		A int        `httprequest:"a,form"`
+		B url.Values `httprequest:",form,map"`
+	}{
+		B: url.Values{
+			"b": {"b1", "b2"},
+		},
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"b": {"b1", "b2"},
+			},
+		},
+	},
+}, {
+	about: "form map with no unbound values",
+	val: struct {
+		A int               `httprequest:"a,form"`
+		B map[string]string `httprequest:",form,map"`
+	}{
+		A: 1,
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"1"},
+			},
+		},
+	},
+}, {
+	about: "form map with invalid type",
+	val: struct {
+		B map[string]int `httprequest:",form,map"`
+	}{},
+	expectError: `bad type .*: invalid target type map\[string\]int for form map`,
+}, {
+	about: "more than one form map",
+	val: struct {
+		A map[string]string `httprequest:",form,map"`
+		B map[string]string `httprequest:",form,map"`
+	}{},
+	expectError: `bad type .*: more than one form map field specified`,
+}, {
+	about: "map on non-form field",
+	val: struct {
+		A map[string]string `httprequest:",header,map"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field A: can only use map with form field`,
+}, {
+	about: "time fields with format",
+	val: struct {
+		A time.Time  `httprequest:"a,form" format:"date"`
+		B time.Time  `httprequest:"b,header" format:"unix"`
+		C *time.Time `httprequest:"c,path" format:"unixmilli"`
+		D time.Time  `httprequest:"d,form" format:"02/01/2006"`
+		E time.Time  `httprequest:"e,form" format:"RFC3339"`
+	}{
+		A: time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC),
+		B: time.Date(2001, 9, 9, 1, 46, 40, 0, time.UTC),
+		C: func() *time.Time {
+			t := time.Date(2001, 9, 9, 1, 46, 40, 123e6, time.UTC)
+			return &t
+		}(),
+		D: time.Date(2020, 5, 6, 0, 0, 0, 0, time.UTC),
+	},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"2020-05-06"},
+				"d": {"06/05/2020"},
+			},
+			Header: http.Header{
+				"b": {"1000000000"},
+			},
+		},
+		PathVar: httprouter.Params{{
+			Key:   "c",
+			Value: "1000000000123",
+		}},
+	},
+}, {
+	about: "time field with bad value for format",
+	val: struct {
+		A time.Time `httprequest:"a,form" format:"unix"`
+	}{},
+	params: httprequest.Params{
+		Request: &http.Request{
+			Form: url.Values{
+				"a": {"yesterday"},
+			},
+		},
+	},
+	expectError: `cannot unmarshal into field A: cannot parse "yesterday" as Unix time`,
+}, {
+	about: "format on non-time field",
+	val: struct {
+		A int `httprequest:"a,form" format:"date"`
+	}{},
+	expectError: `bad type .*: format tag specified on non-time type int`,
+}, {
+	about: "format on body field",
+	val: struct {
+		A time.Time `httprequest:"a,body" format:"date"`
+	}{},
+	expectError: `bad type .*: bad tag .* in field A: can only use format with path, form, header or cookie fields`,
 }, {
 	about:       "non-struct pointer",
 	val:         0,
@@ -0,0 +1,66 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type urlItemReq struct {
+	httprequest.Route `httprequest:"GET /items/:id"`
+	ID                string   `httprequest:"id,path"`
+	Tags              []string `httprequest:"tag,form,omitempty"`
+	Limit             int      `httprequest:"limit,form,omitempty"`
+	Token             string   `httprequest:"X-Token,header"`
+}
+
+func TestClientURL(t *testing.T) {
+	c := qt.New(t)
+
+	client := &httprequest.Client{
+		BaseURL: "https://{region}.example.com/api",
+		APIKey:  "secret",
+	}
+	ctx := httprequest.ContextWithBaseURLVars(context.Background(), map[string]string{
+		"region": "eu",
+	})
+	u, err := client.URL(ctx, &urlItemReq{
+		ID:    "a b",
+		Tags:  []string{"y", "x"},
+		Limit: 10,
+		Token: "tok",
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.String(), qt.Equals, "https://eu.example.com/api/items/a%20b?limit=10&tag=y&tag=x")
+
+	u, err = client.URL(ctx, &urlItemReq{ID: "1"}, httprequest.WithBaseURLVar("region", "us"))
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.String(), qt.Equals, "https://us.example.com/api/items/1")
+
+	_, err = client.URL(ctx, &urlItemReq{})
+	c.Assert(err, qt.ErrorMatches, `missing value for path parameter "id"`)
+
+	_, err = client.URL(ctx, &struct{ ID string }{})
+	c.Assert(err, qt.ErrorMatches, `type \*struct { ID string } has no httprequest.Route field`)
+}
+
+func TestClientURLCanonicalQuery(t *testing.T) {
+	c := qt.New(t)
+
+	client := &httprequest.Client{
+		BaseURL:        "http://example.com",
+		CanonicalQuery: true,
+	}
+	u, err := client.URL(context.Background(), &urlItemReq{
+		ID:   "1",
+		Tags: []string{"a b", "c*d"},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(u.String(), qt.Equals, "http://example.com/items/1?tag=a%20b&tag=c%2Ad")
+}
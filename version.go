@@ -0,0 +1,208 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// VersionMediaType returns the media type used for the given version
+// of an API whose vendor media type is base. For example,
+// VersionMediaType("application/vnd.myapp", "2") returns
+// "application/vnd.myapp.v2+json".
+//
+// A route is given a version with the version tag on its Route
+// field, for example:
+//
+//	httprequest.Route `httprequest:"GET /things/:id" version:"2"`
+//
+// When Client.MediaType is set, calls to such a route ask for the
+// version in their Accept header. On the server, Server.Versioned
+// combines the handlers for the different versions of a route.
+func VersionMediaType(base, version string) string {
+	return base + ".v" + version + "+json"
+}
+
+// Versioned returns hs with the handlers that share a method and
+// path combined into a single handler that chooses between them
+// according to the Accept header of each request, as negotiated
+// against the media types formed from srv.MediaType (see
+// VersionMediaType). The version of each handler is specified by the
+// version tag of its Route field.
+//
+// When the Accept header asks for none of the versions, the handler
+// without a version is used if there is one, or the one with the
+// latest version otherwise; a variant whose media type is refused by
+// the header (with a quality of zero) is skipped. A request that asks only for versions
+// of srv.MediaType that are not available fails with a
+// CodeNotAcceptable error. Successful JSON responses have the media
+// type of the chosen version as their Content-Type.
+//
+// Handlers whose routes have only one variant are returned
+// unchanged. Versioned panics if two handlers have the same route
+// and version.
+func (srv *Server) Versioned(hs []Handler) []Handler {
+	type route struct {
+		method, path string
+	}
+	var routes []route
+	variants := make(map[route][]Handler)
+	for _, h := range hs {
+		r := route{h.Method, h.Path}
+		if _, ok := variants[r]; !ok {
+			routes = append(routes, r)
+		}
+		for _, v := range variants[r] {
+			if v.Version == h.Version {
+				panic(errgo.Newf("duplicate version %q for route %s %s", h.Version, h.Method, h.Path))
+			}
+		}
+		variants[r] = append(variants[r], h)
+	}
+	result := make([]Handler, 0, len(routes))
+	for _, r := range routes {
+		vs := variants[r]
+		if len(vs) == 1 {
+			result = append(result, vs[0])
+			continue
+		}
+		result = append(result, Handler{
+			Method: r.method,
+			Path:   r.path,
+			Handle: srv.versionedHandle(vs),
+		})
+	}
+	return result
+}
+
+// versionedHandle returns a handler that chooses between the given
+// variants of a route.
+func (srv *Server) versionedHandle(variants []Handler) httprouter.Handle {
+	variants = append([]Handler(nil), variants...)
+	// Sort the variants so that the unversioned variant, if any,
+	// comes first, followed by the others from the latest version
+	// down, so that the first one is the default.
+	sort.SliceStable(variants, func(i, j int) bool {
+		vi, vj := variants[i].Version, variants[j].Version
+		if vi == "" || vj == "" {
+			return vi == ""
+		}
+		return versionLess(vj, vi)
+	})
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		w.Header().Add("Vary", "Accept")
+		h, ok := srv.negotiateVersion(req.Header.Get("Accept"), variants)
+		if !ok {
+			srv.WriteError(req.Context(), w, Errorf(CodeNotAcceptable, "no acceptable version of %s available", srv.MediaType))
+			return
+		}
+		if h.Version != "" && srv.MediaType != "" {
+			w = &versionResponseWriter{
+				ResponseWriter: w,
+				mediaType:      VersionMediaType(srv.MediaType, h.Version),
+			}
+		}
+		h.Handle(w, req, p)
+	}
+}
+
+// negotiateVersion returns the variant that best matches the given
+// Accept header value. It reports false if the header asks only for
+// unavailable versions.
+func (srv *Server) negotiateVersion(accept string, variants []Handler) (Handler, bool) {
+	if accept == "" || srv.MediaType == "" {
+		return variants[0], true
+	}
+	ranges := parseAccept(accept)
+	var best *Handler
+	bestQ := 0.0
+	for i, h := range variants {
+		if h.Version == "" {
+			continue
+		}
+		mediaType := VersionMediaType(srv.MediaType, h.Version)
+		for _, r := range ranges {
+			if r.mediaType == mediaType && r.q > bestQ {
+				best, bestQ = &variants[i], r.q
+			}
+		}
+	}
+	if best != nil {
+		return *best, true
+	}
+	for _, r := range ranges {
+		if r.q > 0 && !strings.HasPrefix(r.mediaType, srv.MediaType+".v") {
+			// The client accepts something other than a
+			// specific version, so use the default unless
+			// the client refuses it.
+			for _, h := range variants {
+				if !refused(ranges, srv.variantMediaType(h)) {
+					return h, true
+				}
+			}
+			break
+		}
+	}
+	return Handler{}, false
+}
+
+// variantMediaType returns the media type of the successful JSON
+// responses of the given variant.
+func (srv *Server) variantMediaType(h Handler) string {
+	if h.Version == "" {
+		return "application/json"
+	}
+	return VersionMediaType(srv.MediaType, h.Version)
+}
+
+// versionLess reports whether version v1 is earlier than v2. Numeric
+// versions are compared numerically; others are compared as strings.
+func versionLess(v1, v2 string) bool {
+	n1, err1 := strconv.ParseFloat(v1, 64)
+	n2, err2 := strconv.ParseFloat(v2, 64)
+	if err1 == nil && err2 == nil {
+		return n1 < n2
+	}
+	return v1 < v2
+}
+
+// versionResponseWriter replaces the JSON content type of a
+// successful response with a versioned media type.
+type versionResponseWriter struct {
+	http.ResponseWriter
+	mediaType     string
+	headerWritten bool
+}
+
+func (w *versionResponseWriter) WriteHeader(code int) {
+	if !w.headerWritten && !isInformational(code) {
+		w.headerWritten = true
+		h := w.ResponseWriter.Header()
+		if code < 300 && strings.EqualFold(h.Get("Content-Type"), "application/json") {
+			h.Set("Content-Type", w.mediaType)
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *versionResponseWriter) Write(data []byte) (int, error) {
+	if !w.headerWritten {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher by flushing the underlying
+// ResponseWriter if it supports it.
+func (w *versionResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type thingV1Req struct {
+	httprequest.Route `httprequest:"GET /things/:id"`
+	ID                string `httprequest:"id,path"`
+}
+
+type thingV2Req struct {
+	httprequest.Route `httprequest:"GET /things/:id" version:"2"`
+	ID                string `httprequest:"id,path"`
+}
+
+type thingV10Req struct {
+	httprequest.Route `httprequest:"GET /things/:id" version:"10"`
+	ID                string `httprequest:"id,path"`
+}
+
+type versionHandlers struct{}
+
+func (versionHandlers) V1(req *thingV1Req) (string, error) {
+	return "v1 " + req.ID, nil
+}
+
+func (versionHandlers) V2(req *thingV2Req) (string, error) {
+	return "v2 " + req.ID, nil
+}
+
+func (versionHandlers) V10(req *thingV10Req) (string, error) {
+	return "v10 " + req.ID, nil
+}
+
+var versionNegotiationTests = []struct {
+	about             string
+	accept            string
+	expectStatus      int
+	expectBody        string
+	expectContentType string
+}{{
+	about:             "no accept header",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v1 a"`,
+	expectContentType: "application/json",
+}, {
+	about:             "plain json",
+	accept:            "application/json",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v1 a"`,
+	expectContentType: "application/json",
+}, {
+	about:             "specific version",
+	accept:            "application/vnd.test.v2+json",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v2 a"`,
+	expectContentType: "application/vnd.test.v2+json",
+}, {
+	about:             "preferred version",
+	accept:            "application/vnd.test.v2+json;q=0.5, application/vnd.test.v10+json",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v10 a"`,
+	expectContentType: "application/vnd.test.v10+json",
+}, {
+	about:             "unavailable version with fallback",
+	accept:            "application/vnd.test.v3+json, */*;q=0.1",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v1 a"`,
+	expectContentType: "application/json",
+}, {
+	about:             "refused default",
+	accept:            "*/*, application/json;q=0",
+	expectStatus:      http.StatusOK,
+	expectBody:        `"v10 a"`,
+	expectContentType: "application/vnd.test.v10+json",
+}, {
+	about:        "all variants refused",
+	accept:       "text/plain, application/json;q=0, application/vnd.test.v2+json;q=0, application/vnd.test.v10+json;q=0",
+	expectStatus: http.StatusNotAcceptable,
+	expectBody:   `{"Message":"no acceptable version of application/vnd.test available","Code":"not acceptable"}`,
+}, {
+	about:        "unavailable version only",
+	accept:       "application/vnd.test.v3+json",
+	expectStatus: http.StatusNotAcceptable,
+	expectBody:   `{"Message":"no acceptable version of application/vnd.test available","Code":"not acceptable"}`,
+}}
+
+func TestServerVersioned(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		MediaType: "application/vnd.test",
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (versionHandlers, context.Context, error) {
+		return versionHandlers{}, p.Context, nil
+	})
+	router := newHandlerRouter(srv.Versioned(hs))
+	for _, test := range versionNegotiationTests {
+		c.Run(test.about, func(c *qt.C) {
+			req := httptest.NewRequest("GET", "/things/a", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			c.Assert(rec.Code, qt.Equals, test.expectStatus)
+			c.Assert(rec.Body.String(), qt.Equals, test.expectBody)
+			if test.expectContentType != "" {
+				c.Assert(rec.Header().Get("Content-Type"), qt.Equals, test.expectContentType)
+			}
+			c.Assert(rec.Header().Get("Vary"), qt.Equals, "Accept")
+		})
+	}
+}
+
+func TestServerVersionedDefaultsToLatest(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		MediaType: "application/vnd.test",
+	}
+	router := newHandlerRouter(srv.Versioned([]httprequest.Handler{
+		srv.Handle(func(req *thingV2Req) (string, error) {
+			return "v2", nil
+		}),
+		srv.Handle(func(req *thingV10Req) (string, error) {
+			return "v10", nil
+		}),
+	}))
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/things/a", nil))
+	c.Assert(rec.Body.String(), qt.Equals, `"v10"`)
+	c.Assert(rec.Header().Get("Content-Type"), qt.Equals, "application/vnd.test.v10+json")
+}
+
+func TestServerVersionedDuplicate(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{}
+	h := srv.Handle(func(req *thingV2Req) (string, error) {
+		return "", nil
+	})
+	c.Assert(func() {
+		srv.Versioned([]httprequest.Handler{h, h})
+	}, qt.PanicMatches, `duplicate version "2" for route GET /things/:id`)
+}
+
+func TestClientMediaType(t *testing.T) {
+	c := qt.New(t)
+
+	srv := &httprequest.Server{
+		MediaType: "application/vnd.test",
+	}
+	hs := srv.Handlers(func(p httprequest.Params) (versionHandlers, context.Context, error) {
+		return versionHandlers{}, p.Context, nil
+	})
+	hsrv := newHandlerServer(srv.Versioned(hs))
+	defer hsrv.Close()
+
+	client := &httprequest.Client{
+		BaseURL:   hsrv.URL,
+		MediaType: "application/vnd.test",
+	}
+	ctx := context.Background()
+	var s string
+	err := client.Call(ctx, &thingV2Req{ID: "x"}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "v2 x")
+
+	err = client.Call(ctx, &thingV10Req{ID: "y"}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "v10 y")
+
+	err = client.Call(ctx, &thingV1Req{ID: "z"}, &s)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "v1 z")
+
+	// An explicit Accept header is left alone.
+	err = client.CallWithOptions(ctx, &thingV2Req{ID: "w"}, &s, httprequest.WithHeader("Accept", "application/vnd.test.v3+json"))
+	c.Assert(errgo.Cause(err).(*httprequest.RemoteError).Code, qt.Equals, httprequest.CodeNotAcceptable)
+}
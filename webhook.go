@@ -0,0 +1,186 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrWebhookEventInProgress is returned by WebhookEventStore.Begin
+// when another delivery of the same event is being handled.
+var ErrWebhookEventInProgress = errgo.New("webhook event in progress")
+
+// WebhookEventStore is used by WebhookDeduper to record the webhook
+// events that have been handled.
+type WebhookEventStore interface {
+	// Begin is called when a delivery of the event with the given
+	// ID is received. If the event has already been handled, Begin
+	// returns true. Otherwise it marks the event as in progress and
+	// returns false; if the event is already in progress, it
+	// returns an error with an ErrWebhookEventInProgress cause.
+	Begin(ctx context.Context, id string) (bool, error)
+
+	// Finish is called when a delivery begun with the given ID has
+	// been handled. If handled is true, the event should be
+	// reported as handled by Begin until the given expiry time;
+	// otherwise the event should be forgotten so that it can be
+	// delivered again.
+	Finish(ctx context.Context, id string, handled bool, expires time.Time) error
+}
+
+// WebhookDeduper makes handlers for webhooks from senders that deliver
+// events at least once handle each event only once. The first delivery
+// of an event is handled as usual; later deliveries of the same event
+// are not passed to the handler but are sent an empty response with
+// status http.StatusOK, so that the sender stops retrying.
+//
+// Events are identified by the value of a request header, scoped by
+// the route of the handler. Requests without the header are handled
+// as usual. Only a delivery whose handler responds with a 2xx status
+// is recorded as handled. Any other delivery, including one rejected
+// with a 4xx status because it failed authentication or validation, is
+// forgotten so that a forged or malformed delivery cannot prevent the
+// genuine one from being handled and the sender can retry. A delivery
+// received while another delivery of the same event is being handled
+// fails with a CodeConflict error for the same reason.
+//
+// A WebhookDeduper implements Middleware, so it can be enabled for the
+// handlers created by a Server by adding it to Server.Middleware.
+type WebhookDeduper struct {
+	// Store holds the store used to record events.
+	Store WebhookEventStore
+
+	// Header holds the name of the header holding the event ID.
+	// If it is empty, "Webhook-Id" is used.
+	Header string
+
+	// Expiry holds how long an event is recorded for. It should be
+	// longer than the period over which the sender retries. If it
+	// is zero, 24 hours is used.
+	Expiry time.Duration
+
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+}
+
+var _ Middleware = (*WebhookDeduper)(nil)
+
+// Wrap implements Middleware.Wrap.
+func (d *WebhookDeduper) Wrap(srv *Server, method, pathPattern string, h httprouter.Handle) httprouter.Handle {
+	header := d.Header
+	if header == "" {
+		header = "Webhook-Id"
+	}
+	expiry := d.Expiry
+	if expiry == 0 {
+		expiry = 24 * time.Hour
+	}
+	return func(w http.ResponseWriter, req *http.Request, p httprouter.Params) {
+		id := req.Header.Get(header)
+		if id == "" {
+			h(w, req, p)
+			return
+		}
+		id = method + " " + pathPattern + " " + id
+		ctx := req.Context()
+		handled, err := d.Store.Begin(ctx, id)
+		if err != nil {
+			if errgo.Cause(err) == ErrWebhookEventInProgress {
+				err = Errorf(CodeConflict, "event is already being handled")
+			}
+			srv.WriteError(ctx, w, errgo.Mask(err, errgo.Any))
+			return
+		}
+		if handled {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// Make sure that the event is released if the handler panics.
+		finished := false
+		defer func() {
+			if !finished {
+				d.Store.Finish(ctx, id, false, time.Time{})
+			}
+		}()
+		w1 := &statusResponseWriter{
+			ResponseWriter: w,
+		}
+		h(w1, req, p)
+		finished = true
+		status := w1.status
+		if status == 0 {
+			// Nothing was written, so the response is
+			// an empty one with status http.StatusOK.
+			status = http.StatusOK
+		}
+		handled = status >= 200 && status < 300
+		d.Store.Finish(ctx, id, handled, nowFunc(d.Now)().Add(expiry))
+	}
+}
+
+// MemWebhookEventStore is an in-memory implementation of
+// WebhookEventStore. The zero value is ready to use.
+type MemWebhookEventStore struct {
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	events    map[string]*webhookEvent
+	nextPurge time.Time
+}
+
+type webhookEvent struct {
+	// handled holds whether the event has been handled;
+	// if it is false, the event is in progress.
+	handled bool
+	expires time.Time
+}
+
+// Begin implements WebhookEventStore.Begin.
+func (s *MemWebhookEventStore) Begin(ctx context.Context, id string) (bool, error) {
+	now := nowFunc(s.Now)()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.events == nil {
+		s.events = make(map[string]*webhookEvent)
+	}
+	if now.After(s.nextPurge) {
+		for k, e := range s.events {
+			if e.handled && now.After(e.expires) {
+				delete(s.events, k)
+			}
+		}
+		s.nextPurge = now.Add(time.Minute)
+	}
+	e := s.events[id]
+	switch {
+	case e == nil || e.handled && now.After(e.expires):
+		s.events[id] = &webhookEvent{}
+		return false, nil
+	case !e.handled:
+		return false, ErrWebhookEventInProgress
+	}
+	return true, nil
+}
+
+// Finish implements WebhookEventStore.Finish.
+func (s *MemWebhookEventStore) Finish(ctx context.Context, id string, handled bool, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !handled {
+		delete(s.events, id)
+		return nil
+	}
+	s.events[id] = &webhookEvent{
+		handled: true,
+		expires: expires,
+	}
+	return nil
+}
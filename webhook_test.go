@@ -0,0 +1,132 @@
+// Copyright 2020 Canonical Ltd.
+// Licensed under the LGPLv3, see LICENCE file for details.
+
+package httprequest_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	"gopkg.in/httprequest.v1"
+)
+
+type webhookReq struct {
+	httprequest.Route `httprequest:"POST /hooks/:source"`
+	Source            string `httprequest:"source,path"`
+	Body              struct {
+		Fail   bool
+		Reject string
+	} `httprequest:",body"`
+}
+
+func TestWebhookDeduper(t *testing.T) {
+	c := qt.New(t)
+
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	nowFunc := func() time.Time {
+		return now
+	}
+	srv := httprequest.Server{
+		Middleware: []httprequest.Middleware{
+			&httprequest.WebhookDeduper{
+				Store: &httprequest.MemWebhookEventStore{
+					Now: nowFunc,
+				},
+				Expiry: time.Hour,
+				Now:    nowFunc,
+			},
+		},
+	}
+	calls := 0
+	h := srv.Handle(func(p httprequest.Params, req *webhookReq) (string, error) {
+		calls++
+		if req.Body.Fail {
+			return "", errgo.New("temporary failure")
+		}
+		if req.Body.Reject != "" {
+			return "", httprequest.Errorf(req.Body.Reject, "rejected")
+		}
+		return "ok", nil
+	})
+	router := newHandlerRouter([]httprequest.Handler{h})
+	deliver := func(path, id, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if id != "" {
+			req.Header.Set("Webhook-Id", id)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := deliver("/hooks/a", "ev1", `{}`)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, `"ok"`)
+	c.Assert(calls, qt.Equals, 1)
+
+	// A duplicate delivery is acknowledged without calling the handler.
+	rec = deliver("/hooks/a", "ev1", `{}`)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+	c.Assert(rec.Body.String(), qt.Equals, "")
+	c.Assert(calls, qt.Equals, 1)
+
+	// Event IDs are scoped by route, not by path.
+	deliver("/hooks/b", "ev1", `{}`)
+	c.Assert(calls, qt.Equals, 1)
+
+	// Deliveries without an ID are always handled.
+	deliver("/hooks/a", "", `{}`)
+	deliver("/hooks/a", "", `{}`)
+	c.Assert(calls, qt.Equals, 3)
+
+	// Failed deliveries can be retried.
+	rec = deliver("/hooks/a", "ev2", `{"Fail": true}`)
+	c.Assert(rec.Code, qt.Equals, http.StatusInternalServerError)
+	rec = deliver("/hooks/a", "ev2", `{}`)
+	c.Assert(rec.Body.String(), qt.Equals, `"ok"`)
+	c.Assert(calls, qt.Equals, 5)
+
+	// After the expiry time, the event is forgotten.
+	now = now.Add(2 * time.Hour)
+	deliver("/hooks/a", "ev1", `{}`)
+	c.Assert(calls, qt.Equals, 6)
+
+	// A rejected delivery does not use up the event ID, so the
+	// genuine delivery that follows it is still handled.
+	for i, code := range []string{httprequest.CodeUnauthorized, httprequest.CodeBadRequest} {
+		id := fmt.Sprintf("rejected%d", i)
+		rec = deliver("/hooks/a", id, `{"Reject": "`+code+`"}`)
+		c.Assert(rec.Code, qt.Not(qt.Equals), http.StatusOK)
+		c.Assert(rec.Code < 500, qt.IsTrue)
+		rec = deliver("/hooks/a", id, `{}`)
+		c.Assert(rec.Code, qt.Equals, http.StatusOK)
+		c.Assert(rec.Body.String(), qt.Equals, `"ok"`)
+	}
+	c.Assert(calls, qt.Equals, 10)
+}
+
+func TestMemWebhookEventStoreInProgress(t *testing.T) {
+	c := qt.New(t)
+
+	store := &httprequest.MemWebhookEventStore{}
+	ctx := context.Background()
+	handled, err := store.Begin(ctx, "x")
+	c.Assert(err, qt.IsNil)
+	c.Assert(handled, qt.IsFalse)
+	_, err = store.Begin(ctx, "x")
+	c.Assert(errgo.Cause(err), qt.Equals, httprequest.ErrWebhookEventInProgress)
+	err = store.Finish(ctx, "x", true, time.Now().Add(time.Hour))
+	c.Assert(err, qt.IsNil)
+	handled, err = store.Begin(ctx, "x")
+	c.Assert(err, qt.IsNil)
+	c.Assert(handled, qt.IsTrue)
+}